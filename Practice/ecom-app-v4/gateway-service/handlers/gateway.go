@@ -1,22 +1,28 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"gateway-service/internal/auth"
 	"gateway-service/internal/consul"
 	"github.com/gin-gonic/gin"
 	consulapi "github.com/hashicorp/consul/api"
 	"io"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 )
 
 type Handler struct {
-	client *consulapi.Client
+	client  *consulapi.Client
+	apiKeys *auth.APIKeys
 }
 
-func NewHandler(client *consulapi.Client) *Handler {
+func NewHandler(client *consulapi.Client, apiKeys *auth.APIKeys) *Handler {
 	return &Handler{
-		client: client,
+		client:  client,
+		apiKeys: apiKeys,
 	}
 }
 
@@ -39,6 +45,13 @@ func (h *Handler) APIGateway(c *gin.Context) {
 		return // Exit if no valid service endpoint exists.
 	}
 
+	// The internal routes are only for the services calling each other with service tokens,
+	// they are not exposed to the outside. Matching any segment also covers paths like /users/x/../internal.
+	if slices.ContainsFunc(segments, func(s string) bool { return strings.EqualFold(s, "internal") }) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
 	// Print the service endpoint to understand which service is being targeted.
 	fmt.Println(serviceEndpoint)
 
@@ -94,6 +107,28 @@ func (h *Handler) APIGateway(c *gin.Context) {
 	// Copy all the headers from the original client request into the new request.
 	req.Header = c.Request.Header
 
+	// The services only see the gateway, they need the client address e.g. to throttle logins per IP.
+	// It replaces whatever the client sent with the address of the connection, so it can not be spoofed past the gateway.
+	req.Header.Set("X-Forwarded-For", c.RemoteIP())
+
+	// Tools send "Authorization: ApiKey <key>", the key is exchanged for an access token of its owner
+	// which is sent on instead, the services only ever see tokens.
+	scheme, apiKey, _ := strings.Cut(c.Request.Header.Get("Authorization"), " ")
+	usesAPIKey := strings.EqualFold(scheme, "apikey")
+	if usesAPIKey {
+		token, err := h.apiKeys.Token(ctx, apiKey)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidAPIKey) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				return
+			}
+			slog.Error("error exchanging api key", slog.Any("error", err))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to check API key"})
+			return
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
 	// Forward the prepared request to the backend service using the default HTTP client.
 	resp, err := http.DefaultClient.Do(req)
 
@@ -105,6 +140,11 @@ func (h *Handler) APIGateway(c *gin.Context) {
 	// Ensure the response body is properly closed after reading it.
 	defer resp.Body.Close()
 
+	// A token the services refuse was most likely revoked with its key, the next request exchanges the key again
+	if usesAPIKey && resp.StatusCode == http.StatusUnauthorized {
+		h.apiKeys.Forget(apiKey)
+	}
+
 	// Read the response body from the backend service.
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -114,6 +154,15 @@ func (h *Handler) APIGateway(c *gin.Context) {
 		return
 	}
 
+	// Forward the cookies and the guest cart token set by the backend service,
+	// without them guests would get a new cart on every request.
+	for _, cookie := range resp.Header.Values("Set-Cookie") {
+		c.Writer.Header().Add("Set-Cookie", cookie)
+	}
+	if cartToken := resp.Header.Get("X-Cart-Token"); cartToken != "" {
+		c.Header("X-Cart-Token", cartToken)
+	}
+
 	// Forward the backend service's response directly to the client.
 	// The status code and content type are also propagated to ensure consistency.
 	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeySource exchanges an API key for an access token
+type APIKeySource func(ctx context.Context, key string) (ServiceToken, error)
+
+// APIKeys turns the API keys of requests into access tokens the services check like any other.
+// A token is reused for the requests with the same key until shortly before it expires,
+// the keys are only kept as their sha256.
+type APIKeys struct {
+	source APIKeySource
+
+	mu     sync.Mutex
+	tokens map[[sha256.Size]byte]ServiceToken
+}
+
+func NewAPIKeys(source APIKeySource) (*APIKeys, error) {
+	if source == nil {
+		return nil, errors.New("api key source is nil")
+	}
+	return &APIKeys{source: source, tokens: map[[sha256.Size]byte]ServiceToken{}}, nil
+}
+
+// Token returns the access token of the key, ErrInvalidAPIKey if the user service refused the key
+func (a *APIKeys) Token(ctx context.Context, key string) (string, error) {
+	if key == "" {
+		return "", ErrInvalidAPIKey
+	}
+	sum := sha256.Sum256([]byte(key))
+
+	a.mu.Lock()
+	token, ok := a.tokens[sum]
+	a.mu.Unlock()
+	if ok && time.Until(token.ExpiresAt) > serviceTokenLeeway {
+		return token.AccessToken, nil
+	}
+
+	// the exchange runs without the lock, requests with other keys do not wait for it
+	token, err := a.source(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, t := range a.tokens {
+		if time.Until(t.ExpiresAt) <= serviceTokenLeeway {
+			delete(a.tokens, k)
+		}
+	}
+	a.tokens[sum] = token
+	return token.AccessToken, nil
+}
+
+// Forget drops the token of the key, e.g. after a service refused it because the key was revoked
+func (a *APIKeys) Forget(key string) {
+	sum := sha256.Sum256([]byte(key))
+	a.mu.Lock()
+	delete(a.tokens, sum)
+	a.mu.Unlock()
+}
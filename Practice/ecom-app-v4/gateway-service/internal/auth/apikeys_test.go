@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// countingSource hands out a new token per exchange and counts the exchanges of every key
+func countingSource(expiresIn time.Duration) (APIKeySource, map[string]int) {
+	calls := map[string]int{}
+	return func(ctx context.Context, key string) (ServiceToken, error) {
+		if key == "revoked" {
+			return ServiceToken{}, ErrInvalidAPIKey
+		}
+		calls[key]++
+		return ServiceToken{
+			AccessToken: fmt.Sprintf("%s-%d", key, calls[key]),
+			ExpiresAt:   time.Now().Add(expiresIn),
+		}, nil
+	}, calls
+}
+
+func TestAPIKeysToken(t *testing.T) {
+	source, calls := countingSource(time.Hour)
+	keys, err := NewAPIKeys(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	first, err := keys.Token(ctx, "k1")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	again, err := keys.Token(ctx, "k1")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if again != first || calls["k1"] != 1 {
+		t.Errorf("expected the cached token %q after one exchange, got %q after %d", first, again, calls["k1"])
+	}
+
+	// every key has a token of its own
+	other, err := keys.Token(ctx, "k2")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if other == first {
+		t.Errorf("expected another token for another key, got %q", other)
+	}
+
+	// the key is only kept as its sha256
+	if _, ok := keys.tokens[sha256.Sum256([]byte("k1"))]; !ok {
+		t.Error("expected the token to be kept under the sha256 of the key")
+	}
+
+	if _, err := keys.Token(ctx, ""); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Token(\"\") error = %v, want %v", err, ErrInvalidAPIKey)
+	}
+	if _, err := keys.Token(ctx, "revoked"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Token() error = %v, want %v", err, ErrInvalidAPIKey)
+	}
+	if len(keys.tokens) != 2 {
+		t.Errorf("expected refused keys not to be cached, got %d tokens", len(keys.tokens))
+	}
+}
+
+func TestAPIKeysTokenNearExpiry(t *testing.T) {
+	// a token inside the leeway is exchanged again instead of being sent on
+	source, calls := countingSource(serviceTokenLeeway / 2)
+	keys, err := NewAPIKeys(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := keys.Token(context.Background(), "k1")
+	second, _ := keys.Token(context.Background(), "k1")
+	if first == second || calls["k1"] != 2 {
+		t.Errorf("expected a new token for an expiring one, got %q and %q after %d exchanges", first, second, calls["k1"])
+	}
+}
+
+func TestAPIKeysForget(t *testing.T) {
+	source, calls := countingSource(time.Hour)
+	keys, err := NewAPIKeys(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	first, _ := keys.Token(ctx, "k1")
+	other, _ := keys.Token(ctx, "k2")
+
+	// after a service refused the token the next request exchanges the key again
+	keys.Forget("k1")
+	second, err := keys.Token(ctx, "k1")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if second == first || calls["k1"] != 2 {
+		t.Errorf("expected a new token after Forget, got %q after %d exchanges", second, calls["k1"])
+	}
+
+	// the other keys keep their tokens
+	if got, _ := keys.Token(ctx, "k2"); got != other || calls["k2"] != 1 {
+		t.Errorf("expected k2 to keep %q, got %q after %d exchanges", other, got, calls["k2"])
+	}
+
+	// forgetting a key without a token is a no-op
+	keys.Forget("unknown")
+}
+
+func TestNewAPIKeysNilSource(t *testing.T) {
+	if _, err := NewAPIKeys(nil); err == nil {
+		t.Error("expected an error for a nil source")
+	}
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gateway-service/internal/consul"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// UserServiceTokens gets service tokens from the user service with the client id and secret of this service
+func UserServiceTokens(client *consulapi.Client, clientId, clientSecret string) ServiceTokenSource {
+	return func(ctx context.Context) (ServiceToken, error) {
+		address, port, err := consul.GetServiceAddress(client, "users")
+		if err != nil {
+			return ServiceToken{}, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		form := url.Values{"grant_type": {"client_credentials"}}
+		httpQuery := fmt.Sprintf("http://%s:%d/users/internal/token", address, port)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, httpQuery, strings.NewReader(form.Encode()))
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientId, clientSecret)
+
+		requested := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return ServiceToken{}, fmt.Errorf("user service responded with status %d", resp.StatusCode)
+		}
+
+		var body struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int    `json:"expires_in"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		if body.AccessToken == "" {
+			return ServiceToken{}, fmt.Errorf("user service sent no service token")
+		}
+		return ServiceToken{
+			AccessToken: body.AccessToken,
+			ExpiresAt:   requested.Add(time.Duration(body.ExpiresIn) * time.Second),
+		}, nil
+	}
+}
+
+// UserServiceAPIKeys exchanges API keys for access tokens at the user service
+func UserServiceAPIKeys(client *consulapi.Client, tokens *ServiceTokens) APIKeySource {
+	return func(ctx context.Context, key string) (ServiceToken, error) {
+		address, port, err := consul.GetServiceAddress(client, "users")
+		if err != nil {
+			return ServiceToken{}, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		requestBody, err := json.Marshal(map[string]string{"api_key": key})
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		httpQuery := fmt.Sprintf("http://%s:%d/users/internal/api-keys/exchange", address, port)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, httpQuery, bytes.NewReader(requestBody))
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := tokens.Do(req)
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusBadRequest {
+			return ServiceToken{}, ErrInvalidAPIKey
+		}
+		if resp.StatusCode != http.StatusOK {
+			return ServiceToken{}, fmt.Errorf("user service responded with status %d", resp.StatusCode)
+		}
+
+		var body struct {
+			AccessToken string    `json:"access_token"`
+			ExpiresAt   time.Time `json:"expires_at"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		if body.AccessToken == "" {
+			return ServiceToken{}, fmt.Errorf("user service sent no access token")
+		}
+		return ServiceToken{AccessToken: body.AccessToken, ExpiresAt: body.ExpiresAt}, nil
+	}
+}
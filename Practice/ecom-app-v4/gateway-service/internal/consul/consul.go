@@ -55,3 +55,12 @@ func GetService(client *consulapi.Client, serviceName string) (string, int, erro
 	// - `nil` to indicate no errors occurred.
 	return service.Service.Address, service.Service.Port, nil
 }
+
+// GetServiceAddress finds the service registered for the endpoint prefix, e.g. "users", like the gateway routes requests
+func GetServiceAddress(client *consulapi.Client, serviceEndpoint string) (string, int, error) {
+	pair, _, err := client.KV().Get(serviceEndpoint, nil)
+	if err != nil || pair == nil {
+		return "", 0, fmt.Errorf("service not found")
+	}
+	return GetService(client, string(pair.Value))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"gateway-service/handlers"
+	"gateway-service/internal/auth"
 	"gateway-service/internal/consul"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -25,12 +26,28 @@ func main() {
 		appPort = "80"
 	}
 	router := gin.New()
+	// the gateway faces the clients, a X-Forwarded-For they send is never taken for their address
+	err = router.SetTrustedProxies(nil)
+	if err != nil {
+		panic(err)
+	}
 
 	client, err := consul.CreateConnection()
 	if err != nil {
 		panic(err)
 	}
-	h := handlers.NewHandler(client)
+	// API keys are exchanged for tokens at the user service, the gateway asks with its own service token
+	serviceTokens, err := auth.NewServiceTokens(
+		auth.UserServiceTokens(client, os.Getenv("SERVICE_CLIENT_ID"), os.Getenv("SERVICE_CLIENT_SECRET")))
+	if err != nil {
+		panic(err)
+	}
+	apiKeys, err := auth.NewAPIKeys(auth.UserServiceAPIKeys(client, serviceTokens))
+	if err != nil {
+		panic(err)
+	}
+
+	h := handlers.NewHandler(client, apiKeys)
 	router.Any("/*path", h.APIGateway)
 
 	err = router.Run(":" + appPort)
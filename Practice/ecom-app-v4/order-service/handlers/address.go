@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"order-service/consul"
+	"order-service/internal/orders"
+	"time"
+)
+
+var errAddressNotFound = errors.New("shipping address not found")
+
+// fetchShippingAddress gets the saved address from the user service with the token of the user,
+// so only an address of the user placing the order can be used
+func (h *Handler) fetchShippingAddress(ctx context.Context, authorizationHeader, addressId string) (orders.ShippingAddress, error) {
+	address, port, err := consul.GetServiceAddress(h.client, "users")
+	if err != nil {
+		return orders.ShippingAddress{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	httpQuery := fmt.Sprintf("http://%s:%d/users/addresses/%s", address, port, addressId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpQuery, nil)
+	if err != nil {
+		return orders.ShippingAddress{}, err
+	}
+	req.Header.Set("Authorization", authorizationHeader)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return orders.ShippingAddress{}, err
+	}
+	defer resp.Body.Close()
+
+	// an invalid id is rejected by the user service with a bad request
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest {
+		return orders.ShippingAddress{}, errAddressNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return orders.ShippingAddress{}, fmt.Errorf("user service responded with status %d", resp.StatusCode)
+	}
+
+	var shippingAddress orders.ShippingAddress
+	err = json.NewDecoder(resp.Body).Decode(&shippingAddress)
+	if err != nil {
+		return orders.ShippingAddress{}, err
+	}
+	return shippingAddress, nil
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"order-service/internal/orders"
+	"order-service/internal/pricing"
+	"order-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+)
+
+// pricingLine is the line of a product to be priced
+func pricingLine(product ProductServiceResponse, quantity int64) pricing.Line {
+	return pricing.Line{
+		ProductID:   product.ProductID,
+		Name:        product.Name,
+		Category:    product.Category,
+		UnitPrice:   product.Price,
+		Quantity:    quantity,
+		WeightGrams: product.WeightGrams,
+	}
+}
+
+// shippingDestination returns the saved address the order is shipped to, nil when none was picked, and the
+// shipping region, a saved address decides the region. It answers the request itself when the address is unusable.
+func (h *Handler) shippingDestination(c *gin.Context, traceId, region, addressId string) (*orders.ShippingAddress, string, bool) {
+	if addressId == "" {
+		return nil, region, true
+	}
+
+	address, err := h.fetchShippingAddress(c.Request.Context(), c.Request.Header.Get("Authorization"), addressId)
+	if err != nil {
+		if errors.Is(err, errAddressNotFound) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid shipping address"})
+			return nil, "", false
+		}
+		slog.Error("error fetching shipping address", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch shipping address"})
+		return nil, "", false
+	}
+	return &address, pricing.RegionForState(address.State), true
+}
+
+// quoteCheckout prices the lines with tax and shipping to the region, the quote is what the order is stored with.
+// It answers the request itself when the lines can not be priced.
+func (h *Handler) quoteCheckout(c *gin.Context, traceId string, lines []pricing.Line, discount int64, region string) (pricing.Quote, bool) {
+	quote, err := h.pricer.Quote(c.Request.Context(), lines, discount, region)
+	if err != nil {
+		if errors.Is(err, pricing.ErrNoShippingRate) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Shipping is not available for this cart and region"})
+			return pricing.Quote{}, false
+		}
+		slog.Error("error pricing the order", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to price the order"})
+		return pricing.Quote{}, false
+	}
+	return quote, true
+}
+
+// addQuote tells stripe about the tax and shipping of the quote. GST is charged as a line item of its own since the
+// stripe prices of the products do not include it, shipping is a fixed shipping option.
+func addQuote(params *stripe.CheckoutSessionParams, quote pricing.Quote) {
+	if quote.Tax > 0 {
+		params.LineItems = append(params.LineItems, &stripe.CheckoutSessionLineItemParams{
+			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+				Currency:    stripe.String(string(stripe.CurrencyINR)),
+				ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{Name: stripe.String("GST")},
+				UnitAmount:  stripe.Int64(quote.Tax),
+			},
+			Quantity: stripe.Int64(1),
+		})
+	}
+
+	if quote.Shipping > 0 {
+		params.ShippingOptions = append(params.ShippingOptions, &stripe.CheckoutSessionShippingOptionParams{
+			ShippingRateData: &stripe.CheckoutSessionShippingOptionShippingRateDataParams{
+				DisplayName: stripe.String("Standard shipping"),
+				Type:        stripe.String("fixed_amount"),
+				FixedAmount: &stripe.CheckoutSessionShippingOptionShippingRateDataFixedAmountParams{
+					Amount:   stripe.Int64(quote.Shipping),
+					Currency: stripe.String(string(stripe.CurrencyINR)),
+				},
+			},
+		})
+	}
+}
+
+// addShippingAddress hands the saved address to stripe, without one stripe collects it
+func addShippingAddress(params *stripe.CheckoutSessionParams, address *orders.ShippingAddress) {
+	if address == nil {
+		return
+	}
+	params.PaymentIntentData.Shipping = &stripe.ShippingDetailsParams{
+		Name:  stripe.String(address.FullName),
+		Phone: stripe.String(address.Phone),
+		Address: &stripe.AddressParams{
+			Line1:      stripe.String(address.Line1),
+			Line2:      stripe.String(address.Line2),
+			City:       stripe.String(address.City),
+			State:      stripe.String(address.State),
+			PostalCode: stripe.String(address.PostalCode),
+			Country:    stripe.String(address.Country),
+		},
+	}
+}
+
+// createQuotedOrder stores the pending order with its quote. The order is stored with the quote, so a session
+// charging anything else is expired and must not be paid. It answers the request itself when it fails.
+func (h *Handler) createQuotedOrder(c *gin.Context, traceId, orderId, userId string, discount orders.Discount, quote pricing.Quote,
+	address *orders.ShippingAddress, sessionStripe *stripe.CheckoutSession) bool {
+	if sessionStripe.AmountTotal != quote.Total {
+		slog.Error("stripe total differs from the quote", slog.String(logkey.TraceID, traceId),
+			slog.Int64("StripeTotal", sessionStripe.AmountTotal), slog.Int64("QuoteTotal", quote.Total))
+		_, err := session.Expire(sessionStripe.ID, nil)
+		if err != nil {
+			slog.Error("error expiring Stripe checkout session", slog.String(logkey.TraceID, traceId),
+				slog.String("CheckoutSessionID", sessionStripe.ID), slog.String(logkey.ERROR, err.Error()))
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to price the order"})
+		return false
+	}
+
+	err := h.o.CreateQuotedOrder(c.Request.Context(), orderId, userId, discount, quote, address, sessionStripe.ID)
+	if err != nil {
+		slog.Error("error creating order", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to create order"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"order-service/internal/orders"
+	"order-service/internal/pricing"
+	"testing"
+
+	"github.com/stripe/stripe-go/v81"
+)
+
+func TestAddQuote(t *testing.T) {
+	tt := [...]struct {
+		name      string
+		quote     pricing.Quote
+		lineItems int
+		shipping  int
+	}{
+		{"tax and shipping", pricing.Quote{Tax: 1800, Shipping: 4000}, 2, 1},
+		{"tax only", pricing.Quote{Tax: 1800}, 2, 0},
+		{"shipping only", pricing.Quote{Shipping: 4000}, 1, 1},
+		{"neither", pricing.Quote{}, 1, 0},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			params := &stripe.CheckoutSessionParams{
+				LineItems: []*stripe.CheckoutSessionLineItemParams{{Price: stripe.String("price_1"), Quantity: stripe.Int64(1)}},
+			}
+			addQuote(params, tc.quote)
+
+			if len(params.LineItems) != tc.lineItems || len(params.ShippingOptions) != tc.shipping {
+				t.Fatalf("expected %d line items and %d shipping options, got %d and %d",
+					tc.lineItems, tc.shipping, len(params.LineItems), len(params.ShippingOptions))
+			}
+			if tc.quote.Tax > 0 {
+				if got := *params.LineItems[1].PriceData.UnitAmount; got != tc.quote.Tax {
+					t.Errorf("expected a GST line of %d, got %d", tc.quote.Tax, got)
+				}
+			}
+			if tc.quote.Shipping > 0 {
+				if got := *params.ShippingOptions[0].ShippingRateData.FixedAmount.Amount; got != tc.quote.Shipping {
+					t.Errorf("expected a shipping rate of %d, got %d", tc.quote.Shipping, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAddShippingAddress(t *testing.T) {
+	params := &stripe.CheckoutSessionParams{PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{}}
+	addShippingAddress(params, nil)
+	if params.PaymentIntentData.Shipping != nil {
+		t.Fatal("expected stripe to collect the address when none was picked")
+	}
+
+	addShippingAddress(params, &orders.ShippingAddress{FullName: "Asha", State: "KA", Country: "IN"})
+	shipping := params.PaymentIntentData.Shipping
+	if shipping == nil || *shipping.Name != "Asha" || *shipping.Address.State != "KA" || *shipping.Address.Country != "IN" {
+		t.Errorf("expected the saved address on the payment intent, got %+v", shipping)
+	}
+}
+
+func TestPricingLine(t *testing.T) {
+	product := ProductServiceResponse{ProductID: "p1", Name: "Mug", Price: 25000, Category: "kitchen", WeightGrams: 350}
+	want := pricing.Line{ProductID: "p1", Name: "Mug", Category: "kitchen", UnitPrice: 25000, Quantity: 2, WeightGrams: 350}
+	if got := pricingLine(product, 2); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
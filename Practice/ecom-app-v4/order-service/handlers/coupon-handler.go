@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"order-service/internal/promotions"
+	"order-service/pkg/ctxmanage"
+	"order-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateCoupon lets an admin create a coupon, optionally limited to products or categories
+func (h *Handler) CreateCoupon(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var nc promotions.NewCoupon
+	err := c.ShouldBindJSON(&nc)
+	if err != nil {
+		slog.Error("json validation error", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "please provide values in correct format"})
+		return
+	}
+
+	if nc.DiscountType == promotions.TypePercentage && nc.DiscountValue > 100 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "percentage discount can not be more than 100"})
+		return
+	}
+	if nc.StartsAt != nil && nc.EndsAt != nil && !nc.EndsAt.After(*nc.StartsAt) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "ends_at must be after starts_at"})
+		return
+	}
+
+	coupon, err := h.pr.CreateCoupon(c.Request.Context(), nc)
+	if err != nil {
+		if errors.Is(err, promotions.ErrDuplicateCode) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "Coupon code already exists"})
+			return
+		}
+		slog.Error("error creating coupon", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to create coupon"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, coupon)
+}
+
+// ListCoupons returns all coupons with their usage
+func (h *Handler) ListCoupons(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	coupons, err := h.pr.ListCoupons(c.Request.Context())
+	if err != nil {
+		slog.Error("error fetching coupons", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch coupons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupons)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"order-service/internal/auth"
+	"order-service/internal/orders"
+	"order-service/internal/stores/kafka"
+	"order-service/pkg/ctxmanage"
+	"order-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// StatusUpdateRequest moves an order along its fulfilment, shipping goes through the shipment endpoint
+// and an order only becomes returned through the returns flow
+type StatusUpdateRequest struct {
+	Status string `json:"status" binding:"required,oneof=packed delivered"`
+	Note   string `json:"note" binding:"max=500"`
+}
+
+// ShipmentRequest records the parcel of a packed order and marks it as shipped
+type ShipmentRequest struct {
+	Carrier        string `json:"carrier" binding:"required,min=2,max=100"`
+	TrackingNumber string `json:"tracking_number" binding:"required,min=3,max=100"`
+	Note           string `json:"note" binding:"max=500"`
+}
+
+// UpdateOrderStatus lets an admin mark an order as packed or delivered
+func (h *Handler) UpdateOrderStatus(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var req StatusUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("invalid request body", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+
+	h.transitionOrder(c, orders.StatusChange{Status: req.Status, Note: req.Note})
+}
+
+// RecordShipment lets an admin record the carrier and tracking number of a packed order
+func (h *Handler) RecordShipment(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var req ShipmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("invalid request body", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+
+	h.transitionOrder(c, orders.StatusChange{
+		Status:         orders.StatusShipped,
+		Note:           req.Note,
+		Carrier:        req.Carrier,
+		TrackingNumber: req.TrackingNumber,
+	})
+}
+
+func (h *Handler) transitionOrder(c *gin.Context, change orders.StatusChange) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+	change.ChangedBy = claims.Subject
+
+	orderId := c.Param("orderId")
+	if _, err := uuid.Parse(orderId); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+		return
+	}
+
+	changed, err := h.o.TransitionOrder(c.Request.Context(), orderId, change)
+	if err != nil {
+		switch {
+		case errors.Is(err, orders.ErrOrderNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+		case errors.Is(err, orders.ErrInvalidTransition):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		case errors.Is(err, orders.ErrShipmentRequired):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		default:
+			slog.Error("error updating order status", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to update order status"})
+		}
+		return
+	}
+
+	slog.Info("order status changed", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId),
+		slog.String("From", changed.FromStatus), slog.String("To", changed.Status))
+
+	h.publishStatusChanged(traceId, changed)
+
+	c.JSON(http.StatusOK, gin.H{"order_id": orderId, "status": changed.Status})
+}
+
+// publishStatusChanged tells the product service about the transition so the customer gets an email.
+// The transition is already committed, a failed publish is only logged.
+func (h *Handler) publishStatusChanged(traceId string, changed orders.StatusChanged) {
+	data, err := json.Marshal(kafka.OrderStatusChangedEvent{
+		OrderId:        changed.OrderID,
+		UserId:         changed.UserID,
+		FromStatus:     changed.FromStatus,
+		Status:         changed.Status,
+		Note:           changed.Note,
+		Carrier:        changed.Carrier,
+		TrackingNumber: changed.TrackingNumber,
+		CreatedAt:      changed.ChangedAt,
+	})
+	if err != nil {
+		slog.Error("error marshaling order status changed event", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		return
+	}
+
+	err = h.k.ProduceMessage(kafka.TopicOrderStatusChanged, []byte(changed.OrderID), data)
+	if err != nil {
+		slog.Error("error producing order status changed event", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+	}
+}
+
+// GetOrderTracking returns the fulfilment state of an order to its customer or an admin
+func (h *Handler) GetOrderTracking(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	orderId := c.Param("orderId")
+	if _, err := uuid.Parse(orderId); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+		return
+	}
+
+	tracking, err := h.o.GetTracking(c.Request.Context(), orderId)
+	if err != nil {
+		if errors.Is(err, orders.ErrOrderNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+			return
+		}
+		slog.Error("error fetching order tracking", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch order tracking"})
+		return
+	}
+
+	// orders of other customers are reported as missing
+	if tracking.UserID != claims.Subject && !claims.HasPermissions(auth.PermOrdersRead) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tracking)
+}
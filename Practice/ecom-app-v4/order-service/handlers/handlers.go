@@ -3,7 +3,10 @@ package handlers
 import (
 	"order-service/gen/proto"
 	"order-service/internal/auth"
+	"order-service/internal/invoices"
 	"order-service/internal/orders"
+	"order-service/internal/pricing"
+	"order-service/internal/promotions"
 	"order-service/internal/stores/kafka"
 	"order-service/middleware"
 	"os"
@@ -15,15 +18,19 @@ import (
 type Handler struct {
 	client      *consulapi.Client
 	o           *orders.Conf
+	pr          *promotions.Conf
+	pricer      *pricing.Pricer
+	inv         *invoices.Conf
 	k           *kafka.Conf
 	protoclient proto.ProductServiceClient
+	st          *auth.ServiceTokens // the internal routes of the user and product services are called with it
 }
 
-func NewHandler(client *consulapi.Client, o *orders.Conf, k *kafka.Conf, protoclient proto.ProductServiceClient) *Handler {
-	return &Handler{client: client, o: o, k: k, protoclient: protoclient}
+func NewHandler(client *consulapi.Client, o *orders.Conf, pr *promotions.Conf, pricer *pricing.Pricer, inv *invoices.Conf, k *kafka.Conf, protoclient proto.ProductServiceClient, st *auth.ServiceTokens) *Handler {
+	return &Handler{client: client, o: o, pr: pr, pricer: pricer, inv: inv, k: k, protoclient: protoclient, st: st}
 }
 
-func API(endpointPrefix string, k *auth.Keys, client *consulapi.Client, o *orders.Conf, kafkaConf *kafka.Conf, protoclient proto.ProductServiceClient) *gin.Engine {
+func API(endpointPrefix string, k *auth.Keys, client *consulapi.Client, o *orders.Conf, pr *promotions.Conf, pricer *pricing.Pricer, inv *invoices.Conf, kafkaConf *kafka.Conf, protoclient proto.ProductServiceClient, st *auth.ServiceTokens) *gin.Engine {
 	r := gin.New()
 	mode := os.Getenv("GIN_MODE")
 	if mode == gin.ReleaseMode {
@@ -36,18 +43,47 @@ func API(endpointPrefix string, k *auth.Keys, client *consulapi.Client, o *order
 		panic(err)
 	}
 
-	h := NewHandler(client, o, kafkaConf, protoclient)
+	h := NewHandler(client, o, pr, pricer, inv, kafkaConf, protoclient, st)
 	r.Use(middleware.Logger(), gin.Recovery())
 
 	r.GET("/ping", HealthCheck)
 	v1 := r.Group(endpointPrefix)
 	{
 		v1.POST("/webhook", h.Webhook)
+
+		//called by the product service with a service token, the gateway does not expose these
+		v1.GET("/internal/:orderId/status", m.RequireService(h.GetOrderStatusInternal, "product-service"))
+		v1.GET("/internal/:orderId/invoice", m.RequireService(h.GetInvoiceInternal, "product-service"))
+
 		v1.Use(m.Authentication())
-		v1.POST("/checkout/:productID", h.Checkout)
-		v1.POST("/checkout/v2/:productID", h.CheckoutWithGrpc)
-		v1.POST("/cartcheckout/v2/:orderId", h.CartCheckout)
+		v1.POST("/checkout/:productID", m.RequireVerifiedEmail(h.Checkout))
+		v1.POST("/checkout/v2/:productID", m.RequireVerifiedEmail(h.CheckoutWithGrpc))
+		v1.POST("/cartcheckout/v2/:orderId", m.RequireVerifiedEmail(h.CartCheckout))
 		v1.GET("/ping", HealthCheck)
+
+		//coupons are managed by staff with the coupons permission
+		v1.POST("/coupons", m.RequirePermission(h.CreateCoupon, auth.PermCouponsManage))
+		v1.GET("/coupons", m.RequirePermission(h.ListCoupons, auth.PermCouponsManage))
+
+		//fulfilment, staff move the order along and customers follow it
+		v1.POST("/:orderId/status", m.RequirePermission(h.UpdateOrderStatus, auth.PermOrdersManage))
+		v1.POST("/:orderId/shipment", m.RequirePermission(h.RecordShipment, auth.PermOrdersManage))
+		v1.GET("/:orderId/tracking", h.GetOrderTracking)
+
+		//invoices are issued once the payment succeeds
+		v1.GET("/:orderId/invoice", h.GetInvoice)
+
+		//cancellation by the customer or staff, refunds need their own permission
+		v1.POST("/:orderId/cancel", h.CancelOrder)
+		v1.POST("/:orderId/refund", m.RequirePermission(h.RefundOrder, auth.PermOrdersRefund))
+
+		//returns are opened by the customer and reviewed, received and refunded by staff
+		v1.POST("/:orderId/returns", h.CreateReturn)
+		v1.GET("/:orderId/returns", h.ListOrderReturns)
+		v1.GET("/returns", m.RequirePermission(h.ListReturns, auth.PermReturnsManage))
+		v1.POST("/returns/:returnId/approve", m.RequirePermission(h.ApproveReturn, auth.PermReturnsManage))
+		v1.POST("/returns/:returnId/reject", m.RequirePermission(h.RejectReturn, auth.PermReturnsManage))
+		v1.POST("/returns/:returnId/receive", m.RequirePermission(h.ReceiveReturn, auth.PermReturnsManage))
 	}
 
 	return r
@@ -73,12 +109,25 @@ type ProductOrdersRequest struct {
 	LineItems []LineItem `json:"lineItem" binding:"required"`
 }
 type ProductServiceResponse struct {
-	ProductID string `json:"product_id"`
-	Stock     int    `json:"stock"`
-	PriceID   string `json:"price_id"`
+	ProductID   string `json:"product_id"`
+	Name        string `json:"name"`
+	Stock       int    `json:"stock"`
+	PriceID     string `json:"price_id"`
+	Price       int64  `json:"price"` // unit price in paise
+	Category    string `json:"category"`
+	WeightGrams int64  `json:"weight_grams"` // weight of one unit
+}
+
+// ShippingRequest is the optional body of a single product checkout, it picks where the order is shipped
+type ShippingRequest struct {
+	ShippingRegion string `json:"shippingRegion,omitempty"`
+	AddressID      string `json:"addressId,omitempty"` // saved address of the user, overrides the shipping region
 }
 
 // Product Order request
 type CartOrderRequest struct {
-	LineItems []LineItem `json:"lineItems" binding:"required"`
+	LineItems      []LineItem `json:"lineItems" binding:"required"`
+	CouponCode     string     `json:"couponCode,omitempty"`
+	ShippingRegion string     `json:"shippingRegion,omitempty"`
+	AddressID      string     `json:"addressId,omitempty"` // saved address of the user, overrides the shipping region
 }
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"order-service/pkg/ctxmanage"
+	"order-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetOrderStatusInternal lets other services check whether an order got paid,
+// the product service uses it before releasing a cart stuck in checkout
+func (h *Handler) GetOrderStatusInternal(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	orderId := c.Param("orderId")
+	if orderId == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Order ID is required"})
+		return
+	}
+
+	status, err := h.o.GetOrderStatus(c.Request.Context(), orderId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+			return
+		}
+		slog.Error("error fetching order status", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch order status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"order_id": orderId, "status": status})
+}
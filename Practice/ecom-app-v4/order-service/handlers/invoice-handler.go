@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"order-service/internal/auth"
+	"order-service/internal/invoices"
+	"order-service/internal/orders"
+	"order-service/pkg/ctxmanage"
+	"order-service/pkg/logkey"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// issueInvoice issues the invoice of an order that just got paid, the notifications attach it to the order confirmation.
+// The payment is already recorded, a failure is only logged and the invoice is issued when it is first downloaded.
+func (h *Handler) issueInvoice(ctx context.Context, traceId, orderId string) {
+	inv, err := h.inv.IssueInvoice(ctx, orderId)
+	if err != nil {
+		slog.Error("error issuing invoice", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId),
+			slog.String(logkey.ERROR, err.Error()))
+		return
+	}
+	slog.Info("invoice issued", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId),
+		slog.String("InvoiceNumber", inv.Number))
+}
+
+// GetInvoice sends the PDF invoice of an order to its customer or an admin.
+// A paid order whose invoice was not issued yet gets it issued now.
+func (h *Handler) GetInvoice(c *gin.Context) {
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	inv, ok := h.invoice(c, true)
+	if !ok {
+		return
+	}
+
+	// invoices of other customers are reported as missing
+	if inv.UserID != claims.Subject && !claims.HasPermissions(auth.PermOrdersRead) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Invoice not found"})
+		return
+	}
+
+	sendPDF(c, inv)
+}
+
+// GetInvoiceInternal lets the product service attach the invoice to the confirmation email.
+// The email is sent for the order paid event, which can arrive before the webhook issued the invoice.
+func (h *Handler) GetInvoiceInternal(c *gin.Context) {
+	inv, ok := h.invoice(c, true)
+	if !ok {
+		return
+	}
+	sendPDF(c, inv)
+}
+
+// invoice loads the invoice of the order in the path, issuing it when issue is set and there is none yet
+func (h *Handler) invoice(c *gin.Context, issue bool) (invoices.Invoice, bool) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+	ctx := c.Request.Context()
+
+	orderId := c.Param("orderId")
+	if _, err := uuid.Parse(orderId); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Invoice not found"})
+		return invoices.Invoice{}, false
+	}
+
+	inv, err := h.inv.GetInvoice(ctx, orderId)
+	if errors.Is(err, invoices.ErrInvoiceNotFound) && issue {
+		inv, err = h.inv.IssueInvoice(ctx, orderId)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, invoices.ErrInvoiceNotFound), errors.Is(err, orders.ErrOrderNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Invoice not found"})
+		case errors.Is(err, invoices.ErrOrderNotPaid):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The order is not paid yet"})
+		default:
+			slog.Error("error fetching invoice", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch invoice"})
+		}
+		return invoices.Invoice{}, false
+	}
+	return inv, true
+}
+
+func sendPDF(c *gin.Context, inv invoices.Invoice) {
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, inv.Number))
+	c.Header("X-Invoice-Number", inv.Number)
+	c.Header("X-Invoice-Total", strconv.FormatInt(inv.Total, 10))
+	c.Data(http.StatusOK, "application/pdf", inv.PDF)
+}
@@ -3,22 +3,29 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"order-service/consul"
 	"order-service/internal/auth"
+	"order-service/internal/orders"
+	"order-service/internal/pricing"
+	"order-service/internal/promotions"
 	"order-service/pkg/ctxmanage"
 	"order-service/pkg/logkey"
 	"order-service/protohandler"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/stripe/stripe-go/v81"
 	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/coupon"
 )
 
 func (h *Handler) Checkout(c *gin.Context) {
@@ -36,11 +43,6 @@ func (h *Handler) Checkout(c *gin.Context) {
 	type UserServiceResponse struct {
 		StripCustomerId string `json:"stripe_customer_id"`
 	}
-	type ProductServiceResponse struct {
-		ProductID string `json:"product_id"`
-		Stock     int    `json:"stock"`
-		PriceID   string `json:"price_id"`
-	}
 
 	productID := c.Param("productID")
 	if productID == "" {
@@ -49,6 +51,16 @@ func (h *Handler) Checkout(c *gin.Context) {
 		return
 	}
 
+	// the body is optional, without it the order is shipped to the default region
+	var shipping ShippingRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&shipping); err != nil {
+			slog.Error("invalid request body", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			return
+		}
+	}
+
 	// Create channels for goroutine results
 	userChan := make(chan UserServiceResponse, 1) // For customer ID
 
@@ -65,7 +77,7 @@ func (h *Handler) Checkout(c *gin.Context) {
 			userChan <- UserServiceResponse{}
 			return
 		}
-		httpQuery := fmt.Sprintf("http://%s:%d/users/stripe", address, port)
+		httpQuery := fmt.Sprintf("http://%s:%d/users/internal/%s/stripe", address, port, claims.Subject)
 		slog.Info("httpQuery: "+httpQuery, slog.String(logkey.TraceID, traceId))
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 50*time.Second)
 		defer cancel()
@@ -75,9 +87,7 @@ func (h *Handler) Checkout(c *gin.Context) {
 			userChan <- UserServiceResponse{}
 			return
 		}
-		authorizationHeader := c.Request.Header.Get("Authorization")
-		req.Header.Set("Authorization", authorizationHeader)
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := h.st.Do(req)
 		if err != nil {
 			slog.Error("error fetching user service", slog.String(logkey.TraceID, traceId))
 			userChan <- UserServiceResponse{}
@@ -112,8 +122,14 @@ func (h *Handler) Checkout(c *gin.Context) {
 			productChan <- ProductServiceResponse{}
 			return
 		}
-		httpQuery := fmt.Sprintf("http://%s:%d/products/stock/%s", address, port, productID)
-		resp, err := http.Get(httpQuery)
+		httpQuery := fmt.Sprintf("http://%s:%d/products/internal/stock/%s", address, port, productID)
+		req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, httpQuery, nil)
+		if err != nil {
+			slog.Error("error creating request", slog.String(logkey.TraceID, traceId), slog.Any("error", err.Error()))
+			productChan <- ProductServiceResponse{}
+			return
+		}
+		resp, err := h.st.Do(req)
 		if err != nil {
 			slog.Error("error fetching product service", slog.String(logkey.TraceID, traceId))
 			productChan <- ProductServiceResponse{}
@@ -147,6 +163,15 @@ func (h *Handler) Checkout(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error fetching product information"})
 		return
 	}
+
+	shippingAddress, region, ok := h.shippingDestination(c, traceId, shipping.ShippingRegion, shipping.AddressID)
+	if !ok {
+		return
+	}
+	quote, ok := h.quoteCheckout(c, traceId, []pricing.Line{pricingLine(stockPriceData, 1)}, 0, region)
+	if !ok {
+		return
+	}
 	//c.JSON(http.StatusOK, gin.H{"customerId": userServiceResponse.StripCustomerId, "price_id": priceID, "stock": stock})
 
 	// Step 1: Retrieve the Stripe secret key from the environment variables
@@ -183,6 +208,8 @@ func (h *Handler) Checkout(c *gin.Context) {
 			},
 		},
 	}
+	addQuote(params, quote)
+	addShippingAddress(params, shippingAddress)
 
 	sessionStripe, err := session.New(params)
 	if err != nil {
@@ -197,11 +224,7 @@ func (h *Handler) Checkout(c *gin.Context) {
 	// Respond with the Stripe session ID
 	//c.JSON(http.StatusOK, gin.H{"checkout_session_id": sessionStripe.URL})
 	userId := claims.Subject
-	ctx := c.Request.Context()
-	err = h.o.CreateOrder(ctx, orderId, userId, productID, sessionStripe.AmountTotal)
-	if err != nil {
-		slog.Error("error creating order", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to create order"})
+	if !h.createQuotedOrder(c, traceId, orderId, userId, orders.Discount{}, quote, shippingAddress, sessionStripe) {
 		return
 	}
 	//hit server here
@@ -212,7 +235,7 @@ func (h *Handler) Checkout(c *gin.Context) {
 		return
 	}
 	// Respond with the Stripe session ID
-	c.JSON(http.StatusOK, gin.H{"checkout_session_id": sessionStripe.URL, "protoresp": protoresp})
+	c.JSON(http.StatusOK, gin.H{"checkout_session_id": sessionStripe.URL, "quote": quote, "protoresp": protoresp})
 }
 
 func (h *Handler) CheckoutWithGrpc(c *gin.Context) {
@@ -230,11 +253,6 @@ func (h *Handler) CheckoutWithGrpc(c *gin.Context) {
 	type UserServiceResponse struct {
 		StripCustomerId string `json:"stripe_customer_id"`
 	}
-	type ProductServiceResponse struct {
-		ProductID string `json:"product_id"`
-		Stock     int    `json:"stock"`
-		PriceID   string `json:"price_id"`
-	}
 
 	productID := c.Param("productID")
 	if productID == "" {
@@ -243,6 +261,16 @@ func (h *Handler) CheckoutWithGrpc(c *gin.Context) {
 		return
 	}
 
+	// the body is optional, without it the order is shipped to the default region
+	var shipping ShippingRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&shipping); err != nil {
+			slog.Error("invalid request body", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			return
+		}
+	}
+
 	// Create channels for goroutine results
 	userChan := make(chan UserServiceResponse, 1) // For customer ID
 
@@ -259,7 +287,7 @@ func (h *Handler) CheckoutWithGrpc(c *gin.Context) {
 			userChan <- UserServiceResponse{}
 			return
 		}
-		httpQuery := fmt.Sprintf("http://%s:%d/users/stripe", address, port)
+		httpQuery := fmt.Sprintf("http://%s:%d/users/internal/%s/stripe", address, port, claims.Subject)
 		slog.Info("httpQuery: "+httpQuery, slog.String(logkey.TraceID, traceId))
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 50*time.Second)
 		defer cancel()
@@ -269,9 +297,7 @@ func (h *Handler) CheckoutWithGrpc(c *gin.Context) {
 			userChan <- UserServiceResponse{}
 			return
 		}
-		authorizationHeader := c.Request.Header.Get("Authorization")
-		req.Header.Set("Authorization", authorizationHeader)
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := h.st.Do(req)
 		if err != nil {
 			slog.Error("error fetching user service", slog.String(logkey.TraceID, traceId))
 			userChan <- UserServiceResponse{}
@@ -311,10 +337,18 @@ func (h *Handler) CheckoutWithGrpc(c *gin.Context) {
 		slog.Info("successfully hit grpc and returned", slog.String(logkey.TraceID, traceId))
 		pr := protoresp.GetProdOrder()
 
-		slog.Info("successfully hit grpc and returned", slog.String(logkey.TraceID, traceId), slog.String("product data", fmt.Sprintf("pr", pr)))
+		slog.Info("successfully hit grpc and returned", slog.String(logkey.TraceID, traceId), slog.String("product data", fmt.Sprintf("%v", pr)))
 
 		fmt.Println(int(pr.GetStock()), pr.GetPriceId())
-		productServiceResponse := ProductServiceResponse{ProductID: productID, Stock: int(pr.GetStock()), PriceID: pr.GetPriceId()}
+		productServiceResponse := ProductServiceResponse{
+			ProductID:   productID,
+			Name:        pr.GetName(),
+			Stock:       int(pr.GetStock()),
+			PriceID:     pr.GetPriceId(),
+			Price:       pr.GetPrice(),
+			Category:    pr.GetCategory(),
+			WeightGrams: pr.GetWeightGrams(),
+		}
 		fmt.Println(productServiceResponse)
 		productChan <- productServiceResponse
 	}()
@@ -346,6 +380,15 @@ func (h *Handler) CheckoutWithGrpc(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error fetching product information"})
 		return
 	}
+
+	shippingAddress, region, ok := h.shippingDestination(c, traceId, shipping.ShippingRegion, shipping.AddressID)
+	if !ok {
+		return
+	}
+	quote, ok := h.quoteCheckout(c, traceId, []pricing.Line{pricingLine(stockPriceData, 1)}, 0, region)
+	if !ok {
+		return
+	}
 	fmt.Println("******************************** 349 **************************************")
 
 	//c.JSON(http.StatusOK, gin.H{"customerId": userServiceResponse.StripCustomerId, "price_id": priceID, "stock": stock})
@@ -384,6 +427,8 @@ func (h *Handler) CheckoutWithGrpc(c *gin.Context) {
 			},
 		},
 	}
+	addQuote(params, quote)
+	addShippingAddress(params, shippingAddress)
 
 	sessionStripe, err := session.New(params)
 	if err != nil {
@@ -398,15 +443,11 @@ func (h *Handler) CheckoutWithGrpc(c *gin.Context) {
 	// Respond with the Stripe session ID
 	//c.JSON(http.StatusOK, gin.H{"checkout_session_id": sessionStripe.URL})
 	userId := claims.Subject
-	ctx := c.Request.Context()
-	err = h.o.CreateOrder(ctx, orderId, userId, productID, sessionStripe.AmountTotal)
-	if err != nil {
-		slog.Error("error creating order", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to create order"})
+	if !h.createQuotedOrder(c, traceId, orderId, userId, orders.Discount{}, quote, shippingAddress, sessionStripe) {
 		return
 	}
 	// Respond with the Stripe session ID
-	c.JSON(http.StatusOK, gin.H{"checkout_session_id": sessionStripe.URL})
+	c.JSON(http.StatusOK, gin.H{"checkout_session_id": sessionStripe.URL, "quote": quote})
 }
 
 func (h *Handler) CartCheckout(c *gin.Context) {
@@ -472,7 +513,7 @@ func (h *Handler) CartCheckout(c *gin.Context) {
 			userChan <- UserServiceResponse{}
 			return
 		}
-		httpQuery := fmt.Sprintf("http://%s:%d/users/stripe", address, port)
+		httpQuery := fmt.Sprintf("http://%s:%d/users/internal/%s/stripe", address, port, claims.Subject)
 		slog.Info("httpQuery: "+httpQuery, slog.String(logkey.TraceID, traceId))
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 50*time.Second)
 		defer cancel()
@@ -482,9 +523,7 @@ func (h *Handler) CartCheckout(c *gin.Context) {
 			userChan <- UserServiceResponse{}
 			return
 		}
-		authorizationHeader := c.Request.Header.Get("Authorization")
-		req.Header.Set("Authorization", authorizationHeader)
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := h.st.Do(req)
 		if err != nil {
 			slog.Error("error fetching user service", slog.String(logkey.TraceID, traceId))
 			userChan <- UserServiceResponse{}
@@ -531,11 +570,18 @@ func (h *Handler) CartCheckout(c *gin.Context) {
 			return
 		}
 
-		httpURL := fmt.Sprintf("http://%s:%d/products/stock", address, port)
+		httpURL := fmt.Sprintf("http://%s:%d/products/internal/stock", address, port)
 
 		// Make the HTTP POST request
 		fmt.Println(string(requestBody))
-		resp, err := http.Post(httpURL, "application/json", bytes.NewBuffer(requestBody))
+		req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, httpURL, bytes.NewBuffer(requestBody))
+		if err != nil {
+			slog.Error("error creating request", slog.String(logkey.TraceID, traceId), slog.Any("error", err.Error()))
+			productChan <- nil
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := h.st.Do(req)
 		if err != nil {
 			slog.Error("error fetching product service",
 				slog.String(logkey.TraceID, traceId),
@@ -573,6 +619,8 @@ func (h *Handler) CartCheckout(c *gin.Context) {
 		return
 	}
 	var lineItems []*stripe.CheckoutSessionLineItemParams
+	var couponLines []promotions.CartLine
+	var pricingLines []pricing.Line
 
 	for _, stockVal := range stockData {
 		priceID := stockVal.PriceID
@@ -589,8 +637,56 @@ func (h *Handler) CartCheckout(c *gin.Context) {
 			//Quantity: stripe.Int64(stockVal.Quantity),
 			Quantity: stripe.Int64(int64(productMap[productID].Quantity)),
 		})
+		couponLines = append(couponLines, promotions.CartLine{
+			ProductID: productID,
+			Category:  stockVal.Category,
+			UnitPrice: stockVal.Price,
+			Quantity:  int64(productMap[productID].Quantity),
+		})
+		pricingLines = append(pricingLines, pricingLine(stockVal, int64(productMap[productID].Quantity)))
 		//create metadata
 	}
+
+	// the coupon rules are checked against the current prices before stripe is involved
+	var discount orders.Discount
+	if req.CouponCode != "" {
+		coupon, err := h.pr.GetCouponByCode(c.Request.Context(), req.CouponCode)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid coupon code"})
+				return
+			}
+			slog.Error("error fetching coupon", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to apply coupon"})
+			return
+		}
+
+		userRedemptions, err := h.pr.CountUserRedemptions(c.Request.Context(), coupon.ID, claims.Subject)
+		if err != nil {
+			slog.Error("error counting coupon redemptions", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to apply coupon"})
+			return
+		}
+
+		amount, err := coupon.Discount(couponLines, userRedemptions, time.Now().UTC())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		discount = orders.Discount{CouponID: coupon.ID, Amount: amount}
+	}
+
+	// a saved address decides the shipping region and is copied onto the order
+	shippingAddress, region, ok := h.shippingDestination(c, traceId, req.ShippingRegion, req.AddressID)
+	if !ok {
+		return
+	}
+
+	// the quote is what the order is stored with, stripe is only told about the same amounts
+	quote, ok := h.quoteCheckout(c, traceId, pricingLines, discount.Amount, region)
+	if !ok {
+		return
+	}
 	//c.JSON(http.StatusOK, gin.H{"customerId": userServiceResponse.StripCustomerId, "price_id": priceID, "stock": stock})
 
 	// Step 1: Retrieve the Stripe secret key from the environment variables
@@ -603,6 +699,27 @@ func (h *Handler) CartCheckout(c *gin.Context) {
 	// Step 2: Assign the Stripe API key to the Stripe library's internal configuration
 	stripe.Key = sKey
 
+	// The discount is applied as a one off stripe coupon for the exact amount we calculated,
+	// this way product and category targeting stay in our hands and the stripe total matches our order
+	var sessionDiscounts []*stripe.CheckoutSessionDiscountParams
+	if discount.Amount > 0 {
+		stripeCoupon, err := coupon.New(&stripe.CouponParams{
+			AmountOff:      stripe.Int64(discount.Amount),
+			Currency:       stripe.String(string(stripe.CurrencyINR)),
+			Duration:       stripe.String(string(stripe.CouponDurationOnce)),
+			MaxRedemptions: stripe.Int64(1),
+			Name:           stripe.String(strings.ToUpper(req.CouponCode)),
+		})
+		if err != nil {
+			slog.Error("error creating Stripe coupon", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to apply coupon"})
+			return
+		}
+		sessionDiscounts = append(sessionDiscounts, &stripe.CheckoutSessionDiscountParams{
+			Coupon: stripe.String(stripeCoupon.ID),
+		})
+	}
+
 	// Convert struct to JSON string
 	reqJSON, err := json.Marshal(req)
 	if err != nil {
@@ -624,19 +741,24 @@ func (h *Handler) CartCheckout(c *gin.Context) {
 		Currency:                 stripe.String(string(stripe.CurrencyINR)),
 		BillingAddressCollection: stripe.String("auto"),
 		LineItems:                lineItems,
+		Discounts:                sessionDiscounts,
 		Mode:                     stripe.String(string(stripe.CheckoutSessionModePayment)),
 		SuccessURL:               stripe.String("https://example.com/success"),
 		//ExpiresAt:
 		CancelURL: stripe.String("https://example.com/cancel"),
 		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{
 			Metadata: map[string]string{
-				"order_id": orderId,
-				"user_id":  claims.Subject, // userID in jwt token
-				"products": string(reqJSON),
+				"order_id":    orderId,
+				"user_id":     claims.Subject, // userID in jwt token
+				"products":    string(reqJSON),
+				"coupon_code": strings.ToUpper(req.CouponCode),
 			},
 		},
 	}
 
+	addQuote(params, quote)
+	addShippingAddress(params, shippingAddress)
+
 	sessionStripe, err := session.New(params)
 	if err != nil {
 		slog.Error("error creating Stripe checkout session", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
@@ -653,19 +775,13 @@ func (h *Handler) CartCheckout(c *gin.Context) {
 	// Respond with the Stripe session ID
 	//c.JSON(http.StatusOK, gin.H{"checkout_session_id": sessionStripe.URL})
 	userId := claims.Subject
-	ctx := c.Request.Context()
 	//TODO chnge string(reqJSON) for productId
-	fmt.Println(orderId, userId, productIds[0], sessionStripe.AmountTotal)
-	err = h.o.CreateOrder(ctx, orderId, userId, productIds[0], sessionStripe.AmountTotal)
-	if err != nil {
-		fmt.Println(err)
-		slog.Error("error creating order", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to create order"})
+	if !h.createQuotedOrder(c, traceId, orderId, userId, discount, quote, shippingAddress, sessionStripe) {
 		return
 	}
 
 	// Respond with the Stripe session ID
-	c.JSON(http.StatusOK, gin.H{"checkout_session_id": sessionStripe.URL})
+	c.JSON(http.StatusOK, gin.H{"checkout_session_id": sessionStripe.URL, "quote": quote})
 }
 
 /*
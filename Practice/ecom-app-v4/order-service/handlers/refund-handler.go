@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"order-service/internal/auth"
+	"order-service/internal/orders"
+	"order-service/internal/stores/kafka"
+	"order-service/pkg/ctxmanage"
+	"order-service/pkg/logkey"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/refund"
+)
+
+// CancelRequest is the optional body of a cancellation
+type CancelRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// RefundRequest lets an admin refund part or all of what is left of the payment
+type RefundRequest struct {
+	Amount  int64  `json:"amount" binding:"omitempty,min=1"` // in paise, empty refunds everything left
+	Reason  string `json:"reason" binding:"max=500"`
+	Restock bool   `json:"restock"` // put the products back in stock, e.g. when they came back to us, only with a full refund
+}
+
+// idempotencyKeyHeader carries the client request id of a refund, a retried request with the same id refunds once
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// CancelOrder lets the customer or an admin cancel an order that is not shipped yet.
+// A paid order gets whatever is left of its payment refunded and its products restocked,
+// the checkout session of an unpaid order is expired so it can not be paid anymore.
+func (h *Handler) CancelOrder(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	var req CancelRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			slog.Error("invalid request body", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			return
+		}
+	}
+
+	payment, ok := h.orderPayment(c)
+	if !ok {
+		return
+	}
+
+	// orders of other customers are reported as missing
+	if payment.UserID != claims.Subject && !claims.HasPermissions(auth.PermOrdersManage) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+		return
+	}
+
+	if !orders.CanTransition(payment.Status, orders.StatusCanceled) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("An order that is %s can not be canceled", payment.Status)})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// nothing was paid and no stock was taken yet, the session must not take a payment after the cancellation
+	if payment.Status == orders.StatusPending {
+		if !h.expireCheckoutSession(c, payment.CheckoutSessionID) {
+			return
+		}
+		h.transitionOrder(c, orders.StatusChange{Status: orders.StatusCanceled, Note: req.Reason})
+		return
+	}
+
+	// an order is canceled once, so the order id alone keeps a retried cancellation from refunding twice
+	changed, ok := h.refund(c, payment, orders.Refund{
+		OrderID:        payment.ID,
+		IdempotencyKey: payment.ID + ":cancel",
+		Reason:         req.Reason,
+		CreatedBy:      claims.Subject,
+	}, true, orders.StatusCanceled)
+	if !ok {
+		return
+	}
+
+	h.publishRestock(ctx, traceId, payment.ID, orders.StatusCanceled, nil)
+	c.JSON(http.StatusOK, gin.H{"order_id": payment.ID, "status": changed.Status})
+}
+
+// RefundOrder lets an admin refund a paid order in full or in part.
+// A full refund moves the order to refunded, a partial one leaves the status as it is.
+// The Idempotency-Key header is required, a retried request with the same key refunds once.
+func (h *Handler) RefundOrder(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	requestId := c.GetHeader(idempotencyKeyHeader)
+	if requestId == "" || len(requestId) > 100 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "An Idempotency-Key header of at most 100 characters is required"})
+		return
+	}
+
+	var req RefundRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			slog.Error("invalid request body", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			return
+		}
+	}
+
+	payment, ok := h.orderPayment(c)
+	if !ok {
+		return
+	}
+
+	if payment.Status == orders.StatusCanceled {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The order has nothing left to refund"})
+		return
+	}
+
+	status, ok := refundStatus(req, payment.Refundable())
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Only a full refund can restock the order, use a return for some of its items"})
+		return
+	}
+
+	changed, ok := h.refund(c, payment, orders.Refund{
+		OrderID:        payment.ID,
+		IdempotencyKey: payment.ID + ":refund:" + requestId,
+		Amount:         req.Amount,
+		Reason:         req.Reason,
+		CreatedBy:      claims.Subject,
+	}, false, status)
+	if !ok {
+		return
+	}
+
+	if req.Restock {
+		h.publishRestock(c.Request.Context(), traceId, payment.ID, orders.StatusRefunded, nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"order_id": payment.ID, "status": changed.Status, "refunded": changed.Refunded})
+}
+
+// refundStatus returns the status the refund moves the order to, empty for a partial refund, and whether the
+// refund is allowed. Only a refund of everything left refunds every item, items of a partial refund go through a return.
+func refundStatus(req RefundRequest, refundable int64) (string, bool) {
+	full := req.Amount == 0 || req.Amount == refundable
+	if req.Restock && !full {
+		return "", false
+	}
+	if full {
+		return orders.StatusRefunded, true
+	}
+	return "", true
+}
+
+// orderPayment loads the payment details of the order in the path
+func (h *Handler) orderPayment(c *gin.Context) (orders.OrderPayment, bool) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	orderId := c.Param("orderId")
+	if _, err := uuid.Parse(orderId); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+		return orders.OrderPayment{}, false
+	}
+
+	payment, err := h.o.GetOrderPayment(c.Request.Context(), orderId)
+	if err != nil {
+		if errors.Is(err, orders.ErrOrderNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+			return orders.OrderPayment{}, false
+		}
+		slog.Error("error fetching order", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch order"})
+		return orders.OrderPayment{}, false
+	}
+	return payment, true
+}
+
+// expireCheckoutSession expires the stripe checkout session of a pending order. A session that is
+// already expired is fine, one that completed is about to pay the order, so it can not be canceled.
+func (h *Handler) expireCheckoutSession(c *gin.Context, sessionId string) bool {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	// orders from before sessions were stored, or whose session was never created
+	if sessionId == "" {
+		return true
+	}
+
+	sKey := os.Getenv("STRIPE_TEST_KEY")
+	if sKey == "" {
+		slog.Error("Stripe secret key not found", slog.String(logkey.TraceID, traceId))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Stripe secret key not found"})
+		return false
+	}
+	stripe.Key = sKey
+
+	_, err := session.Expire(sessionId, nil)
+	if err == nil {
+		return true
+	}
+
+	// only an open session can be expired, find out what happened to it
+	s, getErr := session.Get(sessionId, nil)
+	if getErr != nil {
+		slog.Error("error expiring Stripe checkout session", slog.String(logkey.TraceID, traceId),
+			slog.String("CheckoutSessionID", sessionId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"message": "Failed to cancel the checkout"})
+		return false
+	}
+	if s.Status == stripe.CheckoutSessionStatusExpired {
+		return true
+	}
+
+	c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The order is being paid, cancel it once the payment went through"})
+	return false
+}
+
+// refund reserves the refund, refunds it on stripe and moves the order to status when it is set.
+// A capped refund of an order with nothing left to refund, e.g. a fully discounted one, only changes the status.
+// A reserved refund stripe gave no answer for is kept, the request can be retried with the same idempotency key.
+func (h *Handler) refund(c *gin.Context, payment orders.OrderPayment, req orders.Refund, capped bool, status string) (orders.StatusChanged, bool) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+	ctx := c.Request.Context()
+
+	req.ID = uuid.NewString()
+	req.CreatedAt = time.Now().UTC()
+	reserved, err := h.o.ReserveRefund(ctx, req, capped)
+	if err != nil {
+		switch {
+		case errors.Is(err, orders.ErrNothingToRefund) && capped:
+			return h.refundStatusOnly(c, payment, req, status)
+		case errors.Is(err, orders.ErrNothingToRefund):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The order has nothing left to refund"})
+		case errors.Is(err, orders.ErrRefundTooLarge):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "The amount is more than is left of the payment"})
+		case errors.Is(err, orders.ErrRefundKeyReused):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The idempotency key was used for another order"})
+		default:
+			slog.Error("error reserving refund", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to refund the payment"})
+		}
+		return orders.StatusChanged{}, false
+	}
+
+	if reserved.Status == orders.RefundReserved {
+		reserved, err = h.issueRefund(ctx, traceId, payment.StripeTransactionID, reserved)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"message": "Failed to refund the payment"})
+			return orders.StatusChanged{}, false
+		}
+	}
+
+	changed, err := h.o.CompleteRefund(ctx, reserved, status)
+	if err != nil {
+		// the money is on its way back and the refund stays reserved, retrying with the same key records it
+		slog.Error("error recording refund", slog.String(logkey.TraceID, traceId), slog.String("StripeRefundID", reserved.StripeRefundID),
+			slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Refund issued but could not be recorded"})
+		return orders.StatusChanged{}, false
+	}
+	changed.Refunded = reserved.Amount
+
+	if changed.Status != changed.FromStatus {
+		h.publishStatusChanged(traceId, changed)
+	}
+	return changed, true
+}
+
+// issueRefund asks stripe for a reserved refund and returns it with the answer of stripe.
+// A refund stripe refused is released again, without an answer it stays reserved since it may have gone through.
+func (h *Handler) issueRefund(ctx context.Context, traceId, paymentIntentId string, reserved orders.Refund) (orders.Refund, error) {
+	sKey := os.Getenv("STRIPE_TEST_KEY")
+	if sKey == "" {
+		slog.Error("Stripe secret key not found", slog.String(logkey.TraceID, traceId))
+		return orders.Refund{}, errors.New("stripe secret key not found")
+	}
+	stripe.Key = sKey
+
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentId),
+		Amount:        stripe.Int64(reserved.Amount),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+		Metadata: map[string]string{
+			"order_id":  reserved.OrderID,
+			"refund_id": reserved.ID,
+		},
+	}
+	// a retried request sends the same key, stripe answers with the refund it already made
+	params.SetIdempotencyKey(reserved.IdempotencyKey)
+
+	stripeRefund, err := refund.New(params)
+	if err != nil {
+		slog.Error("error creating Stripe refund", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		var stripeErr *stripe.Error
+		if errors.As(err, &stripeErr) && stripeErr.HTTPStatusCode >= 400 && stripeErr.HTTPStatusCode < 500 &&
+			stripeErr.Type != stripe.ErrorTypeIdempotency {
+			if err := h.o.ReleaseRefund(ctx, reserved.ID); err != nil {
+				slog.Error("error releasing refund", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			}
+		}
+		return orders.Refund{}, err
+	}
+
+	reserved.StripeRefundID = stripeRefund.ID
+	reserved.Status = string(stripeRefund.Status)
+	slog.Info("refund issued", slog.String(logkey.TraceID, traceId), slog.String("OrderID", reserved.OrderID),
+		slog.String("StripeRefundID", stripeRefund.ID), slog.Int64("Amount", reserved.Amount))
+	return reserved, nil
+}
+
+// refundStatusOnly moves an order with nothing left to refund to status, when the status allows it
+func (h *Handler) refundStatusOnly(c *gin.Context, payment orders.OrderPayment, req orders.Refund, status string) (orders.StatusChanged, bool) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	if status == "" || !orders.CanTransition(payment.Status, status) {
+		return orders.StatusChanged{OrderID: payment.ID, FromStatus: payment.Status, Status: payment.Status}, true
+	}
+
+	changed, err := h.o.TransitionOrder(c.Request.Context(), payment.ID, orders.StatusChange{Status: status, Note: req.Reason, ChangedBy: req.CreatedBy})
+	if err != nil {
+		if errors.Is(err, orders.ErrInvalidTransition) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": errors.Unwrap(err).Error()})
+			return orders.StatusChanged{}, false
+		}
+		slog.Error("error updating order status", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to update order status"})
+		return orders.StatusChanged{}, false
+	}
+	h.publishStatusChanged(traceId, changed)
+	return changed, true
+}
+
+// publishRestock asks the product service to put items of the order back in stock, nil items restock the
+// whole order. Items restocked before are left out, a retried request does not restock them again.
+func (h *Handler) publishRestock(ctx context.Context, traceId, orderId, reason string, items []orders.Item) {
+	err := h.o.RestockItems(ctx, orderId, items, func(items []orders.Item) error {
+		event := kafka.OrderRestockEvent{OrderId: orderId, Reason: reason, CreatedAt: time.Now().UTC()}
+		for _, item := range items {
+			event.Items = append(event.Items, kafka.RestockItemEvent{ProductId: item.ProductID, Quantity: item.Quantity})
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal order restock event: %w", err)
+		}
+		return h.k.ProduceMessage(kafka.TopicOrderRestock, []byte(orderId), data)
+	})
+	if err != nil {
+		slog.Error("error restocking order", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId),
+			slog.String(logkey.ERROR, err.Error()))
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"order-service/internal/auth"
+	"order-service/internal/orders"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRefundStatus(t *testing.T) {
+	tt := [...]struct {
+		name       string
+		req        RefundRequest
+		refundable int64
+		status     string
+		ok         bool
+	}{
+		{"everything left", RefundRequest{}, 5000, orders.StatusRefunded, true},
+		{"exactly what is left", RefundRequest{Amount: 5000}, 5000, orders.StatusRefunded, true},
+		{"part of it", RefundRequest{Amount: 2000}, 5000, "", true},
+		{"full refund with restock", RefundRequest{Restock: true}, 5000, orders.StatusRefunded, true},
+		{"partial refund with restock", RefundRequest{Amount: 2000, Restock: true}, 5000, "", false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			status, ok := refundStatus(tc.req, tc.refundable)
+			if status != tc.status || ok != tc.ok {
+				t.Errorf("expected (%q, %v), got (%q, %v)", tc.status, tc.ok, status, ok)
+			}
+		})
+	}
+}
+
+// the requests are refused before the order is loaded, so the handler needs no store
+func TestCancelAndRefundRefused(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{}
+	const orderId = "0b6f5c1e-6a43-4d59-9a0e-3f8a7f1f2a11"
+
+	tests := []struct {
+		name    string
+		path    string
+		route   string
+		handler gin.HandlerFunc
+		key     string
+		body    string
+		noLogin bool
+		want    int
+	}{
+		{name: "cancel without a login", path: "/orders/" + orderId + "/cancel", route: "/orders/:orderId/cancel", handler: h.CancelOrder, noLogin: true, want: http.StatusUnauthorized},
+		{name: "cancel with a malformed body", path: "/orders/" + orderId + "/cancel", route: "/orders/:orderId/cancel", handler: h.CancelOrder, body: `{"reason": 1}`, want: http.StatusBadRequest},
+		{name: "cancel a malformed order id", path: "/orders/1;drop/cancel", route: "/orders/:orderId/cancel", handler: h.CancelOrder, want: http.StatusNotFound},
+		{name: "refund without a login", path: "/orders/" + orderId + "/refund", route: "/orders/:orderId/refund", handler: h.RefundOrder, key: "k1", noLogin: true, want: http.StatusUnauthorized},
+		{name: "refund without an idempotency key", path: "/orders/" + orderId + "/refund", route: "/orders/:orderId/refund", handler: h.RefundOrder, want: http.StatusBadRequest},
+		{name: "refund with a too long idempotency key", path: "/orders/" + orderId + "/refund", route: "/orders/:orderId/refund", handler: h.RefundOrder, key: strings.Repeat("k", 101), want: http.StatusBadRequest},
+		{name: "refund of a negative amount", path: "/orders/" + orderId + "/refund", route: "/orders/:orderId/refund", handler: h.RefundOrder, key: "k1", body: `{"amount": -5}`, want: http.StatusBadRequest},
+		{name: "refund a malformed order id", path: "/orders/1;drop/refund", route: "/orders/:orderId/refund", handler: h.RefundOrder, key: "k1", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.POST(tt.route, tt.handler)
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set(idempotencyKeyHeader, tt.key)
+			}
+			if !tt.noLogin {
+				var claims auth.Claims
+				claims.Subject = "5d0c7a43-1f3e-4c2b-8a6d-2b9e4f1c7d80"
+				req = req.WithContext(context.WithValue(req.Context(), auth.ClaimsKey, claims))
+			}
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"order-service/internal/auth"
+	"order-service/internal/orders"
+	"order-service/internal/stores/kafka"
+	"order-service/pkg/ctxmanage"
+	"order-service/pkg/logkey"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ReturnReviewRequest is the optional note an admin leaves when approving or rejecting a return
+type ReturnReviewRequest struct {
+	Note string `json:"note" binding:"max=500"`
+}
+
+// CreateReturn lets a customer send back lines of a delivered order
+func (h *Handler) CreateReturn(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	orderId := c.Param("orderId")
+	if _, err := uuid.Parse(orderId); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+		return
+	}
+
+	var req orders.NewReturn
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("invalid request body", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+
+	ret, err := h.o.CreateReturn(c.Request.Context(), orderId, claims.Subject, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, orders.ErrOrderNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+		case errors.Is(err, orders.ErrReturnNotAllowed), errors.Is(err, orders.ErrReturnWindowClosed):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": errors.Unwrap(err).Error()})
+		case errors.Is(err, orders.ErrInvalidReturnItems):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": orders.ErrInvalidReturnItems.Error()})
+		default:
+			slog.Error("error creating return", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to create return"})
+		}
+		return
+	}
+
+	slog.Info("return requested", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId),
+		slog.String("ReturnID", ret.ID), slog.Int64("RefundAmount", ret.RefundAmount))
+
+	h.publishReturnStatusChanged(traceId, ret)
+	c.JSON(http.StatusCreated, ret)
+}
+
+// ListOrderReturns returns the returns of an order to its customer or an admin
+func (h *Handler) ListOrderReturns(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	payment, ok := h.orderPayment(c)
+	if !ok {
+		return
+	}
+
+	// orders of other customers are reported as missing
+	if payment.UserID != claims.Subject && !claims.HasPermissions(auth.PermOrdersRead) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Order not found"})
+		return
+	}
+
+	returns, err := h.o.ListReturns(c.Request.Context(), payment.ID, "")
+	if err != nil {
+		slog.Error("error fetching returns", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch returns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, returns)
+}
+
+// ListReturns lets an admin work through the returns in a status, requested ones by default, oldest first
+func (h *Handler) ListReturns(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	status := c.DefaultQuery("status", orders.ReturnRequested)
+	switch status {
+	case orders.ReturnRequested, orders.ReturnApproved, orders.ReturnRejected, orders.ReturnReceived, orders.ReturnRefunded:
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Unknown return status"})
+		return
+	}
+
+	returns, err := h.o.ListReturns(c.Request.Context(), "", status)
+	if err != nil {
+		slog.Error("error fetching returns", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch returns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, returns)
+}
+
+// ApproveReturn lets an admin accept a requested return, the customer can then send the items back
+func (h *Handler) ApproveReturn(c *gin.Context) {
+	h.reviewReturn(c, orders.ReturnApproved)
+}
+
+// RejectReturn lets an admin turn down a requested return
+func (h *Handler) RejectReturn(c *gin.Context) {
+	h.reviewReturn(c, orders.ReturnRejected)
+}
+
+func (h *Handler) reviewReturn(c *gin.Context, status string) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var req ReturnReviewRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			slog.Error("invalid request body", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			return
+		}
+	}
+
+	ret, ok := h.transitionReturn(c, status, req.Note)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, ret)
+}
+
+// ReceiveReturn lets an admin confirm the items of an approved return arrived.
+// What the customer paid for them is refunded and the items go back in stock.
+// Calling it again for a received return that is not refunded retries the refund, the refund is keyed
+// by the return so it is only made once.
+func (h *Handler) ReceiveReturn(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	returnId := c.Param("returnId")
+	if _, err := uuid.Parse(returnId); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Return not found"})
+		return
+	}
+
+	ret, moved, err := h.o.ReceiveReturn(c.Request.Context(), returnId)
+	if err != nil {
+		h.returnError(c, traceId, err)
+		return
+	}
+	if moved {
+		h.publishReturnStatusChanged(traceId, ret)
+	}
+
+	payment, err := h.o.GetOrderPayment(c.Request.Context(), ret.OrderID)
+	if err != nil {
+		slog.Error("error fetching order", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch order"})
+		return
+	}
+
+	// earlier refunds of the order may have already paid some of it back, at most what is left is refunded
+	changed, ok := h.refund(c, payment, orders.Refund{
+		OrderID:        ret.OrderID,
+		ReturnID:       ret.ID,
+		IdempotencyKey: "return:" + ret.ID,
+		Amount:         ret.RefundAmount,
+		Reason:         "return " + ret.ID,
+		CreatedBy:      claims.Subject,
+	}, true, orders.StatusReturned)
+	if !ok {
+		return
+	}
+	amount := changed.Refunded
+
+	// only the request that moves the return to refunded puts its items back in stock
+	ret, err = h.o.TransitionReturn(c.Request.Context(), ret.ID, orders.ReturnRefunded, "")
+	if err != nil {
+		if errors.Is(err, orders.ErrInvalidReturnTransition) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The return was refunded already"})
+			return
+		}
+		// the refund went through, only the return is left behind in received
+		slog.Error("error marking return refunded", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Refund issued but the return could not be updated"})
+		return
+	}
+
+	items := make([]orders.Item, 0, len(ret.Items))
+	for _, item := range ret.Items {
+		items = append(items, orders.Item{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	h.publishRestock(c.Request.Context(), traceId, ret.OrderID, orders.StatusReturned, items)
+
+	slog.Info("return refunded", slog.String(logkey.TraceID, traceId), slog.String("OrderID", ret.OrderID),
+		slog.String("ReturnID", ret.ID), slog.Int64("Amount", amount))
+
+	h.publishReturnStatusChanged(traceId, ret)
+	c.JSON(http.StatusOK, gin.H{"return": ret, "refunded": amount})
+}
+
+// transitionReturn moves the return in the path to status and tells the customer about it
+func (h *Handler) transitionReturn(c *gin.Context, status, note string) (orders.Return, bool) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	returnId := c.Param("returnId")
+	if _, err := uuid.Parse(returnId); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Return not found"})
+		return orders.Return{}, false
+	}
+
+	ret, err := h.o.TransitionReturn(c.Request.Context(), returnId, status, note)
+	if err != nil {
+		h.returnError(c, traceId, err)
+		return orders.Return{}, false
+	}
+
+	slog.Info("return status changed", slog.String(logkey.TraceID, traceId), slog.String("ReturnID", returnId),
+		slog.String("Status", ret.Status))
+
+	h.publishReturnStatusChanged(traceId, ret)
+	return ret, true
+}
+
+// returnError answers a failed change of a return status
+func (h *Handler) returnError(c *gin.Context, traceId string, err error) {
+	switch {
+	case errors.Is(err, orders.ErrReturnNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Return not found"})
+	case errors.Is(err, orders.ErrInvalidReturnTransition):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": errors.Unwrap(err).Error()})
+	default:
+		slog.Error("error updating return status", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to update return status"})
+	}
+}
+
+// publishReturnStatusChanged tells the product service about the return so the customer gets an email.
+// The change is already committed, a failed publish is only logged.
+func (h *Handler) publishReturnStatusChanged(traceId string, ret orders.Return) {
+	data, err := json.Marshal(kafka.ReturnStatusChangedEvent{
+		ReturnId:     ret.ID,
+		OrderId:      ret.OrderID,
+		UserId:       ret.UserID,
+		Status:       ret.Status,
+		Note:         ret.AdminNote,
+		RefundAmount: ret.RefundAmount,
+		CreatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Error("error marshaling return status changed event", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		return
+	}
+
+	err = h.k.ProduceMessage(kafka.TopicReturnStatusChanged, []byte(ret.OrderID), data)
+	if err != nil {
+		slog.Error("error producing return status changed event", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+	}
+}
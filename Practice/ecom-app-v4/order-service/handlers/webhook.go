@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -56,9 +57,15 @@ func (h *Handler) Webhook(c *gin.Context) {
 		if productID != "" {
 			slog.Info("Metadata received", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId), slog.String("UserID", userID), slog.String("ProductID", productID))
 
+			// stock is only taken for an order that really became paid
+			if !h.markPaid(c, traceId, orderId, paymentIntent.ID) {
+				return
+			}
+
 			go func() {
 				jsonData, err := json.Marshal(kafka.OrderPaidEvent{
 					OrderId:   orderId,
+					UserId:    userID,
 					ProductId: productID,
 					Quantity:  1,
 					CreatedAt: time.Now().UTC(),
@@ -77,11 +84,7 @@ func (h *Handler) Webhook(c *gin.Context) {
 				slog.Info("Message produced", slog.Any("data", string(jsonData)))
 			}()
 			ctx := c.Request.Context()
-			err = h.o.UpdateOrder(ctx, orderId, orders.StatusPaid, paymentIntent.ID)
-			if err != nil {
-				slog.Error("Failed to update order", slog.Any("error", err.Error()))
-				return
-			}
+			h.issueInvoice(ctx, traceId, orderId)
 		} else if products != "" {
 			// Unmarshal the 'products' JSON string into the CartOrderRequest struct
 			var cartOrder CartOrderRequest
@@ -94,6 +97,11 @@ func (h *Handler) Webhook(c *gin.Context) {
 				return
 			}
 
+			// the order and its coupon redemption are updated once for the whole cart
+			if !h.markPaid(c, traceId, orderId, paymentIntent.ID) {
+				return
+			}
+
 			// Log the products (line items) details
 			for _, item := range cartOrder.LineItems {
 
@@ -102,6 +110,7 @@ func (h *Handler) Webhook(c *gin.Context) {
 				go func() {
 					jsonData, err := json.Marshal(kafka.OrderPaidEvent{
 						OrderId:   orderId,
+						UserId:    userID,
 						ProductId: item.ProductId,
 						Quantity:  int(item.Quantity),
 						CreatedAt: time.Now().UTC(),
@@ -119,14 +128,11 @@ func (h *Handler) Webhook(c *gin.Context) {
 					}
 					slog.Info("Message produced", slog.Any("data", string(jsonData)))
 				}()
-				ctx := c.Request.Context()
-				err = h.o.UpdateOrder(ctx, orderId, orders.StatusPaid, paymentIntent.ID)
-				if err != nil {
-					slog.Error("Failed to update order", slog.Any("error", err.Error()))
-					return
-				}
 			}
 
+			ctx := c.Request.Context()
+			h.issueInvoice(ctx, traceId, orderId)
+
 		} else {
 			// If neither productId nor products exists, return an error
 			slog.Error("No product information found", slog.String("OrderID", orderId), slog.String("UserID", userID))
@@ -138,3 +144,75 @@ func (h *Handler) Webhook(c *gin.Context) {
 		c.Status(http.StatusOK)
 	}
 }
+
+// markPaid marks the order paid and reports whether it is to be fulfilled. The payment of an order
+// that was canceled before it was paid is refunded in full instead.
+func (h *Handler) markPaid(c *gin.Context, traceId, orderId, paymentIntentId string) bool {
+	paid, err := h.o.MarkOrderPaid(c.Request.Context(), orderId, paymentIntentId)
+	if errors.Is(err, orders.ErrPaidAfterCancel) {
+		h.refundCanceledPayment(c, traceId, orderId, paymentIntentId)
+		return false
+	}
+	if err != nil {
+		slog.Error("Failed to update order", slog.String(logkey.TraceID, traceId), slog.Any("error", err.Error()))
+		// stripe sends the event again
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		return false
+	}
+	if !paid.NewlyPaid {
+		// a replayed event, the first one already took the stock and issued the invoice
+		slog.Info("order already paid, event ignored", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId))
+		c.Status(http.StatusOK)
+		return false
+	}
+	if paid.CouponOverLimit {
+		slog.Error("coupon redeemed past its limit, the order needs review", slog.String(logkey.TraceID, traceId),
+			slog.String("OrderID", orderId))
+	}
+	return true
+}
+
+// refundCanceledPayment refunds a payment that came in after its order was canceled, e.g. a checkout session
+// paid while the cancellation was going on. The order id keeps a replayed event from refunding twice.
+func (h *Handler) refundCanceledPayment(c *gin.Context, traceId, orderId, paymentIntentId string) {
+	ctx := c.Request.Context()
+
+	reserved, err := h.o.ReserveRefund(ctx, orders.Refund{
+		ID:             uuid.NewString(),
+		OrderID:        orderId,
+		IdempotencyKey: orderId + ":paid-after-cancel",
+		Reason:         "paid after the order was canceled",
+		CreatedAt:      time.Now().UTC(),
+	}, true)
+	if errors.Is(err, orders.ErrNothingToRefund) {
+		// the order was paid before it was canceled, its cancellation refunded it
+		c.Status(http.StatusOK)
+		return
+	}
+	if err != nil {
+		slog.Error("error reserving refund of canceled order", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId),
+			slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund canceled order"})
+		return
+	}
+
+	if reserved.Status == orders.RefundReserved {
+		reserved, err = h.issueRefund(ctx, traceId, paymentIntentId, reserved)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund canceled order"})
+			return
+		}
+	}
+
+	_, err = h.o.CompleteRefund(ctx, reserved, "")
+	if err != nil {
+		slog.Error("error recording refund of canceled order", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId),
+			slog.String("StripeRefundID", reserved.StripeRefundID), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund canceled order"})
+		return
+	}
+
+	slog.Warn("refunded payment of canceled order", slog.String(logkey.TraceID, traceId), slog.String("OrderID", orderId),
+		slog.String("StripeRefundID", reserved.StripeRefundID), slog.Int64("Amount", reserved.Amount))
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serviceTokenLeeway is how long before it expires a service token is replaced,
+// a request sent with it must still be checked in time
+const serviceTokenLeeway = time.Minute
+
+// ServiceToken is a token that identifies a service to the internal routes of the others
+type ServiceToken struct {
+	AccessToken string
+	ExpiresAt   time.Time
+}
+
+// ServiceTokenSource gets a new service token
+type ServiceTokenSource func(ctx context.Context) (ServiceToken, error)
+
+// ServiceTokens keeps the current service token and gets a new one shortly before it expires
+type ServiceTokens struct {
+	source ServiceTokenSource
+
+	mu      sync.Mutex
+	current ServiceToken
+}
+
+func NewServiceTokens(source ServiceTokenSource) (*ServiceTokens, error) {
+	if source == nil {
+		return nil, errors.New("service token source is nil")
+	}
+	return &ServiceTokens{source: source}, nil
+}
+
+// Token returns a service token that is valid for at least another minute
+func (s *ServiceTokens) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Until(s.current.ExpiresAt) > serviceTokenLeeway {
+		return s.current.AccessToken, nil
+	}
+	token, err := s.source(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.current = token
+	return token.AccessToken, nil
+}
+
+// Do sends the request to an internal route with the service token
+func (s *ServiceTokens) Do(req *http.Request) (*http.Response, error) {
+	token, err := s.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(req)
+}
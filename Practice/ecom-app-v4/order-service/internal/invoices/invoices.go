@@ -0,0 +1,211 @@
+package invoices
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"order-service/internal/orders"
+	"time"
+)
+
+var (
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	ErrOrderNotPaid    = errors.New("order is not paid")
+)
+
+type Conf struct {
+	db     *sql.DB
+	seller Seller
+}
+
+func NewConf(db *sql.DB, seller Seller) (Conf, error) {
+	if db == nil {
+		return Conf{}, fmt.Errorf("db is nil")
+	}
+	return Conf{db: db, seller: seller}, nil
+}
+
+// FormatNumber prints the sequence number of an invoice the way it appears on the document
+func FormatNumber(sequence int64) string {
+	return fmt.Sprintf("INV-%06d", sequence)
+}
+
+// singleLine is the line of an order placed before line items were stored, built from the amounts of the order.
+// Its subtotal is the total without the stored tax and shipping, before the discount, the tax rate is not known.
+func singleLine(productId string, total, discount, tax, shipping int64) Line {
+	subtotal := total + discount - tax - shipping
+	return Line{ProductID: productId, Quantity: 1, UnitPrice: subtotal, LineTotal: subtotal, Discount: discount, Tax: tax}
+}
+
+// IssueInvoice renders and stores the invoice of a paid order with the next invoice number.
+// An order gets a single invoice, issuing it again returns the stored one, so webhook retries are safe.
+func (c *Conf) IssueInvoice(ctx context.Context, orderId string) (Invoice, error) {
+	inv := Invoice{OrderID: orderId}
+	issued := false
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		// locking the order makes concurrent deliveries of the webhook wait for each other
+		var status string
+		err := tx.QueryRowContext(ctx, `
+		SELECT user_id, status, created_at, subtotal, discount_amount, tax_amount, shipping_amount, total_price
+		FROM orders
+		WHERE id = $1
+		FOR UPDATE
+		`, orderId).Scan(&inv.UserID, &status, &inv.OrderedAt, &inv.Subtotal, &inv.Discount, &inv.Tax, &inv.Shipping, &inv.Total)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return orders.ErrOrderNotFound
+			}
+			return fmt.Errorf("failed to fetch order: %w", err)
+		}
+		if status == orders.StatusPending || status == orders.StatusCanceled {
+			return ErrOrderNotPaid
+		}
+
+		err = tx.QueryRowContext(ctx, `SELECT invoice_number FROM invoices WHERE order_id = $1`, orderId).Scan(&inv.Number)
+		if err == nil {
+			issued = true
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to fetch invoice: %w", err)
+		}
+
+		inv.Lines, err = invoiceLines(ctx, tx, orderId)
+		if err != nil {
+			return err
+		}
+		// orders placed before line items were stored only know their total
+		if len(inv.Lines) == 0 {
+			var productId string
+			err := tx.QueryRowContext(ctx, `SELECT product_id FROM orders WHERE id = $1`, orderId).Scan(&productId)
+			if err != nil {
+				return fmt.Errorf("failed to fetch order: %w", err)
+			}
+			line := singleLine(productId, inv.Total, inv.Discount, inv.Tax, inv.Shipping)
+			inv.Subtotal = line.LineTotal
+			inv.Lines = []Line{line}
+		}
+
+		inv.Address, err = orderAddress(ctx, tx, orderId)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+		UPDATE invoice_counter
+		SET last_number = last_number + 1
+		RETURNING last_number
+		`).Scan(&inv.Sequence)
+		if err != nil {
+			return fmt.Errorf("failed to take invoice number: %w", err)
+		}
+		inv.Number = FormatNumber(inv.Sequence)
+		inv.IssuedAt = time.Now().UTC()
+
+		inv.PDF, err = Render(c.seller, inv)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO invoices (order_id, sequence_number, invoice_number, user_id, total, pdf, issued_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, inv.OrderID, inv.Sequence, inv.Number, inv.UserID, inv.Total, inv.PDF, inv.IssuedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert invoice: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return Invoice{}, err
+	}
+	if issued {
+		return c.GetInvoice(ctx, orderId)
+	}
+	return inv, nil
+}
+
+// GetInvoice returns the stored invoice of the order with its PDF, the line items are not loaded
+func (c *Conf) GetInvoice(ctx context.Context, orderId string) (Invoice, error) {
+	inv := Invoice{OrderID: orderId}
+	err := c.db.QueryRowContext(ctx, `
+	SELECT sequence_number, invoice_number, user_id, total, pdf, issued_at
+	FROM invoices
+	WHERE order_id = $1
+	`, orderId).Scan(&inv.Sequence, &inv.Number, &inv.UserID, &inv.Total, &inv.PDF, &inv.IssuedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Invoice{}, ErrInvoiceNotFound
+		}
+		return Invoice{}, fmt.Errorf("failed to fetch invoice: %w", err)
+	}
+	return inv, nil
+}
+
+func invoiceLines(ctx context.Context, tx *sql.Tx, orderId string) ([]Line, error) {
+	rows, err := tx.QueryContext(ctx, `
+	SELECT product_id, name, quantity, unit_price, line_total, discount_amount, tax_rate, tax_amount
+	FROM order_items
+	WHERE order_id = $1
+	ORDER BY name, product_id
+	`, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch order items: %w", err)
+	}
+	defer rows.Close()
+
+	var lines []Line
+	for rows.Next() {
+		var line Line
+		err := rows.Scan(&line.ProductID, &line.Name, &line.Quantity, &line.UnitPrice, &line.LineTotal,
+			&line.Discount, &line.TaxRate, &line.Tax)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return lines, nil
+}
+
+func orderAddress(ctx context.Context, tx *sql.Tx, orderId string) (*orders.ShippingAddress, error) {
+	var a orders.ShippingAddress
+	err := tx.QueryRowContext(ctx, `
+	SELECT address_id, full_name, phone, line1, line2, city, state, postal_code, country
+	FROM order_addresses
+	WHERE order_id = $1
+	`, orderId).Scan(&a.ID, &a.FullName, &a.Phone, &a.Line1, &a.Line2, &a.City, &a.State, &a.PostalCode, &a.Country)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch order address: %w", err)
+	}
+	return &a, nil
+}
+
+func (c *Conf) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		er := tx.Rollback()
+		if er != nil && !errors.Is(er, sql.ErrTxDone) {
+			return fmt.Errorf("failed to rollback withTx: %w", err)
+		}
+		return fmt.Errorf("failed to execute withTx: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit withTx: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package invoices
+
+import (
+	"order-service/internal/orders"
+	"os"
+	"time"
+)
+
+// Seller is the business printed at the top of every invoice
+type Seller struct {
+	Name    string
+	Address string
+	GSTIN   string // GST identification number, left out when empty
+}
+
+// SellerFromEnv reads the seller from INVOICE_SELLER_NAME, INVOICE_SELLER_ADDRESS and INVOICE_SELLER_GSTIN
+func SellerFromEnv() Seller {
+	seller := Seller{
+		Name:    os.Getenv("INVOICE_SELLER_NAME"),
+		Address: os.Getenv("INVOICE_SELLER_ADDRESS"),
+		GSTIN:   os.Getenv("INVOICE_SELLER_GSTIN"),
+	}
+	if seller.Name == "" {
+		seller.Name = "Ecom App"
+	}
+	return seller
+}
+
+// Line is a line item as printed on the invoice, amounts are in paise
+type Line struct {
+	ProductID string `json:"product_id"`
+	Name      string `json:"name"`
+	Quantity  int64  `json:"quantity"`
+	UnitPrice int64  `json:"unit_price"`
+	LineTotal int64  `json:"line_total"`
+	Discount  int64  `json:"discount"`
+	TaxRate   int64  `json:"tax_rate"` // in basis points
+	Tax       int64  `json:"tax"`
+}
+
+// Invoice is the bill of a paid order, PDF is the rendered document that is stored and emailed
+type Invoice struct {
+	Number    string                  `json:"invoice_number"`
+	Sequence  int64                   `json:"-"`
+	OrderID   string                  `json:"order_id"`
+	UserID    string                  `json:"user_id"`
+	OrderedAt time.Time               `json:"ordered_at"`
+	IssuedAt  time.Time               `json:"issued_at"`
+	Address   *orders.ShippingAddress `json:"address,omitempty"` // nil when stripe collected the address
+	Lines     []Line                  `json:"lines"`
+	Subtotal  int64                   `json:"subtotal"`
+	Discount  int64                   `json:"discount"`
+	Tax       int64                   `json:"tax"`
+	Shipping  int64                   `json:"shipping"`
+	Total     int64                   `json:"total"`
+	PDF       []byte                  `json:"-"`
+}
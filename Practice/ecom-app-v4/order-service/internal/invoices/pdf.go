@@ -0,0 +1,157 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// FormatAmount prints paise as rupees with two decimals, e.g. 123456 as 1234.56
+func FormatAmount(paise int64) string {
+	sign := ""
+	if paise < 0 {
+		sign = "-"
+		paise = -paise
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, paise/100, paise%100)
+}
+
+// formatRate prints a rate in basis points as a percentage, e.g. 1800 as 18% and 250 as 2.5%
+func formatRate(basisPoints int64) string {
+	s := fmt.Sprintf("%d.%02d", basisPoints/100, basisPoints%100)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return s + "%"
+}
+
+// Render draws the invoice as an A4 PDF. The core fonts only cover latin characters,
+// product names and addresses are translated to cp1252.
+func Render(seller Seller, inv Invoice) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Invoice "+inv.Number, true)
+	pdf.SetAuthor(seller.Name, true)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+
+	// seller on the left, invoice details on the right
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(110, 9, tr(seller.Name), "", 0, "L", false, 0, "")
+	pdf.CellFormat(80, 9, "TAX INVOICE", "", 1, "R", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 9)
+	details := []string{
+		"Invoice number: " + inv.Number,
+		"Invoice date: " + inv.IssuedAt.Format("02 Jan 2006"),
+		"Order: " + inv.OrderID,
+		"Order date: " + inv.OrderedAt.Format("02 Jan 2006"),
+	}
+	var sellerLines []string
+	if seller.Address != "" {
+		sellerLines = append(sellerLines, strings.Split(seller.Address, "\n")...)
+	}
+	if seller.GSTIN != "" {
+		sellerLines = append(sellerLines, "GSTIN: "+seller.GSTIN)
+	}
+	for i := 0; i < max(len(details), len(sellerLines)); i++ {
+		left, right := "", ""
+		if i < len(sellerLines) {
+			left = sellerLines[i]
+		}
+		if i < len(details) {
+			right = details[i]
+		}
+		pdf.CellFormat(110, 5, tr(left), "", 0, "L", false, 0, "")
+		pdf.CellFormat(80, 5, right, "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(190, 6, "Bill to / Ship to", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	for _, line := range addressLines(inv) {
+		pdf.CellFormat(190, 5, tr(line), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	// line items
+	widths := []float64{70, 15, 25, 25, 20, 35}
+	headers := []string{"Item", "Qty", "Unit price", "Discount", "GST", "Amount"}
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(235, 235, 235)
+	for i, header := range headers {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(widths[i], 7, header, "B", 0, align, true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 9)
+	for _, line := range inv.Lines {
+		name := line.Name
+		if name == "" {
+			name = line.ProductID
+		}
+		amount := line.LineTotal - line.Discount + line.Tax
+		pdf.CellFormat(widths[0], 6, tr(truncate(name, 45)), "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 6, fmt.Sprint(line.Quantity), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[2], 6, FormatAmount(line.UnitPrice), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 6, FormatAmount(line.Discount), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[4], 6, formatRate(line.TaxRate), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[5], 6, FormatAmount(amount), "", 1, "R", false, 0, "")
+	}
+	pdf.CellFormat(190, 1, "", "T", 1, "", false, 0, "")
+	pdf.Ln(2)
+
+	// totals
+	totals := []struct {
+		label  string
+		amount int64
+	}{
+		{"Subtotal", inv.Subtotal},
+		{"Discount", -inv.Discount},
+		{"GST", inv.Tax},
+		{"Shipping", inv.Shipping},
+	}
+	for _, t := range totals {
+		pdf.CellFormat(155, 6, t.label, "", 0, "R", false, 0, "")
+		pdf.CellFormat(35, 6, FormatAmount(t.amount), "", 1, "R", false, 0, "")
+	}
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(155, 8, "Total (INR)", "T", 0, "R", false, 0, "")
+	pdf.CellFormat(35, 8, FormatAmount(inv.Total), "T", 1, "R", false, 0, "")
+
+	pdf.Ln(10)
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.CellFormat(190, 5, "Amounts are in Indian rupees. GST is charged on the discounted value, shipping is not taxed.", "", 1, "L", false, 0, "")
+
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render invoice: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func addressLines(inv Invoice) []string {
+	a := inv.Address
+	if a == nil {
+		return []string{"Customer " + inv.UserID}
+	}
+	lines := []string{a.FullName, a.Line1}
+	if a.Line2 != "" {
+		lines = append(lines, a.Line2)
+	}
+	lines = append(lines, fmt.Sprintf("%s, %s %s, %s", a.City, a.State, a.PostalCode, a.Country), "Phone: "+a.Phone)
+	return lines
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
@@ -0,0 +1,109 @@
+package invoices
+
+import (
+	"bytes"
+	"order-service/internal/orders"
+	"testing"
+	"time"
+)
+
+func TestFormatAmount(t *testing.T) {
+	tt := [...]struct {
+		paise    int64
+		expected string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{123456, "1234.56"},
+		{-2500, "-25.00"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.expected, func(t *testing.T) {
+			if got := FormatAmount(tc.paise); got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tt := [...]struct {
+		basisPoints int64
+		expected    string
+	}{
+		{1800, "18%"},
+		{250, "2.5%"},
+		{0, "0%"},
+		{1234, "12.34%"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.expected, func(t *testing.T) {
+			if got := formatRate(tc.basisPoints); got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestSingleLine(t *testing.T) {
+	tt := [...]struct {
+		name                           string
+		total, discount, tax, shipping int64
+		subtotal                       int64
+	}{
+		{"total only", 50000, 0, 0, 0, 50000},
+		{"with a discount", 45000, 5000, 0, 0, 50000},
+		{"with tax and shipping", 62900, 5000, 8100, 4900, 54900},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			line := singleLine("p1", tc.total, tc.discount, tc.tax, tc.shipping)
+			if line.LineTotal != tc.subtotal || line.UnitPrice != tc.subtotal {
+				t.Errorf("expected a subtotal of %d, got %+v", tc.subtotal, line)
+			}
+			// the line adds up to the total again once shipping is added
+			if got := line.LineTotal - line.Discount + line.Tax + tc.shipping; got != tc.total {
+				t.Errorf("expected the line to add up to %d, got %d", tc.total, got)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	inv := Invoice{
+		Number:    FormatNumber(42),
+		OrderID:   "9b1deb4d-3b7d-4bad-9bdd-2b0d7b3dcb6d",
+		UserID:    "1b9d6bcd-bbfd-4b2d-9b5d-ab8dfbbd4bed",
+		OrderedAt: now,
+		IssuedAt:  now,
+		Address: &orders.ShippingAddress{
+			FullName: "Zoë Fernandes", Phone: "9999999999", Line1: "12 MG Road",
+			City: "Bengaluru", State: "Karnataka", PostalCode: "560001", Country: "IN",
+		},
+		Lines: []Line{
+			{ProductID: "p1", Name: "Café Table", Quantity: 2, UnitPrice: 50000, LineTotal: 100000, Discount: 10000, TaxRate: 1800, Tax: 16200},
+			{ProductID: "p2", Quantity: 1, UnitPrice: 20000, LineTotal: 20000, TaxRate: 500, Tax: 1000},
+		},
+		Subtotal: 120000,
+		Discount: 10000,
+		Tax:      17200,
+		Shipping: 6000,
+		Total:    133200,
+	}
+
+	if inv.Number != "INV-000042" {
+		t.Fatalf("expected INV-000042, got %s", inv.Number)
+	}
+
+	data, err := Render(Seller{Name: "Ecom App", Address: "1 Market Street\nMumbai", GSTIN: "27AAAAA0000A1Z5"}, inv)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Errorf("expected a PDF document, got %q", data[:min(len(data), 10)])
+	}
+}
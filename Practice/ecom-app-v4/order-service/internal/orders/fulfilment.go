@@ -0,0 +1,197 @@
+package orders
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	ErrShipmentRequired  = errors.New("carrier and tracking number are required to ship an order")
+)
+
+// transitions lists the statuses an order can move to from its current status.
+// An order can be canceled until it is shipped and fully refunded once it is paid.
+var transitions = map[string][]string{
+	StatusPending:   {StatusPaid, StatusCanceled},
+	StatusPaid:      {StatusPacked, StatusCanceled, StatusRefunded},
+	StatusPacked:    {StatusShipped, StatusCanceled, StatusRefunded},
+	StatusShipped:   {StatusDelivered, StatusRefunded},
+	StatusDelivered: {StatusReturned, StatusRefunded},
+	StatusReturned:  {StatusRefunded},
+}
+
+// CanTransition reports whether an order in status from may move to status to
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange is the change an admin makes to an order, Carrier and TrackingNumber are required for shipped
+type StatusChange struct {
+	Status         string
+	Note           string
+	Carrier        string
+	TrackingNumber string
+	ChangedBy      string // user id of the admin
+}
+
+// StatusChanged is the result of a transition, it carries what the customer needs to be told
+type StatusChanged struct {
+	OrderID        string
+	UserID         string
+	FromStatus     string
+	Status         string
+	Note           string
+	Carrier        string
+	TrackingNumber string
+	Refunded       int64 // in paise, what a refund that came with the change paid back
+	ChangedAt      time.Time
+}
+
+// StatusHistoryEntry is one transition of an order
+type StatusHistoryEntry struct {
+	FromStatus string    `json:"from_status"`
+	Status     string    `json:"status"`
+	Note       string    `json:"note,omitempty"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// Tracking is the fulfilment state of an order as shown to the customer
+type Tracking struct {
+	OrderID        string               `json:"order_id"`
+	UserID         string               `json:"-"`
+	Status         string               `json:"status"`
+	Carrier        string               `json:"carrier,omitempty"`
+	TrackingNumber string               `json:"tracking_number,omitempty"`
+	ShippedAt      *time.Time           `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time           `json:"delivered_at,omitempty"`
+	History        []StatusHistoryEntry `json:"history"`
+}
+
+// TransitionOrder moves the order to the new status when the transition is allowed and records it in the history.
+// The row is locked for the transaction, so two admins can not move the same order at the same time.
+func (c *Conf) TransitionOrder(ctx context.Context, orderId string, change StatusChange) (StatusChanged, error) {
+	if change.Status == StatusShipped && (change.Carrier == "" || change.TrackingNumber == "") {
+		return StatusChanged{}, ErrShipmentRequired
+	}
+
+	changed := StatusChanged{
+		OrderID:        orderId,
+		Status:         change.Status,
+		Note:           change.Note,
+		Carrier:        change.Carrier,
+		TrackingNumber: change.TrackingNumber,
+		ChangedAt:      time.Now().UTC(),
+	}
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+		SELECT status, user_id
+		FROM orders
+		WHERE id = $1
+		FOR UPDATE
+		`, orderId).Scan(&changed.FromStatus, &changed.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrOrderNotFound
+			}
+			return fmt.Errorf("failed to fetch order: %w", err)
+		}
+
+		if !CanTransition(changed.FromStatus, change.Status) {
+			return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, changed.FromStatus, change.Status)
+		}
+
+		// the shipment details and the timestamps are only written by the transition they belong to
+		query := `
+		UPDATE orders
+		SET status = $1,
+			updated_at = $2,
+			carrier = CASE WHEN $1 = 'shipped' THEN $3 ELSE carrier END,
+			tracking_number = CASE WHEN $1 = 'shipped' THEN $4 ELSE tracking_number END,
+			shipped_at = CASE WHEN $1 = 'shipped' THEN $2 ELSE shipped_at END,
+			delivered_at = CASE WHEN $1 = 'delivered' THEN $2 ELSE delivered_at END
+		WHERE id = $5
+		RETURNING COALESCE(carrier, ''), COALESCE(tracking_number, '')
+		`
+		err = tx.QueryRowContext(ctx, query, change.Status, changed.ChangedAt, change.Carrier, change.TrackingNumber, orderId).
+			Scan(&changed.Carrier, &changed.TrackingNumber)
+		if err != nil {
+			return fmt.Errorf("failed to update order status: %w", err)
+		}
+
+		changedBy := sql.NullString{String: change.ChangedBy, Valid: change.ChangedBy != ""}
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, from_status, to_status, note, changed_by, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`, orderId, changed.FromStatus, change.Status, change.Note, changedBy, changed.ChangedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record order status history: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return StatusChanged{}, err
+	}
+	return changed, nil
+}
+
+// GetTracking returns the fulfilment state of the order with every transition, oldest first
+func (c *Conf) GetTracking(ctx context.Context, orderId string) (Tracking, error) {
+	tracking := Tracking{OrderID: orderId, History: []StatusHistoryEntry{}}
+
+	var carrier, trackingNumber sql.NullString
+	var shippedAt, deliveredAt sql.NullTime
+	err := c.db.QueryRowContext(ctx, `
+	SELECT user_id, status, carrier, tracking_number, shipped_at, delivered_at
+	FROM orders
+	WHERE id = $1
+	`, orderId).Scan(&tracking.UserID, &tracking.Status, &carrier, &trackingNumber, &shippedAt, &deliveredAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Tracking{}, ErrOrderNotFound
+		}
+		return Tracking{}, fmt.Errorf("failed to fetch order: %w", err)
+	}
+	tracking.Carrier = carrier.String
+	tracking.TrackingNumber = trackingNumber.String
+	if shippedAt.Valid {
+		tracking.ShippedAt = &shippedAt.Time
+	}
+	if deliveredAt.Valid {
+		tracking.DeliveredAt = &deliveredAt.Time
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+	SELECT from_status, to_status, note, changed_at
+	FROM order_status_history
+	WHERE order_id = $1
+	ORDER BY changed_at ASC, id ASC
+	`, orderId)
+	if err != nil {
+		return Tracking{}, fmt.Errorf("failed to fetch order status history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry StatusHistoryEntry
+		if err := rows.Scan(&entry.FromStatus, &entry.Status, &entry.Note, &entry.ChangedAt); err != nil {
+			return Tracking{}, fmt.Errorf("failed to scan row: %w", err)
+		}
+		tracking.History = append(tracking.History, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return Tracking{}, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return tracking, nil
+}
@@ -0,0 +1,36 @@
+package orders
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tt := [...]struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{StatusPending, StatusPaid, true},
+		{StatusPaid, StatusPacked, true},
+		{StatusPacked, StatusShipped, true},
+		{StatusShipped, StatusDelivered, true},
+		{StatusDelivered, StatusReturned, true},
+		{StatusPending, StatusPacked, false},
+		{StatusPaid, StatusShipped, false},
+		{StatusShipped, StatusPacked, false},
+		{StatusShipped, StatusReturned, false},
+		{StatusCanceled, StatusPaid, false},
+		{StatusReturned, StatusDelivered, false},
+		{StatusPacked, StatusCanceled, true},
+		{StatusShipped, StatusCanceled, false},
+		{StatusReturned, StatusRefunded, true},
+		{StatusPending, StatusRefunded, false},
+		{StatusRefunded, StatusRefunded, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.from+" to "+tc.to, func(t *testing.T) {
+			if got := CanTransition(tc.from, tc.to); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
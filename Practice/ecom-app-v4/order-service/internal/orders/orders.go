@@ -5,7 +5,10 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"order-service/internal/pricing"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type Conf struct {
@@ -20,46 +23,100 @@ func NewConf(db *sql.DB) (Conf, error) {
 }
 
 const (
-	StatusPending  = "pending"
-	StatusPaid     = "paid"
-	StatusCanceled = "canceled"
+	StatusPending   = "pending"
+	StatusPaid      = "paid"
+	StatusPacked    = "packed"
+	StatusShipped   = "shipped"
+	StatusDelivered = "delivered"
+	StatusReturned  = "returned"
+	StatusRefunded  = "refunded"
+	StatusCanceled  = "canceled"
 )
 
-func (c *Conf) CreateOrder(ctx context.Context, orderId, userId, productId string, totalPrice int64) error {
-	// Define the status and timestamps
+// Discount is the coupon applied to an order, the zero value means no coupon
+type Discount struct {
+	CouponID string
+	Amount   int64 // in paise
+}
+
+// ShippingAddress is the saved address of the user picked at checkout, as fetched from the user service
+type ShippingAddress struct {
+	ID         string `json:"id"`
+	FullName   string `json:"full_name"`
+	Phone      string `json:"phone"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+// CreateQuotedOrder creates a pending order together with its line items and the tax and shipping breakdown of the quote.
+// The shipping address is copied onto the order when one was picked, a nil address leaves it to stripe.
+func (c *Conf) CreateQuotedOrder(ctx context.Context, orderId, userId string, discount Discount, quote pricing.Quote, address *ShippingAddress, checkoutSessionId string) error {
+	if len(quote.Lines) == 0 {
+		return errors.New("quote has no line items")
+	}
+
 	status := StatusPending
 	createdAt := time.Now().UTC()
 	updatedAt := time.Now().UTC()
 
-	// Use a transaction to execute the insert
 	err := c.withTx(ctx, func(tx *sql.Tx) error {
-		// SQL query for inserting a new order and returning the generated ID
+		// product_id keeps pointing at the first item for the older single product queries
 		query := `
 		INSERT INTO orders
-		(id,user_id, product_id, status, total_price, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		(id, user_id, product_id, status, total_price, coupon_id, discount_amount,
+		 subtotal, tax_amount, shipping_amount, shipping_region, checkout_session_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 		`
 
-		// Execute the query and capture the returned ID
-		res, err := tx.ExecContext(ctx, query, orderId, userId, productId, status, totalPrice, createdAt, updatedAt)
+		couponId := sql.NullString{String: discount.CouponID, Valid: discount.CouponID != ""}
+
+		_, err := tx.ExecContext(ctx, query, orderId, userId, quote.Lines[0].ProductID, status, quote.Total, couponId,
+			quote.Discount, quote.Subtotal, quote.Tax, quote.Shipping, quote.Region, checkoutSessionId, createdAt, updatedAt)
 		if err != nil {
-			return fmt.Errorf("failed to insert order and retrieve ID: %w", err)
+			return fmt.Errorf("failed to insert order: %w", err)
+		}
+
+		queryItem := `
+		INSERT INTO order_items
+		(order_id, product_id, name, quantity, unit_price, line_total, discount_amount, tax_rate, tax_amount)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`
+		for _, line := range quote.Lines {
+			_, err := tx.ExecContext(ctx, queryItem, orderId, line.ProductID, line.Name, line.Quantity, line.UnitPrice,
+				line.LineTotal, line.Discount, line.TaxRate, line.Tax)
+			if err != nil {
+				return fmt.Errorf("failed to insert order item: %w", err)
+			}
 		}
-		if num, err := res.RowsAffected(); num == 0 || err != nil {
-			return fmt.Errorf("failed to insert order and retrieve ID: no rows affected")
+
+		if address == nil {
+			return nil
+		}
+
+		queryAddress := `
+		INSERT INTO order_addresses
+		(order_id, address_id, full_name, phone, line1, line2, city, state, postal_code, country)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`
+		_, err = tx.ExecContext(ctx, queryAddress, orderId, address.ID, address.FullName, address.Phone, address.Line1,
+			address.Line2, address.City, address.State, address.PostalCode, address.Country)
+		if err != nil {
+			return fmt.Errorf("failed to insert order address: %w", err)
 		}
-		// Successfully inserted the order
 		return nil
 	})
 
 	if err != nil {
-		// Return an error if the transaction fails
 		return fmt.Errorf("failed to create order: %w", err)
 	}
 
-	// Return the generated order ID if successful
 	return nil
 }
+
 func (c *Conf) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
 	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -117,3 +174,168 @@ func (c *Conf) UpdateOrder(ctx context.Context, orderId string, status string, s
 	// Return nil if the update is successful or skipped gracefully
 	return nil
 }
+
+// GetOrderStatus returns the status of the order, sql.ErrNoRows is returned when there is no such order
+func (c *Conf) GetOrderStatus(ctx context.Context, orderId string) (string, error) {
+	var status string
+
+	query := `
+	SELECT status
+	FROM orders
+	WHERE id = $1
+	`
+	err := c.db.QueryRowContext(ctx, query, orderId).Scan(&status)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch order %s: %w", orderId, err)
+	}
+	return status, nil
+}
+
+// ErrPaidAfterCancel is returned for a payment of an order that was canceled before it was paid.
+// The order stays canceled, the payment is stored on it so it can be refunded.
+var ErrPaidAfterCancel = errors.New("order was canceled before it was paid")
+
+// PaidOrder is what marking an order paid found out about it
+type PaidOrder struct {
+	NewlyPaid       bool // the order went from pending to paid, a replayed event leaves it false
+	CouponOverLimit bool // the coupon had reached a limit by the time the order was paid
+}
+
+// paidTransition tells what a payment does to an order in the given status. Only a pending order becomes paid,
+// a canceled one keeps the payment so it can be refunded, any other status means the event was replayed.
+func paidTransition(status string) (newlyPaid, canceled bool) {
+	switch status {
+	case StatusPending:
+		return true, false
+	case StatusCanceled:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// MarkOrderPaid sets the order to paid and, when a coupon was applied, records the redemption
+// in the same transaction, so a coupon is counted exactly once for every paid order.
+// The limits of the coupon are checked again with the coupon locked, two checkouts can both pass them
+// before either is paid. The customer has paid with the discount by then, so the redemption is kept
+// and flagged for review. A replayed event finds the order no longer pending and leaves it as it is.
+func (c *Conf) MarkOrderPaid(ctx context.Context, orderId string, stripeTransactionId string) (PaidOrder, error) {
+	updatedAt := time.Now().UTC() // Current timestamp
+	var paid PaidOrder
+	var canceled bool
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var userId string
+		var couponId sql.NullString
+		var discountAmount int64
+
+		// the lock keeps a replayed stripe event from paying the order a second time while the first is handled
+		var status string
+		err := tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderId).Scan(&status)
+		if err != nil {
+			return fmt.Errorf("failed to fetch order %s: %w", orderId, err)
+		}
+
+		newlyPaid, isCanceled := paidTransition(status)
+		if isCanceled {
+			// an order canceled after it was paid already has its payment, one canceled before gets it now
+			canceled = true
+			_, err = tx.ExecContext(ctx, `
+			UPDATE orders
+			SET stripe_transaction_id = $1, updated_at = $2
+			WHERE id = $3 AND stripe_transaction_id IS NULL
+			`, stripeTransactionId, updatedAt, orderId)
+			if err != nil {
+				return fmt.Errorf("failed to store payment of canceled order: %w", err)
+			}
+			return nil
+		}
+		if !newlyPaid {
+			// a replayed event, the order was paid and fulfilled by the first one
+			return nil
+		}
+
+		queryUpdate := `
+		UPDATE orders
+		SET status = $1, stripe_transaction_id = $2, updated_at = $3
+		WHERE id = $4
+		RETURNING user_id, coupon_id, discount_amount
+		`
+		err = tx.QueryRowContext(ctx, queryUpdate, StatusPaid, stripeTransactionId, updatedAt, orderId).
+			Scan(&userId, &couponId, &discountAmount)
+		if err != nil {
+			return fmt.Errorf("failed to update order: %w", err)
+		}
+		paid.NewlyPaid = true
+
+		if !couponId.Valid {
+			return nil
+		}
+
+		// the lock keeps concurrent payments with the same coupon from counting past its limits
+		var maxRedemptions, maxRedemptionsPerUser sql.NullInt64
+		var redemptionCount int64
+		err = tx.QueryRowContext(ctx, `
+		SELECT max_redemptions, max_redemptions_per_user, redemption_count
+		FROM coupons
+		WHERE id = $1
+		FOR UPDATE
+		`, couponId.String).Scan(&maxRedemptions, &maxRedemptionsPerUser, &redemptionCount)
+		if err != nil {
+			return fmt.Errorf("failed to fetch coupon %s: %w", couponId.String, err)
+		}
+
+		var userRedemptions int64
+		err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM coupon_redemptions
+		WHERE coupon_id = $1 AND user_id = $2 AND order_id <> $3
+		`, couponId.String, userId, orderId).Scan(&userRedemptions)
+		if err != nil {
+			return fmt.Errorf("failed to count coupon redemptions: %w", err)
+		}
+
+		overLimit := (maxRedemptions.Valid && redemptionCount >= maxRedemptions.Int64) ||
+			(maxRedemptionsPerUser.Valid && userRedemptions >= maxRedemptionsPerUser.Int64)
+
+		// stripe can deliver the same event more than once, the unique order_id keeps a single redemption
+		queryRedeem := `
+		INSERT INTO coupon_redemptions (id, coupon_id, user_id, order_id, discount_amount, over_limit, redeemed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (order_id) DO NOTHING
+		`
+		res, err := tx.ExecContext(ctx, queryRedeem, uuid.NewString(), couponId.String, userId, orderId, discountAmount, overLimit, updatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record coupon redemption: %w", err)
+		}
+
+		num, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to record coupon redemption: %w", err)
+		}
+		if num == 0 {
+			// already redeemed for this order
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE coupons
+		SET redemption_count = redemption_count + 1, updated_at = $2
+		WHERE id = $1
+		`, couponId.String, updatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to update coupon redemption count: %w", err)
+		}
+		paid.CouponOverLimit = overLimit
+		return nil
+	})
+
+	if err != nil {
+		return PaidOrder{}, err
+	}
+	if canceled {
+		return PaidOrder{}, ErrPaidAfterCancel
+	}
+
+	return paid, nil
+}
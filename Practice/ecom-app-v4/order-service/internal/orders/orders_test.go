@@ -0,0 +1,28 @@
+package orders
+
+import "testing"
+
+func TestPaidTransition(t *testing.T) {
+	tt := [...]struct {
+		name      string
+		status    string
+		newlyPaid bool
+		canceled  bool
+	}{
+		{"first event", StatusPending, true, false},
+		{"replayed event", StatusPaid, false, false},
+		{"replayed after packing", StatusPacked, false, false},
+		{"replayed after shipping", StatusShipped, false, false},
+		{"replayed after delivery", StatusDelivered, false, false},
+		{"paid after cancel", StatusCanceled, false, true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			newlyPaid, canceled := paidTransition(tc.status)
+			if newlyPaid != tc.newlyPaid || canceled != tc.canceled {
+				t.Errorf("expected (%v, %v), got (%v, %v)", tc.newlyPaid, tc.canceled, newlyPaid, canceled)
+			}
+		})
+	}
+}
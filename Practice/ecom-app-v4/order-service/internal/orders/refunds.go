@@ -0,0 +1,393 @@
+package orders
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RefundReserved is the status of a refund stripe has not accepted yet
+const RefundReserved = "reserved"
+
+var (
+	ErrNothingToRefund = errors.New("order has nothing left to refund")
+	ErrRefundTooLarge  = errors.New("refund exceeds what is left of the payment")
+	ErrRefundKeyReused = errors.New("idempotency key belongs to a refund of another order")
+)
+
+// OrderPayment is what cancellation and refunds need to know about an order
+type OrderPayment struct {
+	ID                  string
+	UserID              string
+	Status              string
+	StripeTransactionID string // payment intent id, empty until the order is paid
+	CheckoutSessionID   string // stripe checkout session the order is paid through, empty for older orders
+	TotalPrice          int64
+	RefundedAmount      int64 // includes refunds that are only reserved
+}
+
+// Refundable returns how much of the payment can still be refunded, in paise
+func (p OrderPayment) Refundable() int64 {
+	if p.StripeTransactionID == "" {
+		return 0
+	}
+	return p.TotalPrice - p.RefundedAmount
+}
+
+// Refund is a refund made on stripe for an order
+type Refund struct {
+	ID             string    `json:"id"`
+	OrderID        string    `json:"order_id"`
+	StripeRefundID string    `json:"stripe_refund_id,omitempty"`
+	IdempotencyKey string    `json:"-"` // also sent to stripe, a retried request refunds once
+	ReturnID       string    `json:"return_id,omitempty"`
+	Amount         int64     `json:"amount"` // in paise
+	Reason         string    `json:"reason,omitempty"`
+	Status         string    `json:"status"`
+	CreatedBy      string    `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Item is a product and the quantity of it on an order
+type Item struct {
+	ProductID string
+	Quantity  int
+}
+
+// GetOrderPayment returns the payment details of the order
+func (c *Conf) GetOrderPayment(ctx context.Context, orderId string) (OrderPayment, error) {
+	var payment OrderPayment
+	var stripeTransactionId, checkoutSessionId sql.NullString
+
+	err := c.db.QueryRowContext(ctx, `
+	SELECT id, user_id, status, stripe_transaction_id, checkout_session_id, total_price, refunded_amount
+	FROM orders
+	WHERE id = $1
+	`, orderId).Scan(&payment.ID, &payment.UserID, &payment.Status, &stripeTransactionId, &checkoutSessionId,
+		&payment.TotalPrice, &payment.RefundedAmount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OrderPayment{}, ErrOrderNotFound
+		}
+		return OrderPayment{}, fmt.Errorf("failed to fetch order: %w", err)
+	}
+	payment.StripeTransactionID = stripeTransactionId.String
+	payment.CheckoutSessionID = checkoutSessionId.String
+	return payment, nil
+}
+
+// ReserveRefund sets the amount aside before stripe is asked to refund it. The order is locked while
+// what is left of the payment is checked, so concurrent refunds never add up to more than was paid.
+// An amount of 0 reserves everything left. With capped set at most what is left is reserved,
+// otherwise asking for more fails with ErrRefundTooLarge.
+// A refund with the same idempotency key is returned as it is, reserved or done, so a retried request
+// carries on with the refund it started.
+func (c *Conf) ReserveRefund(ctx context.Context, refund Refund, capped bool) (Refund, error) {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var stripeTransactionId sql.NullString
+		var totalPrice, refundedAmount int64
+		err := tx.QueryRowContext(ctx, `
+		SELECT stripe_transaction_id, total_price, refunded_amount
+		FROM orders
+		WHERE id = $1
+		FOR UPDATE
+		`, refund.OrderID).Scan(&stripeTransactionId, &totalPrice, &refundedAmount)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrOrderNotFound
+			}
+			return fmt.Errorf("failed to fetch order: %w", err)
+		}
+
+		var existing Refund
+		var stripeRefundId, createdBy sql.NullString
+		err = tx.QueryRowContext(ctx, `
+		SELECT id, order_id, stripe_refund_id, COALESCE(return_id::text, ''), amount, reason, status, created_by, created_at
+		FROM refunds
+		WHERE idempotency_key = $1
+		`, refund.IdempotencyKey).Scan(&existing.ID, &existing.OrderID, &stripeRefundId, &existing.ReturnID, &existing.Amount,
+			&existing.Reason, &existing.Status, &createdBy, &existing.CreatedAt)
+		if err == nil {
+			if existing.OrderID != refund.OrderID {
+				return ErrRefundKeyReused
+			}
+			existing.StripeRefundID = stripeRefundId.String
+			existing.CreatedBy = createdBy.String
+			existing.IdempotencyKey = refund.IdempotencyKey
+			refund = existing
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to fetch refund: %w", err)
+		}
+
+		refund.Amount, err = reserveAmount(stripeTransactionId.String, totalPrice-refundedAmount, refund.Amount, capped)
+		if err != nil {
+			return err
+		}
+
+		refund.Status = RefundReserved
+		createdBy = sql.NullString{String: refund.CreatedBy, Valid: refund.CreatedBy != ""}
+		returnId := sql.NullString{String: refund.ReturnID, Valid: refund.ReturnID != ""}
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO refunds (id, order_id, idempotency_key, return_id, amount, reason, status, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, refund.ID, refund.OrderID, refund.IdempotencyKey, returnId, refund.Amount, refund.Reason, refund.Status, createdBy, refund.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert refund: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET refunded_amount = refunded_amount + $1, updated_at = $2
+		WHERE id = $3
+		`, refund.Amount, refund.CreatedAt, refund.OrderID)
+		if err != nil {
+			return fmt.Errorf("failed to update refunded amount: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return Refund{}, err
+	}
+	return refund, nil
+}
+
+// reserveAmount returns the amount a refund of amount reserves when left is what remains of the payment
+func reserveAmount(stripeTransactionId string, left, amount int64, capped bool) (int64, error) {
+	if stripeTransactionId == "" || left <= 0 {
+		return 0, ErrNothingToRefund
+	}
+	switch {
+	case amount == 0:
+		return left, nil
+	case amount > left && capped:
+		return left, nil
+	case amount > left:
+		return 0, ErrRefundTooLarge
+	}
+	return amount, nil
+}
+
+// ReleaseRefund gives the amount of a reserved refund back, for when stripe refused it
+func (c *Conf) ReleaseRefund(ctx context.Context, refundId string) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var orderId string
+		var amount int64
+		err := tx.QueryRowContext(ctx, `
+		DELETE FROM refunds
+		WHERE id = $1 AND status = $2
+		RETURNING order_id, amount
+		`, refundId, RefundReserved).Scan(&orderId, &amount)
+		if errors.Is(err, sql.ErrNoRows) {
+			// stripe accepted it after all, or it was released already
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete refund: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET refunded_amount = refunded_amount - $1, updated_at = $2
+		WHERE id = $3
+		`, amount, time.Now().UTC(), orderId)
+		if err != nil {
+			return fmt.Errorf("failed to update refunded amount: %w", err)
+		}
+		return nil
+	})
+	return err
+}
+
+// CompleteRefund records what stripe answered for a reserved refund and moves the order to status when
+// status is set. The refund is recorded even when the order moved on in the meantime, the money is gone
+// either way, only the status is left as it is then. Completing a refund again changes nothing.
+func (c *Conf) CompleteRefund(ctx context.Context, refund Refund, status string) (StatusChanged, error) {
+	changed := StatusChanged{OrderID: refund.OrderID, Note: refund.Reason, ChangedAt: time.Now().UTC()}
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+		SELECT status, user_id
+		FROM orders
+		WHERE id = $1
+		FOR UPDATE
+		`, refund.OrderID).Scan(&changed.FromStatus, &changed.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrOrderNotFound
+			}
+			return fmt.Errorf("failed to fetch order: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE refunds
+		SET stripe_refund_id = $1, status = $2
+		WHERE id = $3
+		`, refund.StripeRefundID, refund.Status, refund.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update refund: %w", err)
+		}
+
+		changed.Status = changed.FromStatus
+		if status == "" || !CanTransition(changed.FromStatus, status) {
+			return nil
+		}
+		changed.Status = status
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET status = $1, updated_at = $2
+		WHERE id = $3
+		`, changed.Status, changed.ChangedAt, refund.OrderID)
+		if err != nil {
+			return fmt.Errorf("failed to update order status: %w", err)
+		}
+
+		createdBy := sql.NullString{String: refund.CreatedBy, Valid: refund.CreatedBy != ""}
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, from_status, to_status, note, changed_by, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`, refund.OrderID, changed.FromStatus, changed.Status, refund.Reason, createdBy, changed.ChangedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record order status history: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return StatusChanged{}, err
+	}
+	return changed, nil
+}
+
+// RestockItems finds what is left to restock of the items of the order and hands it to publish before it is
+// recorded as put back in stock, a failed publish records nothing and the restock can be asked for again.
+// Nil items restock everything on the order. Nothing is restocked twice, whatever was restocked before is
+// taken off, so the product service is only asked for the returned quantities.
+func (c *Conf) RestockItems(ctx context.Context, orderId string, items []Item, publish func([]Item) error) error {
+	return c.withTx(ctx, func(tx *sql.Tx) error {
+		var status string
+		err := tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderId).Scan(&status)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrOrderNotFound
+			}
+			return fmt.Errorf("failed to fetch order: %w", err)
+		}
+
+		ordered, err := orderItems(ctx, tx, orderId)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `SELECT product_id, quantity FROM order_restocks WHERE order_id = $1`, orderId)
+		if err != nil {
+			return fmt.Errorf("failed to fetch order restocks: %w", err)
+		}
+		var restocked []Item
+		for rows.Next() {
+			var item Item
+			if err := rows.Scan(&item.ProductID, &item.Quantity); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+			restocked = append(restocked, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+
+		restock := restockLeft(ordered, restocked, items)
+		if len(restock) == 0 {
+			return nil
+		}
+
+		// the order stays locked while the restock is published, a concurrent one waits and finds it recorded
+		if err := publish(restock); err != nil {
+			return fmt.Errorf("failed to publish order restock: %w", err)
+		}
+
+		for _, item := range restock {
+			_, err := tx.ExecContext(ctx, `
+			INSERT INTO order_restocks (order_id, product_id, quantity)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (order_id, product_id) DO UPDATE SET quantity = order_restocks.quantity + EXCLUDED.quantity
+			`, orderId, item.ProductID, item.Quantity)
+			if err != nil {
+				return fmt.Errorf("failed to record order restock: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// restockLeft returns what is left to restock of items, given what was ordered and what was restocked already.
+// Nil items stand for everything ordered.
+func restockLeft(ordered, restocked, items []Item) []Item {
+	if items == nil {
+		items = ordered
+	}
+
+	left := make(map[string]int, len(ordered))
+	for _, item := range ordered {
+		left[item.ProductID] += item.Quantity
+	}
+	for _, item := range restocked {
+		left[item.ProductID] -= item.Quantity
+	}
+
+	var restock []Item
+	for _, item := range items {
+		quantity := min(item.Quantity, left[item.ProductID])
+		if quantity <= 0 {
+			continue
+		}
+		left[item.ProductID] -= quantity
+		restock = append(restock, Item{ProductID: item.ProductID, Quantity: quantity})
+	}
+	return restock
+}
+
+// orderItems returns the products on the order. Orders placed before line items were stored
+// only know their product, they were always for a single unit.
+func orderItems(ctx context.Context, tx *sql.Tx, orderId string) ([]Item, error) {
+	rows, err := tx.QueryContext(ctx, `
+	SELECT product_id, quantity
+	FROM order_items
+	WHERE order_id = $1
+	`, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch order items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ProductID, &item.Quantity); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	if len(items) > 0 {
+		return items, nil
+	}
+
+	item := Item{Quantity: 1}
+	err = tx.QueryRowContext(ctx, `SELECT product_id FROM orders WHERE id = $1`, orderId).Scan(&item.ProductID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch order: %w", err)
+	}
+	return []Item{item}, nil
+}
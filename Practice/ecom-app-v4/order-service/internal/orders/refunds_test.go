@@ -0,0 +1,65 @@
+package orders
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReserveAmount(t *testing.T) {
+	tt := [...]struct {
+		name     string
+		paid     string
+		left     int64
+		amount   int64
+		capped   bool
+		expected int64
+		err      error
+	}{
+		{"everything left", "pi_1", 5000, 0, false, 5000, nil},
+		{"part of it", "pi_1", 5000, 2000, false, 2000, nil},
+		{"exactly what is left", "pi_1", 5000, 5000, false, 5000, nil},
+		{"more than is left", "pi_1", 5000, 6000, false, 0, ErrRefundTooLarge},
+		{"more than is left, capped", "pi_1", 5000, 6000, true, 5000, nil},
+		{"nothing left", "pi_1", 0, 0, true, 0, ErrNothingToRefund},
+		{"not paid", "", 5000, 0, true, 0, ErrNothingToRefund},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			amount, err := reserveAmount(tc.paid, tc.left, tc.amount, tc.capped)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if amount != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, amount)
+			}
+		})
+	}
+}
+
+func TestRestockLeft(t *testing.T) {
+	ordered := []Item{{ProductID: "a", Quantity: 3}, {ProductID: "b", Quantity: 1}}
+
+	tt := [...]struct {
+		name      string
+		restocked []Item
+		items     []Item
+		expected  []Item
+	}{
+		{"whole order", nil, nil, ordered},
+		{"some items", nil, []Item{{ProductID: "a", Quantity: 2}}, []Item{{ProductID: "a", Quantity: 2}}},
+		{"whole order after a return", []Item{{ProductID: "a", Quantity: 2}}, nil, []Item{{ProductID: "a", Quantity: 1}, {ProductID: "b", Quantity: 1}}},
+		{"more than was ordered", nil, []Item{{ProductID: "b", Quantity: 4}}, []Item{{ProductID: "b", Quantity: 1}}},
+		{"restocked already", ordered, nil, nil},
+		{"not on the order", nil, []Item{{ProductID: "c", Quantity: 1}}, nil},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := restockLeft(ordered, tc.restocked, tc.items); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
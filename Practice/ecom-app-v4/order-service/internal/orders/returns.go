@@ -0,0 +1,435 @@
+package orders
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnReceived  = "received"
+	ReturnRefunded  = "refunded"
+)
+
+// ReturnWindow is how long after delivery a customer can open a return
+const ReturnWindow = 30 * 24 * time.Hour
+
+var (
+	ErrReturnNotFound          = errors.New("return not found")
+	ErrReturnNotAllowed        = errors.New("order can not be returned")
+	ErrReturnWindowClosed      = errors.New("return window has closed")
+	ErrInvalidReturnItems      = errors.New("return items are not on the order or exceed the quantity left to return")
+	ErrInvalidReturnTransition = errors.New("invalid return status transition")
+)
+
+// returnTransitions lists the statuses a return can move to from its current status.
+// An approved return is refunded once the items are received back.
+var returnTransitions = map[string][]string{
+	ReturnRequested: {ReturnApproved, ReturnRejected},
+	ReturnApproved:  {ReturnReceived},
+	ReturnReceived:  {ReturnRefunded},
+}
+
+// CanTransitionReturn reports whether a return in status from may move to status to
+func CanTransitionReturn(from, to string) bool {
+	for _, next := range returnTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ReturnItemRequest is a line of the order the customer sends back
+type ReturnItemRequest struct {
+	ProductID string `json:"product_id" binding:"required,uuid"`
+	Quantity  int    `json:"quantity" binding:"required,min=1"`
+}
+
+// NewReturn is the request body a customer sends to open a return
+type NewReturn struct {
+	Reason string              `json:"reason" binding:"required,min=3,max=500"`
+	Items  []ReturnItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+// ReturnItem is a returned line with what the customer paid for it
+type ReturnItem struct {
+	ProductID    string `json:"product_id"`
+	Quantity     int    `json:"quantity"`
+	RefundAmount int64  `json:"refund_amount"` // in paise
+}
+
+// Return is a return merchandise authorisation for some lines of an order
+type Return struct {
+	ID           string       `json:"id"`
+	OrderID      string       `json:"order_id"`
+	UserID       string       `json:"user_id"`
+	Status       string       `json:"status"`
+	Reason       string       `json:"reason"`
+	AdminNote    string       `json:"admin_note,omitempty"`
+	RefundAmount int64        `json:"refund_amount"` // in paise
+	RefundedAt   *time.Time   `json:"refunded_at,omitempty"`
+	Items        []ReturnItem `json:"items"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// paidLine is what the customer paid for a line of the order, after the discount and with tax
+type paidLine struct {
+	quantity int
+	paid     int64
+}
+
+// paidLines returns what was paid per product of the order. Orders placed before line items
+// were stored are a single unit of their product for the total price.
+func paidLines(ctx context.Context, tx *sql.Tx, orderId string) (map[string]paidLine, error) {
+	rows, err := tx.QueryContext(ctx, `
+	SELECT product_id, quantity, line_total - discount_amount + tax_amount
+	FROM order_items
+	WHERE order_id = $1
+	`, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch order items: %w", err)
+	}
+	defer rows.Close()
+
+	lines := make(map[string]paidLine)
+	for rows.Next() {
+		var productId string
+		var line paidLine
+		if err := rows.Scan(&productId, &line.quantity, &line.paid); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		lines[productId] = line
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	if len(lines) > 0 {
+		return lines, nil
+	}
+
+	var productId string
+	var totalPrice, shipping int64
+	err = tx.QueryRowContext(ctx, `SELECT product_id, total_price, shipping_amount FROM orders WHERE id = $1`, orderId).
+		Scan(&productId, &totalPrice, &shipping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch order: %w", err)
+	}
+	lines[productId] = paidLine{quantity: 1, paid: totalPrice - shipping}
+	return lines, nil
+}
+
+// CreateReturn opens a return for lines of a delivered order of the user. Items already in a return
+// that was not rejected can not be returned again, shipping is not refunded.
+func (c *Conf) CreateReturn(ctx context.Context, orderId, userId string, nr NewReturn) (Return, error) {
+	now := time.Now().UTC()
+	ret := Return{
+		ID:        uuid.NewString(),
+		OrderID:   orderId,
+		UserID:    userId,
+		Status:    ReturnRequested,
+		Reason:    nr.Reason,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var owner, status string
+		var deliveredAt sql.NullTime
+		err := tx.QueryRowContext(ctx, `
+		SELECT user_id, status, delivered_at
+		FROM orders
+		WHERE id = $1
+		FOR UPDATE
+		`, orderId).Scan(&owner, &status, &deliveredAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrOrderNotFound
+			}
+			return fmt.Errorf("failed to fetch order: %w", err)
+		}
+		if owner != userId {
+			return ErrOrderNotFound
+		}
+		if err := returnAllowed(status, deliveredAt, now); err != nil {
+			return err
+		}
+
+		lines, err := paidLines(ctx, tx, orderId)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+		SELECT ri.product_id, SUM(ri.quantity)
+		FROM return_items ri
+		JOIN returns r ON r.id = ri.return_id
+		WHERE r.order_id = $1 AND r.status <> 'rejected'
+		GROUP BY ri.product_id
+		`, orderId)
+		if err != nil {
+			return fmt.Errorf("failed to fetch returned items: %w", err)
+		}
+		returned := make(map[string]int)
+		for rows.Next() {
+			var productId string
+			var quantity int
+			if err := rows.Scan(&productId, &quantity); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+			returned[productId] = quantity
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+
+		ret.Items, ret.RefundAmount, err = returnItems(nr.Items, lines, returned)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO returns (id, order_id, user_id, status, reason, refund_amount, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, ret.ID, ret.OrderID, ret.UserID, ret.Status, ret.Reason, ret.RefundAmount, ret.CreatedAt, ret.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert return: %w", err)
+		}
+
+		for _, item := range ret.Items {
+			_, err := tx.ExecContext(ctx, `
+			INSERT INTO return_items (return_id, product_id, quantity, refund_amount)
+			VALUES ($1, $2, $3, $4)
+			`, ret.ID, item.ProductID, item.Quantity, item.RefundAmount)
+			if err != nil {
+				return fmt.Errorf("failed to insert return item: %w", err)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return Return{}, err
+	}
+	return ret, nil
+}
+
+// returnAllowed checks that an order in status, delivered at deliveredAt, can be returned at now.
+// An order that is returned already can still have lines that were not sent back.
+func returnAllowed(status string, deliveredAt sql.NullTime, now time.Time) error {
+	if (status != StatusDelivered && status != StatusReturned) || !deliveredAt.Valid {
+		return ErrReturnNotAllowed
+	}
+	if now.After(deliveredAt.Time.Add(ReturnWindow)) {
+		return ErrReturnWindowClosed
+	}
+	return nil
+}
+
+// returnItems prices the requested items at what was paid for their lines, prorated by quantity, and returns them
+// with the amount to refund. returned holds the quantities in other returns, no more than the rest can be returned.
+func returnItems(requested []ReturnItemRequest, lines map[string]paidLine, returned map[string]int) ([]ReturnItem, int64, error) {
+	var items []ReturnItem
+	var total int64
+	for _, item := range requested {
+		line, ok := lines[item.ProductID]
+		if !ok || item.Quantity > line.quantity-returned[item.ProductID] {
+			return nil, 0, ErrInvalidReturnItems
+		}
+		// counting it as returned catches the same product listed twice
+		returned[item.ProductID] += item.Quantity
+
+		amount := line.paid * int64(item.Quantity) / int64(line.quantity)
+		items = append(items, ReturnItem{ProductID: item.ProductID, Quantity: item.Quantity, RefundAmount: amount})
+		total += amount
+	}
+	return items, total, nil
+}
+
+const returnColumns = `id, order_id, user_id, status, reason, admin_note, refund_amount, refunded_at, created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanReturn(row scanner) (Return, error) {
+	var ret Return
+	var refundedAt sql.NullTime
+	err := row.Scan(&ret.ID, &ret.OrderID, &ret.UserID, &ret.Status, &ret.Reason, &ret.AdminNote, &ret.RefundAmount,
+		&refundedAt, &ret.CreatedAt, &ret.UpdatedAt)
+	if err != nil {
+		return Return{}, err
+	}
+	if refundedAt.Valid {
+		ret.RefundedAt = &refundedAt.Time
+	}
+	return ret, nil
+}
+
+// GetReturn returns the return with its items
+func (c *Conf) GetReturn(ctx context.Context, returnId string) (Return, error) {
+	ret, err := scanReturn(c.db.QueryRowContext(ctx, `SELECT `+returnColumns+` FROM returns WHERE id = $1`, returnId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Return{}, ErrReturnNotFound
+		}
+		return Return{}, fmt.Errorf("failed to fetch return: %w", err)
+	}
+
+	returns := []Return{ret}
+	err = c.loadReturnItems(ctx, returns)
+	if err != nil {
+		return Return{}, err
+	}
+	return returns[0], nil
+}
+
+// ListReturns returns the returns of an order, or every return in a status when orderId is empty
+func (c *Conf) ListReturns(ctx context.Context, orderId, status string) ([]Return, error) {
+	query := `SELECT ` + returnColumns + ` FROM returns WHERE order_id = $1 ORDER BY created_at DESC`
+	arg := orderId
+	if orderId == "" {
+		query = `SELECT ` + returnColumns + ` FROM returns WHERE status = $1 ORDER BY created_at ASC`
+		arg = status
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch returns: %w", err)
+	}
+	defer rows.Close()
+
+	returns := []Return{}
+	for rows.Next() {
+		ret, err := scanReturn(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		returns = append(returns, ret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	err = c.loadReturnItems(ctx, returns)
+	if err != nil {
+		return nil, err
+	}
+	return returns, nil
+}
+
+func (c *Conf) loadReturnItems(ctx context.Context, returns []Return) error {
+	for i := range returns {
+		rows, err := c.db.QueryContext(ctx, `
+		SELECT product_id, quantity, refund_amount
+		FROM return_items
+		WHERE return_id = $1
+		`, returns[i].ID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch return items: %w", err)
+		}
+
+		returns[i].Items = []ReturnItem{}
+		for rows.Next() {
+			var item ReturnItem
+			if err := rows.Scan(&item.ProductID, &item.Quantity, &item.RefundAmount); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+			returns[i].Items = append(returns[i].Items, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+	}
+	return nil
+}
+
+// TransitionReturn moves the return to the new status, note is kept as the admin note when it is set
+func (c *Conf) TransitionReturn(ctx context.Context, returnId, status, note string) (Return, error) {
+	now := time.Now().UTC()
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var current string
+		err := tx.QueryRowContext(ctx, `SELECT status FROM returns WHERE id = $1 FOR UPDATE`, returnId).Scan(&current)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrReturnNotFound
+			}
+			return fmt.Errorf("failed to fetch return: %w", err)
+		}
+		if !CanTransitionReturn(current, status) {
+			return fmt.Errorf("%w from %s to %s", ErrInvalidReturnTransition, current, status)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE returns
+		SET status = $1,
+			admin_note = CASE WHEN $2 = '' THEN admin_note ELSE $2 END,
+			refunded_at = CASE WHEN $1 = 'refunded' THEN $3 ELSE refunded_at END,
+			updated_at = $3
+		WHERE id = $4
+		`, status, note, now, returnId)
+		if err != nil {
+			return fmt.Errorf("failed to update return: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return Return{}, err
+	}
+	return c.GetReturn(ctx, returnId)
+}
+
+// ReceiveReturn moves an approved return to received. The return is locked while its status is checked,
+// so two admins receiving it at the same time move it once. A received return is returned as it is,
+// its refund is retried. moved tells whether the status changed.
+func (c *Conf) ReceiveReturn(ctx context.Context, returnId string) (Return, bool, error) {
+	now := time.Now().UTC()
+	var moved bool
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var current string
+		err := tx.QueryRowContext(ctx, `SELECT status FROM returns WHERE id = $1 FOR UPDATE`, returnId).Scan(&current)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrReturnNotFound
+			}
+			return fmt.Errorf("failed to fetch return: %w", err)
+		}
+		if current == ReturnReceived {
+			return nil
+		}
+		if !CanTransitionReturn(current, ReturnReceived) {
+			return fmt.Errorf("%w from %s to %s", ErrInvalidReturnTransition, current, ReturnReceived)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE returns SET status = $1, updated_at = $2 WHERE id = $3`, ReturnReceived, now, returnId)
+		if err != nil {
+			return fmt.Errorf("failed to update return: %w", err)
+		}
+		moved = true
+		return nil
+	})
+
+	if err != nil {
+		return Return{}, false, err
+	}
+	ret, err := c.GetReturn(ctx, returnId)
+	if err != nil {
+		return Return{}, false, err
+	}
+	return ret, moved, nil
+}
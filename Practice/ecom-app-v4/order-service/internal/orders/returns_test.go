@@ -0,0 +1,145 @@
+package orders
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCanTransitionReturn(t *testing.T) {
+	tt := [...]struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{ReturnRequested, ReturnApproved, true},
+		{ReturnRequested, ReturnRejected, true},
+		{ReturnApproved, ReturnReceived, true},
+		{ReturnReceived, ReturnRefunded, true},
+		{ReturnRequested, ReturnReceived, false},
+		{ReturnRejected, ReturnApproved, false},
+		{ReturnApproved, ReturnRejected, false},
+		{ReturnRefunded, ReturnReceived, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.from+" to "+tc.to, func(t *testing.T) {
+			if got := CanTransitionReturn(tc.from, tc.to); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestReturnAllowed(t *testing.T) {
+	now := time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)
+	delivered := func(ago time.Duration) sql.NullTime { return sql.NullTime{Time: now.Add(-ago), Valid: true} }
+
+	tt := [...]struct {
+		name        string
+		status      string
+		deliveredAt sql.NullTime
+		expected    error
+	}{
+		{"delivered yesterday", StatusDelivered, delivered(24 * time.Hour), nil},
+		{"last day of the window", StatusDelivered, delivered(ReturnWindow), nil},
+		{"outside the window", StatusDelivered, delivered(ReturnWindow + time.Minute), ErrReturnWindowClosed},
+		{"partly returned", StatusReturned, delivered(24 * time.Hour), nil},
+		{"shipped", StatusShipped, sql.NullTime{}, ErrReturnNotAllowed},
+		{"refunded", StatusRefunded, delivered(24 * time.Hour), ErrReturnNotAllowed},
+		{"delivered without a date", StatusDelivered, sql.NullTime{}, ErrReturnNotAllowed},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := returnAllowed(tc.status, tc.deliveredAt, now); !errors.Is(err, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestReturnItems(t *testing.T) {
+	// 3 of a paid 3000 after the discount, 1 of b paid 999, 3 of c paid 1000
+	lines := map[string]paidLine{"a": {quantity: 3, paid: 3000}, "b": {quantity: 1, paid: 999}, "c": {quantity: 3, paid: 1000}}
+
+	tt := [...]struct {
+		name      string
+		requested []ReturnItemRequest
+		returned  map[string]int
+		items     []ReturnItem
+		amount    int64
+		err       error
+	}{
+		{
+			name:      "whole line",
+			requested: []ReturnItemRequest{{ProductID: "b", Quantity: 1}},
+			items:     []ReturnItem{{ProductID: "b", Quantity: 1, RefundAmount: 999}},
+			amount:    999,
+		},
+		{
+			name:      "part of a line is prorated",
+			requested: []ReturnItemRequest{{ProductID: "a", Quantity: 2}},
+			items:     []ReturnItem{{ProductID: "a", Quantity: 2, RefundAmount: 2000}},
+			amount:    2000,
+		},
+		{
+			name:      "prorated amount is rounded down",
+			requested: []ReturnItemRequest{{ProductID: "c", Quantity: 1}},
+			items:     []ReturnItem{{ProductID: "c", Quantity: 1, RefundAmount: 333}},
+			amount:    333,
+		},
+		{
+			name:      "several lines",
+			requested: []ReturnItemRequest{{ProductID: "a", Quantity: 1}, {ProductID: "b", Quantity: 1}},
+			items:     []ReturnItem{{ProductID: "a", Quantity: 1, RefundAmount: 1000}, {ProductID: "b", Quantity: 1, RefundAmount: 999}},
+			amount:    1999,
+		},
+		{
+			name:      "rest of a partly returned line",
+			requested: []ReturnItemRequest{{ProductID: "a", Quantity: 1}},
+			returned:  map[string]int{"a": 2},
+			items:     []ReturnItem{{ProductID: "a", Quantity: 1, RefundAmount: 1000}},
+			amount:    1000,
+		},
+		{
+			name:      "more than was ordered",
+			requested: []ReturnItemRequest{{ProductID: "a", Quantity: 4}},
+			err:       ErrInvalidReturnItems,
+		},
+		{
+			name:      "more than is left after another return",
+			requested: []ReturnItemRequest{{ProductID: "a", Quantity: 2}},
+			returned:  map[string]int{"a": 2},
+			err:       ErrInvalidReturnItems,
+		},
+		{
+			name:      "same product listed twice",
+			requested: []ReturnItemRequest{{ProductID: "b", Quantity: 1}, {ProductID: "b", Quantity: 1}},
+			err:       ErrInvalidReturnItems,
+		},
+		{
+			name:      "not on the order",
+			requested: []ReturnItemRequest{{ProductID: "d", Quantity: 1}},
+			err:       ErrInvalidReturnItems,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			returned := tc.returned
+			if returned == nil {
+				returned = map[string]int{}
+			}
+			items, amount, err := returnItems(tc.requested, lines, returned)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(items, tc.items) || amount != tc.amount {
+				t.Errorf("expected (%v, %d), got (%v, %d)", tc.items, tc.amount, items, amount)
+			}
+		})
+	}
+}
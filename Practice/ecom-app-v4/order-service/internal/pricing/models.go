@@ -0,0 +1,36 @@
+package pricing
+
+// Line is a line item of the checkout with the product details pricing needs, amounts are in paise
+type Line struct {
+	ProductID   string
+	Name        string
+	Category    string
+	UnitPrice   int64
+	Quantity    int64
+	WeightGrams int64 // weight of one unit
+}
+
+// QuoteLine is the price breakdown of one line item
+type QuoteLine struct {
+	ProductID string `json:"product_id"`
+	Name      string `json:"name"`
+	Category  string `json:"category"`
+	Quantity  int64  `json:"quantity"`
+	UnitPrice int64  `json:"unit_price"`
+	LineTotal int64  `json:"line_total"` // unit price times quantity
+	Discount  int64  `json:"discount"`   // share of the coupon discount
+	TaxRate   int64  `json:"tax_rate"`   // in basis points, 1800 is 18%
+	Tax       int64  `json:"tax"`
+}
+
+// Quote is the itemised price of an order, Total is what the customer pays
+type Quote struct {
+	Lines       []QuoteLine `json:"lines"`
+	Subtotal    int64       `json:"subtotal"`
+	Discount    int64       `json:"discount"`
+	Tax         int64       `json:"tax"`
+	Shipping    int64       `json:"shipping"`
+	Total       int64       `json:"total"`
+	Region      string      `json:"region"`
+	WeightGrams int64       `json:"weight_grams"`
+}
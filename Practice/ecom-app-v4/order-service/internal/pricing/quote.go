@@ -0,0 +1,85 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+)
+
+// ShippingRates returns the shipping cost for a parcel of the given weight to the region
+type ShippingRates interface {
+	ShippingCost(ctx context.Context, region string, weightGrams int64) (int64, error)
+}
+
+// Pricer builds the quote of a checkout from the tax calculator and the shipping rates
+type Pricer struct {
+	tax   TaxCalculator
+	rates ShippingRates
+}
+
+func NewPricer(tax TaxCalculator, rates ShippingRates) (*Pricer, error) {
+	if tax == nil || rates == nil {
+		return nil, errors.New("tax calculator and shipping rates are required")
+	}
+	return &Pricer{tax: tax, rates: rates}, nil
+}
+
+// Quote prices the line items, discount is the coupon discount of the whole cart in paise
+func (p *Pricer) Quote(ctx context.Context, lines []Line, discount int64, region string) (Quote, error) {
+	region = NormalizeRegion(region)
+
+	var weight int64
+	for _, line := range lines {
+		weight += line.WeightGrams * line.Quantity
+	}
+
+	shipping, err := p.rates.ShippingCost(ctx, region, weight)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	quote := BuildQuote(lines, discount, shipping, p.tax)
+	quote.Region = region
+	quote.WeightGrams = weight
+	return quote, nil
+}
+
+// BuildQuote itemises the order. The discount is spread over the line items in proportion to
+// their value, so the tax of every line is charged on what the customer actually pays for it.
+// Shipping is not taxed.
+func BuildQuote(lines []Line, discount int64, shipping int64, tax TaxCalculator) Quote {
+	var quote Quote
+	for _, line := range lines {
+		quote.Subtotal += line.UnitPrice * line.Quantity
+	}
+	discount = min(discount, quote.Subtotal)
+
+	remaining := discount
+	for i, line := range lines {
+		ql := QuoteLine{
+			ProductID: line.ProductID,
+			Name:      line.Name,
+			Category:  line.Category,
+			Quantity:  line.Quantity,
+			UnitPrice: line.UnitPrice,
+			LineTotal: line.UnitPrice * line.Quantity,
+		}
+
+		// the last line takes what is left so the shares add up to the discount exactly
+		if i == len(lines)-1 {
+			ql.Discount = remaining
+		} else if quote.Subtotal > 0 {
+			ql.Discount = discount * ql.LineTotal / quote.Subtotal
+		}
+		remaining -= ql.Discount
+
+		ql.TaxRate, ql.Tax = tax.Tax(line, ql.LineTotal-ql.Discount)
+
+		quote.Tax += ql.Tax
+		quote.Lines = append(quote.Lines, ql)
+	}
+
+	quote.Discount = discount
+	quote.Shipping = shipping
+	quote.Total = quote.Subtotal - quote.Discount + quote.Tax + quote.Shipping
+	return quote
+}
@@ -0,0 +1,75 @@
+package pricing
+
+import "testing"
+
+func TestBuildQuote(t *testing.T) {
+	gst := NewGSTCalculator(DefaultGSTRates, DefaultGSTRate)
+
+	tt := [...]struct {
+		name          string
+		lines         []Line
+		discount      int64
+		shipping      int64
+		expectedTax   int64
+		expectedTotal int64
+		expectedLines []QuoteLine
+	}{
+		{
+			name: "Discount Spread Over Lines",
+			lines: []Line{
+				{ProductID: "p1", Category: "Electronics", UnitPrice: 100000, Quantity: 2},
+				{ProductID: "p2", Category: "Books", UnitPrice: 20000, Quantity: 1},
+			},
+			discount:      22000,
+			shipping:      6000,
+			expectedTax:   32400,
+			expectedTotal: 236400,
+			expectedLines: []QuoteLine{
+				{ProductID: "p1", Category: "Electronics", Quantity: 2, UnitPrice: 100000, LineTotal: 200000, Discount: 20000, TaxRate: 1800, Tax: 32400},
+				{ProductID: "p2", Category: "Books", Quantity: 1, UnitPrice: 20000, LineTotal: 20000, Discount: 2000, TaxRate: 0, Tax: 0},
+			},
+		},
+		{
+			name:          "Tax Rounds Half Up And Unknown Category Uses Default",
+			lines:         []Line{{ProductID: "p1", Category: "grocery", UnitPrice: 333, Quantity: 1}, {ProductID: "p2", Category: "gadgets", UnitPrice: 1000, Quantity: 1}},
+			expectedTax:   197,
+			expectedTotal: 1530,
+			expectedLines: []QuoteLine{
+				{ProductID: "p1", Category: "grocery", Quantity: 1, UnitPrice: 333, LineTotal: 333, TaxRate: 500, Tax: 17},
+				{ProductID: "p2", Category: "gadgets", Quantity: 1, UnitPrice: 1000, LineTotal: 1000, TaxRate: 1800, Tax: 180},
+			},
+		},
+		{
+			name:          "Discount Capped At Subtotal",
+			lines:         []Line{{ProductID: "p1", Category: "Electronics", UnitPrice: 5000, Quantity: 1}},
+			discount:      9000,
+			shipping:      4000,
+			expectedTax:   0,
+			expectedTotal: 4000,
+			expectedLines: []QuoteLine{
+				{ProductID: "p1", Category: "Electronics", Quantity: 1, UnitPrice: 5000, LineTotal: 5000, Discount: 5000, TaxRate: 1800, Tax: 0},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			quote := BuildQuote(tc.lines, tc.discount, tc.shipping, gst)
+
+			if quote.Tax != tc.expectedTax {
+				t.Errorf("expected tax %d, got %d", tc.expectedTax, quote.Tax)
+			}
+			if quote.Total != tc.expectedTotal {
+				t.Errorf("expected total %d, got %d", tc.expectedTotal, quote.Total)
+			}
+			if len(quote.Lines) != len(tc.expectedLines) {
+				t.Fatalf("expected %d lines, got %d", len(tc.expectedLines), len(quote.Lines))
+			}
+			for i, line := range quote.Lines {
+				if line != tc.expectedLines[i] {
+					t.Errorf("line %d: expected %+v, got %+v", i, tc.expectedLines[i], line)
+				}
+			}
+		})
+	}
+}
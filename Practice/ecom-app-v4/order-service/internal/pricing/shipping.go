@@ -0,0 +1,78 @@
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultRegion holds the shipping rates used for regions without rates of their own
+const DefaultRegion = "default"
+
+var ErrNoShippingRate = errors.New("no shipping rate for the weight and region")
+
+type Conf struct {
+	db *sql.DB
+}
+
+func NewConf(db *sql.DB) (Conf, error) {
+	if db == nil {
+		return Conf{}, fmt.Errorf("db is nil")
+	}
+	return Conf{db: db}, nil
+}
+
+// NormalizeRegion lower cases the region, an empty region uses the default rates
+func NormalizeRegion(region string) string {
+	region = strings.ToLower(strings.TrimSpace(region))
+	if region == "" {
+		return DefaultRegion
+	}
+	return region
+}
+
+// northeastStates ship with the northeast rates
+var northeastStates = map[string]bool{
+	"arunachal pradesh": true,
+	"assam":             true,
+	"manipur":           true,
+	"meghalaya":         true,
+	"mizoram":           true,
+	"nagaland":          true,
+	"sikkim":            true,
+	"tripura":           true,
+}
+
+// RegionForState returns the shipping region of an Indian state, other states use the default rates
+func RegionForState(state string) string {
+	if northeastStates[strings.ToLower(strings.TrimSpace(state))] {
+		return "northeast"
+	}
+	return DefaultRegion
+}
+
+// ShippingCost returns the price of the smallest weight slab the parcel fits in.
+// A region with rates of its own never falls back to the default rates.
+func (c *Conf) ShippingCost(ctx context.Context, region string, weightGrams int64) (int64, error) {
+	var price int64
+
+	query := `
+	SELECT price
+	FROM shipping_rates
+	WHERE region = (SELECT COALESCE(MAX(region), $3) FROM shipping_rates WHERE region = $1)
+		AND max_weight_grams >= $2
+	ORDER BY max_weight_grams ASC
+	LIMIT 1
+	`
+
+	err := c.db.QueryRowContext(ctx, query, NormalizeRegion(region), weightGrams, DefaultRegion).Scan(&price)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoShippingRate
+		}
+		return 0, fmt.Errorf("failed to fetch shipping rate: %w", err)
+	}
+	return price, nil
+}
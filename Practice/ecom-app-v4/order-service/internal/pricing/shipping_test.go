@@ -0,0 +1,43 @@
+package pricing
+
+import "testing"
+
+func TestRegionForState(t *testing.T) {
+	tt := [...]struct {
+		name     string
+		state    string
+		expected string
+	}{
+		{name: "Northeast State", state: "Assam", expected: "northeast"},
+		{name: "Case And Spaces Ignored", state: "  ARUNACHAL pradesh ", expected: "northeast"},
+		{name: "Other State", state: "Karnataka", expected: DefaultRegion},
+		{name: "Empty State", state: "", expected: DefaultRegion},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := RegionForState(tc.state); got != tc.expected {
+				t.Errorf("RegionForState(%q) = %q, want %q", tc.state, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeRegion(t *testing.T) {
+	tt := [...]struct {
+		name     string
+		region   string
+		expected string
+	}{
+		{name: "Lower Cased", region: " NorthEast ", expected: "northeast"},
+		{name: "Empty Uses Default", region: "  ", expected: DefaultRegion},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := NormalizeRegion(tc.region); got != tc.expected {
+				t.Errorf("NormalizeRegion(%q) = %q, want %q", tc.region, got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package pricing
+
+import "strings"
+
+// TaxCalculator works out the tax of a line item, taxable is the value of the line after its discount.
+// It returns the rate in basis points and the tax in paise.
+type TaxCalculator interface {
+	Tax(line Line, taxable int64) (rate int64, tax int64)
+}
+
+// DefaultGSTRate is charged on categories without a rate of their own
+const DefaultGSTRate = 1800
+
+// DefaultGSTRates are the GST slabs in basis points per product category
+var DefaultGSTRates = map[string]int64{
+	"books":       0,
+	"grocery":     500,
+	"footwear":    1200,
+	"clothing":    1200,
+	"furniture":   1800,
+	"electronics": 1800,
+	"beauty":      1800,
+	"toys":        1800,
+	"automobile":  2800,
+}
+
+// GSTCalculator charges a flat GST rate per product category
+type GSTCalculator struct {
+	rates       map[string]int64
+	defaultRate int64
+}
+
+// NewGSTCalculator creates the calculator, category names are matched case insensitively
+func NewGSTCalculator(rates map[string]int64, defaultRate int64) *GSTCalculator {
+	r := make(map[string]int64, len(rates))
+	for category, rate := range rates {
+		r[strings.ToLower(category)] = rate
+	}
+	return &GSTCalculator{rates: r, defaultRate: defaultRate}
+}
+
+func (g *GSTCalculator) Tax(line Line, taxable int64) (int64, int64) {
+	rate, ok := g.rates[strings.ToLower(line.Category)]
+	if !ok {
+		rate = g.defaultRate
+	}
+	// round half up to the nearest paisa
+	return rate, (taxable*rate + 5000) / 10000
+}
@@ -0,0 +1,77 @@
+package promotions
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCouponInactive      = errors.New("coupon is not active")
+	ErrCouponNotStarted    = errors.New("coupon is not valid yet")
+	ErrCouponExpired       = errors.New("coupon has expired")
+	ErrUsageLimitReached   = errors.New("coupon usage limit reached")
+	ErrUserLimitReached    = errors.New("coupon already used the maximum number of times")
+	ErrMinCartValue        = errors.New("cart value is below the coupon minimum")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to any item in the cart")
+)
+
+// Discount checks every rule of the coupon and returns the discount in paise for the cart.
+// userRedemptions is the number of paid orders the user already used the coupon on.
+func (cp Coupon) Discount(lines []CartLine, userRedemptions int, now time.Time) (int64, error) {
+	if !cp.Active {
+		return 0, ErrCouponInactive
+	}
+	if cp.StartsAt != nil && now.Before(*cp.StartsAt) {
+		return 0, ErrCouponNotStarted
+	}
+	if cp.EndsAt != nil && !now.Before(*cp.EndsAt) {
+		return 0, ErrCouponExpired
+	}
+	if cp.MaxRedemptions != nil && cp.RedemptionCount >= *cp.MaxRedemptions {
+		return 0, ErrUsageLimitReached
+	}
+	if cp.MaxRedemptionsPerUser != nil && userRedemptions >= *cp.MaxRedemptionsPerUser {
+		return 0, ErrUserLimitReached
+	}
+
+	// only the targeted items count towards the minimum and the discount
+	var eligible int64
+	for _, line := range lines {
+		if cp.appliesTo(line) {
+			eligible += line.UnitPrice * line.Quantity
+		}
+	}
+	if eligible == 0 {
+		return 0, ErrCouponNotApplicable
+	}
+	if eligible < cp.MinCartValue {
+		return 0, ErrMinCartValue
+	}
+
+	switch cp.DiscountType {
+	case TypePercentage:
+		return eligible * cp.DiscountValue / 100, nil
+	default:
+		// a fixed coupon never takes more than the eligible items cost
+		return min(cp.DiscountValue, eligible), nil
+	}
+}
+
+// appliesTo reports whether the line item is targeted, a coupon without targets applies to everything
+func (cp Coupon) appliesTo(line CartLine) bool {
+	if len(cp.ProductIDs) == 0 && len(cp.Categories) == 0 {
+		return true
+	}
+	for _, id := range cp.ProductIDs {
+		if id == line.ProductID {
+			return true
+		}
+	}
+	for _, category := range cp.Categories {
+		if strings.EqualFold(category, line.Category) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package promotions
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCouponDiscount(t *testing.T) {
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-24 * time.Hour)
+	future := now.Add(24 * time.Hour)
+	one := 1
+
+	lines := []CartLine{
+		{ProductID: "p1", Category: "Electronics", UnitPrice: 50000, Quantity: 2},
+		{ProductID: "p2", Category: "Books", UnitPrice: 20000, Quantity: 1},
+	}
+
+	tt := [...]struct {
+		name            string
+		coupon          Coupon
+		userRedemptions int
+		expectedAmount  int64
+		expectedErr     error
+	}{
+		{
+			name:           "Percentage On Whole Cart",
+			coupon:         Coupon{Active: true, DiscountType: TypePercentage, DiscountValue: 10},
+			expectedAmount: 12000,
+		},
+		{
+			name:           "Fixed Is Capped At Eligible Total",
+			coupon:         Coupon{Active: true, DiscountType: TypeFixed, DiscountValue: 50000, Categories: []string{"books"}},
+			expectedAmount: 20000,
+		},
+		{
+			name:           "Product Target",
+			coupon:         Coupon{Active: true, DiscountType: TypePercentage, DiscountValue: 50, ProductIDs: []string{"p1"}},
+			expectedAmount: 50000,
+		},
+		{
+			name:        "Inactive",
+			coupon:      Coupon{Active: false, DiscountType: TypeFixed, DiscountValue: 100},
+			expectedErr: ErrCouponInactive,
+		},
+		{
+			name:        "Not Started",
+			coupon:      Coupon{Active: true, DiscountType: TypeFixed, DiscountValue: 100, StartsAt: &future},
+			expectedErr: ErrCouponNotStarted,
+		},
+		{
+			name:        "Expired",
+			coupon:      Coupon{Active: true, DiscountType: TypeFixed, DiscountValue: 100, EndsAt: &past},
+			expectedErr: ErrCouponExpired,
+		},
+		{
+			name:        "Usage Limit Reached",
+			coupon:      Coupon{Active: true, DiscountType: TypeFixed, DiscountValue: 100, MaxRedemptions: &one, RedemptionCount: 1},
+			expectedErr: ErrUsageLimitReached,
+		},
+		{
+			name:            "User Limit Reached",
+			coupon:          Coupon{Active: true, DiscountType: TypeFixed, DiscountValue: 100, MaxRedemptionsPerUser: &one},
+			userRedemptions: 1,
+			expectedErr:     ErrUserLimitReached,
+		},
+		{
+			name:        "Below Minimum Of Targeted Items",
+			coupon:      Coupon{Active: true, DiscountType: TypeFixed, DiscountValue: 100, MinCartValue: 30000, Categories: []string{"Books"}},
+			expectedErr: ErrMinCartValue,
+		},
+		{
+			name:        "No Targeted Items",
+			coupon:      Coupon{Active: true, DiscountType: TypeFixed, DiscountValue: 100, ProductIDs: []string{"p3"}},
+			expectedErr: ErrCouponNotApplicable,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			amount, err := tc.coupon.Discount(lines, tc.userRedemptions, now)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if amount != tc.expectedAmount {
+				t.Errorf("expected discount %d, got %d", tc.expectedAmount, amount)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package promotions
+
+import "time"
+
+const (
+	TypePercentage = "percentage"
+	TypeFixed      = "fixed"
+
+	TargetProduct  = "product"
+	TargetCategory = "category"
+)
+
+// Coupon represents a coupon in the database, amounts are in paise
+type Coupon struct {
+	ID                    string     `json:"id"`
+	Code                  string     `json:"code"`
+	DiscountType          string     `json:"discount_type"`  // percentage or fixed
+	DiscountValue         int64      `json:"discount_value"` // percent or paise depending on the type
+	MinCartValue          int64      `json:"min_cart_value"`
+	MaxRedemptions        *int       `json:"max_redemptions,omitempty"`          // nil for no global limit
+	MaxRedemptionsPerUser *int       `json:"max_redemptions_per_user,omitempty"` // nil for no per user limit
+	RedemptionCount       int        `json:"redemption_count"`
+	StartsAt              *time.Time `json:"starts_at,omitempty"`
+	EndsAt                *time.Time `json:"ends_at,omitempty"`
+	Active                bool       `json:"active"`
+	ProductIDs            []string   `json:"product_ids,omitempty"` // products the coupon is limited to
+	Categories            []string   `json:"categories,omitempty"`  // categories the coupon is limited to
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+// NewCoupon is the request body an admin sends to create a coupon
+type NewCoupon struct {
+	Code                  string     `json:"code" binding:"required,min=3,max=50,alphanum"`
+	DiscountType          string     `json:"discount_type" binding:"required,oneof=percentage fixed"`
+	DiscountValue         int64      `json:"discount_value" binding:"required,min=1"`
+	MinCartValue          int64      `json:"min_cart_value" binding:"omitempty,min=0"`
+	MaxRedemptions        *int       `json:"max_redemptions" binding:"omitempty,min=1"`
+	MaxRedemptionsPerUser *int       `json:"max_redemptions_per_user" binding:"omitempty,min=1"`
+	StartsAt              *time.Time `json:"starts_at"`
+	EndsAt                *time.Time `json:"ends_at"`
+	ProductIDs            []string   `json:"product_ids" binding:"omitempty,dive,uuid"`
+	Categories            []string   `json:"categories" binding:"omitempty,dive,min=1,max=100"`
+}
+
+// CartLine is a line item of the checkout with the product details the coupon rules need
+type CartLine struct {
+	ProductID string
+	Category  string
+	UnitPrice int64 // in paise
+	Quantity  int64
+}
@@ -0,0 +1,239 @@
+package promotions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var ErrDuplicateCode = errors.New("coupon code already exists")
+
+type Conf struct {
+	db *sql.DB
+}
+
+func NewConf(db *sql.DB) (Conf, error) {
+	if db == nil {
+		return Conf{}, fmt.Errorf("db is nil")
+	}
+	return Conf{db: db}, nil
+}
+
+// CreateCoupon stores the coupon and its product and category targets
+func (c *Conf) CreateCoupon(ctx context.Context, nc NewCoupon) (Coupon, error) {
+	now := time.Now().UTC()
+
+	coupon := Coupon{
+		ID:                    uuid.NewString(),
+		Code:                  strings.ToUpper(nc.Code),
+		DiscountType:          nc.DiscountType,
+		DiscountValue:         nc.DiscountValue,
+		MinCartValue:          nc.MinCartValue,
+		MaxRedemptions:        nc.MaxRedemptions,
+		MaxRedemptionsPerUser: nc.MaxRedemptionsPerUser,
+		StartsAt:              nc.StartsAt,
+		EndsAt:                nc.EndsAt,
+		Active:                true,
+		ProductIDs:            nc.ProductIDs,
+		Categories:            nc.Categories,
+		CreatedAt:             now,
+		UpdatedAt:             now,
+	}
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+		INSERT INTO coupons
+		(id, code, discount_type, discount_value, min_cart_value, max_redemptions, max_redemptions_per_user,
+		 starts_at, ends_at, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		`
+		_, err := tx.ExecContext(ctx, query, coupon.ID, coupon.Code, coupon.DiscountType, coupon.DiscountValue,
+			coupon.MinCartValue, coupon.MaxRedemptions, coupon.MaxRedemptionsPerUser,
+			coupon.StartsAt, coupon.EndsAt, coupon.Active, coupon.CreatedAt, coupon.UpdatedAt)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+				return ErrDuplicateCode
+			}
+			return fmt.Errorf("failed to insert coupon: %w", err)
+		}
+
+		queryTarget := `
+		INSERT INTO coupon_targets (coupon_id, target_type, target_value)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		`
+		for _, productId := range coupon.ProductIDs {
+			_, err := tx.ExecContext(ctx, queryTarget, coupon.ID, TargetProduct, productId)
+			if err != nil {
+				return fmt.Errorf("failed to insert coupon target: %w", err)
+			}
+		}
+		for _, category := range coupon.Categories {
+			_, err := tx.ExecContext(ctx, queryTarget, coupon.ID, TargetCategory, category)
+			if err != nil {
+				return fmt.Errorf("failed to insert coupon target: %w", err)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return Coupon{}, fmt.Errorf("failed to create coupon: %w", err)
+	}
+
+	return coupon, nil
+}
+
+const couponColumns = `
+	id, code, discount_type, discount_value, min_cart_value, max_redemptions, max_redemptions_per_user,
+	redemption_count, starts_at, ends_at, active, created_at, updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCoupon(row scanner) (Coupon, error) {
+	var cp Coupon
+	var maxRedemptions, maxPerUser sql.NullInt32
+	var startsAt, endsAt sql.NullTime
+
+	err := row.Scan(&cp.ID, &cp.Code, &cp.DiscountType, &cp.DiscountValue, &cp.MinCartValue,
+		&maxRedemptions, &maxPerUser, &cp.RedemptionCount, &startsAt, &endsAt, &cp.Active,
+		&cp.CreatedAt, &cp.UpdatedAt)
+	if err != nil {
+		return Coupon{}, err
+	}
+
+	if maxRedemptions.Valid {
+		v := int(maxRedemptions.Int32)
+		cp.MaxRedemptions = &v
+	}
+	if maxPerUser.Valid {
+		v := int(maxPerUser.Int32)
+		cp.MaxRedemptionsPerUser = &v
+	}
+	if startsAt.Valid {
+		cp.StartsAt = &startsAt.Time
+	}
+	if endsAt.Valid {
+		cp.EndsAt = &endsAt.Time
+	}
+	return cp, nil
+}
+
+// GetCouponByCode returns the coupon with its targets, sql.ErrNoRows is returned for an unknown code
+func (c *Conf) GetCouponByCode(ctx context.Context, code string) (Coupon, error) {
+	query := `SELECT ` + couponColumns + ` FROM coupons WHERE code = $1`
+
+	coupon, err := scanCoupon(c.db.QueryRowContext(ctx, query, strings.ToUpper(code)))
+	if err != nil {
+		return Coupon{}, fmt.Errorf("failed to fetch coupon %s: %w", code, err)
+	}
+
+	err = c.loadTargets(ctx, &coupon)
+	if err != nil {
+		return Coupon{}, err
+	}
+	return coupon, nil
+}
+
+// ListCoupons returns every coupon, newest first
+func (c *Conf) ListCoupons(ctx context.Context) ([]Coupon, error) {
+	query := `SELECT ` + couponColumns + ` FROM coupons ORDER BY created_at DESC`
+
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch coupons: %w", err)
+	}
+	defer rows.Close()
+
+	var coupons []Coupon
+	for rows.Next() {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		coupons = append(coupons, coupon)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	for i := range coupons {
+		err := c.loadTargets(ctx, &coupons[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return coupons, nil
+}
+
+func (c *Conf) loadTargets(ctx context.Context, coupon *Coupon) error {
+	rows, err := c.db.QueryContext(ctx, `
+	SELECT target_type, target_value
+	FROM coupon_targets
+	WHERE coupon_id = $1
+	`, coupon.ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch coupon targets: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var targetType, targetValue string
+		if err := rows.Scan(&targetType, &targetValue); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		switch targetType {
+		case TargetProduct:
+			coupon.ProductIDs = append(coupon.ProductIDs, targetValue)
+		case TargetCategory:
+			coupon.Categories = append(coupon.Categories, targetValue)
+		}
+	}
+	return rows.Err()
+}
+
+// CountUserRedemptions returns on how many paid orders the user used the coupon
+func (c *Conf) CountUserRedemptions(ctx context.Context, couponId string, userId string) (int, error) {
+	var count int
+	err := c.db.QueryRowContext(ctx, `
+	SELECT COUNT(*)
+	FROM coupon_redemptions
+	WHERE coupon_id = $1 AND user_id = $2
+	`, couponId, userId).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count coupon redemptions: %w", err)
+	}
+	return count, nil
+}
+
+func (c *Conf) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		er := tx.Rollback()
+		if er != nil && !errors.Is(er, sql.ErrTxDone) {
+			return fmt.Errorf("failed to rollback withTx: %w", err)
+		}
+		return fmt.Errorf("failed to execute withTx: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit withTx: %w", err)
+	}
+	return nil
+
+}
@@ -9,7 +9,51 @@ const ConsumerGroup = `order-service`
 
 type OrderPaidEvent struct {
 	OrderId   string    `json:"order_id"` // UUID
+	UserId    string    `json:"user_id"`
 	ProductId string    `json:"product_id"`
 	Quantity  int       `json:"quantity"`
 	CreatedAt time.Time `json:"created_at"` // Timestamp of creation
 }
+
+const TopicOrderStatusChanged = `order-service.order-status-changed`
+
+// OrderStatusChangedEvent is published on every fulfilment transition of an order,
+// the payment itself is announced by the order paid event
+type OrderStatusChangedEvent struct {
+	OrderId        string    `json:"order_id"`
+	UserId         string    `json:"user_id"`
+	FromStatus     string    `json:"from_status"`
+	Status         string    `json:"status"`
+	Note           string    `json:"note,omitempty"`
+	Carrier        string    `json:"carrier,omitempty"`
+	TrackingNumber string    `json:"tracking_number,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+const TopicOrderRestock = `order-service.order-restock`
+
+// OrderRestockEvent is published when the products of a canceled, refunded or returned order go back on the shelf
+type OrderRestockEvent struct {
+	OrderId   string             `json:"order_id"`
+	Reason    string             `json:"reason"` // canceled, refunded or returned
+	Items     []RestockItemEvent `json:"items"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+type RestockItemEvent struct {
+	ProductId string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+const TopicReturnStatusChanged = `order-service.return-status-changed`
+
+// ReturnStatusChangedEvent is published when a return is opened, reviewed, received or refunded
+type ReturnStatusChangedEvent struct {
+	ReturnId     string    `json:"return_id"`
+	OrderId      string    `json:"order_id"`
+	UserId       string    `json:"user_id"`
+	Status       string    `json:"status"`
+	Note         string    `json:"note,omitempty"`
+	RefundAmount int64     `json:"refund_amount"` // in paise
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -153,7 +153,7 @@ func (c *Conf) ConsumeMessage(ctx context.Context, topicName string, ConsumerGro
 			// returned from polls so that users can notice and take action.
 
 			//maybe kafka is down
-			slog.Error("ERROR: ", errs)
+			slog.Error("ERROR: ", slog.Any("errors", errs))
 			time.Sleep(10 * time.Second)
 			continue
 		}
@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS coupons (
+    id UUID NOT NULL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE,                      -- code the customer enters at checkout, stored upper case
+    discount_type VARCHAR(20) NOT NULL CHECK (discount_type IN ('percentage', 'fixed')),
+    discount_value BIGINT NOT NULL CHECK (discount_value > 0), -- percent for percentage coupons, paise for fixed coupons
+    min_cart_value BIGINT NOT NULL DEFAULT 0,       -- minimum value of the eligible items in paise
+    max_redemptions INTEGER,                        -- NULL for no global limit
+    max_redemptions_per_user INTEGER,               -- NULL for no per user limit
+    redemption_count INTEGER NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP,                            -- NULL for valid right away
+    ends_at TIMESTAMP,                              -- NULL for never expires
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    CHECK (discount_type <> 'percentage' OR discount_value <= 100)
+);
+
+-- a coupon without targets applies to the whole cart
+CREATE TABLE IF NOT EXISTS coupon_targets (
+    coupon_id UUID NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    target_type VARCHAR(20) NOT NULL CHECK (target_type IN ('product', 'category')),
+    target_value TEXT NOT NULL,                     -- product id or category name
+    PRIMARY KEY (coupon_id, target_type, target_value)
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id UUID NOT NULL PRIMARY KEY,
+    coupon_id UUID NOT NULL REFERENCES coupons(id),
+    user_id UUID NOT NULL,
+    order_id UUID NOT NULL UNIQUE REFERENCES orders(id), -- a coupon is redeemed once per order
+    discount_amount BIGINT NOT NULL,
+    redeemed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS coupon_redemptions_user_idx ON coupon_redemptions (coupon_id, user_id);
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS coupon_id UUID REFERENCES coupons(id),
+    ADD COLUMN IF NOT EXISTS discount_amount BIGINT NOT NULL DEFAULT 0; -- in paise, total_price is after the discount
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS discount_amount,
+    DROP COLUMN IF EXISTS coupon_id;
+
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupon_targets;
+DROP TABLE IF EXISTS coupons;
+-- +goose StatementEnd
@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS shipping_rates (
+    id SERIAL PRIMARY KEY,
+    region TEXT NOT NULL,                           -- lower case region name, 'default' is used for regions without rates
+    max_weight_grams INTEGER NOT NULL CHECK (max_weight_grams > 0), -- heaviest parcel of the slab
+    price BIGINT NOT NULL CHECK (price >= 0),       -- in paise
+    UNIQUE (region, max_weight_grams)
+);
+
+INSERT INTO shipping_rates (region, max_weight_grams, price) VALUES
+    ('default', 500, 4000),
+    ('default', 1000, 6000),
+    ('default', 2000, 9000),
+    ('default', 5000, 15000),
+    ('default', 10000, 25000),
+    ('northeast', 500, 6000),
+    ('northeast', 1000, 9000),
+    ('northeast', 2000, 13000),
+    ('northeast', 5000, 22000),
+    ('northeast', 10000, 36000)
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS subtotal BIGINT NOT NULL DEFAULT 0,        -- in paise, before discount, tax and shipping
+    ADD COLUMN IF NOT EXISTS tax_amount BIGINT NOT NULL DEFAULT 0,      -- in paise
+    ADD COLUMN IF NOT EXISTS shipping_amount BIGINT NOT NULL DEFAULT 0, -- in paise
+    ADD COLUMN IF NOT EXISTS shipping_region TEXT;
+
+CREATE TABLE IF NOT EXISTS order_items (
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    unit_price BIGINT NOT NULL,                     -- in paise
+    line_total BIGINT NOT NULL,                     -- unit price times quantity
+    discount_amount BIGINT NOT NULL DEFAULT 0,      -- share of the coupon discount
+    tax_rate INTEGER NOT NULL,                      -- in basis points
+    tax_amount BIGINT NOT NULL,
+    PRIMARY KEY (order_id, product_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_items;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS shipping_region,
+    DROP COLUMN IF EXISTS shipping_amount,
+    DROP COLUMN IF EXISTS tax_amount,
+    DROP COLUMN IF EXISTS subtotal;
+
+DROP TABLE IF EXISTS shipping_rates;
+-- +goose StatementEnd
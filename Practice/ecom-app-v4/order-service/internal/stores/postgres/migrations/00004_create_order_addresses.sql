@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- copy of the shipping address taken at checkout, later edits of the saved address do not change the order
+CREATE TABLE IF NOT EXISTS order_addresses (
+    order_id UUID NOT NULL PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+    address_id UUID NOT NULL,                       -- saved address in the user service the copy was taken from
+    full_name TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    line1 TEXT NOT NULL,
+    line2 TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL,
+    state TEXT NOT NULL,
+    postal_code TEXT NOT NULL,
+    country CHAR(2) NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_addresses;
+-- +goose StatementEnd
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'paid', 'packed', 'shipped', 'delivered', 'returned', 'canceled'));
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS carrier TEXT,              -- set when the order is shipped
+    ADD COLUMN IF NOT EXISTS tracking_number TEXT,
+    ADD COLUMN IF NOT EXISTS shipped_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id SERIAL PRIMARY KEY,
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    changed_by UUID,                                    -- admin that made the change
+    changed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_idx ON order_status_history (order_id, changed_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_status_history;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS delivered_at,
+    DROP COLUMN IF EXISTS shipped_at,
+    DROP COLUMN IF EXISTS tracking_number,
+    DROP COLUMN IF EXISTS carrier;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN ('pending', 'paid', 'canceled'));
+-- +goose StatementEnd
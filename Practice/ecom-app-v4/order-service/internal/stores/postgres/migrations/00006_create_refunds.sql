@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'paid', 'packed', 'shipped', 'delivered', 'returned', 'refunded', 'canceled'));
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS refunded_amount BIGINT NOT NULL DEFAULT 0; -- in paise, never more than total_price
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id UUID NOT NULL PRIMARY KEY,                   -- the stripe request is keyed by idempotency_key, added in 00010
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    stripe_refund_id TEXT NOT NULL UNIQUE,
+    amount BIGINT NOT NULL CHECK (amount > 0),      -- in paise
+    reason TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL,                    -- stripe refund status, e.g. pending or succeeded
+    created_by UUID,                                -- customer or admin that asked for the refund
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refunds_order_idx ON refunds (order_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS refunds;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS refunded_amount;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'paid', 'packed', 'shipped', 'delivered', 'returned', 'canceled'));
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS returns (
+    id UUID NOT NULL PRIMARY KEY,
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunded')),
+    reason TEXT NOT NULL,                           -- why the customer sends the items back
+    admin_note TEXT NOT NULL DEFAULT '',            -- why the return was approved or rejected
+    refund_amount BIGINT NOT NULL DEFAULT 0,        -- in paise, what the customer paid for the returned items
+    refunded_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS returns_order_idx ON returns (order_id);
+CREATE INDEX IF NOT EXISTS returns_status_idx ON returns (status, created_at);
+
+CREATE TABLE IF NOT EXISTS return_items (
+    return_id UUID NOT NULL REFERENCES returns(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    refund_amount BIGINT NOT NULL,                  -- in paise, for the whole quantity
+    PRIMARY KEY (return_id, product_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS return_items;
+DROP TABLE IF EXISTS returns;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+-- product names are copied at checkout so invoices keep showing what was bought
+ALTER TABLE order_items
+    ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT '';
+
+-- a single row counter instead of a sequence, a rolled back invoice must not leave a gap in the numbers
+CREATE TABLE IF NOT EXISTS invoice_counter (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    last_number BIGINT NOT NULL
+);
+
+INSERT INTO invoice_counter (id, last_number) VALUES (TRUE, 0) ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS invoices (
+    order_id UUID NOT NULL PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+    sequence_number BIGINT NOT NULL UNIQUE,
+    invoice_number TEXT NOT NULL UNIQUE,            -- sequence number as printed, e.g. INV-000042
+    user_id UUID NOT NULL,
+    total BIGINT NOT NULL,                          -- in paise
+    pdf BYTEA NOT NULL,
+    issued_at TIMESTAMP NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS invoice_counter;
+
+ALTER TABLE order_items
+    DROP COLUMN IF EXISTS name;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- two checkouts can pass the limits of a coupon before either is paid, the one paid last is flagged for review
+ALTER TABLE coupon_redemptions
+    ADD COLUMN IF NOT EXISTS over_limit BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE coupon_redemptions DROP COLUMN IF EXISTS over_limit;
+-- +goose StatementEnd
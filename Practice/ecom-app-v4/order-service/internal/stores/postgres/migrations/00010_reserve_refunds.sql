@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a refund is reserved before stripe is asked for it, so concurrent refunds can not add up to more than was paid.
+-- The row keeps status 'reserved' and no stripe id until stripe accepted it.
+ALTER TABLE refunds
+    ALTER COLUMN stripe_refund_id DROP NOT NULL,
+    ADD COLUMN IF NOT EXISTS idempotency_key TEXT UNIQUE; -- order id and what asked for the refund, sent to stripe as well
+
+-- the checkout session a pending order is paid through, canceling the order expires it
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS checkout_session_id TEXT;
+
+-- what was put back in stock per product of an order, so nothing is restocked twice
+CREATE TABLE IF NOT EXISTS order_restocks (
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (order_id, product_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_restocks;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS checkout_session_id;
+
+DELETE FROM refunds WHERE stripe_refund_id IS NULL;
+ALTER TABLE refunds
+    DROP COLUMN IF EXISTS idempotency_key,
+    ALTER COLUMN stripe_refund_id SET NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a return is refunded once, retried requests find its refund by the return
+ALTER TABLE refunds
+    ADD COLUMN IF NOT EXISTS return_id UUID UNIQUE REFERENCES returns(id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE refunds DROP COLUMN IF EXISTS return_id;
+-- +goose StatementEnd
@@ -8,7 +8,10 @@ import (
 	"order-service/handlers"
 	"order-service/internal/auth"
 	"order-service/internal/consul"
+	"order-service/internal/invoices"
 	"order-service/internal/orders"
+	"order-service/internal/pricing"
+	"order-service/internal/promotions"
 	"order-service/internal/stores/kafka"
 	postgres "order-service/internal/stores/postgres/migrations"
 	"os"
@@ -18,7 +21,6 @@ import (
 
 	pb "order-service/gen/proto"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -80,28 +82,50 @@ func startApp() error {
 	if err != nil {
 		return err
 	}
+
 	/*
 		//------------------------------------------------------//
-		//  Setting up Auth layer
+		//    Setting up promotions package config
 		//------------------------------------------------------//
 	*/
-
-	slog.Info("main : Started : Initializing authentication support")
-	publicPEM, err := os.ReadFile("pubkey.pem")
+	pr, err := promotions.NewConf(db)
 	if err != nil {
-		return fmt.Errorf("reading auth public key %w", err)
+		return err
 	}
 
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+	/*
+		//------------------------------------------------------//
+		//    Setting up pricing, GST per category and shipping rates from the db
+		//------------------------------------------------------//
+	*/
+	shippingRates, err := pricing.NewConf(db)
 	if err != nil {
-		return fmt.Errorf("parsing auth public key %w", err)
+		return err
 	}
-
-	a, err := auth.NewKeys(publicKey)
+	pricer, err := pricing.NewPricer(pricing.NewGSTCalculator(pricing.DefaultGSTRates, pricing.DefaultGSTRate), &shippingRates)
 	if err != nil {
-		return fmt.Errorf("initializing auth %w", err)
+		return err
+	}
+	/*
+		//------------------------------------------------------//
+		//    Setting up invoices, the seller details come from the env
+		//------------------------------------------------------//
+	*/
+	inv, err := invoices.NewConf(db, invoices.SellerFromEnv())
+	if err != nil {
+		return err
 	}
 
+	/*
+		//------------------------------------------------------//
+		//  Setting up Auth layer
+		//------------------------------------------------------//
+	*/
+
+	slog.Info("main : Started : Initializing authentication support")
+	// tokens revoked by the user service are refused, the list is synced once consul is up
+	revoked := auth.NewRevocations()
+
 	/*
 			//------------------------------------------------------//
 		                Setting up Kafka & Creating topics
@@ -128,6 +152,24 @@ func startApp() error {
 	}
 
 	defer consulClient.Agent().ServiceDeregister(regId)
+
+	// tokens are checked with the keys the user service publishes, a new kid loads them again
+	a, err := auth.NewKeys(auth.UserServiceJWKS(consulClient), revoked)
+	if err != nil {
+		return fmt.Errorf("initializing auth %w", err)
+	}
+
+	// the internal routes of the other services are called with a service token the user service issues
+	serviceTokens, err := auth.NewServiceTokens(
+		auth.UserServiceTokens(consulClient, os.Getenv("SERVICE_CLIENT_ID"), os.Getenv("SERVICE_CLIENT_SECRET")))
+	if err != nil {
+		return fmt.Errorf("initializing service tokens %w", err)
+	}
+
+	authSyncCtx, stopAuthSync := context.WithCancel(context.Background())
+	defer stopAuthSync()
+	go a.Sync(authSyncCtx, auth.JWKSSyncInterval())
+	go revoked.Poll(authSyncCtx, auth.RevocationSyncInterval(), auth.UserServiceRevocations(consulClient, serviceTokens))
 	/*
 			//------------------------------------------------------//
 		               Setting up GRPC
@@ -180,7 +222,7 @@ func startApp() error {
 		WriteTimeout: 800 * time.Second,
 		IdleTimeout:  800 * time.Second,
 
-		Handler: handlers.API(prefix, a, consulClient, &o, kafkaConf, client),
+		Handler: handlers.API(prefix, a, consulClient, &o, &pr, pricer, &inv, kafkaConf, client, serviceTokens),
 	}
 	serverErrors := make(chan error)
 	go func() {
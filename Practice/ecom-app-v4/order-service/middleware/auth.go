@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"order-service/internal/auth"
 	"order-service/pkg/logkey"
+	"slices"
 	"strings"
 )
 
@@ -47,7 +48,22 @@ func (m *Mid) Authentication() gin.HandlerFunc {
 			return
 		}
 
-		ctx = context.WithValue(ctx, auth.ClaimsKey, claims)
+		// service tokens only open the internal routes, they do not act for a user
+		if claims.IsService() {
+			slog.Error("service token used on a user route",
+				slog.String("Service", claims.Service),
+				slog.Any(logkey.TraceID, traceId),
+			)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		// the token of an API key is kept apart, RequirePermission is the only one to take it
+		key := auth.ClaimsKey
+		if claims.APIKey != "" {
+			key = auth.APIKeyClaimsKey
+		}
+		ctx = context.WithValue(ctx, key, claims)
 		c.Request = c.Request.WithContext(ctx)
 		// Call the validate token from auth struct
 		//put the validated claims in context
@@ -57,3 +73,96 @@ func (m *Mid) Authentication() gin.HandlerFunc {
 
 	}
 }
+
+// RequirePermission lets the request through when the token carries every one of the permissions,
+// it must run after Authentication. It is the only check a token of an API key passes, the handler then sees its claims.
+func (m *Mid) RequirePermission(next gin.HandlerFunc, requiredPermissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		claims, ok := ctx.Value(auth.ClaimsKey).(auth.Claims)
+		if !ok {
+			claims, ok = ctx.Value(auth.APIKeyClaimsKey).(auth.Claims)
+		}
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized,
+				gin.H{"error": "claims missing from the context: RequirePermission called without/before Authenticate"})
+			return
+		}
+		if !claims.HasPermissions(requiredPermissions...) {
+			slog.Error("missing required permission",
+				slog.String("UserID", claims.Subject),
+				slog.Any("Required", requiredPermissions),
+				slog.Any("Permissions", claims.Permissions),
+			)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": http.StatusText(http.StatusForbidden)})
+			return
+		}
+
+		if claims.APIKey != "" {
+			c.Request = c.Request.WithContext(context.WithValue(ctx, auth.ClaimsKey, claims))
+		}
+		next(c)
+	}
+}
+
+// RequireVerifiedEmail refuses users that did not verify their email yet, unless verification is switched off
+func (m *Mid) RequireVerifiedEmail(next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		claims, ok := ctx.Value(auth.ClaimsKey).(auth.Claims)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized,
+				gin.H{"error": "claims missing from the context: RequireVerifiedEmail called without/before Authenticate"})
+			return
+		}
+		if auth.VerificationRequired() && !claims.EmailVerified {
+			slog.Info("checkout refused, email not verified", slog.String("UserID", claims.Subject))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Please verify your email address before checking out"})
+			return
+		}
+
+		next(c)
+	}
+}
+
+// RequireService lets the request through when it carries a service token of one of the services,
+// any service is let through when none are named. It guards the internal routes and needs no Authentication before it.
+func (m *Mid) RequireService(next gin.HandlerFunc, services ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		traceId, ok := ctx.Value(TraceIdKey).(string)
+		if !ok {
+			traceId = "unknown"
+		}
+
+		scheme, token, _ := strings.Cut(c.Request.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "bearer") || token == "" {
+			slog.Error("internal route called without a service token", slog.Any(logkey.TraceID, traceId))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+		claims, err := m.a.ValidateToken(token)
+		if err != nil {
+			slog.Error("invalid service token",
+				slog.Any(logkey.ERROR, err),
+				slog.Any(logkey.TraceID, traceId),
+			)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+		if !claims.IsService() || (len(services) > 0 && !slices.Contains(services, claims.Service)) {
+			slog.Error("token not allowed on the internal route",
+				slog.String("Subject", claims.Subject),
+				slog.String("Service", claims.Service),
+				slog.Any("Allowed", services),
+				slog.Any(logkey.TraceID, traceId),
+			)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": http.StatusText(http.StatusForbidden)})
+			return
+		}
+
+		ctx = context.WithValue(ctx, auth.ClaimsKey, claims)
+		c.Request = c.Request.WithContext(ctx)
+		next(c)
+	}
+}
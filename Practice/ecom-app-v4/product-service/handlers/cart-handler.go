@@ -36,20 +36,16 @@ func (h *Handler) addToCart(c *gin.Context) {
 		return
 	}
 
-	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
-	if err != nil {
-		slog.Error(
-			"missing claims",
-			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
-		return
+	// guests without a cart token get a new guest cart
+	owner, ok := h.cartOwner(c)
+	var cartToken string
+	if !ok {
+		owner.GuestID, cartToken = h.ct.NewGuestToken()
 	}
 
-	userId := claims.Subject
-
 	var newCart products.NewCartLine
 
-	err = c.ShouldBindBodyWithJSON(&newCart)
+	err := c.ShouldBindBodyWithJSON(&newCart)
 
 	if err != nil {
 		// Log an error if JSON parsing or validation fails, along with the trace ID.
@@ -83,8 +79,16 @@ func (h *Handler) addToCart(c *gin.Context) {
 	ctx := c.Request.Context()
 
 	// Attempt to insert the new user into the database using the `InsertUser` method.
-	err = h.p.InsertOrUpdateCart(ctx, userId, newCart)
+	err = h.p.InsertOrUpdateCart(ctx, owner, newCart)
 	if err != nil {
+		if errors.Is(err, products.ErrInsufficientStock) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Not enough stock for the requested quantity"})
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Product not found"})
+			return
+		}
 		// Log an error if user creation fails, along with the trace ID and specific error message.
 		slog.Error("error in creating the product",
 			slog.String(logkey.TraceID, traceId),
@@ -97,6 +101,12 @@ func (h *Handler) addToCart(c *gin.Context) {
 		})
 		return
 	}
+	if cartToken != "" {
+		setCartToken(c, cartToken)
+		c.JSON(http.StatusOK, gin.H{"message": "Product added to cart", "cart_token": cartToken})
+		return
+	}
+
 	// Respond with HTTP 200 OK and return the created user's data as JSON.
 	c.JSON(http.StatusOK, gin.H{"message": "Product added to cart"})
 
@@ -133,6 +143,25 @@ func (h *Handler) checkout(c *gin.Context) {
 
 	userId := claims.Subject
 
+	// the body is optional, it is only needed to apply a coupon or pick the shipping address
+	var checkoutReq products.CheckoutRequest
+	if c.Request.ContentLength > 0 {
+		err = c.ShouldBindJSON(&checkoutReq)
+		if err == nil {
+			err = h.validate.Struct(checkoutReq)
+		}
+		if err != nil {
+			slog.Error("validation failed",
+				slog.String(logkey.TraceID, traceId),
+				slog.String(logkey.ERROR, err.Error()),
+			)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "please provide values in correct format",
+			})
+			return
+		}
+	}
+
 	ctx := c.Request.Context()
 
 	// Check if items are inProgress for checkout
@@ -161,6 +190,9 @@ func (h *Handler) checkout(c *gin.Context) {
 
 	var orderReq products.OrderRequest
 	orderReq.LineItems = cartRet.LineItems
+	orderReq.CouponCode = checkoutReq.CouponCode
+	orderReq.ShippingRegion = checkoutReq.ShippingRegion
+	orderReq.AddressID = checkoutReq.AddressID
 
 	orderId := cartRet.OrderId
 
@@ -183,7 +215,10 @@ func (h *Handler) checkout(c *gin.Context) {
 	}
 
 	type OrderServiceResponse struct {
-		CheckoutSessionID string `json:"checkout_session_id"`
+		CheckoutSessionID string          `json:"checkout_session_id"`
+		Quote             json.RawMessage `json:"quote,omitempty"`   // itemised price breakdown of the order
+		Message           string          `json:"message,omitempty"` // set when the order service rejects the checkout
+		StatusCode        int             `json:"-"`
 	}
 
 	//caLL ORDER SERVICE CHECKOUT
@@ -229,14 +264,18 @@ func (h *Handler) checkout(c *gin.Context) {
 			orderChan <- OrderServiceResponse{}
 			return
 		}
+		defer resp.Body.Close()
+
 		if resp.StatusCode != http.StatusOK {
 			slog.Error("error fetching checkout session from order service", slog.String(logkey.TraceID, traceId))
-			orderChan <- OrderServiceResponse{}
+			var orderServiceResponse OrderServiceResponse
+			json.NewDecoder(resp.Body).Decode(&orderServiceResponse)
+			orderServiceResponse.CheckoutSessionID = ""
+			orderServiceResponse.StatusCode = resp.StatusCode
+			orderChan <- orderServiceResponse
 			return
 		}
 
-		defer resp.Body.Close()
-
 		var orderServiceResponse OrderServiceResponse
 		err = json.NewDecoder(resp.Body).Decode(&orderServiceResponse)
 		if err != nil {
@@ -251,6 +290,19 @@ func (h *Handler) checkout(c *gin.Context) {
 
 	orderServiceResponse := <-orderChan
 	if orderServiceResponse.CheckoutSessionID == "" {
+		// no order was created, the customer can fix the cart or the coupon and check out again
+		err = h.p.RevertCartCheckout(ctx, orderId)
+		if err != nil {
+			slog.Error("error in reverting the cart status",
+				slog.String(logkey.TraceID, traceId),
+				slog.String(logkey.ERROR, err.Error()),
+			)
+		}
+
+		if orderServiceResponse.StatusCode == http.StatusBadRequest && orderServiceResponse.Message != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": orderServiceResponse.Message})
+			return
+		}
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error fetching checkout session"})
 		return
 	}
@@ -277,24 +329,20 @@ func (h *Handler) fetchCartDetails(c *gin.Context) {
 		return
 	}
 
-	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
-	if err != nil {
-		slog.Error(
-			"missing claims",
-			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+	owner, ok := h.cartOwner(c)
+	if !ok {
+		// a guest without a cart token has not added anything yet
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Empty Cart",
+		})
 		return
 	}
 
-	// FETCH USER ID
-
-	userId := claims.Subject
-
 	ctx := c.Request.Context()
 
 	// Check if items are inProgress for checkout
 	// Attempt to insert the new user into the database using the `InsertUser` method.
-	cartRet, err := h.p.FetchCartDetails(ctx, userId, products.StatusInProgress)
+	cartRet, err := h.p.FetchCartDetails(ctx, owner, products.StatusInProgress)
 	if err != nil {
 		// Log an error if user creation fails, along with the trace ID and specific error message.
 		slog.Error("error in fetching the cart",
@@ -308,7 +356,7 @@ func (h *Handler) fetchCartDetails(c *gin.Context) {
 		})
 		return
 	}
-	if len(cartRet) == 0 {
+	if len(cartRet.LineItems) == 0 {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Empty Cart",
 		})
@@ -335,18 +383,15 @@ func (h *Handler) deleteCartByID(c *gin.Context) {
 		return
 	}
 
-	// Extract claims and validate user authentication
-	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
-	if err != nil {
-		slog.Error("missing claims",
-			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+	// Resolve the cart of the logged in user or the guest
+	owner, ok := h.cartOwner(c)
+	if !ok {
+		slog.Error("missing claims and cart token",
+			slog.String(logkey.TraceID, traceId))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id or cart token is required"})
 		return
 	}
 
-	// Extract user ID from claims
-	userId := claims.Subject
-
 	// Extract cart ID from request URL parameter
 	cartID := c.Param("id")
 	if cartID == "" {
@@ -361,7 +406,7 @@ func (h *Handler) deleteCartByID(c *gin.Context) {
 	ctx := c.Request.Context()
 
 	// Call the service layer to delete the cart item
-	err = h.p.DeleteCartByIDIfPending(ctx, cartID, userId)
+	err := h.p.DeleteCartByIDIfPending(ctx, cartID, owner)
 	if err != nil {
 		// Handle specific errors (e.g., no rows affected)
 		if errors.Is(err, sql.ErrNoRows) {
@@ -393,3 +438,122 @@ func (h *Handler) deleteCartByID(c *gin.Context) {
 		"message": "Cart item successfully deleted",
 	})
 }
+
+func (h *Handler) updateCartQuantity(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	// Ensure the request body isn't too large
+	if c.Request.ContentLength > 5*1024 {
+		slog.Error("request body limit breached",
+			slog.String(logkey.TraceID, traceId),
+			slog.Int64("Size Received", c.Request.ContentLength),
+		)
+
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "payload exceeding size limit",
+		})
+		return
+	}
+
+	// Resolve the cart of the logged in user or the guest
+	owner, ok := h.cartOwner(c)
+	if !ok {
+		slog.Error("missing claims and cart token",
+			slog.String(logkey.TraceID, traceId))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id or cart token is required"})
+		return
+	}
+
+	cartID := c.Param("id")
+	if cartID == "" {
+		slog.Error("missing cart ID",
+			slog.String(logkey.TraceID, traceId))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "Cart ID is required",
+		})
+		return
+	}
+
+	var req products.UpdateCartLine
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		slog.Error("json validation error",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": http.StatusText(http.StatusBadRequest),
+		})
+		return
+	}
+
+	err = h.validate.Struct(req)
+	if err != nil {
+		slog.Error("validation failed",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "please provide values in correct format",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	err = h.p.UpdateCartQuantity(ctx, cartID, owner, req.Quantity)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Cart item not found"})
+			return
+		}
+		if errors.Is(err, products.ErrInsufficientStock) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Not enough stock for the requested quantity"})
+			return
+		}
+
+		slog.Error("failed to update cart item",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to update cart item",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Cart item successfully updated",
+	})
+}
+
+func (h *Handler) clearCart(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	// Resolve the cart of the logged in user or the guest
+	owner, ok := h.cartOwner(c)
+	if !ok {
+		slog.Error("missing claims and cart token",
+			slog.String(logkey.TraceID, traceId))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id or cart token is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	err := h.p.ClearCart(ctx, owner)
+	if err != nil {
+		slog.Error("failed to clear cart",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to clear cart",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Cart cleared",
+	})
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"product-service/internal/auth"
+	"product-service/internal/products"
+	"product-service/pkg/ctxmanage"
+	"product-service/pkg/logkey"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// guest carts are kept for 30 days in the browser
+const cartTokenMaxAge = 30 * 24 * time.Hour
+
+// cartOwner resolves whose cart the request works on.
+// A logged in user always uses their own cart, otherwise the guest cart of the signed cart token is used.
+func (h *Handler) cartOwner(c *gin.Context) (products.CartOwner, bool) {
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err == nil {
+		return products.CartOwner{UserID: claims.Subject}, true
+	}
+
+	guestId, err := h.guestIdFromRequest(c)
+	if err != nil {
+		return products.CartOwner{}, false
+	}
+	return products.CartOwner{GuestID: guestId}, true
+}
+
+// guestIdFromRequest validates the cart token sent in the X-Cart-Token header or the cart_token cookie
+func (h *Handler) guestIdFromRequest(c *gin.Context) (string, error) {
+	token := c.GetHeader(auth.CartTokenHeader)
+	if token == "" {
+		cookie, err := c.Cookie(auth.CartTokenCookie)
+		if err != nil {
+			return "", auth.ErrInvalidCartToken
+		}
+		token = cookie
+	}
+	return h.ct.ValidateToken(token)
+}
+
+// setCartToken hands the cart token to the guest both as a cookie and as a header,
+// so browsers and api clients can send it back on the next request
+func setCartToken(c *gin.Context, token string) {
+	c.Header(auth.CartTokenHeader, token)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.CartTokenCookie, token, int(cartTokenMaxAge.Seconds()), "/", "", c.Request.TLS != nil, true)
+}
+
+// clearCartToken removes the cart token cookie once the guest cart is merged
+func clearCartToken(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.CartTokenCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+}
+
+// mergeGuestCart merges the guest cart of the request into the cart of the logged in user
+func (h *Handler) mergeGuestCart(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	guestId, err := h.guestIdFromRequest(c)
+	if err != nil {
+		slog.Error("invalid cart token",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "A valid cart token is required"})
+		return
+	}
+
+	err = h.p.MergeGuestCart(c.Request.Context(), guestId, claims.Subject)
+	if err != nil {
+		slog.Error("error in merging the guest cart",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Cart Merge Failed",
+		})
+		return
+	}
+
+	clearCartToken(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Guest cart merged"})
+}
+
+// mergeGuestCartInternal is called by the user service when a guest signs up or logs in
+func (h *Handler) mergeGuestCartInternal(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var req products.MergeCartRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		slog.Error("json validation error",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": http.StatusText(http.StatusBadRequest),
+		})
+		return
+	}
+
+	err = h.validate.Struct(req)
+	if err != nil {
+		slog.Error("validation failed",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "please provide values in correct format",
+		})
+		return
+	}
+
+	guestId, err := h.ct.ValidateToken(req.CartToken)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "A valid cart token is required"})
+		return
+	}
+
+	err = h.p.MergeGuestCart(c.Request.Context(), guestId, req.UserID)
+	if err != nil {
+		slog.Error("error in merging the guest cart",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Cart Merge Failed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Guest cart merged"})
+}
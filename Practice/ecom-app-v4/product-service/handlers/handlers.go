@@ -5,7 +5,9 @@ import (
 	"net/http"
 	"os"
 	"product-service/internal/auth"
+	"product-service/internal/notifications"
 	"product-service/internal/products"
+	"product-service/internal/stores/kafka"
 	"product-service/middleware"
 	"product-service/pkg/ctxmanage"
 
@@ -17,18 +19,24 @@ import (
 type Handler struct {
 	client   *consulapi.Client
 	p        *products.Conf
+	k        *kafka.Conf
+	nt       *notifications.Conf
+	ct       *auth.CartTokens
 	validate *validator.Validate
 }
 
-func NewHandler(client *consulapi.Client, p *products.Conf) *Handler {
+func NewHandler(client *consulapi.Client, p *products.Conf, kafkaConf *kafka.Conf, nt *notifications.Conf, ct *auth.CartTokens) *Handler {
 	return &Handler{
 		client:   client,
 		p:        p,
+		k:        kafkaConf,
+		nt:       nt,
+		ct:       ct,
 		validate: validator.New(),
 	}
 }
 
-func API(client *consulapi.Client, p *products.Conf, k *auth.Keys) *gin.Engine {
+func API(client *consulapi.Client, p *products.Conf, k *auth.Keys, kafkaConf *kafka.Conf, nt *notifications.Conf, ct *auth.CartTokens) *gin.Engine {
 	r := gin.New()
 	mode := os.Getenv("GIN_MODE")
 	if mode == "release" {
@@ -37,7 +45,7 @@ func API(client *consulapi.Client, p *products.Conf, k *auth.Keys) *gin.Engine {
 
 	m := middleware.NewMid(k)
 
-	h := NewHandler(client, p)
+	h := NewHandler(client, p, kafkaConf, nt, ct)
 
 	prefix := os.Getenv("SERVICE_ENDPOINT_PREFIX")
 	if prefix == "" {
@@ -52,24 +60,50 @@ func API(client *consulapi.Client, p *products.Conf, k *auth.Keys) *gin.Engine {
 	v1 := r.Group(prefix)
 	{
 
-		//v1.GET("/stock/:productID"
-		//fetch PriceId , stock
-		v1.GET("/stock/:productID", h.getProductOrderDetail)
 		v1.GET("/", h.fetchAllProducts)
-		v1.POST("/stock", h.getProductOrderDetails)
+
+		//called by the order service with a service token, fetch PriceId , stock
+		v1.GET("/internal/stock/:productID", m.RequireService(h.getProductOrderDetail, "order-service"))
+		v1.POST("/internal/stock", m.RequireService(h.getProductOrderDetails, "order-service"))
+
+		//Cart service calls, guests use a signed cart token instead of a login
+		cart := v1.Group("/cart", m.OptionalAuthentication())
+		{
+			cart.POST("/addtocart", h.addToCart)
+			cart.GET("/fetchcart", h.fetchCartDetails)
+			cart.PATCH("/:id", h.updateCartQuantity)
+			cart.DELETE("/:id", h.deleteCartByID)
+			cart.DELETE("", h.clearCart)
+		}
+
+		//called by the user service after a guest logs in or signs up
+		v1.POST("/internal/cart/merge", m.RequireService(h.mergeGuestCartInternal, "user-service"))
 
 		v1.Use(m.Authentication())
 
-		v1.POST("/", m.Authorize(h.createProduct, auth.RoleAdmin))
+		v1.POST("/", m.RequirePermission(h.createProduct, auth.PermProductsWrite))
+
+		v1.PATCH("/:productID", m.RequirePermission(h.updateProduct, auth.PermProductsWrite))
+		v1.POST("/:productID/restock", m.RequirePermission(h.restockProduct, auth.PermProductsWrite))
 
-		v1.PATCH("/:productID", m.Authorize(h.updateProduct, auth.RoleAdmin))
+		//customers waiting for an out of stock product
+		v1.POST("/:productID/notify-me", h.subscribeBackInStock)
 
-		//Cart service calls
-		v1.POST("/cart/addtocart", h.addToCart)
+		//checkout needs a logged in user
 		v1.POST("/cart/checkout", h.checkout)
-		//TODO TEST THIS CODE
-		v1.GET("/cart/fetchcart", h.fetchCartDetails)
-		v1.DELETE("/cart/:id", h.deleteCartByID)
+		v1.POST("/cart/merge", h.mergeGuestCart)
+		v1.POST("/cart/:id/save-for-later", h.saveForLater)
+
+		//Wishlist calls
+		v1.GET("/wishlist", h.fetchWishlist)
+		v1.POST("/wishlist", h.addToWishlist)
+		v1.DELETE("/wishlist/:id", h.removeFromWishlist)
+		v1.POST("/wishlist/:id/move-to-cart", h.moveWishlistToCart)
+
+		//what the user is emailed about and what was sent
+		v1.GET("/notifications/preferences", h.fetchNotificationPreferences)
+		v1.PUT("/notifications/preferences", h.updateNotificationPreferences)
+		v1.GET("/notifications", h.fetchNotificationDeliveries)
 
 	}
 
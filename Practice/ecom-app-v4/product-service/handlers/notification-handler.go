@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"product-service/internal/notifications"
+	"product-service/pkg/ctxmanage"
+	"product-service/pkg/logkey"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDeliveriesLimit = 20
+	maxDeliveriesLimit     = 100
+)
+
+// fetchNotificationPreferences returns which notifications the user gets by email
+func (h *Handler) fetchNotificationPreferences(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	prefs, err := h.nt.Preferences(c.Request.Context(), claims.Subject)
+	if err != nil {
+		slog.Error("error in fetching notification preferences",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// updateNotificationPreferences switches emails of notification types on or off,
+// order confirmations and the welcome email can not be switched off
+func (h *Handler) updateNotificationPreferences(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	var updates []notifications.PreferenceUpdate
+	err = c.ShouldBindJSON(&updates)
+	if err != nil {
+		slog.Error("json validation error",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": http.StatusText(http.StatusBadRequest),
+		})
+		return
+	}
+
+	err = h.validate.Var(updates, "required,dive")
+	if err != nil {
+		slog.Error("validation failed",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "please provide values in correct format",
+		})
+		return
+	}
+
+	prefs, err := h.nt.SetPreferences(c.Request.Context(), claims.Subject, updates)
+	if err != nil {
+		if errors.Is(err, notifications.ErrUnknownPreference) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		slog.Error("error in updating notification preferences",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to update notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// fetchNotificationDeliveries returns the notifications sent to the user, newest first, ?limit= and ?offset= page through them
+func (h *Handler) fetchNotificationDeliveries(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDeliveriesLimit)))
+	if err != nil || limit < 1 || limit > maxDeliveriesLimit {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "limit must be between 1 and 100"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "offset must not be negative"})
+		return
+	}
+
+	deliveries, err := h.nt.Deliveries(c.Request.Context(), claims.Subject, limit, offset)
+	if err != nil {
+		slog.Error("error in fetching notification deliveries",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"product-service/internal/products"
+	"product-service/internal/stores/kafka"
+	"product-service/pkg/ctxmanage"
+	"product-service/pkg/logkey"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// restockProduct adds stock to a product, if the product was out of stock the customers
+// that subscribed to it are notified
+func (h *Handler) restockProduct(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	productID := c.Param("productID")
+	if productID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Product ID is required"})
+		return
+	}
+
+	var req products.RestockRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		slog.Error("json validation error",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": http.StatusText(http.StatusBadRequest),
+		})
+		return
+	}
+
+	err = h.validate.Struct(req)
+	if err != nil {
+		slog.Error("validation failed",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "please provide values in correct format",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	level, err := h.p.RestockProduct(ctx, productID, req.Quantity)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Product not found"})
+			return
+		}
+		slog.Error("error in restocking the product",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Product Restock Failed",
+		})
+		return
+	}
+
+	if level.BackInStock() {
+		subs, err := h.p.ClaimBackInStockSubscriptions(ctx, productID)
+		if err != nil {
+			// the restock itself went through, customers can still see the stock on the product
+			slog.Error("error in fetching stock subscriptions",
+				slog.String(logkey.TraceID, traceId),
+				slog.String(logkey.ERROR, err.Error()),
+			)
+		}
+
+		if len(subs) > 0 {
+			// Notify the customers asynchronously so the admin does not wait on kafka or smtp
+			go NotifyBackInStock(h.k, traceId, level, subs)
+		}
+	}
+
+	c.JSON(http.StatusOK, level)
+}
+
+// NotifyBackInStock publishes the back in stock event, the subscribed customers are emailed for it.
+// It is used for admin restocks and for products coming back from canceled orders
+func NotifyBackInStock(k *kafka.Conf, traceId string, level products.StockLevel, subs []products.StockSubscription) {
+	userIds := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		userIds = append(userIds, sub.UserID)
+	}
+
+	data, err := json.Marshal(kafka.BackInStockEvent{
+		ProductId: level.ProductID,
+		Name:      level.Name,
+		Stock:     level.Stock,
+		UserIds:   userIds,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Error("error in marshaling back in stock event",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		return
+	}
+
+	err = k.ProduceMessage(kafka.TopicBackInStock, []byte(level.ProductID), data)
+	if err != nil {
+		slog.Error("error in producing message",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+	}
+}
+
+// subscribeBackInStock lets a customer ask to be emailed when an out of stock product is available again
+func (h *Handler) subscribeBackInStock(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	productID := c.Param("productID")
+	if productID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Product ID is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	err = h.p.SubscribeBackInStock(ctx, productID, claims.Subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Product not found"})
+			return
+		}
+		if errors.Is(err, products.ErrProductInStock) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Product is in stock"})
+			return
+		}
+		if errors.Is(err, products.ErrAlreadySubscribed) {
+			c.JSON(http.StatusOK, gin.H{"message": "You will be notified when the product is back in stock"})
+			return
+		}
+
+		slog.Error("error in subscribing to the product",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Subscription Failed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "You will be notified when the product is back in stock"})
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"product-service/internal/products"
+	"product-service/pkg/ctxmanage"
+	"product-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) addToWishlist(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	var item products.NewWishlistItem
+	err = c.ShouldBindJSON(&item)
+	if err != nil {
+		slog.Error("json validation error",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": http.StatusText(http.StatusBadRequest),
+		})
+		return
+	}
+
+	err = h.validate.Struct(item)
+	if err != nil {
+		slog.Error("validation failed",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "please provide values in correct format",
+		})
+		return
+	}
+
+	err = h.p.AddToWishlist(c.Request.Context(), claims.Subject, item)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Product not found"})
+			return
+		}
+		slog.Error("error in adding to the wishlist",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Add To Wishlist Failed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product saved to wishlist"})
+}
+
+func (h *Handler) fetchWishlist(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	items, err := h.p.FetchWishlist(c.Request.Context(), claims.Subject)
+	if err != nil {
+		slog.Error("error in fetching the wishlist",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "fetch Wishlist Failed",
+		})
+		return
+	}
+
+	if len(items) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Empty Wishlist",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *Handler) removeFromWishlist(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	wishlistID := c.Param("id")
+	if wishlistID == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Wishlist ID is required"})
+		return
+	}
+	if err := h.validate.Var(wishlistID, "uuid"); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Wishlist item not found"})
+		return
+	}
+
+	err = h.p.RemoveFromWishlist(c.Request.Context(), claims.Subject, wishlistID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Wishlist item not found"})
+			return
+		}
+		slog.Error("failed to remove wishlist item",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to remove wishlist item",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Wishlist item successfully removed"})
+}
+
+// moveWishlistToCart moves a saved product from the wishlist into the cart
+func (h *Handler) moveWishlistToCart(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	wishlistID := c.Param("id")
+	if wishlistID == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Wishlist ID is required"})
+		return
+	}
+	if err := h.validate.Var(wishlistID, "uuid"); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Wishlist item not found"})
+		return
+	}
+
+	err = h.p.MoveWishlistToCart(c.Request.Context(), claims.Subject, wishlistID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Wishlist item not found"})
+			return
+		}
+		if errors.Is(err, products.ErrInsufficientStock) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Not enough stock for the saved quantity"})
+			return
+		}
+		slog.Error("failed to move wishlist item to cart",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to move wishlist item to cart",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product moved to cart"})
+}
+
+// saveForLater moves a line item out of the cart into the wishlist
+func (h *Handler) saveForLater(c *gin.Context) {
+
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return
+	}
+
+	cartID := c.Param("id")
+	if cartID == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Cart ID is required"})
+		return
+	}
+	if err := h.validate.Var(cartID, "uuid"); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Cart item not found"})
+		return
+	}
+
+	err = h.p.SaveForLater(c.Request.Context(), claims.Subject, cartID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Cart item not found"})
+			return
+		}
+		slog.Error("failed to save cart item for later",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to save cart item for later",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cart item saved for later"})
+}
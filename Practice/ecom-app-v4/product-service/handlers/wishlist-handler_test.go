@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"product-service/internal/auth"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+func TestWishlistMalformedID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{validate: validator.New()}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		route   string
+		handler gin.HandlerFunc
+	}{
+		{name: "remove from wishlist", method: http.MethodDelete, path: "/wishlist/not-a-uuid", route: "/wishlist/:id", handler: h.removeFromWishlist},
+		{name: "move to cart", method: http.MethodPost, path: "/wishlist/not-a-uuid/move-to-cart", route: "/wishlist/:id/move-to-cart", handler: h.moveWishlistToCart},
+		{name: "save for later", method: http.MethodPost, path: "/cart/1;drop/save-for-later", route: "/cart/:id/save-for-later", handler: h.saveForLater},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Handle(tt.method, tt.route, tt.handler)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			var claims auth.Claims
+			claims.Subject = "0b6f5c1e-6a43-4d59-9a0e-3f8a7f1f2a11"
+			req = req.WithContext(context.WithValue(req.Context(), auth.ClaimsKey, claims))
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -3,6 +3,9 @@ package auth
 import (
 	"crypto/rsa"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -11,52 +14,82 @@ type ctxKey int
 
 const ClaimsKey ctxKey = 1
 
-const RoleUser = "user"
-const RoleAdmin = "admin"
+// APIKeyClaimsKey holds the claims of a token an API key was exchanged for. Only RequirePermission
+// reads it, so a key opens the routes gated by a permission and none of the customer ones.
+const APIKeyClaimsKey ctxKey = 2
 
+// Permissions are granted to roles in the user service and carried by the token, the routes check them
+const (
+	PermProductsWrite = "products:write" // create, update and restock products
+)
+
+// Keys validates tokens with the public keys of the user service, loaded from its JWKS document and cached by kid
 type Keys struct {
-	publicKey *rsa.PublicKey
+	source  JWKSource
+	revoked *Revocations // nil skips the revocation check
+
+	mu         sync.RWMutex
+	publicKeys map[string]*rsa.PublicKey
+
+	// refreshMu lets one request at a time load the document
+	refreshMu   sync.Mutex
+	refreshedAt time.Time
 }
 
-// NewKeys is a constructor function for Keys struct. It accepts privateKey and publicKey as parameters and returns
-// an instance of Keys struct. If either of privateKey or publicKey is nil, it returns an error.
-func NewKeys(publicKey *rsa.PublicKey) (*Keys, error) {
-	if publicKey == nil {
+// NewKeys is a constructor function for Keys struct. It accepts the source of the JWKS document and the list of
+// revoked tokens and returns an instance of Keys struct. If either of them is nil, it returns an error.
+// The keys are loaded on the first token or by Sync.
+func NewKeys(source JWKSource, revoked *Revocations) (*Keys, error) {
+	if source == nil || revoked == nil {
 		return nil, fmt.Errorf("invalid keys")
 	}
-	return &Keys{publicKey}, nil
-
+	return &Keys{source: source, revoked: revoked, publicKeys: make(map[string]*rsa.PublicKey)}, nil
 }
 
 type Claims struct {
 	jwt.RegisteredClaims
-	Roles []string `json:"roles"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`       // of the roles, checked by RequirePermission
+	Service     string   `json:"service,omitempty"` // set on service tokens, names the calling service
+	APIKey      string   `json:"api_key,omitempty"` // set on tokens an API key was exchanged for, the id of the key
 }
 
-func (c Claims) HasRoles(requiredRoles ...string) bool {
-	for _, has := range c.Roles { // roles with the user in the token
-		for _, want := range requiredRoles {
-			if has == want {
-				return true
-			}
+// AudienceInternal is the audience of the service tokens the user service issues, user tokens are issued for "everyone"
+const AudienceInternal = "internal"
+
+// IsService reports whether the claims are of a service token
+func (c Claims) IsService() bool {
+	return c.Service != "" && slices.Contains(c.Audience, AudienceInternal)
+}
+
+// HasPermissions reports whether the token carries every one of the permissions
+func (c Claims) HasPermissions(requiredPermissions ...string) bool {
+	for _, want := range requiredPermissions {
+		if !slices.Contains(c.Permissions, want) {
+			return false
 		}
 	}
-	return false
+	return true
 }
 
-// ValidateToken is a method for Auth struct. It verifies the provided JWT token using the publicKey of the Auth struct
-// it's called upon and returns the parsed claims if the JWT token is valid. If the JWT token is invalid or
+// ValidateToken is a method for Auth struct. It verifies the provided JWT token using the key named by its kid header
+// and returns the parsed claims if the JWT token is valid. If the JWT token is invalid, signed with an unknown key or
 // there is an error during parsing, it returns an error.
 func (k *Keys) ValidateToken(tokenStr string) (Claims, error) {
 	var claims Claims
 	tkn, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
-		return k.publicKey, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		return k.publicKey(kid)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
 	if err != nil {
 		return Claims{}, err
 	}
 	if !tkn.Valid {
 		return Claims{}, fmt.Errorf("invalid token")
 	}
+	// tokens revoked on logout or a stolen refresh token are refused until they expire
+	if k.revoked != nil && k.revoked.IsRevoked(claims.ID) {
+		return Claims{}, ErrTokenRevoked
+	}
 	return claims, nil
 }
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// CartTokenHeader and CartTokenCookie carry the guest cart token, clients can use either
+const (
+	CartTokenHeader = "X-Cart-Token"
+	CartTokenCookie = "cart_token"
+)
+
+var ErrInvalidCartToken = errors.New("invalid cart token")
+
+// CartTokens signs and verifies the tokens that identify a guest cart.
+// A token is the guest id followed by an HMAC-SHA256 signature of it,
+// so a guest can not guess or forge the id of another guest's cart.
+type CartTokens struct {
+	secret []byte
+}
+
+// NewCartTokens is a constructor function for CartTokens, the secret is used to sign every token
+func NewCartTokens(secret []byte) (*CartTokens, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("cart token secret must be at least 32 bytes")
+	}
+	return &CartTokens{secret: secret}, nil
+}
+
+// NewGuestToken creates a new guest id and returns it with its signed token
+func (ct *CartTokens) NewGuestToken() (guestId string, token string) {
+	guestId = uuid.NewString()
+	return guestId, ct.sign(guestId)
+}
+
+// ValidateToken verifies the signature of the token and returns the guest id inside it
+func (ct *CartTokens) ValidateToken(token string) (string, error) {
+	guestId, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidCartToken
+	}
+
+	if _, err := uuid.Parse(guestId); err != nil {
+		return "", ErrInvalidCartToken
+	}
+
+	// constant time comparison, so the signature can not be guessed byte by byte
+	if !hmac.Equal([]byte(token), []byte(ct.sign(guestId))) {
+		return "", ErrInvalidCartToken
+	}
+	return guestId, nil
+}
+
+func (ct *CartTokens) sign(guestId string) string {
+	mac := hmac.New(sha256.New, ct.secret)
+	mac.Write([]byte(guestId))
+	return guestId + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateCartToken(t *testing.T) {
+	ct, err := NewCartTokens([]byte(strings.Repeat("s", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCartTokens([]byte(strings.Repeat("o", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guestId, token := ct.NewGuestToken()
+	_, otherToken := other.NewGuestToken()
+	_, sig, _ := strings.Cut(token, ".")
+	forgedId, _ := ct.NewGuestToken()
+	tampered := token[:len(token)-1] + "A"
+	if tampered == token {
+		tampered = token[:len(token)-1] + "B"
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{name: "valid", token: token, want: guestId},
+		{name: "signed with another secret", token: otherToken},
+		{name: "another guest id with the signature", token: forgedId + "." + sig},
+		{name: "tampered signature", token: tampered},
+		{name: "no signature", token: guestId + "."},
+		{name: "no separator", token: guestId},
+		{name: "not a uuid", token: "guest." + sig},
+		{name: "empty", token: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ct.ValidateToken(tt.token)
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidCartToken) {
+					t.Errorf("err = %v, want %v", err, ErrInvalidCartToken)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got (%q, %v), want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCartTokensShortSecret(t *testing.T) {
+	if _, err := NewCartTokens([]byte("short")); err == nil {
+		t.Error("a secret under 32 bytes was accepted")
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"time"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+const (
+	defaultJWKSSyncInterval = 10 * time.Minute
+
+	// an unknown kid loads the document again at most this often, tokens with made up kids can not flood the user service
+	jwksMinRefreshInterval = 30 * time.Second
+)
+
+// JWKSSyncInterval is how often the signing keys are loaded from the user service, a key removed there
+// is accepted for at most this long. JWKS_SYNC_INTERVAL overrides it, e.g. "5m".
+func JWKSSyncInterval() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("JWKS_SYNC_INTERVAL"))
+	if err != nil || d <= 0 {
+		return defaultJWKSSyncInterval
+	}
+	return d
+}
+
+// JWK is a public key of the user service as published in its JWKS document (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is the document listing the keys the user service signs tokens with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKSource returns the JWKS document of the user service
+type JWKSource func(ctx context.Context) (JWKS, error)
+
+// PublicKey decodes the RSA public key of the JWK
+func (j JWK) PublicKey() (*rsa.PublicKey, error) {
+	if j.Kty != "RSA" {
+		return nil, fmt.Errorf("key %s: unsupported key type %q", j.Kid, j.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(j.N)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: decoding modulus: %w", j.Kid, err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(j.E)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: decoding exponent: %w", j.Kid, err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("key %s: invalid key", j.Kid)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// Refresh loads the JWKS document and replaces the cached keys.
+// A failed load or a document without usable keys keeps the previous keys.
+func (k *Keys) Refresh(ctx context.Context) error {
+	k.refreshMu.Lock()
+	defer k.refreshMu.Unlock()
+	return k.refresh(ctx)
+}
+
+// refresh must be called with refreshMu held
+func (k *Keys) refresh(ctx context.Context) error {
+	k.refreshedAt = time.Now()
+
+	jwks, err := k.source(ctx)
+	if err != nil {
+		return fmt.Errorf("loading signing keys: %w", err)
+	}
+
+	publicKeys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		publicKey, err := jwk.PublicKey()
+		if err != nil {
+			slog.Error("skipping signing key", slog.Any("error", err))
+			continue
+		}
+		publicKeys[jwk.Kid] = publicKey
+	}
+	if len(publicKeys) == 0 {
+		return errors.New("loading signing keys: no usable keys in the JWKS document")
+	}
+
+	k.mu.Lock()
+	k.publicKeys = publicKeys
+	k.mu.Unlock()
+	return nil
+}
+
+// publicKey returns the key with the kid. An unknown kid usually means the user service started signing
+// with a new key, so the document is loaded again unless that happened moments ago.
+func (k *Keys) publicKey(kid string) (*rsa.PublicKey, error) {
+	if publicKey, ok := k.cachedKey(kid); ok {
+		return publicKey, nil
+	}
+
+	k.refreshMu.Lock()
+	defer k.refreshMu.Unlock()
+	// another request may have loaded it while this one waited
+	if publicKey, ok := k.cachedKey(kid); ok {
+		return publicKey, nil
+	}
+	if time.Since(k.refreshedAt) < jwksMinRefreshInterval {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := k.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	if publicKey, ok := k.cachedKey(kid); ok {
+		return publicKey, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+}
+
+func (k *Keys) cachedKey(kid string) (*rsa.PublicKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	publicKey, ok := k.publicKeys[kid]
+	return publicKey, ok
+}
+
+// Sync loads the keys every interval until the context is canceled, keys removed from the document stop being accepted
+func (k *Keys) Sync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := k.Refresh(ctx); err != nil {
+			slog.Error("error syncing signing keys", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signedToken(t *testing.T, kid string, key *rsa.PrivateKey) string {
+	t.Helper()
+	tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "u1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}})
+	tkn.Header["kid"] = kid
+	token, err := tkn.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func jwkOf(kid string, key *rsa.PrivateKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func TestValidateTokenLoadsRotatedKeys(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published := JWKS{Keys: []JWK{jwkOf("old", oldKey)}}
+	loads := 0
+	k, err := NewKeys(func(ctx context.Context) (JWKS, error) {
+		loads++
+		return published, nil
+	}, NewRevocations())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first token loads the document, the next ones use the cache
+	for i := 0; i < 2; i++ {
+		if _, err := k.ValidateToken(signedToken(t, "old", oldKey)); err != nil {
+			t.Fatalf("ValidateToken() error = %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("document loaded %d times, want 1", loads)
+	}
+
+	// the user service starts signing with a new key, the unknown kid loads the document again
+	published = JWKS{Keys: []JWK{jwkOf("new", newKey), jwkOf("old", oldKey)}}
+	k.refreshedAt = time.Now().Add(-jwksMinRefreshInterval)
+	if _, err := k.ValidateToken(signedToken(t, "new", newKey)); err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if loads != 2 {
+		t.Fatalf("document loaded %d times, want 2", loads)
+	}
+
+	// made up kids do not load the document on every request
+	if _, err := k.ValidateToken(signedToken(t, "other", newKey)); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("ValidateToken() error = %v, want %v", err, ErrUnknownKey)
+	}
+	if loads != 2 {
+		t.Fatalf("document loaded %d times, want 2", loads)
+	}
+
+	// a key removed from the document is no longer accepted once synced
+	published = JWKS{Keys: []JWK{jwkOf("new", newKey)}}
+	if err := k.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.ValidateToken(signedToken(t, "old", oldKey)); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("ValidateToken() error = %v, want %v", err, ErrUnknownKey)
+	}
+}
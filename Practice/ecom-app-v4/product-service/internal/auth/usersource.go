@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"product-service/internal/consul"
+	"strings"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// UserServiceTokens gets service tokens from the user service with the client id and secret of this service
+func UserServiceTokens(client *consulapi.Client, clientId, clientSecret string) ServiceTokenSource {
+	return func(ctx context.Context) (ServiceToken, error) {
+		address, port, err := consul.GetServiceAddress(client, "users")
+		if err != nil {
+			return ServiceToken{}, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		form := url.Values{"grant_type": {"client_credentials"}}
+		httpQuery := fmt.Sprintf("http://%s:%d/users/internal/token", address, port)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, httpQuery, strings.NewReader(form.Encode()))
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientId, clientSecret)
+
+		requested := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return ServiceToken{}, fmt.Errorf("user service responded with status %d", resp.StatusCode)
+		}
+
+		var body struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int    `json:"expires_in"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		if body.AccessToken == "" {
+			return ServiceToken{}, fmt.Errorf("user service sent no service token")
+		}
+		return ServiceToken{
+			AccessToken: body.AccessToken,
+			ExpiresAt:   requested.Add(time.Duration(body.ExpiresIn) * time.Second),
+		}, nil
+	}
+}
+
+// UserServiceRevocations loads the revoked tokens from the user service
+func UserServiceRevocations(client *consulapi.Client, tokens *ServiceTokens) RevocationSource {
+	return func(ctx context.Context) ([]RevokedToken, error) {
+		address, port, err := consul.GetServiceAddress(client, "users")
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		httpQuery := fmt.Sprintf("http://%s:%d/users/internal/revocations", address, port)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpQuery, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := tokens.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("user service responded with status %d", resp.StatusCode)
+		}
+
+		var tokens []RevokedToken
+		err = json.NewDecoder(resp.Body).Decode(&tokens)
+		if err != nil {
+			return nil, err
+		}
+		return tokens, nil
+	}
+}
+
+// UserServiceJWKS loads the JWKS document from the user service
+func UserServiceJWKS(client *consulapi.Client) JWKSource {
+	return func(ctx context.Context) (JWKS, error) {
+		address, port, err := consul.GetServiceAddress(client, "users")
+		if err != nil {
+			return JWKS{}, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		httpQuery := fmt.Sprintf("http://%s:%d/users/.well-known/jwks.json", address, port)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpQuery, nil)
+		if err != nil {
+			return JWKS{}, err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return JWKS{}, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return JWKS{}, fmt.Errorf("user service responded with status %d", resp.StatusCode)
+		}
+
+		var jwks JWKS
+		err = json.NewDecoder(resp.Body).Decode(&jwks)
+		if err != nil {
+			return JWKS{}, err
+		}
+		return jwks, nil
+	}
+}
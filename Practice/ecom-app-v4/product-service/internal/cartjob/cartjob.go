@@ -0,0 +1,202 @@
+// Package cartjob runs in the background and looks after carts nobody is working on anymore.
+// Idle in progress carts get a cart abandoned event, which the customer is reminded by, carts stuck in
+// pending because the checkout was never paid are moved back to in progress.
+package cartjob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"product-service/internal/auth"
+	"product-service/internal/consul"
+	"product-service/internal/products"
+	"product-service/internal/stores/kafka"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+const (
+	// stripe checkout sessions expire after 24 hours, a pending cart older than that can not be paid anymore
+	checkoutExpiry  = 24 * time.Hour
+	defaultIdleAge  = 24 * time.Hour
+	defaultInterval = 15 * time.Minute
+)
+
+// Config controls how long a cart has to be idle and how often the job runs
+type Config struct {
+	IdleAge  time.Duration
+	Interval time.Duration
+}
+
+// checkoutAge is how long a pending cart is left alone. A shorter idle age only applies to in progress
+// carts, the stripe session of a pending cart can still be paid until it expires.
+func (c Config) checkoutAge() time.Duration {
+	return max(c.IdleAge, checkoutExpiry)
+}
+
+// LoadConfig reads ABANDONED_CART_IDLE_AGE and ABANDONED_CART_CHECK_INTERVAL, e.g. "24h" and "15m"
+func LoadConfig() (Config, error) {
+	cfg := Config{IdleAge: defaultIdleAge, Interval: defaultInterval}
+
+	if v := os.Getenv("ABANDONED_CART_IDLE_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid ABANDONED_CART_IDLE_AGE %q", v)
+		}
+		cfg.IdleAge = d
+	}
+
+	if v := os.Getenv("ABANDONED_CART_CHECK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid ABANDONED_CART_CHECK_INTERVAL %q", v)
+		}
+		cfg.Interval = d
+	}
+
+	return cfg, nil
+}
+
+type Job struct {
+	cfg    Config
+	p      *products.Conf
+	k      *kafka.Conf
+	client *consulapi.Client
+	tokens *auth.ServiceTokens
+}
+
+func NewJob(cfg Config, p *products.Conf, k *kafka.Conf, client *consulapi.Client, tokens *auth.ServiceTokens) (*Job, error) {
+	if p == nil || k == nil || client == nil || tokens == nil {
+		return nil, errors.New("cart job dependencies are not initialized")
+	}
+	return &Job{cfg: cfg, p: p, k: k, client: client, tokens: tokens}, nil
+}
+
+// Run checks the carts every interval until the context is canceled
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().UTC()
+			j.remindAbandonedCarts(ctx, now.Add(-j.cfg.IdleAge))
+			j.releaseStaleCheckouts(ctx, now.Add(-j.cfg.checkoutAge()))
+		}
+	}
+}
+
+func (j *Job) remindAbandonedCarts(ctx context.Context, idleBefore time.Time) {
+	carts, err := j.p.ClaimAbandonedCarts(ctx, idleBefore)
+	if err != nil {
+		slog.Error("error fetching abandoned carts", slog.Any("error", err))
+		return
+	}
+
+	for _, cart := range carts {
+		lines := make([]kafka.CartLineEvent, 0, len(cart.LineItems))
+		for _, line := range cart.LineItems {
+			lines = append(lines, kafka.CartLineEvent{ProductId: line.ProductID, Quantity: line.Quantity})
+		}
+
+		data, err := json.Marshal(kafka.CartAbandonedEvent{
+			OrderId:   cart.OrderId,
+			UserId:    cart.UserID,
+			LineItems: lines,
+			IdleSince: cart.IdleSince,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			slog.Error("error marshaling cart abandoned event", slog.Any("error", err))
+			continue
+		}
+
+		err = j.k.ProduceMessage(kafka.TopicCartAbandoned, []byte(cart.UserID), data)
+		if err != nil {
+			slog.Error("error producing cart abandoned event", slog.String("OrderID", cart.OrderId), slog.Any("error", err))
+		}
+	}
+}
+
+func (j *Job) releaseStaleCheckouts(ctx context.Context, idleBefore time.Time) {
+	carts, err := j.p.FetchStaleCheckouts(ctx, idleBefore)
+	if err != nil {
+		slog.Error("error fetching stale checkouts", slog.Any("error", err))
+		return
+	}
+
+	for _, cart := range carts {
+		paid, err := j.orderPaid(ctx, cart.OrderId)
+		if err != nil {
+			// try again on the next run instead of releasing a cart that might be paid
+			slog.Error("error fetching order status", slog.String("OrderID", cart.OrderId), slog.Any("error", err))
+			continue
+		}
+		if paid {
+			// the order paid event moves the cart to completed
+			continue
+		}
+
+		newOrderId, err := j.p.ReleasePendingCart(ctx, cart.OrderId, cart.UserID)
+		if err != nil {
+			slog.Error("error releasing pending cart", slog.String("OrderID", cart.OrderId), slog.Any("error", err))
+			continue
+		}
+		slog.Info("released unpaid checkout", slog.String("OrderID", cart.OrderId), slog.String("NewOrderID", newOrderId))
+	}
+}
+
+// orderPaid asks the order service whether the order of the checkout got paid.
+// A checkout that failed before the order was created has no order and is treated as unpaid.
+func (j *Job) orderPaid(ctx context.Context, orderId string) (bool, error) {
+	address, port, err := consul.GetServiceAddress(j.client, "orders")
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	httpQuery := fmt.Sprintf("http://%s:%d/orders/internal/%s/status", address, port, orderId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpQuery, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := j.tokens.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return decodeOrderPaid(resp)
+}
+
+// decodeOrderPaid reads the order status response of the order service
+func decodeOrderPaid(resp *http.Response) (bool, error) {
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("order service responded with status %d", resp.StatusCode)
+	}
+
+	var orderStatus struct {
+		Status string `json:"status"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&orderStatus)
+	if err != nil {
+		return false, err
+	}
+
+	// an order that moved past paid, e.g. packed or refunded, was paid as well
+	return orderStatus.Status != "pending" && orderStatus.Status != "canceled", nil
+}
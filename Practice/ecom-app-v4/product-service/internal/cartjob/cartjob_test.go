@@ -0,0 +1,60 @@
+package cartjob
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckoutAge(t *testing.T) {
+	tests := []struct {
+		name    string
+		idleAge time.Duration
+		want    time.Duration
+	}{
+		{name: "short idle age waits for the session to expire", idleAge: time.Hour, want: 24 * time.Hour},
+		{name: "default idle age", idleAge: 24 * time.Hour, want: 24 * time.Hour},
+		{name: "longer idle age is kept", idleAge: 72 * time.Hour, want: 72 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Config{IdleAge: tt.idleAge}).checkoutAge(); got != tt.want {
+				t.Errorf("checkoutAge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeOrderPaid(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "paid", status: http.StatusOK, body: `{"status":"paid"}`, want: true},
+		{name: "moved past paid", status: http.StatusOK, body: `{"status":"refunded"}`, want: true},
+		{name: "pending", status: http.StatusOK, body: `{"status":"pending"}`},
+		{name: "canceled", status: http.StatusOK, body: `{"status":"canceled"}`},
+		{name: "no order was created", status: http.StatusNotFound},
+		{name: "order service failed", status: http.StatusInternalServerError, wantErr: true},
+		{name: "malformed body", status: http.StatusOK, body: `{`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rec.WriteHeader(tt.status)
+			rec.WriteString(tt.body)
+
+			got, err := decodeOrderPaid(rec.Result())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeOrderPaid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decodeOrderPaid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Package invoices fetches the PDF invoices the order service issues for paid orders
+package invoices
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"product-service/internal/auth"
+	"product-service/internal/consul"
+	"strconv"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// maxInvoiceSize guards the email against an unexpected response, invoices are a few kilobytes
+const maxInvoiceSize = 5 << 20
+
+// Invoice is the PDF invoice of an order with the details the confirmation email shows
+type Invoice struct {
+	Number string
+	Total  int64 // in paise
+	PDF    []byte
+}
+
+// Fetch downloads the PDF invoice of the order from the order service, which issues it if it is not yet
+func Fetch(ctx context.Context, client *consulapi.Client, tokens *auth.ServiceTokens, orderId string) (Invoice, error) {
+	address, port, err := consul.GetServiceAddress(client, "orders")
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	httpQuery := fmt.Sprintf("http://%s:%d/orders/internal/%s/invoice", address, port, orderId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpQuery, nil)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	resp, err := tokens.Do(req)
+	if err != nil {
+		return Invoice{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Invoice{}, fmt.Errorf("order service responded with status %d", resp.StatusCode)
+	}
+
+	total, err := strconv.ParseInt(resp.Header.Get("X-Invoice-Total"), 10, 64)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("invalid invoice total: %w", err)
+	}
+
+	pdf, err := io.ReadAll(io.LimitReader(resp.Body, maxInvoiceSize))
+	if err != nil {
+		return Invoice{}, fmt.Errorf("reading invoice: %w", err)
+	}
+	return Invoice{Number: resp.Header.Get("X-Invoice-Number"), Total: total, PDF: pdf}, nil
+}
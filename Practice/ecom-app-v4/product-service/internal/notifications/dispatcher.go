@@ -0,0 +1,186 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"product-service/internal/auth"
+	"product-service/internal/invoices"
+	"product-service/internal/notify"
+	"product-service/internal/stores/kafka"
+	"strings"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// the order paid event can be consumed before the order service stored the payment,
+// fetching the invoice is retried for a little while before the confirmation goes out without it
+const (
+	invoiceAttempts = 3
+	invoiceBackoff  = 2 * time.Second
+)
+
+// a delivery that failed, or is still queued because the service stopped before sending it,
+// is sent again by the retry loop a while later, up to retryAttempts sends in total
+const (
+	retryEvery    = time.Minute
+	retryAfter    = 10 * time.Minute
+	retryAttempts = 5
+	retryBatch    = 50
+)
+
+// Dispatcher consumes the domain events and hands the notifications to the notifier
+type Dispatcher struct {
+	store    *Conf
+	notifier *notify.Notifier
+	client   *consulapi.Client
+	tokens   *auth.ServiceTokens // the invoices are fetched from an internal route of the order service
+}
+
+func NewDispatcher(store *Conf, notifier *notify.Notifier, client *consulapi.Client, tokens *auth.ServiceTokens) (*Dispatcher, error) {
+	if store == nil || notifier == nil || client == nil || tokens == nil {
+		return nil, errors.New("notification dispatcher dependencies are not initialized")
+	}
+	return &Dispatcher{store: store, notifier: notifier, client: client, tokens: tokens}, nil
+}
+
+// Run consumes every topic of Topics in its own goroutine and retries the failed deliveries
+// until the context is canceled
+func (d *Dispatcher) Run(ctx context.Context) {
+	for _, topic := range Topics {
+		go d.consume(ctx, topic)
+	}
+	go d.retry(ctx)
+}
+
+// retry sends the failed and stale deliveries again every retryEvery
+func (d *Dispatcher) retry(ctx context.Context) {
+	ticker := time.NewTicker(retryEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		retries, err := d.store.ClaimRetries(ctx, retryAfter, retryAttempts, retryBatch)
+		if err != nil {
+			slog.Error("error claiming notification retries", slog.Any("error", err))
+			continue
+		}
+		for _, r := range retries {
+			slog.Info("retrying notification", slog.String("EventID", r.job.eventID), slog.Int("Attempt", r.Attempt))
+			d.send(ctx, r.ID, r.job)
+		}
+	}
+}
+
+func (d *Dispatcher) consume(ctx context.Context, topic string) {
+	ch := make(chan kafka.ConsumeResult)
+	go kafka.ConsumeMessage(ctx, topic, kafka.NotificationsConsumerGroup, ch)
+	for v := range ch {
+		if v.Err != nil {
+			slog.Error("error consuming notification events", slog.String("Topic", topic), slog.Any("error", v.Err))
+			continue
+		}
+
+		jobs, err := jobsFor(v.Record)
+		if err != nil {
+			slog.Error("error reading notification event", slog.String("Topic", topic), slog.Int64("Offset", v.Record.Offset),
+				slog.Any("error", err))
+			continue
+		}
+		for _, j := range jobs {
+			d.dispatch(ctx, j)
+		}
+	}
+}
+
+// dispatch claims the job so it is sent only once and sends it, the worker records the outcome in the delivery log
+func (d *Dispatcher) dispatch(ctx context.Context, j job) {
+	id, claimed, err := d.store.Claim(ctx, j)
+	if err != nil {
+		slog.Error("error recording notification", slog.String("EventID", j.eventID), slog.Any("error", err))
+		return
+	}
+	if !claimed {
+		slog.Info("notification already handled", slog.String("EventID", j.eventID))
+		return
+	}
+	d.send(ctx, id, j)
+}
+
+// send honours the preferences of the user and queues the email of the claimed delivery
+func (d *Dispatcher) send(ctx context.Context, id string, j job) {
+	if j.nt.UserID != "" {
+		enabled, err := d.store.EmailEnabled(ctx, j.nt.UserID, j.nt.Event)
+		if err != nil {
+			slog.Error("error fetching notification preference", slog.String("EventID", j.eventID), slog.Any("error", err))
+			d.finish(id, StatusFailed, notify.Message{}, err)
+			return
+		}
+		if !enabled {
+			slog.Info("notification switched off by the user", slog.String("EventID", j.eventID), slog.String("UserID", j.nt.UserID))
+			d.finish(id, StatusSkipped, notify.Message{}, nil)
+			return
+		}
+	}
+
+	if j.invoiceOf != "" {
+		d.attachInvoice(ctx, &j.nt, j.invoiceOf)
+	}
+
+	j.nt.OnDone = func(msg notify.Message, err error) {
+		status := StatusSent
+		if err != nil {
+			status = StatusFailed
+		}
+		d.finish(id, status, msg, err)
+	}
+
+	err := d.notifier.Notify(ctx, j.nt)
+	if err != nil {
+		slog.Error("error queueing notification", slog.String("EventID", j.eventID), slog.Any("error", err))
+		d.finish(id, StatusFailed, notify.Message{}, err)
+	}
+}
+
+// attachInvoice adds the invoice of the order to the confirmation, the email still goes out if it can not be fetched
+func (d *Dispatcher) attachInvoice(ctx context.Context, nt *notify.Notification, orderId string) {
+	var err error
+	for attempt := 1; attempt <= invoiceAttempts; attempt++ {
+		var inv invoices.Invoice
+		inv, err = invoices.Fetch(ctx, d.client, d.tokens, orderId)
+		if err == nil {
+			nt.Data["InvoiceNumber"] = inv.Number
+			nt.Data["Total"] = notify.FormatAmount(inv.Total)
+			nt.Attachments = []notify.Attachment{{Filename: inv.Number + ".pdf", ContentType: "application/pdf", Data: inv.PDF}}
+			return
+		}
+		if attempt == invoiceAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(time.Duration(attempt) * invoiceBackoff):
+		case <-ctx.Done():
+			return
+		}
+	}
+	slog.Error("error fetching invoice, sending the confirmation without it", slog.String("OrderID", orderId), slog.Any("error", err))
+}
+
+// finish records the outcome of the delivery, the email is already sent or given up on so a failure is only logged
+func (d *Dispatcher) finish(id, status string, msg notify.Message, sendErr error) {
+	errMsg := ""
+	if sendErr != nil {
+		errMsg = sendErr.Error()
+	}
+
+	err := d.store.Finish(context.Background(), id, status, strings.Join(msg.To, ", "), msg.Subject, errMsg, retryAttempts)
+	if err != nil {
+		slog.Error("error updating delivery log", slog.String("DeliveryID", id), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,226 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"product-service/internal/notify"
+	"product-service/internal/stores/kafka"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Topics are the domain events that end up in a notification
+var Topics = []string{
+	kafka.TopicAccountCreated,
+	kafka.TopicEmailVerificationRequested,
+	kafka.TopicPasswordResetRequested,
+	kafka.TopicUserLocked,
+	kafka.TopicOrderPaid,
+	kafka.TopicOrderStatusChanged,
+	kafka.TopicReturnStatusChanged,
+	kafka.TopicBackInStock,
+	kafka.TopicCartAbandoned,
+	kafka.TopicLowStock,
+}
+
+var errNoUser = errors.New("event has no user")
+
+// job is one notification to send for an event
+type job struct {
+	eventID string // unique per notification, a job with a seen id is dropped
+	nt      notify.Notification
+
+	// invoiceOf is the order whose invoice is attached, it is only fetched once the job is claimed
+	invoiceOf string
+}
+
+// jobsFor decodes the record and returns the notifications it leads to.
+// Events that carry a natural identity, like an order moving to a status, use it for the event id
+// so the same change published twice is sent once, the others use their position in the topic.
+func jobsFor(record *kgo.Record) ([]job, error) {
+	switch record.Topic {
+	case kafka.TopicAccountCreated:
+		var event kafka.AccountCreatedEvent
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		return []job{{
+			eventID: "account-created:" + event.ID,
+			nt:      notify.Notification{Event: notify.EventAccountCreated, UserID: event.ID, Data: map[string]any{}},
+		}}, nil
+
+	case kafka.TopicEmailVerificationRequested:
+		var event kafka.EmailVerificationRequested
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		// every request carries a new link, a resend is sent even though the user is the same
+		return []job{{
+			eventID: recordID(record),
+			nt: notify.Notification{
+				Event:  notify.EventVerifyEmail,
+				UserID: event.UserID,
+				Data: map[string]any{
+					"VerifyURL": event.VerifyURL,
+					"ExpiresAt": event.ExpiresAt.UTC().Format("02 Jan 2006 15:04 UTC"),
+				},
+			},
+		}}, nil
+
+	case kafka.TopicPasswordResetRequested:
+		var event kafka.PasswordResetRequested
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		return []job{{
+			eventID: recordID(record),
+			nt: notify.Notification{
+				Event:  notify.EventPasswordReset,
+				UserID: event.UserID,
+				Data: map[string]any{
+					"ResetURL":  event.ResetURL,
+					"ExpiresAt": event.ExpiresAt.UTC().Format("02 Jan 2006 15:04 UTC"),
+				},
+			},
+		}}, nil
+
+	case kafka.TopicUserLocked:
+		var event kafka.UserLocked
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		return []job{{
+			eventID: fmt.Sprintf("user-locked:%s:%d", event.UserID, event.LockedUntil.Unix()),
+			nt: notify.Notification{
+				Event:  notify.EventAccountLocked,
+				UserID: event.UserID,
+				Data: map[string]any{
+					"IP":          event.IP,
+					"LockedUntil": event.LockedUntil.UTC().Format("02 Jan 2006 15:04 UTC"),
+				},
+			},
+		}}, nil
+
+	case kafka.TopicOrderPaid:
+		var event kafka.OrderPaidEvent
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		if event.UserId == "" {
+			return nil, fmt.Errorf("order %s: %w", event.OrderId, errNoUser)
+		}
+		// a cart order is announced once per line, the customer gets one confirmation
+		return []job{{
+			eventID: "order-paid:" + event.OrderId,
+			nt: notify.Notification{
+				Event:  notify.EventOrderConfirmation,
+				UserID: event.UserId,
+				Data:   map[string]any{"OrderID": event.OrderId, "InvoiceNumber": "", "Total": ""},
+			},
+			invoiceOf: event.OrderId,
+		}}, nil
+
+	case kafka.TopicOrderStatusChanged:
+		var event kafka.OrderStatusChangedEvent
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		return []job{{
+			eventID: fmt.Sprintf("order-status-changed:%s:%s", event.OrderId, event.Status),
+			nt: notify.Notification{
+				Event:  notify.EventOrderStatus,
+				UserID: event.UserId,
+				Data: map[string]any{
+					"OrderID":        event.OrderId,
+					"Status":         event.Status,
+					"Carrier":        event.Carrier,
+					"TrackingNumber": event.TrackingNumber,
+					"Note":           event.Note,
+				},
+			},
+		}}, nil
+
+	case kafka.TopicReturnStatusChanged:
+		var event kafka.ReturnStatusChangedEvent
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		return []job{{
+			eventID: fmt.Sprintf("return-status-changed:%s:%s", event.ReturnId, event.Status),
+			nt: notify.Notification{
+				Event:  notify.EventReturnStatus,
+				UserID: event.UserId,
+				Data: map[string]any{
+					"ReturnID":     event.ReturnId,
+					"OrderID":      event.OrderId,
+					"Status":       event.Status,
+					"Note":         event.Note,
+					"RefundAmount": notify.FormatAmount(event.RefundAmount),
+				},
+			},
+		}}, nil
+
+	case kafka.TopicBackInStock:
+		var event kafka.BackInStockEvent
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		jobs := make([]job, 0, len(event.UserIds))
+		for _, userId := range event.UserIds {
+			jobs = append(jobs, job{
+				eventID: recordID(record) + ":" + userId,
+				nt: notify.Notification{
+					Event:  notify.EventBackInStock,
+					UserID: userId,
+					Data:   map[string]any{"ProductName": event.Name},
+				},
+			})
+		}
+		return jobs, nil
+
+	case kafka.TopicCartAbandoned:
+		var event kafka.CartAbandonedEvent
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		itemCount := 0
+		for _, line := range event.LineItems {
+			itemCount += line.Quantity
+		}
+		return []job{{
+			eventID: fmt.Sprintf("cart-abandoned:%s:%d", event.OrderId, event.IdleSince.Unix()),
+			nt: notify.Notification{
+				Event:  notify.EventCartReminder,
+				UserID: event.UserId,
+				Data:   map[string]any{"ItemCount": itemCount},
+			},
+		}}, nil
+
+	case kafka.TopicLowStock:
+		var event kafka.LowStockEvent
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			return nil, err
+		}
+		return []job{{
+			eventID: recordID(record),
+			nt: notify.Notification{
+				Event:    notify.EventLowStock,
+				ToAdmins: true,
+				Data: map[string]any{
+					"ProductID":        event.ProductId,
+					"ProductName":      event.Name,
+					"Stock":            event.Stock,
+					"ReorderThreshold": event.ReorderThreshold,
+				},
+			},
+		}}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected topic %s", record.Topic)
+}
+
+// recordID identifies the record by its position, a redelivered record gets the same id
+func recordID(record *kgo.Record) string {
+	return fmt.Sprintf("%s/%d/%d", record.Topic, record.Partition, record.Offset)
+}
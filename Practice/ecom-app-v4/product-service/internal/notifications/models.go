@@ -0,0 +1,111 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"product-service/internal/notify"
+	"slices"
+	"time"
+)
+
+// Statuses of a delivery in the log
+const (
+	StatusQueued  = "queued"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped" // switched off by the user
+)
+
+const ChannelEmail = "email"
+
+// Optional lists the notifications a user can switch off,
+// order confirmations and the welcome email always go out
+var Optional = []string{
+	notify.EventOrderStatus,
+	notify.EventReturnStatus,
+	notify.EventBackInStock,
+	notify.EventCartReminder,
+}
+
+// IsOptional reports whether the user can switch the notification off
+func IsOptional(event string) bool {
+	return slices.Contains(Optional, event)
+}
+
+// Preference tells whether the user gets emails of the notification type
+type Preference struct {
+	Event string `json:"event"`
+	Email bool   `json:"email"`
+}
+
+// PreferenceUpdate switches the emails of one notification type on or off
+type PreferenceUpdate struct {
+	Event string `json:"event" validate:"required"`
+	Email *bool  `json:"email" validate:"required"`
+}
+
+// Delivery is an entry of the delivery log, one per event and recipient
+type Delivery struct {
+	ID        string    `json:"id"`
+	EventID   string    `json:"event_id"`
+	Event     string    `json:"event"`
+	Channel   string    `json:"channel"`
+	Recipient string    `json:"recipient"`
+	Subject   string    `json:"subject"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// mergePreferences fills in the optional notifications the user never changed, they are enabled
+func mergePreferences(stored map[string]bool) []Preference {
+	prefs := make([]Preference, 0, len(Optional))
+	for _, event := range Optional {
+		email, ok := stored[event]
+		if !ok {
+			email = true
+		}
+		prefs = append(prefs, Preference{Event: event, Email: email})
+	}
+	return prefs
+}
+
+// Retry is a failed or stale delivery claimed to be sent again
+type Retry struct {
+	ID      string
+	Attempt int
+	job     job
+}
+
+// pending is the notification stored with its delivery, it is all a retry needs to send it again
+type pending struct {
+	Event     string         `json:"event"`
+	UserID    string         `json:"user_id,omitempty"`
+	ToAdmins  bool           `json:"to_admins,omitempty"`
+	Data      map[string]any `json:"data"`
+	InvoiceOf string         `json:"invoice_of,omitempty"`
+}
+
+func pendingOf(j job) pending {
+	return pending{Event: j.nt.Event, UserID: j.nt.UserID, ToAdmins: j.nt.ToAdmins, Data: j.nt.Data, InvoiceOf: j.invoiceOf}
+}
+
+// decodePending keeps the numbers of the data as they were written, a count is not rendered as a float
+func decodePending(payload []byte) (pending, error) {
+	var p pending
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&p); err != nil {
+		return pending{}, fmt.Errorf("failed to decode delivery payload: %w", err)
+	}
+	if p.Data == nil {
+		p.Data = map[string]any{}
+	}
+	return p, nil
+}
+
+func (p pending) notification() notify.Notification {
+	return notify.Notification{Event: p.Event, UserID: p.UserID, ToAdmins: p.ToAdmins, Data: p.Data}
+}
@@ -0,0 +1,225 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"product-service/internal/notify"
+	"product-service/internal/stores/kafka"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestJobsFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   *kgo.Record
+		eventIDs []string
+		event    string
+		userIDs  []string
+		invoice  string
+		wantErr  error
+	}{
+		{
+			name:     "account created",
+			record:   &kgo.Record{Topic: kafka.TopicAccountCreated, Value: []byte(`{"id":"u1","name":"Asha","email":"asha@example.com"}`)},
+			eventIDs: []string{"account-created:u1"},
+			event:    notify.EventAccountCreated,
+			userIDs:  []string{"u1"},
+		},
+		{
+			name:     "every verification request is sent",
+			record:   &kgo.Record{Topic: kafka.TopicEmailVerificationRequested, Offset: 4, Value: []byte(`{"user_id":"u1","verify_url":"http://localhost/users/verify?token=abc"}`)},
+			eventIDs: []string{kafka.TopicEmailVerificationRequested + "/0/4"},
+			event:    notify.EventVerifyEmail,
+			userIDs:  []string{"u1"},
+		},
+		{
+			name:     "every password reset is sent",
+			record:   &kgo.Record{Topic: kafka.TopicPasswordResetRequested, Offset: 2, Value: []byte(`{"user_id":"u1","reset_url":"http://localhost/reset-password?token=abc"}`)},
+			eventIDs: []string{kafka.TopicPasswordResetRequested + "/0/2"},
+			event:    notify.EventPasswordReset,
+			userIDs:  []string{"u1"},
+		},
+		{
+			name:     "a lock is keyed by user and lock end",
+			record:   &kgo.Record{Topic: kafka.TopicUserLocked, Value: []byte(`{"user_id":"u1","ip":"10.0.0.1","locked_until":"2025-01-02T03:04:05Z"}`)},
+			eventIDs: []string{"user-locked:u1:1735787045"},
+			event:    notify.EventAccountLocked,
+			userIDs:  []string{"u1"},
+		},
+		{
+			name:     "order paid is keyed by the order",
+			record:   &kgo.Record{Topic: kafka.TopicOrderPaid, Offset: 7, Value: []byte(`{"order_id":"o1","user_id":"u1","product_id":"p1","quantity":2}`)},
+			eventIDs: []string{"order-paid:o1"},
+			event:    notify.EventOrderConfirmation,
+			userIDs:  []string{"u1"},
+			invoice:  "o1",
+		},
+		{
+			name:    "order paid without a user",
+			record:  &kgo.Record{Topic: kafka.TopicOrderPaid, Value: []byte(`{"order_id":"o1","product_id":"p1","quantity":2}`)},
+			wantErr: errNoUser,
+		},
+		{
+			name:     "order status is keyed by order and status",
+			record:   &kgo.Record{Topic: kafka.TopicOrderStatusChanged, Value: []byte(`{"order_id":"o1","user_id":"u1","status":"shipped"}`)},
+			eventIDs: []string{"order-status-changed:o1:shipped"},
+			event:    notify.EventOrderStatus,
+			userIDs:  []string{"u1"},
+		},
+		{
+			name:     "return status is keyed by return and status",
+			record:   &kgo.Record{Topic: kafka.TopicReturnStatusChanged, Value: []byte(`{"return_id":"r1","order_id":"o1","user_id":"u1","status":"approved"}`)},
+			eventIDs: []string{"return-status-changed:r1:approved"},
+			event:    notify.EventReturnStatus,
+			userIDs:  []string{"u1"},
+		},
+		{
+			name:     "back in stock fans out to the subscribers",
+			record:   &kgo.Record{Topic: kafka.TopicBackInStock, Partition: 1, Offset: 3, Value: []byte(`{"product_id":"p1","name":"Mug","user_ids":["u1","u2"]}`)},
+			eventIDs: []string{kafka.TopicBackInStock + "/1/3:u1", kafka.TopicBackInStock + "/1/3:u2"},
+			event:    notify.EventBackInStock,
+			userIDs:  []string{"u1", "u2"},
+		},
+		{
+			name:     "cart abandoned is keyed by cart and idle time",
+			record:   &kgo.Record{Topic: kafka.TopicCartAbandoned, Value: []byte(`{"order_id":"c1","user_id":"u1","idle_since":"2025-01-02T03:04:05Z"}`)},
+			eventIDs: []string{"cart-abandoned:c1:1735787045"},
+			event:    notify.EventCartReminder,
+			userIDs:  []string{"u1"},
+		},
+		{
+			name:     "low stock goes to the admins",
+			record:   &kgo.Record{Topic: kafka.TopicLowStock, Offset: 9, Value: []byte(`{"product_id":"p1","name":"Mug","stock":2,"reorder_threshold":5}`)},
+			eventIDs: []string{kafka.TopicLowStock + "/0/9"},
+			event:    notify.EventLowStock,
+			userIDs:  []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := jobsFor(tt.record)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("jobsFor() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("jobsFor() error = %v", err)
+			}
+			if len(jobs) != len(tt.eventIDs) {
+				t.Fatalf("jobsFor() returned %d jobs, want %d", len(jobs), len(tt.eventIDs))
+			}
+			for i, j := range jobs {
+				if j.eventID != tt.eventIDs[i] {
+					t.Errorf("job %d event id = %q, want %q", i, j.eventID, tt.eventIDs[i])
+				}
+				if j.nt.Event != tt.event {
+					t.Errorf("job %d event = %q, want %q", i, j.nt.Event, tt.event)
+				}
+				if j.nt.UserID != tt.userIDs[i] {
+					t.Errorf("job %d user = %q, want %q", i, j.nt.UserID, tt.userIDs[i])
+				}
+				if j.invoiceOf != tt.invoice {
+					t.Errorf("job %d invoice = %q, want %q", i, j.invoiceOf, tt.invoice)
+				}
+			}
+		})
+	}
+}
+
+func TestJobsForRendersEveryTemplate(t *testing.T) {
+	tmpl, err := notify.LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []*kgo.Record{
+		{Topic: kafka.TopicAccountCreated, Value: []byte(`{"id":"u1"}`)},
+		{Topic: kafka.TopicEmailVerificationRequested, Value: []byte(`{"user_id":"u1","verify_url":"http://localhost/users/verify?token=abc","expires_at":"2025-01-02T03:04:05Z"}`)},
+		{Topic: kafka.TopicPasswordResetRequested, Value: []byte(`{"user_id":"u1","reset_url":"http://localhost/reset-password?token=abc","expires_at":"2025-01-02T03:04:05Z"}`)},
+		{Topic: kafka.TopicUserLocked, Value: []byte(`{"user_id":"u1","ip":"10.0.0.1","locked_until":"2025-01-02T03:04:05Z"}`)},
+		{Topic: kafka.TopicOrderPaid, Value: []byte(`{"order_id":"o1","user_id":"u1"}`)},
+		{Topic: kafka.TopicOrderStatusChanged, Value: []byte(`{"order_id":"o1","user_id":"u1","status":"shipped"}`)},
+		{Topic: kafka.TopicReturnStatusChanged, Value: []byte(`{"return_id":"r1","user_id":"u1","status":"refunded","refund_amount":1999}`)},
+		{Topic: kafka.TopicBackInStock, Value: []byte(`{"name":"Mug","user_ids":["u1"]}`)},
+		{Topic: kafka.TopicCartAbandoned, Value: []byte(`{"order_id":"c1","user_id":"u1","line_items":[{"quantity":2}]}`)},
+		{Topic: kafka.TopicLowStock, Value: []byte(`{"product_id":"p1","name":"Mug","stock":2,"reorder_threshold":5}`)},
+	}
+	for _, record := range records {
+		jobs, err := jobsFor(record)
+		if err != nil {
+			t.Fatalf("%s: %v", record.Topic, err)
+		}
+		for _, j := range jobs {
+			_, _, _, err := tmpl.Render(j.nt.Event, notify.Recipient{Name: "Asha"}, j.nt.Data)
+			if err != nil {
+				t.Errorf("%s: rendering %s: %v", record.Topic, j.nt.Event, err)
+			}
+		}
+	}
+}
+
+func TestMergePreferences(t *testing.T) {
+	prefs := mergePreferences(map[string]bool{notify.EventCartReminder: false, "unknown": false})
+	if len(prefs) != len(Optional) {
+		t.Fatalf("got %d preferences, want %d", len(prefs), len(Optional))
+	}
+	for _, p := range prefs {
+		want := p.Event != notify.EventCartReminder
+		if p.Email != want {
+			t.Errorf("%s email = %v, want %v", p.Event, p.Email, want)
+		}
+	}
+	if IsOptional(notify.EventOrderConfirmation) || IsOptional(notify.EventAccountCreated) {
+		t.Error("confirmations and the welcome email must not be optional")
+	}
+}
+
+func TestPendingRendersLikeTheJob(t *testing.T) {
+	tmpl, err := notify.LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []*kgo.Record{
+		{Topic: kafka.TopicOrderPaid, Value: []byte(`{"order_id":"o1","user_id":"u1"}`)},
+		{Topic: kafka.TopicCartAbandoned, Value: []byte(`{"order_id":"c1","user_id":"u1","line_items":[{"quantity":2},{"quantity":1}]}`)},
+		{Topic: kafka.TopicLowStock, Value: []byte(`{"product_id":"p1","name":"Mug","stock":2,"reorder_threshold":5}`)},
+	}
+	for _, record := range records {
+		jobs, err := jobsFor(record)
+		if err != nil {
+			t.Fatalf("%s: %v", record.Topic, err)
+		}
+		for _, j := range jobs {
+			payload, err := json.Marshal(pendingOf(j))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := decodePending(payload)
+			if err != nil {
+				t.Fatalf("%s: %v", record.Topic, err)
+			}
+			nt := p.notification()
+			if nt.Event != j.nt.Event || nt.UserID != j.nt.UserID || nt.ToAdmins != j.nt.ToAdmins || p.InvoiceOf != j.invoiceOf {
+				t.Errorf("%s: got %+v, want %+v", record.Topic, p, pendingOf(j))
+			}
+
+			subject, text, html, err := tmpl.Render(j.nt.Event, notify.Recipient{Name: "Asha"}, j.nt.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotSubject, gotText, gotHTML, err := tmpl.Render(nt.Event, notify.Recipient{Name: "Asha"}, nt.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotSubject != subject || gotText != text || gotHTML != html {
+				t.Errorf("%s: the retry renders\n%s\nwant\n%s", record.Topic, gotText, text)
+			}
+		}
+	}
+}
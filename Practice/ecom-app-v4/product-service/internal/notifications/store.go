@@ -0,0 +1,248 @@
+// Package notifications turns the domain events of the other services into emails.
+// It keeps the notification preferences of the users, makes sure an event is only
+// sent once and records every delivery so users can see what was sent to them.
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnknownPreference = errors.New("unknown notification type")
+
+type Conf struct {
+	db *sql.DB
+}
+
+func NewConf(db *sql.DB) (*Conf, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+	return &Conf{db: db}, nil
+}
+
+// Claim records a queued delivery for the job, with what it takes to send it again. claimed is false
+// when the event was already handled, e.g. because kafka delivered it again after a restart.
+func (c *Conf) Claim(ctx context.Context, j job) (id string, claimed bool, err error) {
+	payload, err := json.Marshal(pendingOf(j))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to record delivery: %w", err)
+	}
+	now := time.Now().UTC()
+
+	query := `
+	INSERT INTO notification_deliveries (id, event_id, user_id, event_type, channel, status, payload, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+	ON CONFLICT (event_id) DO NOTHING
+	`
+	id = uuid.NewString()
+	res, err := c.db.ExecContext(ctx, query, id, j.eventID, sql.NullString{String: j.nt.UserID, Valid: j.nt.UserID != ""},
+		j.nt.Event, ChannelEmail, StatusQueued, payload, now)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to record delivery: %w", err)
+	}
+
+	num, err := res.RowsAffected()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to record delivery: %w", err)
+	}
+	return id, num > 0, nil
+}
+
+// ClaimRetries queues again the deliveries that failed, or stayed queued because the service stopped
+// before they were sent, once they are older than after. A delivery is given up on after maxAttempts.
+// The rows are claimed with SKIP LOCKED so two instances never send the same delivery.
+func (c *Conf) ClaimRetries(ctx context.Context, after time.Duration, maxAttempts, limit int) ([]Retry, error) {
+	now := time.Now().UTC()
+
+	query := `
+	UPDATE notification_deliveries d
+	SET status = $1, attempts = d.attempts + 1, error = '', updated_at = $2
+	FROM (
+		SELECT id FROM notification_deliveries
+		WHERE status IN ($1, $3) AND payload IS NOT NULL AND attempts < $4 AND updated_at < $5
+		ORDER BY updated_at
+		LIMIT $6
+		FOR UPDATE SKIP LOCKED
+	) due
+	WHERE d.id = due.id
+	RETURNING d.id, d.event_id, d.attempts, d.payload
+	`
+	rows, err := c.db.QueryContext(ctx, query, StatusQueued, now, StatusFailed, maxAttempts, now.Add(-after), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim delivery retries: %w", err)
+	}
+	defer rows.Close()
+
+	retries := []Retry{}
+	for rows.Next() {
+		var r Retry
+		var payload []byte
+		err := rows.Scan(&r.ID, &r.job.eventID, &r.Attempt, &payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan delivery retry: %w", err)
+		}
+		p, err := decodePending(payload)
+		if err != nil {
+			return nil, fmt.Errorf("delivery %s: %w", r.ID, err)
+		}
+		r.job.nt, r.job.invoiceOf = p.notification(), p.InvoiceOf
+		retries = append(retries, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim delivery retries: %w", err)
+	}
+	return retries, nil
+}
+
+// Finish records the outcome of the delivery. The payload is only kept while a failed delivery is to be sent
+// again, it holds the links of the email, e.g. password reset links, that must not stay in the log once it is sent.
+func (c *Conf) Finish(ctx context.Context, id, status, recipient, subject, errMsg string, maxAttempts int) error {
+	query := `
+	UPDATE notification_deliveries
+	SET status = $2, recipient = $3, subject = $4, error = $5, updated_at = $6,
+		payload = CASE WHEN $2 = $7 AND attempts < $8 THEN payload END
+	WHERE id = $1
+	`
+	_, err := c.db.ExecContext(ctx, query, id, status, recipient, subject, errMsg, time.Now().UTC(), StatusFailed, maxAttempts)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery %s: %w", id, err)
+	}
+	return nil
+}
+
+// EmailEnabled reports whether the user wants emails of the notification type
+func (c *Conf) EmailEnabled(ctx context.Context, userId, event string) (bool, error) {
+	if !IsOptional(event) {
+		return true, nil
+	}
+
+	query := `SELECT email_enabled FROM notification_preferences WHERE user_id = $1 AND event_type = $2`
+
+	var enabled bool
+	err := c.db.QueryRowContext(ctx, query, userId, event).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch notification preference: %w", err)
+	}
+	return enabled, nil
+}
+
+// Preferences returns the preference of the user for every notification type that can be switched off
+func (c *Conf) Preferences(ctx context.Context, userId string) ([]Preference, error) {
+	query := `SELECT event_type, email_enabled FROM notification_preferences WHERE user_id = $1`
+
+	rows, err := c.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notification preferences: %w", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string]bool)
+	for rows.Next() {
+		var event string
+		var enabled bool
+		err := rows.Scan(&event, &enabled)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification preference: %w", err)
+		}
+		stored[event] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch notification preferences: %w", err)
+	}
+
+	return mergePreferences(stored), nil
+}
+
+// SetPreferences applies the updates and returns all the preferences of the user
+func (c *Conf) SetPreferences(ctx context.Context, userId string, updates []PreferenceUpdate) ([]Preference, error) {
+	for _, u := range updates {
+		if !IsOptional(u.Event) {
+			return nil, fmt.Errorf("%s: %w", u.Event, ErrUnknownPreference)
+		}
+	}
+
+	now := time.Now().UTC()
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+		INSERT INTO notification_preferences (user_id, event_type, email_enabled, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, event_type) DO UPDATE
+		SET email_enabled = EXCLUDED.email_enabled, updated_at = EXCLUDED.updated_at
+		`
+		for _, u := range updates {
+			_, err := tx.ExecContext(ctx, query, userId, u.Event, *u.Email, now)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update notification preferences: %w", err)
+	}
+
+	return c.Preferences(ctx, userId)
+}
+
+// Deliveries returns the delivery log of the user, newest first
+func (c *Conf) Deliveries(ctx context.Context, userId string, limit, offset int) ([]Delivery, error) {
+	query := `
+	SELECT id, event_id, event_type, channel, recipient, subject, status, error, created_at, updated_at
+	FROM notification_deliveries
+	WHERE user_id = $1
+	ORDER BY created_at DESC
+	LIMIT $2 OFFSET $3
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, userId, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		err := rows.Scan(&d.ID, &d.EventID, &d.Event, &d.Channel, &d.Recipient, &d.Subject, &d.Status, &d.Error,
+			&d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (c *Conf) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		er := tx.Rollback()
+		if er != nil && !errors.Is(er, sql.ErrTxDone) {
+			return fmt.Errorf("failed to rollback withTx: %w", err)
+		}
+		return fmt.Errorf("failed to execute withTx: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit withTx: %w", err)
+	}
+	return nil
+}
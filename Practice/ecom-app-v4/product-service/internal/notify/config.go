@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BackendSMTP    = "smtp"
+	BackendCapture = "capture"
+
+	defaultPort        = 587
+	defaultMaxAttempts = 5
+	defaultBackoff     = 2 * time.Second
+	defaultWorkers     = 2
+	defaultQueueSize   = 100
+)
+
+// Config controls where the notifications are sent from and how hard the notifier tries
+type Config struct {
+	Backend     string // smtp, or capture to keep the messages in memory
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	From        string
+	AdminEmails []string      // recipients of the notifications meant for the admins
+	MaxAttempts int           // sends of a message before it is given up on
+	Backoff     time.Duration // wait before the first retry, doubled for every retry after it
+	Workers     int
+	QueueSize   int
+}
+
+// LoadConfig reads MAIL_BACKEND, MAIL_HOST, MAIL_PORT, MAIL_USERNAME, MAIL_PASSWORD, MAIL_FROM,
+// ADMIN_EMAILS as a comma separated list, MAIL_MAX_ATTEMPTS, MAIL_RETRY_BACKOFF e.g. "2s",
+// MAIL_WORKERS and MAIL_QUEUE_SIZE
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		Backend:     os.Getenv("MAIL_BACKEND"),
+		Host:        os.Getenv("MAIL_HOST"),
+		Port:        defaultPort,
+		Username:    os.Getenv("MAIL_USERNAME"),
+		Password:    os.Getenv("MAIL_PASSWORD"),
+		From:        os.Getenv("MAIL_FROM"),
+		MaxAttempts: defaultMaxAttempts,
+		Backoff:     defaultBackoff,
+		Workers:     defaultWorkers,
+		QueueSize:   defaultQueueSize,
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = BackendSMTP
+	}
+
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		email = strings.TrimSpace(email)
+		if email != "" {
+			cfg.AdminEmails = append(cfg.AdminEmails, email)
+		}
+	}
+
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"MAIL_PORT", &cfg.Port},
+		{"MAIL_MAX_ATTEMPTS", &cfg.MaxAttempts},
+		{"MAIL_WORKERS", &cfg.Workers},
+		{"MAIL_QUEUE_SIZE", &cfg.QueueSize},
+	}
+	for _, i := range ints {
+		if v := os.Getenv(i.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return Config{}, fmt.Errorf("invalid %s %q", i.name, v)
+			}
+			*i.dst = n
+		}
+	}
+
+	if v := os.Getenv("MAIL_RETRY_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid MAIL_RETRY_BACKOFF %q", v)
+		}
+		cfg.Backoff = d
+	}
+
+	switch cfg.Backend {
+	case BackendSMTP:
+		if cfg.Host == "" || cfg.From == "" {
+			return Config{}, fmt.Errorf("MAIL_HOST and MAIL_FROM are required for the smtp backend")
+		}
+	case BackendCapture:
+		if cfg.From == "" {
+			cfg.From = "noreply@localhost"
+		}
+	default:
+		return Config{}, fmt.Errorf("invalid MAIL_BACKEND %q", cfg.Backend)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,82 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"product-service/internal/auth"
+	"product-service/internal/consul"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+var ErrUnknownRecipient = errors.New("recipient not found")
+
+// Recipient is the person a notification is addressed to
+type Recipient struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Directory finds the recipient of a notification from the user id
+type Directory interface {
+	Lookup(ctx context.Context, userId string) (Recipient, error)
+}
+
+// UserDirectory looks the users up in the user service
+type UserDirectory struct {
+	client *consulapi.Client
+	tokens *auth.ServiceTokens
+}
+
+func NewUserDirectory(client *consulapi.Client, tokens *auth.ServiceTokens) (*UserDirectory, error) {
+	if client == nil {
+		return nil, errors.New("consul client is nil")
+	}
+	if tokens == nil {
+		return nil, errors.New("service tokens are nil")
+	}
+	return &UserDirectory{client: client, tokens: tokens}, nil
+}
+
+func (d *UserDirectory) Lookup(ctx context.Context, userId string) (Recipient, error) {
+	address, port, err := consul.GetServiceAddress(d.client, "users")
+	if err != nil {
+		return Recipient{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	httpQuery := fmt.Sprintf("http://%s:%d/users/internal/%s/contact", address, port, userId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpQuery, nil)
+	if err != nil {
+		return Recipient{}, err
+	}
+
+	resp, err := d.tokens.Do(req)
+	if err != nil {
+		return Recipient{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Recipient{}, fmt.Errorf("%w: user %s", ErrUnknownRecipient, userId)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Recipient{}, fmt.Errorf("user service responded with status %d", resp.StatusCode)
+	}
+
+	var r Recipient
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return Recipient{}, err
+	}
+	if r.Email == "" {
+		return Recipient{}, fmt.Errorf("%w: user %s has no email", ErrUnknownRecipient, userId)
+	}
+	return r, nil
+}
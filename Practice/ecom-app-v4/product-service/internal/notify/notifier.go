@@ -0,0 +1,204 @@
+// Package notify sends the emails of the store. Notifications are queued and sent by background
+// workers, the recipient is looked up in the user service and the message is rendered from the
+// templates of its event type. Failed sends are retried with a growing backoff.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var ErrNotifierClosed = errors.New("notifier is closed")
+
+// Notification is an email to send, addressed to a user, to explicit addresses or to the admins
+type Notification struct {
+	Event       string
+	UserID      string         // the recipient is looked up in the user service
+	To          []string       // sent to these addresses when there is no user
+	ToAdmins    bool           // sent to the configured admin addresses
+	Data        map[string]any // what the templates of the event need
+	Attachments []Attachment
+
+	// OnDone is called by the worker once the notification is sent or given up on,
+	// msg carries the recipients and subject when the message could be rendered
+	OnDone func(msg Message, err error)
+}
+
+type Notifier struct {
+	cfg       Config
+	sender    Sender
+	directory Directory
+	templates *Templates
+
+	queue  chan Notification
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewNotifier(cfg Config, sender Sender, directory Directory) (*Notifier, error) {
+	if sender == nil || directory == nil {
+		return nil, errors.New("notifier dependencies are not initialized")
+	}
+	templates, err := LoadTemplates()
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxAttempts = max(cfg.MaxAttempts, 1)
+	cfg.Workers = max(cfg.Workers, 1)
+
+	return &Notifier{
+		cfg:       cfg,
+		sender:    sender,
+		directory: directory,
+		templates: templates,
+		queue:     make(chan Notification, max(cfg.QueueSize, 0)),
+	}, nil
+}
+
+// Start runs the workers until Close is called, a canceled context stops the retries in flight
+func (n *Notifier) Start(ctx context.Context) {
+	for i := 0; i < n.cfg.Workers; i++ {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			for nt := range n.queue {
+				msg, err := n.deliver(ctx, nt)
+				if err != nil {
+					slog.Error("error sending notification", slog.String("Event", nt.Event), slog.String("UserID", nt.UserID),
+						slog.Any("error", err))
+				}
+				if nt.OnDone != nil {
+					nt.OnDone(msg, err)
+				}
+			}
+		}()
+	}
+}
+
+// Notify queues the notification, it waits while the queue is full
+func (n *Notifier) Notify(ctx context.Context, nt Notification) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return ErrNotifierClosed
+	}
+
+	select {
+	case n.queue <- nt:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops taking notifications and waits for the queued ones to be sent
+func (n *Notifier) Close() {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.queue)
+	n.mu.Unlock()
+
+	n.wg.Wait()
+}
+
+// Deliver renders and sends the notification right away, retrying failed sends
+func (n *Notifier) Deliver(ctx context.Context, nt Notification) error {
+	_, err := n.deliver(ctx, nt)
+	return err
+}
+
+func (n *Notifier) deliver(ctx context.Context, nt Notification) (Message, error) {
+	msg, err := n.message(ctx, nt)
+	if err != nil {
+		return Message{}, err
+	}
+
+	backoff := n.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		err = n.sender.Send(ctx, msg)
+		if err == nil {
+			slog.Info("notification sent", slog.String("Event", nt.Event), slog.Any("To", msg.To), slog.Int("Attempt", attempt))
+			return msg, nil
+		}
+		if attempt >= n.cfg.MaxAttempts {
+			return msg, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		slog.Warn("error sending notification, retrying", slog.String("Event", nt.Event), slog.Int("Attempt", attempt),
+			slog.Duration("Backoff", backoff), slog.Any("error", err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return msg, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// message addresses and renders the notification, the user lookup is retried like a send
+func (n *Notifier) message(ctx context.Context, nt Notification) (Message, error) {
+	var recipient Recipient
+	to := nt.To
+
+	switch {
+	case nt.UserID != "":
+		var err error
+		recipient, err = n.lookup(ctx, nt.UserID)
+		if err != nil {
+			return Message{}, err
+		}
+		to = []string{recipient.Email}
+	case nt.ToAdmins:
+		to = n.cfg.AdminEmails
+	}
+	if len(to) == 0 {
+		return Message{}, fmt.Errorf("no recipients for %s notification", nt.Event)
+	}
+
+	subject, text, html, err := n.templates.Render(nt.Event, recipient, nt.Data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		From:        n.cfg.From,
+		To:          to,
+		Subject:     subject,
+		Text:        text,
+		HTML:        html,
+		Attachments: nt.Attachments,
+	}, nil
+}
+
+func (n *Notifier) lookup(ctx context.Context, userId string) (Recipient, error) {
+	backoff := n.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		recipient, err := n.directory.Lookup(ctx, userId)
+		if err == nil {
+			return recipient, nil
+		}
+		// retrying does not give a user an email address
+		if errors.Is(err, ErrUnknownRecipient) {
+			return Recipient{}, err
+		}
+		if attempt >= n.cfg.MaxAttempts {
+			return Recipient{}, fmt.Errorf("looking up recipient after %d attempts: %w", attempt, err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return Recipient{}, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
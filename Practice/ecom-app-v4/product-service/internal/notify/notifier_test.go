@@ -0,0 +1,226 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeDirectory map[string]Recipient
+
+func (d fakeDirectory) Lookup(ctx context.Context, userId string) (Recipient, error) {
+	r, ok := d[userId]
+	if !ok {
+		return Recipient{}, ErrUnknownRecipient
+	}
+	return r, nil
+}
+
+// flakySender fails the first failures sends and captures the rest
+type flakySender struct {
+	*CaptureSender
+	mu       sync.Mutex
+	failures int
+	attempts int
+}
+
+func (s *flakySender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	s.attempts++
+	fail := s.attempts <= s.failures
+	s.mu.Unlock()
+	if fail {
+		return errors.New("smtp unavailable")
+	}
+	return s.CaptureSender.Send(ctx, msg)
+}
+
+func newTestNotifier(t *testing.T, sender Sender) *Notifier {
+	t.Helper()
+	cfg := Config{
+		From:        "shop@example.com",
+		AdminEmails: []string{"admin@example.com"},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		Workers:     2,
+		QueueSize:   10,
+	}
+	n, err := NewNotifier(cfg, sender, fakeDirectory{"u1": {Name: "Asha", Email: "asha@example.com"}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return n
+}
+
+func TestTemplatesRenderEveryEvent(t *testing.T) {
+	data := map[string]map[string]any{
+		EventAccountCreated:    {},
+		EventVerifyEmail:       {"VerifyURL": "http://localhost/users/verify?token=abc", "ExpiresAt": "02 Jan 2025 15:04 UTC"},
+		EventPasswordReset:     {"ResetURL": "http://localhost/reset-password?token=abc", "ExpiresAt": "02 Jan 2025 15:04 UTC"},
+		EventAccountLocked:     {"IP": "10.0.0.1", "LockedUntil": "02 Jan 2025 15:04 UTC"},
+		EventOrderConfirmation: {"OrderID": "o1", "InvoiceNumber": "INV-000001", "Total": "1180.00"},
+		EventOrderStatus:       {"OrderID": "o1", "Status": "shipped", "Carrier": "DHL", "TrackingNumber": "T1", "Note": ""},
+		EventReturnStatus:      {"ReturnID": "r1", "OrderID": "o1", "Status": "refunded", "Note": "", "RefundAmount": "500.00"},
+		EventBackInStock:       {"ProductName": "Lamp"},
+		EventCartReminder:      {"ItemCount": 3},
+		EventLowStock:          {"ProductID": "p1", "ProductName": "Lamp", "Stock": 2, "ReorderThreshold": 5},
+	}
+
+	templates, err := LoadTemplates()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, event := range events {
+		t.Run(event, func(t *testing.T) {
+			subject, text, html, err := templates.Render(event, Recipient{Name: "Asha"}, data[event])
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if subject == "" || strings.Contains(subject, "\n") {
+				t.Errorf("expected a single line subject, got %q", subject)
+			}
+			if !strings.HasPrefix(text, "Hi Asha,") {
+				t.Errorf("expected the text to greet the recipient, got %q", text)
+			}
+			if !strings.Contains(html, "<html>") || !strings.Contains(html, "Hi Asha,") {
+				t.Errorf("expected the html layout, got %q", html)
+			}
+		})
+	}
+}
+
+func TestTemplatesMissingKey(t *testing.T) {
+	templates, err := LoadTemplates()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, _, _, err = templates.Render(EventBackInStock, Recipient{}, map[string]any{})
+	if err == nil {
+		t.Error("expected an error for the missing product name")
+	}
+}
+
+func TestTemplatesEscapeHTML(t *testing.T) {
+	templates, err := LoadTemplates()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, _, html, err := templates.Render(EventBackInStock, Recipient{}, map[string]any{"ProductName": "<script>x</script>"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strings.Contains(html, "<script>") {
+		t.Errorf("expected the product name to be escaped, got %q", html)
+	}
+}
+
+func TestNotifyLooksUpTheUser(t *testing.T) {
+	capture := NewCaptureSender()
+	n := newTestNotifier(t, capture)
+	n.Start(context.Background())
+
+	err := n.Notify(context.Background(), Notification{
+		Event:       EventOrderStatus,
+		UserID:      "u1",
+		Data:        map[string]any{"OrderID": "o1", "Status": "shipped", "Carrier": "DHL", "TrackingNumber": "T1", "Note": ""},
+		Attachments: []Attachment{{Filename: "a.txt", ContentType: "text/plain", Data: []byte("a")}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n.Close()
+
+	messages := capture.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	msg := messages[0]
+	if len(msg.To) != 1 || msg.To[0] != "asha@example.com" {
+		t.Errorf("expected the email of the user, got %v", msg.To)
+	}
+	if msg.From != "shop@example.com" {
+		t.Errorf("expected the configured sender, got %s", msg.From)
+	}
+	if msg.Subject != "Your order is shipped" {
+		t.Errorf("unexpected subject %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "tracking number: T1") {
+		t.Errorf("expected the tracking number in the text, got %q", msg.Text)
+	}
+	if len(msg.Attachments) != 1 {
+		t.Errorf("expected the attachment, got %d", len(msg.Attachments))
+	}
+}
+
+func TestNotifyAdmins(t *testing.T) {
+	capture := NewCaptureSender()
+	n := newTestNotifier(t, capture)
+
+	err := n.Deliver(context.Background(), Notification{
+		Event:    EventLowStock,
+		ToAdmins: true,
+		Data:     map[string]any{"ProductID": "p1", "ProductName": "Lamp", "Stock": 2, "ReorderThreshold": 5},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if messages := capture.Messages(); len(messages) != 1 || messages[0].To[0] != "admin@example.com" {
+		t.Errorf("expected one message to the admins, got %v", messages)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tt := [...]struct {
+		name        string
+		failures    int
+		expectedErr bool
+		expectedN   int
+	}{
+		{name: "Sent First Time", failures: 0, expectedN: 1},
+		{name: "Sent On Retry", failures: 2, expectedN: 1},
+		{name: "Gives Up", failures: 3, expectedErr: true, expectedN: 0},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sender := &flakySender{CaptureSender: NewCaptureSender(), failures: tc.failures}
+			n := newTestNotifier(t, sender)
+
+			err := n.Deliver(context.Background(), Notification{Event: EventBackInStock, UserID: "u1", Data: map[string]any{"ProductName": "Lamp"}})
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if got := len(sender.Messages()); got != tc.expectedN {
+				t.Errorf("expected %d messages, got %d", tc.expectedN, got)
+			}
+		})
+	}
+}
+
+func TestDeliverUnknownUser(t *testing.T) {
+	capture := NewCaptureSender()
+	n := newTestNotifier(t, capture)
+
+	err := n.Deliver(context.Background(), Notification{Event: EventBackInStock, UserID: "missing", Data: map[string]any{"ProductName": "Lamp"}})
+	if !errors.Is(err, ErrUnknownRecipient) {
+		t.Errorf("expected ErrUnknownRecipient, got %v", err)
+	}
+	if len(capture.Messages()) != 0 {
+		t.Error("expected nothing to be sent")
+	}
+}
+
+func TestNotifyAfterClose(t *testing.T) {
+	n := newTestNotifier(t, NewCaptureSender())
+	n.Start(context.Background())
+	n.Close()
+
+	err := n.Notify(context.Background(), Notification{Event: EventBackInStock, UserID: "u1"})
+	if !errors.Is(err, ErrNotifierClosed) {
+		t.Errorf("expected ErrNotifierClosed, got %v", err)
+	}
+}
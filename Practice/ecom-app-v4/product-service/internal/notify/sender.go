@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Attachment is a file sent along with a message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Message is a rendered email ready to be sent
+type Message struct {
+	From        string
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// Sender delivers rendered messages
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSender returns the sender of the configured backend
+func NewSender(cfg Config) Sender {
+	if cfg.Backend == BackendCapture {
+		return NewCaptureSender()
+	}
+	return &SMTPSender{dialer: gomail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password)}
+}
+
+// SMTPSender sends messages through an SMTP server
+type SMTPSender struct {
+	dialer *gomail.Dialer
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", msg.From)
+	m.SetHeader("To", msg.To...)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	if msg.HTML != "" {
+		m.AddAlternative("text/html", msg.HTML)
+	}
+	for _, a := range msg.Attachments {
+		data := a.Data
+		m.Attach(a.Filename,
+			gomail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			}))
+	}
+
+	// gomail does not take a context, a canceled send is only noticed before dialing
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.dialer.DialAndSend(m)
+}
+
+// CaptureSender keeps the messages in memory instead of sending them,
+// for local development and for tests that assert on what was sent
+type CaptureSender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewCaptureSender() *CaptureSender {
+	return &CaptureSender{}
+}
+
+func (s *CaptureSender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	slog.Info("captured email", slog.Any("To", msg.To), slog.String("Subject", msg.Subject))
+	return nil
+}
+
+// Messages returns a copy of the messages captured so far
+func (s *CaptureSender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Reset forgets the captured messages
+func (s *CaptureSender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
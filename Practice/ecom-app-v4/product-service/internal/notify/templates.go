@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Event types, each has a <event>.txt template defining the subject and a <event>.html template for the layout
+const (
+	EventAccountCreated    = "account_created"
+	EventVerifyEmail       = "verify_email"
+	EventPasswordReset     = "password_reset"
+	EventAccountLocked     = "account_locked"
+	EventOrderConfirmation = "order_confirmation"
+	EventOrderStatus       = "order_status"
+	EventReturnStatus      = "return_status"
+	EventBackInStock       = "back_in_stock"
+	EventCartReminder      = "cart_reminder"
+	EventLowStock          = "low_stock"
+)
+
+var events = []string{
+	EventAccountCreated,
+	EventVerifyEmail,
+	EventPasswordReset,
+	EventAccountLocked,
+	EventOrderConfirmation,
+	EventOrderStatus,
+	EventReturnStatus,
+	EventBackInStock,
+	EventCartReminder,
+	EventLowStock,
+}
+
+//go:embed templates/*
+var templateFS embed.FS
+
+type eventTemplates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Templates renders the subject, text and html body of every event type
+type Templates struct {
+	byEvent map[string]eventTemplates
+}
+
+// templateData is what the templates see, the keys of Data depend on the event type
+type templateData struct {
+	Recipient Recipient
+	Data      map[string]any
+}
+
+// LoadTemplates parses the embedded templates, a missing key in the data fails the render
+func LoadTemplates() (*Templates, error) {
+	t := &Templates{byEvent: make(map[string]eventTemplates, len(events))}
+	for _, event := range events {
+		text, err := texttemplate.New(event+".txt").Option("missingkey=error").ParseFS(templateFS, "templates/"+event+".txt")
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s text template: %w", event, err)
+		}
+		if text.Lookup("subject") == nil {
+			return nil, fmt.Errorf("%s text template does not define a subject", event)
+		}
+
+		html, err := htmltemplate.New("layout.html").Option("missingkey=error").ParseFS(templateFS, "templates/layout.html", "templates/"+event+".html")
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s html template: %w", event, err)
+		}
+
+		t.byEvent[event] = eventTemplates{text: text, html: html}
+	}
+	return t, nil
+}
+
+// Render fills in the templates of the event for the recipient
+func (t *Templates) Render(event string, recipient Recipient, data map[string]any) (subject, text, html string, err error) {
+	et, ok := t.byEvent[event]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown event type %q", event)
+	}
+	td := templateData{Recipient: recipient, Data: data}
+
+	var buf bytes.Buffer
+	if err := et.text.ExecuteTemplate(&buf, "subject", td); err != nil {
+		return "", "", "", fmt.Errorf("rendering %s subject: %w", event, err)
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := et.text.Execute(&buf, td); err != nil {
+		return "", "", "", fmt.Errorf("rendering %s text: %w", event, err)
+	}
+	text = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := et.html.ExecuteTemplate(&buf, "layout", td); err != nil {
+		return "", "", "", fmt.Errorf("rendering %s html: %w", event, err)
+	}
+	html = buf.String()
+
+	return subject, text, html, nil
+}
+
+// FormatAmount prints paise as rupees with two decimals, e.g. 123456 as 1234.56
+func FormatAmount(paise int64) string {
+	sign := ""
+	if paise < 0 {
+		sign = "-"
+		paise = -paise
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, paise/100, paise%100)
+}
@@ -0,0 +1,155 @@
+package products
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AbandonedCart is an in progress cart of a user that was not touched for a while
+type AbandonedCart struct {
+	OrderId   string
+	UserID    string
+	LineItems []LineItem
+	IdleSince time.Time // last time a line item of the cart was changed
+}
+
+// StaleCheckout is a cart that went to checkout but stayed pending
+type StaleCheckout struct {
+	OrderId string
+	UserID  string
+}
+
+// ClaimAbandonedCarts returns the in progress carts of users that were not changed since idleBefore
+// and marks them as reminded, so a cart is only reminded about once until it changes again.
+// Guest carts are skipped since there is nobody to remind.
+func (c *Conf) ClaimAbandonedCarts(ctx context.Context, idleBefore time.Time) ([]AbandonedCart, error) {
+
+	var carts []AbandonedCart
+	remindedAt := time.Now().UTC()
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		// a cart is reminded again only when one of its line items changed after the last reminder
+		query := `
+		WITH idle AS (
+			SELECT order_id
+			FROM cart
+			WHERE status = 'inprogress' AND user_id IS NOT NULL
+			GROUP BY order_id
+			HAVING MAX(updated_at) < $1
+				AND (MAX(reminded_at) IS NULL OR MAX(reminded_at) < MAX(updated_at))
+		)
+		UPDATE cart
+		SET reminded_at = $2
+		WHERE status = 'inprogress' AND order_id IN (SELECT order_id FROM idle)
+		RETURNING order_id, user_id, product_id, quantity, updated_at
+		`
+
+		rows, err := tx.QueryContext(ctx, query, idleBefore, remindedAt)
+		if err != nil {
+			return fmt.Errorf("failed to claim abandoned carts: %w", err)
+		}
+		defer rows.Close()
+
+		byOrder := make(map[string]int)
+		for rows.Next() {
+			var orderId, userId string
+			var line LineItem
+			var updatedAt time.Time
+			if err := rows.Scan(&orderId, &userId, &line.ProductID, &line.Quantity, &updatedAt); err != nil {
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+
+			i, ok := byOrder[orderId]
+			if !ok {
+				i = len(carts)
+				byOrder[orderId] = i
+				carts = append(carts, AbandonedCart{OrderId: orderId, UserID: userId})
+			}
+			carts[i].LineItems = append(carts[i].LineItems, line)
+			if updatedAt.After(carts[i].IdleSince) {
+				carts[i].IdleSince = updatedAt
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return carts, nil
+}
+
+// FetchStaleCheckouts returns the carts that are pending since before idleBefore
+func (c *Conf) FetchStaleCheckouts(ctx context.Context, idleBefore time.Time) ([]StaleCheckout, error) {
+
+	query := `
+	SELECT order_id, user_id
+	FROM cart
+	WHERE status = 'pending'
+	GROUP BY order_id, user_id
+	HAVING MAX(updated_at) < $1
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, idleBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stale checkouts: %w", err)
+	}
+	defer rows.Close()
+
+	var carts []StaleCheckout
+	for rows.Next() {
+		var cart StaleCheckout
+		if err := rows.Scan(&cart.OrderId, &cart.UserID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		carts = append(carts, cart)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return carts, nil
+}
+
+// ReleasePendingCart moves the line items of a checkout that was never paid back into the
+// in progress cart of the user, so the user can check out again. The order id of the old
+// checkout is not reused since the order service already has an order with that id.
+func (c *Conf) ReleasePendingCart(ctx context.Context, orderId string, userId string) (string, error) {
+
+	var newOrderId string
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		lines, err := fetchCartLines(ctx, tx, "order_id", orderId, StatusPending)
+		if err != nil {
+			return fmt.Errorf("failed to fetch pending cart: %w", err)
+		}
+
+		newOrderId, err = mergeIntoUserCart(ctx, tx, userId, lines)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		DELETE FROM cart
+		WHERE order_id = $1 AND status = 'pending'
+		`, orderId)
+		if err != nil {
+			return fmt.Errorf("failed to delete pending cart: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to release pending cart: %w", err)
+	}
+
+	return newOrderId, nil
+}
@@ -3,89 +3,116 @@ package products
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-func (c *Conf) InsertOrUpdateCart(ctx context.Context, userId string, lineItem NewCartLine) error {
+var ErrInsufficientStock = errors.New("quantity exceeds available stock")
 
-	id := uuid.NewString()
+func (c *Conf) InsertOrUpdateCart(ctx context.Context, owner CartOwner, lineItem NewCartLine) error {
 
 	// Use a transaction to ensure consistency
 	err := c.withTx(ctx, func(tx *sql.Tx) error {
-		var existingOrderID string
-		// Check if user exists with a pending status
-		err := tx.QueryRow(`
-		SELECT order_id 
+		return addCartLine(ctx, tx, owner, lineItem)
+	})
+
+	// If the transaction or insertion fails, return an error.
+	if err != nil {
+		return fmt.Errorf("failed to fetch products: %w", err)
+	}
+
+	return nil
+
+}
+
+// addCartLine adds the product to the in progress cart of the owner, or adds to its quantity
+// when it is already in the cart. The cart can never hold more than the available stock.
+func addCartLine(ctx context.Context, tx *sql.Tx, owner CartOwner, lineItem NewCartLine) error {
+
+	id := uuid.NewString()
+	ownerColumn, ownerId := owner.column()
+
+	// the cart can never hold more than what is available. The product row stays locked until
+	// the line is saved, so concurrent adds of the same product check against each other's quantity
+	var stock int
+	err := tx.QueryRowContext(ctx, `SELECT stock FROM products WHERE id = $1 FOR UPDATE`, lineItem.ProductID).Scan(&stock)
+	if err != nil {
+		return fmt.Errorf("failed to fetch product %s: %w", lineItem.ProductID, err)
+	}
+
+	var existingOrderID string
+	// Check if user exists with a pending status
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`
+	SELECT order_id 
+	FROM cart 
+	WHERE %s = $1 AND status = 'inprogress' 
+	LIMIT 1
+`, ownerColumn), ownerId).Scan(&existingOrderID)
+
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to query existing order ID: %w", err)
+	}
+
+	if existingOrderID != "" {
+		// Check if product exists for the user with pending status
+		var currentQuantity int
+		err = tx.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT quantity 
 		FROM cart 
-		WHERE user_id = $1 AND status = 'inprogress' 
-		LIMIT 1
-	`, userId).Scan(&existingOrderID)
+		WHERE %s = $1 AND product_id = $2 AND status = 'inprogress'
+	`, ownerColumn), ownerId, lineItem.ProductID).Scan(&currentQuantity)
 
 		if err != nil && err != sql.ErrNoRows {
-			return fmt.Errorf("failed to query existing order ID: %w", err)
+			return fmt.Errorf("failed to query existing product: %w", err)
 		}
 
-		if existingOrderID != "" {
-			// Check if product exists for the user with pending status
-			var currentQuantity int
-			err = tx.QueryRow(`
-			SELECT quantity 
-			FROM cart 
-			WHERE user_id = $1 AND product_id = $2 AND status = 'inprogress'
-		`, userId, lineItem.ProductID).Scan(&currentQuantity)
+		if currentQuantity+lineItem.Quantity > stock {
+			return ErrInsufficientStock
+		}
 
-			if err != nil && err != sql.ErrNoRows {
-				return fmt.Errorf("failed to query existing product: %w", err)
-			}
+		if currentQuantity > 0 {
+			// Update the quantity for the existing product
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE cart 
+			SET quantity = quantity + $1, updated_at = $2 
+			WHERE %s = $3 AND product_id = $4 AND status = 'inprogress'
+		`, ownerColumn), lineItem.Quantity, time.Now().UTC(), ownerId, lineItem.ProductID)
 
-			if currentQuantity > 0 {
-				// Update the quantity for the existing product
-				_, err = tx.Exec(`
-				UPDATE cart 
-				SET quantity = quantity + $1, updated_at = $2 
-				WHERE user_id = $3 AND product_id = $4 AND status = 'inprogress'
-			`, lineItem.Quantity, time.Now().UTC(), userId, lineItem.ProductID)
-
-				if err != nil {
-					return fmt.Errorf("failed to update product quantity: %w", err)
-				}
-			} else {
-				// Insert a new row with the existing order ID
-				_, err = tx.Exec(`
-				INSERT INTO cart (id, product_id, user_id, order_id, quantity, status, created_at, updated_at) 
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-			`, id, lineItem.ProductID, userId, existingOrderID, lineItem.Quantity, StatusInProgress, time.Now().UTC(), time.Now().UTC())
-
-				if err != nil {
-					return fmt.Errorf("failed to insert new product: %w", err)
-				}
+			if err != nil {
+				return fmt.Errorf("failed to update product quantity: %w", err)
 			}
 		} else {
-			// Insert a new row with a new order ID
-			newOrderId := uuid.NewString()
-
-			_, err = tx.Exec(`
-			INSERT INTO cart (id, product_id, user_id, order_id, quantity, status, created_at, updated_at) 
+			// Insert a new row with the existing order ID
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO cart (id, product_id, %s, order_id, quantity, status, created_at, updated_at) 
 			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		`, id, lineItem.ProductID, userId, newOrderId, lineItem.Quantity, StatusInProgress, time.Now().UTC(), time.Now().UTC())
+		`, ownerColumn), id, lineItem.ProductID, ownerId, existingOrderID, lineItem.Quantity, StatusInProgress, time.Now().UTC(), time.Now().UTC())
 
 			if err != nil {
-				return fmt.Errorf("failed to insert new row with new order ID: %w", err)
+				return fmt.Errorf("failed to insert new product: %w", err)
 			}
 		}
-		return nil
-	})
+	} else {
+		if lineItem.Quantity > stock {
+			return ErrInsufficientStock
+		}
 
-	// If the transaction or insertion fails, return an error.
-	if err != nil {
-		return fmt.Errorf("failed to fetch products: %w", err)
-	}
+		// Insert a new row with a new order ID
+		newOrderId := uuid.NewString()
 
-	return nil
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO cart (id, product_id, %s, order_id, quantity, status, created_at, updated_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, ownerColumn), id, lineItem.ProductID, ownerId, newOrderId, lineItem.Quantity, StatusInProgress, time.Now().UTC(), time.Now().UTC())
 
+		if err != nil {
+			return fmt.Errorf("failed to insert new row with new order ID: %w", err)
+		}
+	}
+	return nil
 }
 
 func (c *Conf) FetchCartItems(ctx context.Context, userId string, status StatusEnum) (CartReturn, error) {
@@ -138,22 +165,25 @@ func (c *Conf) FetchCartItems(ctx context.Context, userId string, status StatusE
 
 }
 
-func (c *Conf) FetchCartDetails(ctx context.Context, userId string, status StatusEnum) ([]CartDetails, error) {
+// FetchCartDetails returns the cart of the owner with the product name, price and stock of every line item
+func (c *Conf) FetchCartDetails(ctx context.Context, owner CartOwner, status StatusEnum) (CartView, error) {
 
-	// An album slice to hold data from returned rows.
 	var cartLines []CartDetails
+	ownerColumn, ownerId := owner.column()
 
 	// Use a transaction to ensure consistency
 	err := c.withTx(ctx, func(tx *sql.Tx) error {
 
-		// Step 3: Perform the update since the `updated_at` condition is met
-		queryFetch := `
-		select id,order_id,product_id, quantity
-		from cart
-		WHERE user_id = $1 AND status = $2;
-		`
+		// products.price holds the price in paise
+		queryFetch := fmt.Sprintf(`
+		SELECT c.id, c.order_id, c.product_id, p.name, CAST(p.price AS BIGINT), c.quantity, p.stock
+		FROM cart c
+		JOIN products p ON p.id = c.product_id
+		WHERE c.%s = $1 AND c.status = $2
+		ORDER BY c.created_at;
+		`, ownerColumn)
 
-		rows, err := tx.Query(queryFetch, userId, status)
+		rows, err := tx.QueryContext(ctx, queryFetch, ownerId, status)
 		if err != nil {
 			return fmt.Errorf("failed to fetch cart items: %w", err)
 		}
@@ -164,7 +194,7 @@ func (c *Conf) FetchCartDetails(ctx context.Context, userId string, status Statu
 		for rows.Next() {
 			var line CartDetails
 
-			if err := rows.Scan(&line.ID, &line.OrderId, &line.ProductID, &line.Quantity); err != nil {
+			if err := rows.Scan(&line.ID, &line.OrderId, &line.ProductID, &line.Name, &line.UnitPrice, &line.Quantity, &line.Stock); err != nil {
 				return err
 			}
 			cartLines = append(cartLines, line)
@@ -177,11 +207,78 @@ func (c *Conf) FetchCartDetails(ctx context.Context, userId string, status Statu
 
 	// If the transaction or insertion fails, return an error.
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch products: %w", err)
+		return CartView{}, fmt.Errorf("failed to fetch products: %w", err)
 	}
 
-	return cartLines, nil
+	return NewCartView(cartLines), nil
+
+}
+
+// UpdateCartQuantity sets the quantity of a line item that is still in the cart
+func (c *Conf) UpdateCartQuantity(ctx context.Context, cartID string, owner CartOwner, quantity int) error {
+
+	updatedAt := time.Now().UTC() // Current timestamp
+	ownerColumn, ownerId := owner.column()
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var stock int
+		queryStock := fmt.Sprintf(`
+		SELECT p.stock
+		FROM cart c
+		JOIN products p ON p.id = c.product_id
+		WHERE c.id = $1 AND c.%s = $2 AND c.status = $3
+		FOR UPDATE
+		`, ownerColumn)
+		err := tx.QueryRowContext(ctx, queryStock, cartID, ownerId, StatusInProgress).Scan(&stock)
+		if err != nil {
+			return fmt.Errorf("failed to fetch cart item with ID %s: %w", cartID, err)
+		}
+
+		if quantity > stock {
+			return ErrInsufficientStock
+		}
+
+		queryUpdate := fmt.Sprintf(`
+		UPDATE cart
+		SET quantity = $1, updated_at = $2
+		WHERE id = $3 AND %s = $4 AND status = $5
+		`, ownerColumn)
+		_, err = tx.ExecContext(ctx, queryUpdate, quantity, updatedAt, cartID, ownerId, StatusInProgress)
+		if err != nil {
+			return fmt.Errorf("failed to update cart item with ID %s: %w", cartID, err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to update cart quantity: %w", err)
+	}
 
+	return nil
+}
+
+// ClearCart removes every line item the owner has not checked out yet
+func (c *Conf) ClearCart(ctx context.Context, owner CartOwner) error {
+	ownerColumn, ownerId := owner.column()
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		queryDelete := fmt.Sprintf(`
+			DELETE FROM cart
+			WHERE %s = $1 AND status = $2;
+		`, ownerColumn)
+
+		_, err := tx.ExecContext(ctx, queryDelete, ownerId, StatusInProgress)
+		if err != nil {
+			return fmt.Errorf("failed to clear cart: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to clear cart: %w", err)
+	}
+
+	return nil
 }
 
 func (c *Conf) UpdateCartStatusFromInProgressToPending(ctx context.Context, userId string) error {
@@ -221,6 +318,32 @@ func (c *Conf) UpdateCartStatusFromInProgressToPending(ctx context.Context, user
 	return nil
 }
 
+// RevertCartCheckout moves a pending cart back to in progress when the checkout could not be started
+func (c *Conf) RevertCartCheckout(ctx context.Context, orderId string) error {
+
+	updatedAt := time.Now().UTC() // Current timestamp
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		queryUpdate := `
+				UPDATE cart 
+				SET status = $1, updated_at = $2 
+				WHERE order_id = $3 AND status = $4
+			`
+
+		_, err := tx.ExecContext(ctx, queryUpdate, StatusInProgress, updatedAt, orderId, StatusPending)
+		if err != nil {
+			return fmt.Errorf("failed to update cart status: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (c *Conf) UpdateCartStatusForOrderId(ctx context.Context, orderId string) error {
 
 	updatedAt := time.Now().UTC() // Current timestamp
@@ -258,17 +381,19 @@ func (c *Conf) UpdateCartStatusForOrderId(ctx context.Context, orderId string) e
 	return nil
 }
 
-func (c *Conf) DeleteCartByIDIfPending(ctx context.Context, cartID string, userId string) error {
+func (c *Conf) DeleteCartByIDIfPending(ctx context.Context, cartID string, owner CartOwner) error {
+	ownerColumn, ownerId := owner.column()
+
 	// Use a transaction to ensure consistency
 	err := c.withTx(ctx, func(tx *sql.Tx) error {
 		// Prepare the DELETE query
-		queryDelete := `
+		queryDelete := fmt.Sprintf(`
 			DELETE FROM cart
-			WHERE id = $1 AND status = $3 AND user_id = $2;
-		`
+			WHERE id = $1 AND status = $3 AND %s = $2;
+		`, ownerColumn)
 
 		// Execute the DELETE query
-		result, err := tx.Exec(queryDelete, cartID, userId, StatusInProgress)
+		result, err := tx.Exec(queryDelete, cartID, ownerId, StatusInProgress)
 		if err != nil {
 			return fmt.Errorf("failed to delete cart item with ID %s: %w", cartID, err)
 		}
@@ -292,3 +417,135 @@ func (c *Conf) DeleteCartByIDIfPending(ctx context.Context, cartID string, userI
 
 	return nil
 }
+
+// MergeGuestCart moves the in progress line items of a guest into the cart of the user.
+// Quantities of the same product are combined but never go above the available stock,
+// the guest cart is removed once it is merged.
+func (c *Conf) MergeGuestCart(ctx context.Context, guestId string, userId string) error {
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		lines, err := fetchCartLines(ctx, tx, "guest_id", guestId, StatusInProgress)
+		if err != nil {
+			return fmt.Errorf("failed to fetch guest cart: %w", err)
+		}
+
+		_, err = mergeIntoUserCart(ctx, tx, userId, lines)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		DELETE FROM cart
+		WHERE guest_id = $1 AND status = 'inprogress'
+		`, guestId)
+		if err != nil {
+			return fmt.Errorf("failed to delete guest cart: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to merge guest cart: %w", err)
+	}
+
+	return nil
+}
+
+// cartLine is a line item that is moved from one cart to another, with the stock of its product
+type cartLine struct {
+	productId string
+	quantity  int
+	stock     int
+}
+
+// fetchCartLines reads the line items of the cart owned by ownerId in the given column
+func fetchCartLines(ctx context.Context, tx *sql.Tx, ownerColumn string, ownerId string, status StatusEnum) ([]cartLine, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT c.product_id, c.quantity, p.stock
+		FROM cart c
+		JOIN products p ON p.id = c.product_id
+		WHERE c.%s = $1 AND c.status = $2
+		`, ownerColumn), ownerId, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []cartLine
+	for rows.Next() {
+		var line cartLine
+		if err := rows.Scan(&line.productId, &line.quantity, &line.stock); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return lines, nil
+}
+
+// mergedQuantity combines the quantity a cart has of a product with the quantity added to it,
+// capped at the stock. A cart that already holds more than the stock keeps its quantity.
+func mergedQuantity(current, added, stock int) int {
+	return max(min(current+added, stock), current)
+}
+
+// mergeIntoUserCart adds the line items to the in progress cart of the user and returns its order id.
+// Quantities of the same product are combined but never go above the available stock.
+func mergeIntoUserCart(ctx context.Context, tx *sql.Tx, userId string, lines []cartLine) (string, error) {
+	updatedAt := time.Now().UTC() // Current timestamp
+
+	var orderId string
+	err := tx.QueryRowContext(ctx, `
+		SELECT order_id
+		FROM cart
+		WHERE user_id = $1 AND status = 'inprogress'
+		LIMIT 1
+		`, userId).Scan(&orderId)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to query existing order ID: %w", err)
+	}
+	if orderId == "" {
+		orderId = uuid.NewString()
+	}
+
+	for _, line := range lines {
+		var currentQuantity int
+		err := tx.QueryRowContext(ctx, `
+			SELECT quantity
+			FROM cart
+			WHERE user_id = $1 AND product_id = $2 AND status = 'inprogress'
+			`, userId, line.productId).Scan(&currentQuantity)
+		if err != nil && err != sql.ErrNoRows {
+			return "", fmt.Errorf("failed to query existing product: %w", err)
+		}
+
+		merged := mergedQuantity(currentQuantity, line.quantity, line.stock)
+		if merged <= currentQuantity {
+			// nothing more can be added for this product
+			continue
+		}
+
+		if currentQuantity > 0 {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE cart
+				SET quantity = $1, updated_at = $2
+				WHERE user_id = $3 AND product_id = $4 AND status = 'inprogress'
+				`, merged, updatedAt, userId, line.productId)
+			if err != nil {
+				return "", fmt.Errorf("failed to update product quantity: %w", err)
+			}
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO cart (id, product_id, user_id, order_id, quantity, status, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			`, uuid.NewString(), line.productId, userId, orderId, merged, StatusInProgress, updatedAt, updatedAt)
+		if err != nil {
+			return "", fmt.Errorf("failed to insert new product: %w", err)
+		}
+	}
+	return orderId, nil
+}
@@ -0,0 +1,27 @@
+package products
+
+import "testing"
+
+func TestMergedQuantity(t *testing.T) {
+	tests := []struct {
+		name    string
+		current int
+		added   int
+		stock   int
+		want    int
+	}{
+		{name: "not in the user cart", current: 0, added: 2, stock: 10, want: 2},
+		{name: "combined with the user cart", current: 3, added: 2, stock: 10, want: 5},
+		{name: "capped at the stock", current: 3, added: 9, stock: 10, want: 10},
+		{name: "user cart holds the stock", current: 10, added: 1, stock: 10, want: 10},
+		{name: "user cart holds more than the stock", current: 6, added: 1, stock: 4, want: 6},
+		{name: "out of stock", current: 0, added: 1, stock: 0, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergedQuantity(tt.current, tt.added, tt.stock); got != tt.want {
+				t.Errorf("mergedQuantity() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
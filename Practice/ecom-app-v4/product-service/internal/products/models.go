@@ -1,35 +1,44 @@
 package products
 
 import (
+	"fmt"
 	"time"
 )
 
 // User struct represents the users table in the stores
 type Product struct {
-	ID          string    `json:"id"` // UUID
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Price       string    `json:"price"`
-	Category    string    `json:"category"`
-	Stock       string    `json:"stock"`
-	CreatedAt   time.Time `json:"created_at"` // Timestamp of creation
-	UpdatedAt   time.Time `json:"updated_at"` // Timestamp of last update
+	ID               string    `json:"id"` // UUID
+	Name             string    `json:"name"`
+	Description      string    `json:"description"`
+	Price            string    `json:"price"`
+	Category         string    `json:"category"`
+	Stock            string    `json:"stock"`
+	ReorderThreshold int       `json:"reorder_threshold"` // Stock level at or below which admins get a low stock alert
+	WeightGrams      int       `json:"weight_grams"`      // Shipping weight of one unit
+	CreatedAt        time.Time `json:"created_at"`        // Timestamp of creation
+	UpdatedAt        time.Time `json:"updated_at"`        // Timestamp of last update
 }
 
 // NewUser struct represents the data required when creating a new user
 type NewProduct struct {
-	Name        string `json:"name" validate:"required,min=2,max=100"`        // Valid email required
-	Description string `json:"description" validate:"required,min=2,max=100"` // Password must be at least 5 characters long
-	Price       string `json:"price" validate:"required,min=2,max=100"`
-	Category    string `json:"category" validate:"required,min=2,max=100"`
-	Stock       string `json:"stock" validate:"required,min=2,max=100"`
+	Name             string `json:"name" validate:"required,min=2,max=100"`        // Valid email required
+	Description      string `json:"description" validate:"required,min=2,max=100"` // Password must be at least 5 characters long
+	Price            string `json:"price" validate:"required,min=2,max=100"`
+	Category         string `json:"category" validate:"required,min=2,max=100"`
+	Stock            string `json:"stock" validate:"required,min=2,max=100"`
+	ReorderThreshold string `json:"reorder_threshold,omitempty" validate:"omitempty,numeric"` // empty disables low stock alerts
+	WeightGrams      string `json:"weight_grams,omitempty" validate:"omitempty,numeric"`      // empty uses the default weight
 }
 
 // keeping it simple this is json which will be returned
 type ProductOrder struct {
 	ProductId string `json:"product_id"`
+	Name      string `json:"name"`
 	PriceId   string `json:"price_id"`
 	Stock     int    `json:"stock"`
+	Price     int64  `json:"price"` // unit price in paise, as on the stripe price
+	Category  string `json:"category"`
+	Weight    int    `json:"weight_grams"` // shipping weight of one unit
 }
 
 //required: The roles field is mandatory.
@@ -56,8 +65,53 @@ type ProductUpdateRequest struct {
 	Description string `json:"description,omitempty" validate:"omitempty,min=2,max=100"` // Password must be at least 5 characters long
 	//TODO allow when stripe price update is sorted
 	//Price       string `json:"price,omitempty" validate:"omitempty,min=2,max=100"`
-	Category string `json:"category,omitempty" validate:"omitempty,min=2,max=100"`
-	Stock    string `json:"stock,omitempty" validate:"omitempty,min=2,max=100"`
+	Category         string `json:"category,omitempty" validate:"omitempty,min=2,max=100"`
+	Stock            string `json:"stock,omitempty" validate:"omitempty,min=2,max=100"`
+	ReorderThreshold string `json:"reorder_threshold,omitempty" validate:"omitempty,numeric"`
+	WeightGrams      string `json:"weight_grams,omitempty" validate:"omitempty,numeric"`
+}
+
+/*
+	//------------------------------------------------------//
+	//   Adding Stock Alert Structs
+	//------------------------------------------------------//
+*/
+
+// StockLevel is the stock of a product right after it was changed
+type StockLevel struct {
+	ProductID        string `json:"product_id"`
+	Name             string `json:"name"`
+	PreviousStock    int    `json:"previous_stock"`
+	Stock            int    `json:"stock"`
+	ReorderThreshold int    `json:"reorder_threshold"`
+}
+
+// CrossedReorderThreshold reports whether the change took the stock from above the
+// reorder threshold to at or below it. A threshold of 0 means alerts are disabled.
+func (s StockLevel) CrossedReorderThreshold() bool {
+	if s.ReorderThreshold <= 0 {
+		return false
+	}
+	return s.PreviousStock > s.ReorderThreshold && s.Stock <= s.ReorderThreshold
+}
+
+// BackInStock reports whether the change took the product from out of stock to available
+func (s StockLevel) BackInStock() bool {
+	return s.PreviousStock <= 0 && s.Stock > 0
+}
+
+// RestockRequest is sent by admins when new stock arrives
+type RestockRequest struct {
+	Quantity int `json:"quantity" validate:"required,min=1,max=100000"`
+}
+
+// StockSubscription is a customer waiting for a product to be back in stock
+type StockSubscription struct {
+	ID         string    `json:"id"`
+	ProductID  string    `json:"product_id"`
+	UserID     string    `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	NotifiedAt time.Time `json:"notified_at"`
 }
 
 /*
@@ -86,6 +140,26 @@ const (
 	StatusCompleted  StatusEnum = "completed"
 )
 
+// MergeCartRequest is sent by the user service to merge a guest cart once the guest logs in or signs up
+type MergeCartRequest struct {
+	UserID    string `json:"user_id" validate:"required,uuid"`
+	CartToken string `json:"cart_token" validate:"required"`
+}
+
+// CartOwner identifies whose cart is used, a logged in user or a guest holding a cart token
+type CartOwner struct {
+	UserID  string
+	GuestID string
+}
+
+// column returns the cart column and value that identify the owner
+func (o CartOwner) column() (string, string) {
+	if o.UserID != "" {
+		return "user_id", o.UserID
+	}
+	return "guest_id", o.GuestID
+}
+
 // NewUser struct represents the data required when creating a new line item inside the cart
 type NewCartLine struct {
 	ProductID string `json:"product_id" validate:"required,min=2,max=100"`
@@ -103,16 +177,106 @@ type CartReturn struct {
 }
 
 type OrderRequest struct {
-	LineItems []LineItem `json:"lineItems" `
+	LineItems      []LineItem `json:"lineItems" `
+	CouponCode     string     `json:"couponCode,omitempty"`
+	ShippingRegion string     `json:"shippingRegion,omitempty"`
+	AddressID      string     `json:"addressId,omitempty"`
+}
+
+// CheckoutRequest is the optional body of the cart checkout
+type CheckoutRequest struct {
+	CouponCode     string `json:"coupon_code" validate:"omitempty,min=3,max=50"`
+	ShippingRegion string `json:"shipping_region" validate:"omitempty,min=2,max=50"` // empty ships with the default rates
+	AddressID      string `json:"address_id" validate:"omitempty,uuid"`              // saved shipping address of the user
 }
 
 type FetchCartResponse struct {
 	LineItems []LineItem `json:"lineItems" binding:"required"`
 }
 
+// UpdateCartLine is the request body to set the quantity of a line item in the cart
+type UpdateCartLine struct {
+	Quantity int `json:"quantity" validate:"required,min=1,max=100"`
+}
+
+// CartDetails is a line item of the cart priced with the current product details
 type CartDetails struct {
-	ID        string
-	OrderId   string
-	ProductID string
-	Quantity  int
+	ID        string `json:"id"`
+	OrderId   string `json:"order_id"`
+	ProductID string `json:"product_id"`
+	Name      string `json:"name"`
+	UnitPrice int64  `json:"unit_price"` // in paise
+	Quantity  int    `json:"quantity"`
+	LineTotal int64  `json:"line_total"` // in paise
+	Stock     int    `json:"stock"`
+	Warning   string `json:"warning,omitempty"`
+}
+
+// StockWarning tells the customer when the product cannot fulfil the quantity in the cart
+func (cd CartDetails) StockWarning() string {
+	switch {
+	case cd.Stock <= 0:
+		return "out of stock"
+	case cd.Quantity > cd.Stock:
+		return fmt.Sprintf("only %d left in stock", cd.Stock)
+	}
+	return ""
+}
+
+// CartView is the cart returned to the customer with the subtotal of all line items
+type CartView struct {
+	OrderId   string        `json:"order_id"`
+	LineItems []CartDetails `json:"line_items"`
+	Subtotal  int64         `json:"subtotal"` // in paise
+	Warnings  []string      `json:"warnings,omitempty"`
+}
+
+// NewCartView prices every line item and adds them up to the cart subtotal
+func NewCartView(lines []CartDetails) CartView {
+	view := CartView{LineItems: lines}
+	for i := range view.LineItems {
+		line := &view.LineItems[i]
+		view.OrderId = line.OrderId
+		line.LineTotal = line.UnitPrice * int64(line.Quantity)
+		line.Warning = line.StockWarning()
+		view.Subtotal += line.LineTotal
+		if line.Warning != "" {
+			view.Warnings = append(view.Warnings, fmt.Sprintf("%s: %s", line.Name, line.Warning))
+		}
+	}
+	return view
+}
+
+/*
+	//------------------------------------------------------//
+	//   Adding Wishlist Structs
+	//------------------------------------------------------//
+*/
+
+// NewWishlistItem is the request body to save a product to the wishlist
+type NewWishlistItem struct {
+	ProductID string `json:"product_id" validate:"required,uuid"`
+	Quantity  int    `json:"quantity,omitempty" validate:"omitempty,min=1,max=100"` // defaults to 1
+}
+
+// WishlistItem is a saved product with its current price and stock
+type WishlistItem struct {
+	ID           string    `json:"id"`
+	ProductID    string    `json:"product_id"`
+	Name         string    `json:"name"`
+	Quantity     int       `json:"quantity"`
+	SavedPrice   int64     `json:"saved_price"` // in paise
+	Price        int64     `json:"price"`       // in paise
+	Stock        int       `json:"stock"`
+	PriceDropped bool      `json:"price_dropped"`
+	BackInStock  bool      `json:"back_in_stock"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	savedStock int
+}
+
+// setFlags compares the product today with the product when it was saved
+func (w *WishlistItem) setFlags() {
+	w.PriceDropped = w.Price < w.SavedPrice
+	w.BackInStock = w.savedStock <= 0 && w.Stock > 0
 }
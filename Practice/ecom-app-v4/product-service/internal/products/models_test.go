@@ -0,0 +1,123 @@
+package products
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCrossedReorderThreshold(t *testing.T) {
+	tests := []struct {
+		name  string
+		level StockLevel
+		want  bool
+	}{
+		{name: "drops to the threshold", level: StockLevel{PreviousStock: 11, Stock: 10, ReorderThreshold: 10}, want: true},
+		{name: "drops below the threshold", level: StockLevel{PreviousStock: 20, Stock: 3, ReorderThreshold: 10}, want: true},
+		{name: "stays above the threshold", level: StockLevel{PreviousStock: 20, Stock: 11, ReorderThreshold: 10}},
+		{name: "already at or below the threshold", level: StockLevel{PreviousStock: 10, Stock: 4, ReorderThreshold: 10}},
+		{name: "restocked above the threshold", level: StockLevel{PreviousStock: 4, Stock: 40, ReorderThreshold: 10}},
+		{name: "alerts disabled", level: StockLevel{PreviousStock: 5, Stock: 0, ReorderThreshold: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.level.CrossedReorderThreshold(); got != tt.want {
+				t.Errorf("CrossedReorderThreshold() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackInStock(t *testing.T) {
+	tests := []struct {
+		name  string
+		level StockLevel
+		want  bool
+	}{
+		{name: "restocked from zero", level: StockLevel{PreviousStock: 0, Stock: 5}, want: true},
+		{name: "restocked from oversold", level: StockLevel{PreviousStock: -2, Stock: 1}, want: true},
+		{name: "still out of stock", level: StockLevel{PreviousStock: 0, Stock: 0}},
+		{name: "was already in stock", level: StockLevel{PreviousStock: 3, Stock: 8}},
+		{name: "sold out", level: StockLevel{PreviousStock: 1, Stock: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.level.BackInStock(); got != tt.want {
+				t.Errorf("BackInStock() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStockWarning(t *testing.T) {
+	tests := []struct {
+		name string
+		line CartDetails
+		want string
+	}{
+		{name: "enough stock", line: CartDetails{Quantity: 2, Stock: 5}},
+		{name: "exactly the stock", line: CartDetails{Quantity: 5, Stock: 5}},
+		{name: "more than the stock", line: CartDetails{Quantity: 6, Stock: 5}, want: "only 5 left in stock"},
+		{name: "out of stock", line: CartDetails{Quantity: 1, Stock: 0}, want: "out of stock"},
+		{name: "oversold", line: CartDetails{Quantity: 1, Stock: -1}, want: "out of stock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.line.StockWarning(); got != tt.want {
+				t.Errorf("StockWarning() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCartView(t *testing.T) {
+	tests := []struct {
+		name         string
+		lines        []CartDetails
+		wantTotals   []int64
+		wantSubtotal int64
+		wantWarnings []string
+	}{
+		{
+			name:  "empty cart",
+			lines: nil,
+		},
+		{
+			name: "line totals add up to the subtotal",
+			lines: []CartDetails{
+				{OrderId: "o1", Name: "Mug", UnitPrice: 25000, Quantity: 2, Stock: 10},
+				{OrderId: "o1", Name: "Tea", UnitPrice: 9900, Quantity: 1, Stock: 3},
+			},
+			wantTotals:   []int64{50000, 9900},
+			wantSubtotal: 59900,
+		},
+		{
+			name: "warnings name the product",
+			lines: []CartDetails{
+				{OrderId: "o1", Name: "Mug", UnitPrice: 25000, Quantity: 4, Stock: 3},
+				{OrderId: "o1", Name: "Tea", UnitPrice: 9900, Quantity: 1, Stock: 0},
+			},
+			wantTotals:   []int64{100000, 9900},
+			wantSubtotal: 109900,
+			wantWarnings: []string{"Mug: only 3 left in stock", "Tea: out of stock"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			view := NewCartView(tt.lines)
+			if view.Subtotal != tt.wantSubtotal {
+				t.Errorf("Subtotal = %d, want %d", view.Subtotal, tt.wantSubtotal)
+			}
+			if !reflect.DeepEqual(view.Warnings, tt.wantWarnings) {
+				t.Errorf("Warnings = %v, want %v", view.Warnings, tt.wantWarnings)
+			}
+			for i, line := range view.LineItems {
+				if line.LineTotal != tt.wantTotals[i] {
+					t.Errorf("line %d LineTotal = %d, want %d", i, line.LineTotal, tt.wantTotals[i])
+				}
+			}
+			if len(tt.lines) > 0 && view.OrderId != tt.lines[0].OrderId {
+				t.Errorf("OrderId = %q, want %q", view.OrderId, tt.lines[0].OrderId)
+			}
+		})
+	}
+}
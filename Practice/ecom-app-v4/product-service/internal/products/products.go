@@ -32,6 +32,18 @@ func (c *Conf) InsertProduct(ctx context.Context, newProduct NewProduct) (Produc
 
 	var prod Product
 
+	// an empty reorder threshold keeps low stock alerts switched off for the product
+	reorderThreshold := newProduct.ReorderThreshold
+	if reorderThreshold == "" {
+		reorderThreshold = "0"
+	}
+
+	// products without a weight ship with the default weight of the column
+	weightGrams := newProduct.WeightGrams
+	if weightGrams == "" {
+		weightGrams = "500"
+	}
+
 	// Use a transaction to ensure atomicity of the database operation.
 
 	err := c.withTx(ctx, func(tx *sql.Tx) error {
@@ -39,14 +51,14 @@ func (c *Conf) InsertProduct(ctx context.Context, newProduct NewProduct) (Produc
 		// The `RETURNING` clause retrieves the inserted user's data after the operation.
 		query := `
       INSERT INTO products
-      (id, name, description, price, category, stock, created_at,updated_at)
-      VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-      RETURNING id, name, created_at, updated_at
+      (id, name, description, price, category, stock, reorder_threshold, weight_grams, created_at,updated_at)
+      VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+      RETURNING id, name, reorder_threshold, weight_grams, created_at, updated_at
       `
 		// Execute the `INSERT` query within the transaction to add the new user.
 		// `QueryRowContext` executes the query and scans the resulting row into the `user` struct.
-		err := tx.QueryRowContext(ctx, query, id, newProduct.Name, newProduct.Description, newProduct.Price, newProduct.Category, newProduct.Stock, createdAt, updatedAt).
-			Scan(&prod.ID, &prod.Name, &prod.CreatedAt, &prod.UpdatedAt)
+		err := tx.QueryRowContext(ctx, query, id, newProduct.Name, newProduct.Description, newProduct.Price, newProduct.Category, newProduct.Stock, reorderThreshold, weightGrams, createdAt, updatedAt).
+			Scan(&prod.ID, &prod.Name, &prod.ReorderThreshold, &prod.WeightGrams, &prod.CreatedAt, &prod.UpdatedAt)
 		if err != nil {
 			// Return an error if the query execution or scan fails.
 			return fmt.Errorf("failed to insert user: %w", err)
@@ -99,41 +111,42 @@ func (c *Conf) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
 	return nil
 }
 
-func (c *Conf) DecrementStock(ctx context.Context, productId string, stock int) error {
+// DecrementStock reduces the stock of a product once an order is paid and returns the
+// stock level before and after the change, so the caller can raise low stock alerts
+func (c *Conf) DecrementStock(ctx context.Context, productId string, stock int) (StockLevel, error) {
 	updatedAt := time.Now().UTC() // Current timestamp
 
+	var level StockLevel
+
 	// Use a transaction to ensure consistency
 	err := c.withTx(ctx, func(tx *sql.Tx) error {
 
 		// Step 3: Perform the update since the `updated_at` condition is met
+		// stock + $2 in RETURNING gives back the value before the update
 		queryUpdate := `
 		UPDATE products
 		SET stock = stock - $2,  updated_at = $3
-		WHERE id = $1 AND stock > 0;
-
+		WHERE id = $1 AND stock > 0
+		RETURNING id, name, stock + $2, stock, reorder_threshold;
 		`
 
-		res, err := tx.ExecContext(ctx, queryUpdate, productId, stock, updatedAt)
+		err := tx.QueryRowContext(ctx, queryUpdate, productId, stock, updatedAt).
+			Scan(&level.ProductID, &level.Name, &level.PreviousStock, &level.Stock, &level.ReorderThreshold)
 		if err != nil {
 			return fmt.Errorf("failed to update order: %w", err)
 		}
 
-		num, err := res.RowsAffected()
-		if num == 0 || err != nil {
-			return fmt.Errorf("failed to update order: %w", err)
-		}
-
 		// Successfully updated the order
 		return nil
 	})
 
 	if err != nil {
 		// Return the error, if any
-		return err
+		return StockLevel{}, err
 	}
 
 	// Return nil if the update is successful or skipped gracefully
-	return nil
+	return level, nil
 }
 
 func (c *Conf) FetchAllProducts(ctx context.Context) ([]ProductDetail, error) {
@@ -244,6 +257,16 @@ func buildUpdateQuery(tableName, idColumn, idValue string, req ProductUpdateRequ
 		args = append(args, req.Stock)
 		argIndex++
 	}
+	if req.ReorderThreshold != "" {
+		setClauses = append(setClauses, fmt.Sprintf("reorder_threshold = $%d", argIndex))
+		args = append(args, req.ReorderThreshold)
+		argIndex++
+	}
+	if req.WeightGrams != "" {
+		setClauses = append(setClauses, fmt.Sprintf("weight_grams = $%d", argIndex))
+		args = append(args, req.WeightGrams)
+		argIndex++
+	}
 
 	// If no fields to update, return an error
 	if len(setClauses) == 0 {
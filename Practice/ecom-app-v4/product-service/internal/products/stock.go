@@ -0,0 +1,137 @@
+package products
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAlreadySubscribed = errors.New("already subscribed to this product")
+	ErrProductInStock    = errors.New("product is in stock")
+)
+
+// RestockProduct adds newly arrived stock to a product and returns the stock level
+// before and after the change, so the caller can notify customers waiting for it
+func (c *Conf) RestockProduct(ctx context.Context, productId string, quantity int) (StockLevel, error) {
+	updatedAt := time.Now().UTC() // Current timestamp
+
+	var level StockLevel
+
+	// Use a transaction to ensure consistency
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+
+		// stock - $2 in RETURNING gives back the value before the update
+		queryUpdate := `
+		UPDATE products
+		SET stock = stock + $2, updated_at = $3
+		WHERE id = $1
+		RETURNING id, name, stock - $2, stock, reorder_threshold;
+		`
+
+		err := tx.QueryRowContext(ctx, queryUpdate, productId, quantity, updatedAt).
+			Scan(&level.ProductID, &level.Name, &level.PreviousStock, &level.Stock, &level.ReorderThreshold)
+		if err != nil {
+			return fmt.Errorf("failed to restock product %s: %w", productId, err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return StockLevel{}, err
+	}
+
+	return level, nil
+}
+
+// SubscribeBackInStock registers the user to be notified when the product is back in stock.
+// Subscribing is only allowed while the product is out of stock.
+func (c *Conf) SubscribeBackInStock(ctx context.Context, productId string, userId string) error {
+
+	id := uuid.NewString()
+	createdAt := time.Now().UTC()
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var stock int
+		err := tx.QueryRowContext(ctx, `SELECT stock FROM products WHERE id = $1`, productId).Scan(&stock)
+		if err != nil {
+			return fmt.Errorf("failed to fetch product %s: %w", productId, err)
+		}
+
+		if stock > 0 {
+			return ErrProductInStock
+		}
+
+		// the partial unique index only allows one open subscription per user and product
+		query := `
+		INSERT INTO stock_subscriptions (id, product_id, user_id, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+		`
+		res, err := tx.ExecContext(ctx, query, id, productId, userId, createdAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert stock subscription: %w", err)
+		}
+
+		num, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to insert stock subscription: %w", err)
+		}
+		if num == 0 {
+			return ErrAlreadySubscribed
+		}
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to product: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimBackInStockSubscriptions marks all open subscriptions of a product as notified
+// and returns them, so every waiting customer is notified exactly once
+func (c *Conf) ClaimBackInStockSubscriptions(ctx context.Context, productId string) ([]StockSubscription, error) {
+
+	var subs []StockSubscription
+	notifiedAt := time.Now().UTC()
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+		UPDATE stock_subscriptions
+		SET notified_at = $2
+		WHERE product_id = $1 AND notified_at IS NULL
+		RETURNING id, product_id, user_id, created_at, notified_at
+		`
+
+		rows, err := tx.QueryContext(ctx, query, productId, notifiedAt)
+		if err != nil {
+			return fmt.Errorf("failed to claim stock subscriptions: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var sub StockSubscription
+			if err := rows.Scan(&sub.ID, &sub.ProductID, &sub.UserID, &sub.CreatedAt, &sub.NotifiedAt); err != nil {
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+			subs = append(subs, sub)
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
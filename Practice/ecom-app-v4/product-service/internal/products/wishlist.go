@@ -0,0 +1,159 @@
+package products
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AddToWishlist saves the product for the user with its current price and stock,
+// saving a product that is already in the wishlist only updates the quantity
+func (c *Conf) AddToWishlist(ctx context.Context, userId string, item NewWishlistItem) error {
+	if item.Quantity == 0 {
+		item.Quantity = 1
+	}
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		return saveToWishlist(ctx, tx, userId, item.ProductID, item.Quantity)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to add to wishlist: %w", err)
+	}
+
+	return nil
+}
+
+// saveToWishlist inserts or updates the wishlist row, sql.ErrNoRows is returned when the product does not exist
+func saveToWishlist(ctx context.Context, tx *sql.Tx, userId string, productId string, quantity int) error {
+	now := time.Now().UTC()
+
+	// the price and stock are only captured the first time the product is saved
+	query := `
+	INSERT INTO wishlists (id, user_id, product_id, quantity, saved_price, saved_stock, created_at, updated_at)
+	SELECT $1, $2, p.id, $4, CAST(p.price AS BIGINT), p.stock, $5, $5
+	FROM products p
+	WHERE p.id = $3
+	ON CONFLICT (user_id, product_id) DO UPDATE
+	SET quantity = EXCLUDED.quantity, updated_at = EXCLUDED.updated_at
+	`
+	res, err := tx.ExecContext(ctx, query, uuid.NewString(), userId, productId, quantity, now)
+	if err != nil {
+		return fmt.Errorf("failed to save product %s: %w", productId, err)
+	}
+
+	num, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to save product %s: %w", productId, err)
+	}
+	if num == 0 {
+		return fmt.Errorf("product %s not found: %w", productId, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// FetchWishlist returns the saved products of the user with the price drop and back in stock flags
+func (c *Conf) FetchWishlist(ctx context.Context, userId string) ([]WishlistItem, error) {
+
+	query := `
+	SELECT w.id, w.product_id, p.name, w.quantity, w.saved_price, CAST(p.price AS BIGINT), p.stock, w.saved_stock, w.created_at
+	FROM wishlists w
+	JOIN products p ON p.id = w.product_id
+	WHERE w.user_id = $1
+	ORDER BY w.created_at DESC
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch wishlist: %w", err)
+	}
+	defer rows.Close()
+
+	var items []WishlistItem
+	for rows.Next() {
+		var item WishlistItem
+		err := rows.Scan(&item.ID, &item.ProductID, &item.Name, &item.Quantity, &item.SavedPrice,
+			&item.Price, &item.Stock, &item.savedStock, &item.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		item.setFlags()
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return items, nil
+}
+
+// RemoveFromWishlist deletes a saved product, sql.ErrNoRows is returned when it is not in the wishlist
+func (c *Conf) RemoveFromWishlist(ctx context.Context, userId string, wishlistId string) error {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM wishlists WHERE id = $1 AND user_id = $2`, wishlistId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to remove from wishlist: %w", err)
+	}
+
+	num, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to remove from wishlist: %w", err)
+	}
+	if num == 0 {
+		return fmt.Errorf("wishlist item %s not found: %w", wishlistId, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// MoveWishlistToCart moves a saved product into the in progress cart of the user.
+// The product stays in the wishlist when the stock can not cover the quantity.
+func (c *Conf) MoveWishlistToCart(ctx context.Context, userId string, wishlistId string) error {
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var line NewCartLine
+		err := tx.QueryRowContext(ctx, `
+		DELETE FROM wishlists
+		WHERE id = $1 AND user_id = $2
+		RETURNING product_id, quantity
+		`, wishlistId, userId).Scan(&line.ProductID, &line.Quantity)
+		if err != nil {
+			return fmt.Errorf("failed to remove wishlist item %s: %w", wishlistId, err)
+		}
+
+		return addCartLine(ctx, tx, CartOwner{UserID: userId}, line)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to move to cart: %w", err)
+	}
+
+	return nil
+}
+
+// SaveForLater moves a line item of the in progress cart into the wishlist of the user
+func (c *Conf) SaveForLater(ctx context.Context, userId string, cartId string) error {
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var productId string
+		var quantity int
+		err := tx.QueryRowContext(ctx, `
+		DELETE FROM cart
+		WHERE id = $1 AND user_id = $2 AND status = $3
+		RETURNING product_id, quantity
+		`, cartId, userId, StatusInProgress).Scan(&productId, &quantity)
+		if err != nil {
+			return fmt.Errorf("failed to remove cart item %s: %w", cartId, err)
+		}
+
+		return saveToWishlist(ctx, tx, userId, productId, quantity)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to save for later: %w", err)
+	}
+
+	return nil
+}
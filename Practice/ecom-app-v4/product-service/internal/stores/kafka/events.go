@@ -5,11 +5,135 @@ import "time"
 //<microservice>.<event-type>.<version> // topic naming
 
 const TopicOrderPaid = `order-service.order-paid`
+const TopicOrderStatusChanged = `order-service.order-status-changed`
+const TopicOrderRestock = `order-service.order-restock`
+const TopicReturnStatusChanged = `order-service.return-status-changed`
 const ConsumerGroup = `product-service`
 
+// NotificationsConsumerGroup reads the same topics as ConsumerGroup, every event is seen by both
+const NotificationsConsumerGroup = `product-service-notifications`
+
+const TopicAccountCreated = `user-service.account-created`
+const TopicEmailVerificationRequested = `user-service.email-verification-requested`
+const TopicPasswordResetRequested = `user-service.password-reset-requested`
+const TopicUserLocked = `user-service.user-locked`
+
+const (
+	TopicLowStock      = `product-service.low-stock`
+	TopicBackInStock   = `product-service.back-in-stock`
+	TopicCartAbandoned = `product-service.cart-abandoned`
+)
+
 type OrderPaidEvent struct {
 	OrderId   string    `json:"order_id"` // UUID
+	UserId    string    `json:"user_id"`  // empty for events published before it was added
 	ProductId string    `json:"product_id"`
 	Quantity  int       `json:"quantity"`
 	CreatedAt time.Time `json:"created_at"` // Timestamp of creation
 }
+
+// OrderStatusChangedEvent is published by the order service on every fulfilment transition of an order
+type OrderStatusChangedEvent struct {
+	OrderId        string    `json:"order_id"`
+	UserId         string    `json:"user_id"`
+	FromStatus     string    `json:"from_status"`
+	Status         string    `json:"status"`
+	Note           string    `json:"note,omitempty"`
+	Carrier        string    `json:"carrier,omitempty"`
+	TrackingNumber string    `json:"tracking_number,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// OrderRestockEvent is published by the order service when the products of a canceled or refunded order go back on the shelf
+type OrderRestockEvent struct {
+	OrderId   string             `json:"order_id"`
+	Reason    string             `json:"reason"`
+	Items     []RestockItemEvent `json:"items"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+type RestockItemEvent struct {
+	ProductId string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// LowStockEvent is published when a paid order takes a product to or below its reorder threshold
+type LowStockEvent struct {
+	ProductId        string    `json:"product_id"`
+	Name             string    `json:"name"`
+	Stock            int       `json:"stock"`
+	ReorderThreshold int       `json:"reorder_threshold"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// BackInStockEvent is published when an admin restock takes a product above zero stock
+type BackInStockEvent struct {
+	ProductId string    `json:"product_id"`
+	Name      string    `json:"name"`
+	Stock     int       `json:"stock"`
+	UserIds   []string  `json:"user_ids"` // customers that asked to be notified
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CartAbandonedEvent is published when a user leaves items in the cart without checking out
+type CartAbandonedEvent struct {
+	OrderId   string          `json:"order_id"`
+	UserId    string          `json:"user_id"`
+	LineItems []CartLineEvent `json:"line_items"`
+	IdleSince time.Time       `json:"idle_since"` // last time the cart was changed
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type CartLineEvent struct {
+	ProductId string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// ReturnStatusChangedEvent is published by the order service when a return is opened, reviewed, received or refunded
+type ReturnStatusChangedEvent struct {
+	ReturnId     string    `json:"return_id"`
+	OrderId      string    `json:"order_id"`
+	UserId       string    `json:"user_id"`
+	Status       string    `json:"status"`
+	Note         string    `json:"note,omitempty"`
+	RefundAmount int64     `json:"refund_amount"` // in paise
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AccountCreatedEvent is published by the user service when someone signs up
+type AccountCreatedEvent struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EmailVerificationRequested is published by the user service on signup and when a user asks for the link again
+type EmailVerificationRequested struct {
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	VerifyURL string    `json:"verify_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PasswordResetRequested is published by the user service when a user forgot the password
+type PasswordResetRequested struct {
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	ResetURL  string    `json:"reset_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserLocked is published by the user service when failed logins locked an account
+type UserLocked struct {
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	IP          string    `json:"ip"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `json:"created_at"`
+}
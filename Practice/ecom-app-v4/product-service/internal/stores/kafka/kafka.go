@@ -53,7 +53,7 @@ func ConsumeMessage(ctx context.Context, topicName string, ConsumerGroupName str
 			// returned from polls so that users can notice and take action.
 
 			//maybe kafka is down
-			slog.Error("ERROR: ", errs)
+			slog.Error("ERROR: ", slog.Any("errors", errs))
 			time.Sleep(10 * time.Second)
 			continue
 		}
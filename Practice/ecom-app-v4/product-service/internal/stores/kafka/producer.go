@@ -0,0 +1,86 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"product-service/pkg/logkey"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Conf holds the kafka client used by product-service to publish its own events
+type Conf struct {
+	client *kgo.Client
+}
+
+// NewConf initializes the kafka producer for product-service.
+// This function handles connection retries with exponential backoff.
+func NewConf() (*Conf, error) {
+	// Read Kafka connection details (host and port) from environment variables.
+	host := os.Getenv("KAFKA_HOST")
+	port := os.Getenv("KAFKA_PORT")
+
+	// If either the host or port is not set, return an error.
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kafka host or port is empty")
+	}
+
+	connString := fmt.Sprintf("%s:%s", host, port)
+
+	var err error
+	var client *kgo.Client
+
+	// Retry loop to handle temporary failures when setting up the Kafka client.
+	for i := 1; i < 8; i++ {
+		client, err = kgo.NewClient(
+			kgo.SeedBrokers(connString),  // Configure Kafka endpoint using the broker's connection string.
+			kgo.ProducerLinger(0),        // Messages won't linger; they are sent immediately.
+			kgo.AllowAutoTopicCreation(), // Allow Kafka to automatically create the target topic if it doesn't exist.
+		)
+		if err != nil {
+			slog.Error("kafka client error", slog.String(logkey.ERROR, err.Error()))
+			time.Sleep(time.Duration(2*i) * time.Second)
+			continue
+		}
+
+		// test the connection by pinging the Kafka cluster.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*4)
+		err = client.Ping(ctx)
+		cancel()
+		if err != nil {
+			slog.Error("kafka client ping error", slog.String(logkey.ERROR, err.Error()))
+			time.Sleep(time.Duration(2*i) * time.Second)
+			continue
+		}
+		break
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("kafka client error: %v", err)
+	}
+
+	return &Conf{client: client}, nil
+}
+
+func (c *Conf) ProduceMessage(topicName string, key []byte, value []byte) error {
+
+	ctx := context.Background()
+
+	// Define a Kafka message (record) with the topic and the message value.
+	record := &kgo.Record{Topic: topicName, Key: key, Value: value, Timestamp: time.Now().UTC()}
+
+	// Produce the record synchronously and wait for the broker's acknowledgment.
+	pr := c.client.ProduceSync(ctx, record)
+
+	err := pr.FirstErr()
+	if err != nil {
+		slog.Error("record had a produce error while synchronously producing", slog.String("Topic", topicName),
+			slog.String(logkey.ERROR, err.Error()))
+		return err
+	}
+
+	return nil
+}
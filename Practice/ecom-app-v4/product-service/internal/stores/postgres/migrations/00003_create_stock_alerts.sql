@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS reorder_threshold INTEGER NOT NULL DEFAULT 0 CHECK (reorder_threshold >= 0); -- 0 disables low stock alerts
+
+CREATE TABLE IF NOT EXISTS stock_subscriptions (
+    id UUID PRIMARY KEY, -- Unique identifier for the subscription
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE, -- Foreign key referencing products table
+    user_id UUID NOT NULL, -- customer waiting for the product
+    created_at TIMESTAMP,
+    notified_at TIMESTAMP -- set once the back in stock email is sent
+);
+
+-- a customer can only have one open subscription per product
+CREATE UNIQUE INDEX IF NOT EXISTS stock_subscriptions_open_idx
+    ON stock_subscriptions (product_id, user_id)
+    WHERE notified_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS stock_subscriptions;
+
+ALTER TABLE products DROP COLUMN IF EXISTS reorder_threshold;
+-- +goose StatementEnd
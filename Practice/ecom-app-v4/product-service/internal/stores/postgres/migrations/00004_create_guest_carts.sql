@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE cart ALTER COLUMN user_id DROP NOT NULL; -- NULL while the cart belongs to a guest
+
+ALTER TABLE cart
+    ADD COLUMN IF NOT EXISTS guest_id UUID; -- identifies an anonymous shopper through the signed cart token
+
+-- every line item belongs to either a user or a guest
+ALTER TABLE cart
+    ADD CONSTRAINT cart_owner_check CHECK (user_id IS NOT NULL OR guest_id IS NOT NULL);
+
+CREATE INDEX IF NOT EXISTS cart_guest_id_idx ON cart (guest_id) WHERE guest_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM cart WHERE user_id IS NULL;
+
+DROP INDEX IF EXISTS cart_guest_id_idx;
+
+ALTER TABLE cart DROP CONSTRAINT IF EXISTS cart_owner_check;
+
+ALTER TABLE cart DROP COLUMN IF EXISTS guest_id;
+
+ALTER TABLE cart ALTER COLUMN user_id SET NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE cart
+    ADD COLUMN IF NOT EXISTS reminded_at TIMESTAMP; -- last time the user was reminded about the abandoned cart
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE cart DROP COLUMN IF EXISTS reminded_at;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS wishlists (
+    id UUID PRIMARY KEY, -- Unique identifier for the saved product
+    user_id UUID NOT NULL,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE, -- Foreign key referencing products table
+    quantity INTEGER NOT NULL DEFAULT 1 CHECK (quantity >= 1), -- kept when a cart line is saved for later
+    saved_price BIGINT NOT NULL, -- price in paise when the product was saved, used for the price drop flag
+    saved_stock INTEGER NOT NULL, -- stock when the product was saved, used for the back in stock flag
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    UNIQUE (user_id, product_id) -- a product is saved only once per user
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS wishlists;
+-- +goose StatementEnd
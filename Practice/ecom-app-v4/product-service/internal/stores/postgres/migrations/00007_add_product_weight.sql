@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS weight_grams INTEGER NOT NULL DEFAULT 500 CHECK (weight_grams > 0); -- shipping weight of one unit
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE products DROP COLUMN IF EXISTS weight_grams;
+-- +goose StatementEnd
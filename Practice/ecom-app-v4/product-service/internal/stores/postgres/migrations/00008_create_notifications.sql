@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id UUID NOT NULL,
+    event_type TEXT NOT NULL, -- notification type, e.g. order_status or cart_reminder
+    email_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP,
+    PRIMARY KEY (user_id, event_type) -- types without a row are enabled
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+    id UUID PRIMARY KEY,
+    event_id TEXT NOT NULL UNIQUE, -- derived from the kafka event, a redelivered event is not sent twice
+    user_id UUID, -- empty for the admin notifications
+    event_type TEXT NOT NULL,
+    channel TEXT NOT NULL DEFAULT 'email',
+    recipient TEXT NOT NULL DEFAULT '',
+    subject TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'sent', 'failed', 'skipped')),
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS notification_deliveries_user_idx ON notification_deliveries (user_id, created_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS notification_deliveries;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS notification_preferences;
+-- +goose StatementEnd
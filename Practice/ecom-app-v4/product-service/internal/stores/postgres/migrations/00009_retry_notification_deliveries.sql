@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE notification_deliveries
+    ADD COLUMN IF NOT EXISTS payload JSONB, -- the notification to send, a failed delivery is sent again from it
+    ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 1;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS notification_deliveries_pending_idx ON notification_deliveries (updated_at)
+    WHERE status IN ('queued', 'failed');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS notification_deliveries_pending_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE notification_deliveries DROP COLUMN IF EXISTS attempts, DROP COLUMN IF EXISTS payload;
+-- +goose StatementEnd
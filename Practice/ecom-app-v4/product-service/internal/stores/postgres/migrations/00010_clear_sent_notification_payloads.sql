@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the payload holds the links of the email, e.g. password reset links, it is only kept for a delivery to be sent again
+UPDATE notification_deliveries
+SET payload = NULL
+WHERE payload IS NOT NULL AND (status NOT IN ('queued', 'failed') OR attempts >= 5);
+-- +goose StatementEnd
+
+-- +goose Down
+-- the payloads are gone, there is nothing to put back
@@ -11,16 +11,17 @@ import (
 	"os/signal"
 	"product-service/handlers"
 	"product-service/internal/auth"
+	"product-service/internal/cartjob"
 	"product-service/internal/consul"
+	"product-service/internal/notifications"
+	"product-service/internal/notify"
 	"product-service/internal/products"
 	"product-service/internal/stores/kafka"
 	"product-service/internal/stores/postgres"
-	email "product-service/mail"
 	"product-service/protohandler"
 	"syscall"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
@@ -98,21 +99,97 @@ func startApp() error {
 	*/
 
 	slog.Info("main : Started : Initializing authentication support")
-	publicPEM, err := os.ReadFile("pubkey.pem")
+	// tokens revoked by the user service are refused, the list is synced once consul is up
+	revoked := auth.NewRevocations()
+
+	// guest carts are identified by tokens signed with this secret
+	cartTokens, err := auth.NewCartTokens([]byte(os.Getenv("CART_TOKEN_SECRET")))
+	if err != nil {
+		return fmt.Errorf("initializing cart tokens %w", err)
+	}
+
+	/*
+		//------------------------------------------------------//
+		//   Setting up Kafka producer [PRODUCT SERVICE EVENTS]
+		//------------------------------------------------------//
+	*/
+	kafkaConf, err := kafka.NewConf()
 	if err != nil {
-		return fmt.Errorf("reading auth public key %w", err)
+		return err
 	}
 
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+	/*
+			//------------------------------------------------------//
+		               Registering with Consul
+			//------------------------------------------------------//
+	*/
+
+	consulClient, regId, err := consul.RegisterWithConsul()
 	if err != nil {
-		return fmt.Errorf("parsing auth public key %w", err)
+		return err
 	}
 
-	k, err := auth.NewKeys(publicKey)
+	defer consulClient.Agent().ServiceDeregister(regId)
+
+	// tokens are checked with the keys the user service publishes, a new kid loads them again
+	k, err := auth.NewKeys(auth.UserServiceJWKS(consulClient), revoked)
 	if err != nil {
 		return fmt.Errorf("initializing auth %w", err)
 	}
 
+	// the internal routes of the other services are called with a service token the user service issues
+	serviceTokens, err := auth.NewServiceTokens(
+		auth.UserServiceTokens(consulClient, os.Getenv("SERVICE_CLIENT_ID"), os.Getenv("SERVICE_CLIENT_SECRET")))
+	if err != nil {
+		return fmt.Errorf("initializing service tokens %w", err)
+	}
+
+	authSyncCtx, stopAuthSync := context.WithCancel(context.Background())
+	defer stopAuthSync()
+	go k.Sync(authSyncCtx, auth.JWKSSyncInterval())
+	go revoked.Poll(authSyncCtx, auth.RevocationSyncInterval(), auth.UserServiceRevocations(consulClient, serviceTokens))
+
+	/*
+		//------------------------------------------------------//
+		//   Setting up notifications, recipients are looked up in the user service
+		//------------------------------------------------------//
+	*/
+	notifyConfig, err := notify.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	directory, err := notify.NewUserDirectory(consulClient, serviceTokens)
+	if err != nil {
+		return err
+	}
+
+	notifier, err := notify.NewNotifier(notifyConfig, notify.NewSender(notifyConfig), directory)
+	if err != nil {
+		return err
+	}
+
+	notifyCtx, stopNotifier := context.WithCancel(context.Background())
+	notifier.Start(notifyCtx)
+	// queued emails get a few seconds to go out on shutdown, the retries are cut short after that
+	defer func() {
+		time.AfterFunc(5*time.Second, stopNotifier)
+		notifier.Close()
+		stopNotifier()
+	}()
+
+	// the domain events are turned into emails by their own consumer group, apart from the inventory consumers below
+	notificationStore, err := notifications.NewConf(db)
+	if err != nil {
+		return err
+	}
+
+	dispatcher, err := notifications.NewDispatcher(notificationStore, notifier, consulClient, serviceTokens)
+	if err != nil {
+		return err
+	}
+	dispatcher.Run(notifyCtx)
+
 	/*
 		/*
 			//------------------------------------------------------//
@@ -137,19 +214,62 @@ func startApp() error {
 			ctx := context.Background()
 			fmt.Println("decrement the stock of the product", event.OrderId)
 
-			p.DecrementStock(ctx, event.ProductId, event.Quantity)
-			fmt.Println("successfully decremented the stock of the product")
+			level, err := p.DecrementStock(ctx, event.ProductId, event.Quantity)
+			if err != nil {
+				slog.Error("error decrementing the stock", slog.String("ProductID", event.ProductId), slog.Any("error", err))
+			} else {
+				fmt.Println("successfully decremented the stock of the product")
+				if level.CrossedReorderThreshold() {
+					notifyLowStock(kafkaConf, level)
+				}
+			}
 
 			fmt.Println("moving line items for completed", event.OrderId)
 
 			p.UpdateCartStatusForOrderId(ctx, event.OrderId)
 
 			fmt.Println("line items moved to completed", event.OrderId)
+		}
+	}()
 
-			fmt.Println("Sending confirmation email:  ", event.OrderId)
-			email.SendEmail(event.OrderId)
-			fmt.Println("Sent order confirmation email:  ", event.OrderId)
+	go func() {
+		ch := make(chan kafka.ConsumeResult)
+		go kafka.ConsumeMessage(context.Background(), kafka.TopicOrderRestock, kafka.ConsumerGroup, ch)
+		for v := range ch {
+			if v.Err != nil {
+				slog.Error("error consuming order restocks", slog.Any("error", v.Err))
+				continue
+			}
+			var event kafka.OrderRestockEvent
+			err := json.Unmarshal(v.Record.Value, &event)
+			if err != nil {
+				slog.Error("error unmarshaling order restock event", slog.Any("error", err))
+				continue
+			}
 
+			ctx := context.Background()
+			for _, item := range event.Items {
+				level, err := p.RestockProduct(ctx, item.ProductId, item.Quantity)
+				if err != nil {
+					slog.Error("error restocking the product", slog.String("OrderID", event.OrderId),
+						slog.String("ProductID", item.ProductId), slog.Any("error", err))
+					continue
+				}
+				slog.Info("restocked product of order", slog.String("OrderID", event.OrderId),
+					slog.String("ProductID", item.ProductId), slog.Int("Stock", level.Stock))
+
+				if !level.BackInStock() {
+					continue
+				}
+				subs, err := p.ClaimBackInStockSubscriptions(ctx, item.ProductId)
+				if err != nil {
+					slog.Error("error fetching stock subscriptions", slog.String("ProductID", item.ProductId), slog.Any("error", err))
+					continue
+				}
+				if len(subs) > 0 {
+					handlers.NotifyBackInStock(kafkaConf, event.OrderId, level, subs)
+				}
+			}
 		}
 	}()
 
@@ -188,16 +308,23 @@ func startApp() error {
 
 	/*
 			//------------------------------------------------------//
-		               Registering with Consul
+		               Abandoned cart job
 			//------------------------------------------------------//
 	*/
 
-	consulClient, regId, err := consul.RegisterWithConsul()
+	cartJobConfig, err := cartjob.LoadConfig()
 	if err != nil {
 		return err
 	}
 
-	defer consulClient.Agent().ServiceDeregister(regId)
+	cartJob, err := cartjob.NewJob(cartJobConfig, p, kafkaConf, consulClient, serviceTokens)
+	if err != nil {
+		return err
+	}
+
+	jobCtx, stopJob := context.WithCancel(context.Background())
+	defer stopJob()
+	go cartJob.Run(jobCtx)
 
 	//setting up http server
 	port := os.Getenv("PORT")
@@ -212,7 +339,7 @@ func startApp() error {
 		WriteTimeout: 800 * time.Second,
 		IdleTimeout:  800 * time.Second,
 		//handlers.API returns gin.Engine which implements Handler Interface
-		Handler: handlers.API(consulClient, p, k),
+		Handler: handlers.API(consulClient, p, k, kafkaConf, notificationStore, cartTokens),
 	}
 	serverErrors := make(chan error)
 	go func() {
@@ -250,6 +377,28 @@ func startApp() error {
 
 }
 
+// notifyLowStock publishes the low stock event, the admins are emailed for it so the product can be reordered
+func notifyLowStock(kafkaConf *kafka.Conf, level products.StockLevel) {
+	slog.Info("product reached its reorder threshold", slog.String("ProductID", level.ProductID), slog.Int("Stock", level.Stock))
+
+	data, err := json.Marshal(kafka.LowStockEvent{
+		ProductId:        level.ProductID,
+		Name:             level.Name,
+		Stock:            level.Stock,
+		ReorderThreshold: level.ReorderThreshold,
+		CreatedAt:        time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Error("error marshaling low stock event", slog.Any("error", err))
+		return
+	}
+
+	err = kafkaConf.ProduceMessage(kafka.TopicLowStock, []byte(level.ProductID), data)
+	if err != nil {
+		slog.Error("error producing low stock event", slog.Any("error", err))
+	}
+}
+
 func setupSlog() {
 	logHandler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		//AddSource: true: This will cause the source file and line number of the log message to be included in the output
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"product-service/internal/auth"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testMid returns middleware that trusts a single key, and a function signing tokens with that key
+func testMid(t *testing.T) (*Mid, func(auth.Claims) string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := auth.JWKS{Keys: []auth.JWK{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: "k1",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	k, err := auth.NewKeys(func(ctx context.Context) (auth.JWKS, error) { return jwks, nil }, auth.NewRevocations())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(claims auth.Claims) string {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tkn.Header["kid"] = "k1"
+		token, err := tkn.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	return NewMid(k), sign
+}
+
+// serve runs the request through the handlers and returns the status and the subject of the claims the last one saw
+func serve(req *http.Request, handlers ...gin.HandlerFunc) (int, string) {
+	gin.SetMode(gin.TestMode)
+	var subject string
+	r := gin.New()
+	r.GET("/", append(handlers, func(c *gin.Context) {
+		if claims, ok := c.Request.Context().Value(auth.ClaimsKey).(auth.Claims); ok {
+			subject = claims.Subject
+		}
+		c.Status(http.StatusOK)
+	})...)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec.Code, subject
+}
+
+func TestOptionalAuthentication(t *testing.T) {
+	m, sign := testMid(t)
+	var user auth.Claims
+	user.Subject = "u1"
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantSubject   string
+	}{
+		{name: "guest", wantStatus: http.StatusOK},
+		{name: "signed in", authorization: "Bearer " + sign(user), wantStatus: http.StatusOK, wantSubject: "u1"},
+		{name: "invalid token", authorization: "Bearer not-a-token", wantStatus: http.StatusUnauthorized},
+		{name: "malformed header", authorization: "Basic dTE6cHc=", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			status, subject := serve(req, m.OptionalAuthentication())
+			if status != tt.wantStatus || subject != tt.wantSubject {
+				t.Errorf("got (%d, %q), want (%d, %q)", status, subject, tt.wantStatus, tt.wantSubject)
+			}
+		})
+	}
+}
+
+// the token an API key was exchanged for is kept from every handler that reads ClaimsKey,
+// only RequirePermission hands its claims on
+func TestAPIKeyToken(t *testing.T) {
+	m, sign := testMid(t)
+	var key auth.Claims
+	key.Subject = "u1"
+	key.APIKey = "key-1"
+	key.Permissions = []string{auth.PermProductsWrite}
+	token := "Bearer " + sign(key)
+
+	noop := func(c *gin.Context) {}
+	tests := []struct {
+		name        string
+		handlers    []gin.HandlerFunc
+		wantStatus  int
+		wantSubject string
+	}{
+		{name: "customer route", handlers: []gin.HandlerFunc{m.Authentication()}, wantStatus: http.StatusOK},
+		{name: "optional authentication", handlers: []gin.HandlerFunc{m.OptionalAuthentication()}, wantStatus: http.StatusOK},
+		{name: "granted permission", handlers: []gin.HandlerFunc{m.Authentication(), m.RequirePermission(noop, auth.PermProductsWrite)},
+			wantStatus: http.StatusOK, wantSubject: "u1"},
+		{name: "missing permission", handlers: []gin.HandlerFunc{m.Authentication(), m.RequirePermission(noop, "orders:refund")},
+			wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", token)
+			status, subject := serve(req, tt.handlers...)
+			if status != tt.wantStatus || subject != tt.wantSubject {
+				t.Errorf("got (%d, %q), want (%d, %q)", status, subject, tt.wantStatus, tt.wantSubject)
+			}
+		})
+	}
+}
@@ -26,8 +26,12 @@ func (p ProtoHandler) GetProductOrderDetail(ctx context.Context, req *pb.Product
 	//slog.Info("successfully got stripe customer id for", productID)
 
 	pbProdOrder := &pb.ProductOrderDetails{
-		PriceId: prodOrder.PriceId,
-		Stock:   int64(prodOrder.Stock),
+		PriceId:     prodOrder.PriceId,
+		Stock:       int64(prodOrder.Stock),
+		Name:        prodOrder.Name,
+		Price:       prodOrder.Price,
+		Category:    prodOrder.Category,
+		WeightGrams: int64(prodOrder.Weight),
 	}
 	return &pb.ProductOrderResponse{ProdOrder: pbProdOrder}, nil
 }
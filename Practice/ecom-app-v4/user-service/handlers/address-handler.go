@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// addressRequest returns the user of the request and the address id of the path when one is expected
+func (h *Handler) addressRequest(c *gin.Context, withId bool) (string, string, bool) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		slog.Error(
+			"missing claims",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "User Id is required"})
+		return "", "", false
+	}
+
+	if !withId {
+		return claims.Subject, "", true
+	}
+
+	addressId := c.Param("addressId")
+	if err := h.validate.Var(addressId, "required,uuid"); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "A valid address id is required"})
+		return "", "", false
+	}
+	return claims.Subject, addressId, true
+}
+
+// bindAddress reads and validates the address of the request body
+func (h *Handler) bindAddress(c *gin.Context) (users.NewAddress, bool) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	if c.Request.ContentLength > 5*1024 {
+		slog.Error("request body limit breached",
+			slog.String(logkey.TraceID, traceId),
+			slog.Int64("Size Received", c.Request.ContentLength),
+		)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "payload exceeding size limit"})
+		return users.NewAddress{}, false
+	}
+
+	var na users.NewAddress
+	err := c.ShouldBindJSON(&na)
+	if err != nil {
+		slog.Error("json validation error",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": http.StatusText(http.StatusBadRequest)})
+		return users.NewAddress{}, false
+	}
+
+	// country codes are stored upper case, a lower case one is accepted as well
+	na.Country = strings.ToUpper(strings.TrimSpace(na.Country))
+
+	err = h.validate.Struct(na)
+	if err != nil {
+		slog.Error("validation failed",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "please provide values in correct format"})
+		return users.NewAddress{}, false
+	}
+	return na, true
+}
+
+// addressError responds to a failed address operation
+func addressError(c *gin.Context, err error, msg string) {
+	if errors.Is(err, users.ErrAddressNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Address not found"})
+		return
+	}
+	slog.Error(msg,
+		slog.String(logkey.TraceID, ctxmanage.GetTraceIdOfRequest(c)),
+		slog.String(logkey.ERROR, err.Error()),
+	)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Address operation failed"})
+}
+
+func (h *Handler) CreateAddress(c *gin.Context) {
+	userId, _, ok := h.addressRequest(c, false)
+	if !ok {
+		return
+	}
+	na, ok := h.bindAddress(c)
+	if !ok {
+		return
+	}
+
+	address, err := h.u.CreateAddress(c.Request.Context(), userId, na)
+	if err != nil {
+		addressError(c, err, "error in creating the address")
+		return
+	}
+	c.JSON(http.StatusCreated, address)
+}
+
+func (h *Handler) ListAddresses(c *gin.Context) {
+	userId, _, ok := h.addressRequest(c, false)
+	if !ok {
+		return
+	}
+
+	addresses, err := h.u.ListAddresses(c.Request.Context(), userId)
+	if err != nil {
+		addressError(c, err, "error in fetching the addresses")
+		return
+	}
+	c.JSON(http.StatusOK, addresses)
+}
+
+// GetAddress is also used by the order service at checkout with the token of the user
+func (h *Handler) GetAddress(c *gin.Context) {
+	userId, addressId, ok := h.addressRequest(c, true)
+	if !ok {
+		return
+	}
+
+	address, err := h.u.GetAddress(c.Request.Context(), userId, addressId)
+	if err != nil {
+		addressError(c, err, "error in fetching the address")
+		return
+	}
+	c.JSON(http.StatusOK, address)
+}
+
+func (h *Handler) UpdateAddress(c *gin.Context) {
+	userId, addressId, ok := h.addressRequest(c, true)
+	if !ok {
+		return
+	}
+	na, ok := h.bindAddress(c)
+	if !ok {
+		return
+	}
+
+	address, err := h.u.UpdateAddress(c.Request.Context(), userId, addressId, na)
+	if err != nil {
+		addressError(c, err, "error in updating the address")
+		return
+	}
+	c.JSON(http.StatusOK, address)
+}
+
+func (h *Handler) SetDefaultAddress(c *gin.Context) {
+	userId, addressId, ok := h.addressRequest(c, true)
+	if !ok {
+		return
+	}
+
+	err := h.u.SetDefaultAddress(c.Request.Context(), userId, addressId)
+	if err != nil {
+		addressError(c, err, "error in setting the default address")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Default address updated"})
+}
+
+func (h *Handler) DeleteAddress(c *gin.Context) {
+	userId, addressId, ok := h.addressRequest(c, true)
+	if !ok {
+		return
+	}
+
+	err := h.u.DeleteAddress(c.Request.Context(), userId, addressId)
+	if err != nil {
+		addressError(c, err, "error in deleting the address")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Address deleted"})
+}
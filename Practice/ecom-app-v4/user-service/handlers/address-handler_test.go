@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user-service/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+const validAddress = `{"full_name": "Asha Rao", "phone": "9876543210", "line1": "12 MG Road", "city": "Bengaluru",
+	"state": "Karnataka", "postal_code": "560001"`
+
+func TestBindAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{validate: validator.New()}
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantCountry string
+	}{
+		{name: "default country", body: validAddress + `}`, wantStatus: http.StatusOK},
+		{name: "upper case country", body: validAddress + `, "country": "DE"}`, wantStatus: http.StatusOK, wantCountry: "DE"},
+		{name: "lower case country", body: validAddress + `, "country": " de "}`, wantStatus: http.StatusOK, wantCountry: "DE"},
+		{name: "unknown country", body: validAddress + `, "country": "XX"}`, wantStatus: http.StatusBadRequest},
+		{name: "missing city", body: strings.Replace(validAddress, `"city": "Bengaluru",`, ``, 1) + `}`, wantStatus: http.StatusBadRequest},
+		{name: "not json", body: `city=Bengaluru`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var country string
+			r := gin.New()
+			r.POST("/addresses", func(c *gin.Context) {
+				na, ok := h.bindAddress(c)
+				if !ok {
+					return
+				}
+				country = na.Country
+				c.Status(http.StatusOK)
+			})
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/addresses", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus || country != tt.wantCountry {
+				t.Errorf("got (%d, %q), want (%d, %q)", rec.Code, country, tt.wantStatus, tt.wantCountry)
+			}
+		})
+	}
+}
+
+// the requests are refused before the address book is read, so the handler needs no store
+func TestAddressMalformedID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{validate: validator.New()}
+
+	tests := []struct {
+		name    string
+		method  string
+		route   string
+		handler gin.HandlerFunc
+	}{
+		{name: "get", method: http.MethodGet, route: "/addresses/:addressId", handler: h.GetAddress},
+		{name: "update", method: http.MethodPut, route: "/addresses/:addressId", handler: h.UpdateAddress},
+		{name: "delete", method: http.MethodDelete, route: "/addresses/:addressId", handler: h.DeleteAddress},
+		{name: "set default", method: http.MethodPost, route: "/addresses/:addressId/default", handler: h.SetDefaultAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Handle(tt.method, tt.route, tt.handler)
+
+			path := strings.Replace(tt.route, ":addressId", "1;drop", 1)
+			req := httptest.NewRequest(tt.method, path, strings.NewReader(validAddress+`}`))
+			var claims auth.Claims
+			claims.Subject = "0b6f5c1e-6a43-4d59-9a0e-3f8a7f1f2a11"
+			req = req.WithContext(context.WithValue(req.Context(), auth.ClaimsKey, claims))
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"user-service/internal/auth"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultUsersLimit = 20
+	maxUsersLimit     = 100
+)
+
+// ListUsers lets admins page through and search the users.
+// ?q= matches name and email, ?role= and ?disabled=true|false narrow the list, ?limit= and ?offset= page through it.
+func (h *Handler) ListUsers(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultUsersLimit)))
+	if err != nil || limit < 1 || limit > maxUsersLimit {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+
+	filter := users.UserFilter{Query: c.Query("q"), Role: c.Query("role"), Limit: limit, Offset: offset}
+	if v := c.Query("disabled"); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "disabled must be true or false"})
+			return
+		}
+		filter.Disabled = &disabled
+	}
+
+	page, err := h.u.ListUsers(c.Request.Context(), filter)
+	if err != nil {
+		slog.Error("error listing users", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetUserAdmin returns any user to an admin
+func (h *Handler) GetUserAdmin(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	user, err := h.u.GetUser(c.Request.Context(), c.Param("userId"))
+	if err != nil {
+		h.accountError(c, traceId, "error fetching user", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateUserRoles replaces the roles of a user, the user is logged out so the next token carries them.
+// Admins can not give themselves roles without the permission to manage users,
+// so the last admin can not lock everyone out by accident.
+func (h *Handler) UpdateUserRoles(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	var req users.RolesUpdate
+	err = c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "roles must be a non empty list of role names"})
+		return
+	}
+
+	userId := c.Param("userId")
+	if userId == claims.Subject {
+		permissions, err := h.u.RolePermissions(c.Request.Context(), req.Roles)
+		if err != nil {
+			h.accountError(c, traceId, "error fetching permissions", err)
+			return
+		}
+		if !slices.Contains(permissions, auth.PermUsersManage) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "You can not remove your own permission to manage users"})
+			return
+		}
+	}
+
+	user, err := h.u.SetRoles(c.Request.Context(), userId, req.Roles)
+	if err != nil {
+		if errors.Is(err, users.ErrUnknownRole) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+			return
+		}
+		h.accountError(c, traceId, "error updating roles", err)
+		return
+	}
+
+	slog.Info("user roles changed", slog.String(logkey.TraceID, traceId), slog.String("UserID", user.ID),
+		slog.Any("Roles", req.Roles), slog.String("By", claims.Subject))
+	c.JSON(http.StatusOK, user)
+}
+
+// DisableUser disables the account of a user and logs them out everywhere
+func (h *Handler) DisableUser(c *gin.Context) {
+	h.setDisabled(c, true)
+}
+
+// EnableUser lets a disabled user log in again
+func (h *Handler) EnableUser(c *gin.Context) {
+	h.setDisabled(c, false)
+}
+
+func (h *Handler) setDisabled(c *gin.Context, disabled bool) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	userId := c.Param("userId")
+	if userId == claims.Subject && disabled {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "You can not disable your own account"})
+		return
+	}
+
+	user, err := h.u.SetDisabled(c.Request.Context(), userId, disabled)
+	if err != nil {
+		h.accountError(c, traceId, "error updating account", err)
+		return
+	}
+
+	slog.Info("user account updated", slog.String(logkey.TraceID, traceId), slog.String("UserID", user.ID),
+		slog.Bool("Disabled", disabled), slog.String("By", claims.Subject))
+	c.JSON(http.StatusOK, user)
+}
+
+// accountError answers a failed lookup or update of a user
+func (h *Handler) accountError(c *gin.Context, traceId, msg string, err error) {
+	if errors.Is(err, users.ErrUserNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	slog.Error(msg, slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+}
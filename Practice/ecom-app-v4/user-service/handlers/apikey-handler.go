@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateAPIKey creates a key for your own account, the key is in the response and can not be shown again
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+	h.createAPIKey(c, claims.Subject, claims.Subject)
+}
+
+// ListAPIKeys returns the keys of your own account
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+	h.listAPIKeys(c, claims.Subject)
+}
+
+// RevokeAPIKey revokes a key of your own account
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+	h.revokeAPIKey(c, claims.Subject, claims.Subject)
+}
+
+// CreateUserAPIKey lets an admin create a key owned by a user, e.g. an account set up for a partner integration
+func (h *Handler) CreateUserAPIKey(c *gin.Context) {
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+	h.createAPIKey(c, c.Param("userId"), claims.Subject)
+}
+
+// ListUserAPIKeys returns the keys of any user to an admin
+func (h *Handler) ListUserAPIKeys(c *gin.Context) {
+	h.listAPIKeys(c, c.Param("userId"))
+}
+
+// RevokeUserAPIKey lets an admin revoke a key of any user
+func (h *Handler) RevokeUserAPIKey(c *gin.Context) {
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+	h.revokeAPIKey(c, c.Param("userId"), claims.Subject)
+}
+
+func (h *Handler) createAPIKey(c *gin.Context, ownerId, createdBy string) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var req users.NewAPIKey
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err == nil {
+		err = h.validate.Var(ownerId, "required,uuid")
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "a key needs a name and a list of permissions, it expires after 1 to 365 days or never"})
+		return
+	}
+
+	key, err := h.u.CreateAPIKey(c.Request.Context(), ownerId, createdBy, req)
+	if err != nil {
+		if errors.Is(err, users.ErrPermissionNotHeld) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "A key can only have permissions its owner has"})
+			return
+		}
+		h.accountError(c, traceId, "error creating api key", err)
+		return
+	}
+
+	slog.Info("api key created", slog.String(logkey.TraceID, traceId), slog.String("APIKeyID", key.ID),
+		slog.String("UserID", key.UserID), slog.Any("Permissions", key.Permissions), slog.String("By", createdBy))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, key)
+}
+
+func (h *Handler) listAPIKeys(c *gin.Context, userId string) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	if err := h.validate.Var(userId, "required,uuid"); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	keys, err := h.u.ListAPIKeys(c.Request.Context(), userId)
+	if err != nil {
+		slog.Error("error listing api keys", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (h *Handler) revokeAPIKey(c *gin.Context, userId, revokedBy string) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	keyId := c.Param("keyId")
+	if h.validate.Var(userId, "required,uuid") != nil || h.validate.Var(keyId, "required,uuid") != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	key, err := h.u.RevokeAPIKey(c.Request.Context(), userId, keyId)
+	if err != nil {
+		if errors.Is(err, users.ErrAPIKeyNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		slog.Error("error revoking api key", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	slog.Info("api key revoked", slog.String(logkey.TraceID, traceId), slog.String("APIKeyID", key.ID),
+		slog.String("UserID", key.UserID), slog.String("By", revokedBy))
+	c.JSON(http.StatusOK, key)
+}
+
+// ExchangeAPIKeyInternal gives the gateway an access token for the API key of a request, the gateway
+// sends it on in place of the key so the services check it like any other token
+func (h *Handler) ExchangeAPIKeyInternal(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var req struct {
+		APIKey string `json:"api_key" validate:"required,max=200"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "api_key is required"})
+		return
+	}
+
+	claims, err := h.u.ExchangeAPIKey(c.Request.Context(), req.APIKey)
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidAPIKey) {
+			slog.Error("invalid api key", slog.String(logkey.TraceID, traceId))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			return
+		}
+		slog.Error("error exchanging api key", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	token, err := h.a.GenerateToken(claims)
+	if err != nil {
+		slog.Error("error signing api key token", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	slog.Info("api key exchanged", slog.String(logkey.TraceID, traceId), slog.String("APIKeyID", claims.APIKey),
+		slog.String("UserID", claims.Subject))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_at":   claims.ExpiresAt.Time,
+	})
+}
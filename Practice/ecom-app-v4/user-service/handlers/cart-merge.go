@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"user-service/internal/consul"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the product service hands guests their cart token in this header or cookie
+const (
+	cartTokenHeader = "X-Cart-Token"
+	cartTokenCookie = "cart_token"
+)
+
+// cartTokenFromRequest returns the guest cart token sent with the request, empty if there is none
+func cartTokenFromRequest(c *gin.Context) string {
+	token := c.GetHeader(cartTokenHeader)
+	if token != "" {
+		return token
+	}
+	cookie, err := c.Cookie(cartTokenCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie
+}
+
+// mergeGuestCart asks the product service to move the guest cart into the cart of the user.
+// A failed merge does not fail the login, the guest cart stays with the token and can be merged later.
+func (h *Handler) mergeGuestCart(c *gin.Context, traceId string, userId string) {
+	token := cartTokenFromRequest(c)
+	if token == "" {
+		return
+	}
+
+	err := h.requestCartMerge(c.Request.Context(), traceId, userId, token)
+	if err != nil {
+		slog.Error("error in merging the guest cart",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		return
+	}
+
+	// the guest cart is gone, so the token is of no use anymore
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(cartTokenCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+}
+
+func (h *Handler) requestCartMerge(ctx context.Context, traceId string, userId string, token string) error {
+	requestBody, err := json.Marshal(map[string]string{
+		"user_id":    userId,
+		"cart_token": token,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling request body: %w", err)
+	}
+
+	address, port, err := consul.GetServiceAddress(h.client, "products")
+	if err != nil {
+		return fmt.Errorf("product service unavailable: %w", err)
+	}
+
+	httpQuery := fmt.Sprintf("http://%s:%d/products/internal/cart/merge", address, port)
+	slog.Info("httpQuery: "+httpQuery, slog.String(logkey.TraceID, traceId))
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, httpQuery, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.st.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling product service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("product service responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
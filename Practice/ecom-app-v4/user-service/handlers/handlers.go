@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"user-service/internal/auth"
+	"user-service/internal/oidc"
 	"user-service/internal/stores/kafka"
 	"user-service/internal/users"
 	"user-service/middleware"
@@ -12,32 +14,65 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	consulapi "github.com/hashicorp/consul/api"
 )
 
 type Handler struct {
+	client   *consulapi.Client
 	u        *users.Conf
 	validate *validator.Validate
 	k        *kafka.Conf
 	a        *auth.Keys
+	vt       *auth.VerificationTokens
+	totp     *auth.TOTP
+	oidc     map[string]oidc.Provider // identity providers users can sign in with, by name
+	sc       *auth.ServiceClients     // the services that may ask for service tokens
+	st       *auth.ServiceTokens      // the token the user service calls the internal routes of the others with
+
+	// the verification link mailed to users, the token is added as a query parameter
+	verifyURL string
+	// the page the password reset link opens, the token is added as a query parameter
+	resetURL string
+
+	loginPolicy users.LoginPolicy
 }
 
-func NewHandler(u *users.Conf, a *auth.Keys, k *kafka.Conf) *Handler {
+func NewHandler(client *consulapi.Client, u *users.Conf, a *auth.Keys, vt *auth.VerificationTokens, totp *auth.TOTP, providers map[string]oidc.Provider, sc *auth.ServiceClients, st *auth.ServiceTokens, k *kafka.Conf, verifyURL, resetURL string) *Handler {
 	return &Handler{
-		u:        u,
-		k:        k,
-		a:        a,
-		validate: validator.New(),
+		client:      client,
+		u:           u,
+		k:           k,
+		a:           a,
+		vt:          vt,
+		totp:        totp,
+		oidc:        providers,
+		sc:          sc,
+		st:          st,
+		verifyURL:   verifyURL,
+		resetURL:    resetURL,
+		loginPolicy: users.LoginPolicyFromEnv(),
+		validate:    validator.New(),
 	}
 }
 
-func API(u *users.Conf, a *auth.Keys, k *kafka.Conf) *gin.Engine {
+func API(client *consulapi.Client, u *users.Conf, a *auth.Keys, vt *auth.VerificationTokens, totp *auth.TOTP, providers map[string]oidc.Provider, sc *auth.ServiceClients, st *auth.ServiceTokens, k *kafka.Conf) *gin.Engine {
 	r := gin.New()
 	mode := os.Getenv("GIN_MODE")
 	if mode == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	h := NewHandler(u, a, k)
+	verifyURL := os.Getenv("EMAIL_VERIFICATION_URL")
+	if verifyURL == "" {
+		verifyURL = "http://localhost/users/verify"
+	}
+
+	resetURL := os.Getenv("PASSWORD_RESET_URL")
+	if resetURL == "" {
+		resetURL = "http://localhost/reset-password"
+	}
+
+	h := NewHandler(client, u, a, vt, totp, providers, sc, st, k, verifyURL, resetURL)
 	m, err := middleware.NewMid(a)
 	if err != nil {
 		panic(err)
@@ -47,12 +82,37 @@ func API(u *users.Conf, a *auth.Keys, k *kafka.Conf) *gin.Engine {
 	if prefix == "" {
 		panic("SERVICE_ENDPOINT_PREFIX is not set")
 	}
+	// the client address is taken from the X-Forwarded-For the gateway sets, it is ignored from anyone else.
+	// With no TRUSTED_PROXIES every request seems to come from its peer, the IP throttling then counts the gateway.
+	r.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	err = r.SetTrustedProxies(trustedProxies())
+	if err != nil {
+		panic(err)
+	}
+
 	r.Use(gin.Logger(), gin.Recovery(), middleware.Logger())
 	r.GET("/ping", healthCheck)
 	v1 := r.Group(prefix)
 	{
 		v1.POST("/signup", h.Signup)
 		v1.POST("/login", h.Login)
+		v1.POST("/login/mfa", h.LoginMFA)
+		v1.GET("/oauth/providers", h.ListOAuthProviders)
+		v1.POST("/oauth/:provider/start", h.StartOAuthLogin)
+		v1.POST("/oauth/:provider/callback", h.OAuthCallback)
+		v1.POST("/refresh", h.Refresh)
+		v1.GET("/verify", h.VerifyEmail)
+		v1.POST("/verify/resend", h.ResendVerification)
+		v1.GET("/.well-known/jwks.json", h.JWKS)
+		v1.POST("/password/forgot", h.ForgotPassword)
+		v1.POST("/password/reset", h.ResetPassword)
+
+		//called by the other services, they get a service token first and the gateway does not expose these
+		v1.POST("/internal/token", h.IssueServiceToken)
+		v1.GET("/internal/:userId/contact", m.RequireService(h.GetContactInternal, "product-service"))
+		v1.GET("/internal/:userId/stripe", m.RequireService(h.GetStripeDetailsInternal, "order-service"))
+		v1.GET("/internal/revocations", m.RequireService(h.GetRevocationsInternal))
+		v1.POST("/internal/api-keys/exchange", m.RequireService(h.ExchangeAPIKeyInternal, "gateway-service"))
 
 		// this middleware would be applied to the handler functions which are after it
 		// it would not apply to the previous one
@@ -66,10 +126,58 @@ func API(u *users.Conf, a *auth.Keys, k *kafka.Conf) *gin.Engine {
 			c.JSON(200, gin.H{"Auth Check": "You are authenticated " + claims.Subject})
 		})
 		v1.GET("/stripe", h.GetStripeDetails)
+		v1.POST("/logout", m.RequireLogin(h.Logout))
+		v1.POST("/password/change", m.RequireLogin(h.ChangePassword))
+		v1.GET("/me", h.GetMe)
+		v1.PATCH("/me", m.RequireLogin(h.UpdateMe))
+		v1.GET("/mfa", h.GetMFAStatus)
+		v1.POST("/mfa/totp/enroll", m.RequireLogin(h.EnrollTOTP))
+		v1.POST("/mfa/totp/confirm", m.RequireLogin(h.ConfirmTOTP))
+		v1.POST("/mfa/disable", m.RequireLogin(h.DisableMFA))
+		v1.POST("/mfa/recovery-codes", m.RequireLogin(h.RegenerateRecoveryCodes))
+
+		//API keys let tools call the APIs as their owner, the gateway exchanges them for tokens
+		v1.GET("/api-keys", m.RequireLogin(h.ListAPIKeys))
+		v1.POST("/api-keys", m.RequireLogin(m.RequirePermission(h.CreateAPIKey, auth.PermAPIKeysCreate)))
+		v1.DELETE("/api-keys/:keyId", m.RequireLogin(h.RevokeAPIKey))
+
+		v1.GET("/addresses", m.RequireLogin(h.ListAddresses))
+		v1.POST("/addresses", m.RequireLogin(h.CreateAddress))
+		v1.GET("/addresses/:addressId", m.RequireLogin(h.GetAddress))
+		v1.PUT("/addresses/:addressId", m.RequireLogin(h.UpdateAddress))
+		v1.DELETE("/addresses/:addressId", m.RequireLogin(h.DeleteAddress))
+		v1.POST("/addresses/:addressId/default", m.RequireLogin(h.SetDefaultAddress))
+
+		v1.GET("/admin/users", m.RequirePermission(h.ListUsers, auth.PermUsersManage))
+		v1.GET("/admin/users/:userId", m.RequirePermission(h.GetUserAdmin, auth.PermUsersManage))
+		v1.PUT("/admin/users/:userId/roles", m.RequirePermission(h.UpdateUserRoles, auth.PermUsersManage))
+		v1.POST("/admin/users/:userId/disable", m.RequirePermission(h.DisableUser, auth.PermUsersManage))
+		v1.POST("/admin/users/:userId/enable", m.RequirePermission(h.EnableUser, auth.PermUsersManage))
+		v1.POST("/admin/users/:userId/unlock", m.RequirePermission(h.UnlockUser, auth.PermUsersManage))
+		v1.DELETE("/admin/users/:userId/mfa", m.RequirePermission(h.ResetUserMFA, auth.PermUsersManage))
+		v1.GET("/admin/users/:userId/api-keys", m.RequirePermission(h.ListUserAPIKeys, auth.PermUsersManage))
+		v1.POST("/admin/users/:userId/api-keys", m.RequireLogin(m.RequirePermission(h.CreateUserAPIKey, auth.PermUsersManage)))
+		v1.DELETE("/admin/users/:userId/api-keys/:keyId", m.RequirePermission(h.RevokeUserAPIKey, auth.PermUsersManage))
+
+		v1.GET("/admin/permissions", m.RequirePermission(h.ListPermissions, auth.PermUsersManage))
+		v1.GET("/admin/roles", m.RequirePermission(h.ListRoles, auth.PermUsersManage))
+		v1.PUT("/admin/roles/:role", m.RequirePermission(h.PutRole, auth.PermUsersManage))
+		v1.DELETE("/admin/roles/:role", m.RequirePermission(h.DeleteRole, auth.PermUsersManage))
 	}
 	return r
 }
 
+// trustedProxies returns the addresses or CIDRs of TRUSTED_PROXIES (comma separated), the gateway's
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
 func healthCheck(c *gin.Context) {
 	traceId := ctxmanage.GetTraceIdOfRequest(c)
 
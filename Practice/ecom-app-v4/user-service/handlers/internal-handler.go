@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+	"user-service/internal/auth"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetContactInternal lets other services look up where to reach a user,
+// the product service uses it to address notification emails
+func (h *Handler) GetContactInternal(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	userId := c.Param("userId")
+	if err := h.validate.Var(userId, "required,uuid"); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	}
+
+	contact, err := h.u.GetContact(c.Request.Context(), userId)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "User not found"})
+			return
+		}
+		slog.Error("error fetching user contact", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch user contact"})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+// IssueServiceToken hands out service tokens like an OAuth2 client credentials grant.
+// The service sends grant_type=client_credentials as a form and its client id and secret with basic auth
+// or as the client_id and client_secret fields, the token opens the internal routes for a few minutes.
+func (h *Handler) IssueServiceToken(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	if c.PostForm("grant_type") != "client_credentials" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+		return
+	}
+
+	clientId, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientId, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	if !h.sc.Authenticate(clientId, secret) {
+		slog.Error("invalid service client credentials", slog.String(logkey.TraceID, traceId), slog.String("ClientID", clientId))
+		c.Header("WWW-Authenticate", `Basic realm="internal"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return
+	}
+
+	now := time.Now()
+	token, err := h.a.GenerateToken(auth.NewServiceClaims(clientId, now))
+	if err != nil {
+		slog.Error("error signing service token", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	slog.Info("issued service token", slog.String(logkey.TraceID, traceId), slog.String("ClientID", clientId))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(auth.ServiceTokenTTL.Seconds()),
+	})
+}
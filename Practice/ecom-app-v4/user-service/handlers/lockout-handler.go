@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+	"user-service/internal/stores/kafka"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginFailed records the failed login and answers it. Every case ends in the same answer,
+// it does not tell whether the email exists.
+func (h *Handler) loginFailed(c *gin.Context, traceId, email string) {
+	h.recordLoginFailure(c, traceId, email)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+}
+
+// recordLoginFailure counts a wrong password or two factor code towards the lock of the email, notifies the owner
+// when it locked the account and holds the answer back as long as the policy asks
+func (h *Handler) recordLoginFailure(c *gin.Context, traceId, email string) {
+	ip := c.ClientIP()
+
+	failure, err := h.u.RecordLoginFailure(c.Request.Context(), email, ip, h.loginPolicy)
+	if err != nil {
+		slog.Error("error recording login failure", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+	}
+
+	if failure.Locked {
+		slog.Warn("login locked after too many failures", slog.String(logkey.TraceID, traceId),
+			slog.String("IP", ip), slog.Time("LockedUntil", failure.LockedUntil))
+		go h.notifyLocked(traceId, email, ip, failure.LockedUntil)
+	}
+
+	if failure.Delay > 0 {
+		select {
+		case <-time.After(failure.Delay):
+		case <-c.Request.Context().Done():
+		}
+	}
+}
+
+// notifyLocked publishes that the account with the email got locked, the product service emails the owner.
+// Emails without an account are locked the same way, there is just nobody to tell.
+func (h *Handler) notifyLocked(traceId, email, ip string, lockedUntil time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	contact, err := h.u.GetContactByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, users.ErrUserNotFound) {
+			slog.Error("error fetching locked user", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		}
+		return
+	}
+
+	data, err := json.Marshal(kafka.MSGUserLocked{
+		UserID:      contact.ID,
+		Name:        contact.Name,
+		Email:       contact.Email,
+		IP:          ip,
+		LockedUntil: lockedUntil,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Error("error in marshaling user locked event", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		return
+	}
+
+	err = h.k.ProduceMessage(kafka.TopicUserLocked, []byte(contact.ID), data)
+	if err != nil {
+		slog.Error("error in producing message", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+	}
+}
+
+// UnlockUser lets an admin lift the login lock of a user before it runs out
+func (h *Handler) UnlockUser(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	user, err := h.u.UnlockUser(c.Request.Context(), c.Param("userId"))
+	if err != nil {
+		h.accountError(c, traceId, "error unlocking user", err)
+		return
+	}
+
+	slog.Info("user unlocked", slog.String(logkey.TraceID, traceId), slog.String("UserID", user.ID), slog.String("By", claims.Subject))
+	c.JSON(http.StatusOK, user)
+}
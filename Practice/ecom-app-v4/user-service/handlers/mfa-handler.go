@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MFACodeRequest carries a code from the authenticator app, or a recovery code where those are accepted
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required,max=20"`
+}
+
+// DisableMFARequest asks for the password on top of a code, a stolen session alone can not turn it off
+type DisableMFARequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required,max=20"`
+}
+
+// MFALoginRequest is the second login step
+type MFALoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required,max=20"`
+}
+
+// GetMFAStatus tells the logged in user whether two factor authentication is on and how many recovery codes are left
+func (h *Handler) GetMFAStatus(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	status, err := h.u.GetMFAStatus(c.Request.Context(), claims.Subject)
+	if err != nil {
+		h.accountError(c, traceId, "error fetching mfa status", err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// EnrollTOTP creates a secret for the authenticator app. The provisioning uri is what the client shows as a QR code,
+// the secret is for typing it in by hand. Nothing changes for logins until ConfirmTOTP accepted a code.
+func (h *Handler) EnrollTOTP(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	user, err := h.u.GetUser(c.Request.Context(), claims.Subject)
+	if err != nil {
+		h.accountError(c, traceId, "error fetching user", err)
+		return
+	}
+
+	secret, err := h.u.StartTOTPEnrollment(c.Request.Context(), user.ID, h.totp)
+	if err != nil {
+		h.mfaError(c, traceId, "error starting totp enrollment", err)
+		return
+	}
+
+	slog.Info("totp enrollment started", slog.String(logkey.TraceID, traceId), slog.String("UserID", user.ID))
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": h.totp.ProvisioningURI(user.Email, secret),
+	})
+}
+
+// ConfirmTOTP turns two factor authentication on with the first code from the app and returns the recovery codes.
+// They are shown once. Every session of the user is logged out, including this one.
+func (h *Handler) ConfirmTOTP(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	var req MFACodeRequest
+	err = c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	codes, err := h.u.ConfirmTOTP(c.Request.Context(), claims.Subject, req.Code, h.totp)
+	if err != nil {
+		h.mfaError(c, traceId, "error confirming totp", err)
+		return
+	}
+
+	slog.Info("two factor authentication enabled", slog.String(logkey.TraceID, traceId), slog.String("UserID", claims.Subject))
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two factor authentication enabled, please log in again",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA turns two factor authentication off, every session of the user is logged out
+func (h *Handler) DisableMFA(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	var req DisableMFARequest
+	err = c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "password and code are required"})
+		return
+	}
+
+	err = h.u.DisableMFA(c.Request.Context(), claims.Subject, req.Password, req.Code, h.totp)
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidPassword) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+			return
+		}
+		h.mfaError(c, traceId, "error disabling mfa", err)
+		return
+	}
+
+	slog.Info("two factor authentication disabled", slog.String(logkey.TraceID, traceId), slog.String("UserID", claims.Subject))
+	c.JSON(http.StatusOK, gin.H{"message": "Two factor authentication disabled, please log in again"})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, the old ones stop working
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	var req MFACodeRequest
+	err = c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	codes, err := h.u.RegenerateRecoveryCodes(c.Request.Context(), claims.Subject, req.Code, h.totp)
+	if err != nil {
+		h.mfaError(c, traceId, "error regenerating recovery codes", err)
+		return
+	}
+
+	slog.Info("recovery codes regenerated", slog.String(logkey.TraceID, traceId), slog.String("UserID", claims.Subject))
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// LoginMFA is the second login step, it exchanges the challenge from Login and a code for the tokens
+func (h *Handler) LoginMFA(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var req MFALoginRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "challenge_token and code are required"})
+		return
+	}
+
+	user, err := h.u.CompleteMFAChallenge(c.Request.Context(), req.ChallengeToken, req.Code, h.totp)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrInvalidChallenge):
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login, please log in again"})
+		case errors.Is(err, users.ErrInvalidMFACode):
+			// a wrong code counts like a wrong password, new challenges can not be used to guess on and on
+			slog.Warn("wrong two factor code at login", slog.String(logkey.TraceID, traceId), slog.String("IP", c.ClientIP()))
+			h.recordLoginFailure(c, traceId, user.Email)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		default:
+			h.mfaError(c, traceId, "error completing mfa login", err)
+		}
+		return
+	}
+
+	// the account may have been disabled since the password was checked
+	if user.Disabled {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+		return
+	}
+	h.clearLoginFailures(c, traceId, user.Email)
+
+	claims, err := h.u.Claims(c.Request.Context(), user)
+	if err != nil {
+		slog.Error("Error in building claims", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	h.completeLogin(c, traceId, user, claims)
+}
+
+// ResetUserMFA lets an admin turn off two factor authentication for a user who lost the app and the recovery codes
+func (h *Handler) ResetUserMFA(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	user, err := h.u.ResetMFA(c.Request.Context(), c.Param("userId"))
+	if err != nil {
+		h.accountError(c, traceId, "error resetting mfa", err)
+		return
+	}
+
+	slog.Info("mfa reset", slog.String(logkey.TraceID, traceId), slog.String("UserID", user.ID), slog.String("By", claims.Subject))
+	c.JSON(http.StatusOK, user)
+}
+
+// mfaError answers the errors two factor changes have in common
+func (h *Handler) mfaError(c *gin.Context, traceId, msg string, err error) {
+	switch {
+	case errors.Is(err, users.ErrInvalidMFACode):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+	case errors.Is(err, users.ErrMFAAlreadyEnabled):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Two factor authentication is already enabled"})
+	case errors.Is(err, users.ErrMFANotEnabled):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Two factor authentication is not enabled"})
+	case errors.Is(err, users.ErrNoMFAEnrollment):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Please start the enrollment first"})
+	default:
+		h.accountError(c, traceId, msg, err)
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"user-service/internal/oidc"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OAuthCallbackRequest is what the provider sent back to the redirect page
+type OAuthCallbackRequest struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
+
+// ListOAuthProviders returns the identity providers users can sign in with, for the login page
+func (h *Handler) ListOAuthProviders(c *gin.Context) {
+	names := make([]string, 0, len(h.oidc))
+	for name := range h.oidc {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	c.JSON(http.StatusOK, gin.H{"providers": names})
+}
+
+// StartOAuthLogin begins a login at an identity provider. The client sends the user to the authorization url,
+// the provider sends them back to the redirect page with a code and the state, which the page posts to OAuthCallback.
+func (h *Handler) StartOAuthLogin(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	provider, ok := h.oidc[c.Param("provider")]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	login, err := h.u.StartExternalLogin(c.Request.Context(), provider.Name())
+	if err != nil {
+		slog.Error("error starting external login", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), login.State, login.Nonce, oidc.CodeChallenge(login.CodeVerifier))
+	if err != nil {
+		slog.Error("error building authorization url", slog.String(logkey.TraceID, traceId),
+			slog.String("Provider", provider.Name()), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "The identity provider is not reachable"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL, "state": login.State})
+}
+
+// OAuthCallback finishes the login at the identity provider and logs the user in like a password login would.
+// The first login with a provider links it to the account with the same email or creates one.
+func (h *Handler) OAuthCallback(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	provider, ok := h.oidc[c.Param("provider")]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	var req OAuthCallbackRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+		return
+	}
+
+	login, err := h.u.FinishExternalLogin(c.Request.Context(), provider.Name(), req.State)
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidLoginState) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login, please try again"})
+			return
+		}
+		slog.Error("error finishing external login", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), req.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		slog.Error("error exchanging authorization code", slog.String(logkey.TraceID, traceId),
+			slog.String("Provider", provider.Name()), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Sign in with " + provider.Name() + " failed, please try again"})
+		return
+	}
+
+	userData, created, err := h.u.LoginWithIdentity(c.Request.Context(), identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrIdentityUnverified):
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Please verify your email address with " + provider.Name() + " first"})
+		default:
+			slog.Error("error logging in with identity", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		}
+		return
+	}
+
+	if created {
+		slog.Info("user created from external identity", slog.String(logkey.TraceID, traceId),
+			slog.String("UserID", userData.ID), slog.String("Provider", provider.Name()))
+		// the Stripe customer is created for the new account like after a signup
+		go h.publishAccountCreated(traceId, userData)
+	}
+
+	if userData.Disabled {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+		return
+	}
+
+	claims, err := h.u.Claims(c.Request.Context(), userData)
+	if err != nil {
+		slog.Error("Error in building claims", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	h.loginVerified(c, traceId, userData, claims)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+	"user-service/internal/stores/kafka"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// a user can ask for another reset link once this long after the previous one
+const passwordResetCooldown = time.Minute
+
+// ResetPasswordRequest sets a new password with the token from the reset link
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=5"`
+}
+
+// ChangePasswordRequest replaces the password of the logged in user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=5,nefield=CurrentPassword"`
+}
+
+// ForgotPassword emails a single use reset link to the user with the email.
+// The response is the same whether or not the email belongs to an account, so it can not be used to find users.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var payload struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	err := c.ShouldBindJSON(&payload)
+	if err == nil {
+		err = h.validate.Struct(payload)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "please provide a valid email"})
+		return
+	}
+
+	reset, ok, err := h.u.CreatePasswordReset(c.Request.Context(), payload.Email, users.PasswordResetTTL(), passwordResetCooldown)
+	if err != nil {
+		slog.Error("error in creating password reset", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+	if ok {
+		// publishing in the background keeps the response time the same for unknown emails
+		go h.requestPasswordReset(traceId, reset)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a password reset email is on its way"})
+}
+
+// ResetPassword sets a new password with the token from the reset link, every session of the user is logged out
+func (h *Handler) ResetPassword(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var req ResetPasswordRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "please provide the token and a password of at least 5 characters"})
+		return
+	}
+
+	userId, err := h.u.ResetPassword(c.Request.Context(), req.Token, req.Password)
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidResetToken) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The reset link is invalid or has expired"})
+			return
+		}
+		slog.Error("error in resetting the password", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	slog.Info("password reset", slog.String(logkey.TraceID, traceId), slog.String("UserID", userId))
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset, please log in with the new password"})
+}
+
+// ChangePassword replaces the password of the logged in user after checking the current one.
+// Every session of the user is logged out, including this one.
+func (h *Handler) ChangePassword(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	var req ChangePasswordRequest
+	err = c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "please provide the current password and a different new password of at least 5 characters"})
+		return
+	}
+
+	err = h.u.ChangePassword(c.Request.Context(), claims.Subject, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrInvalidPassword):
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		case errors.Is(err, users.ErrUserNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			slog.Error("error in changing the password", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		}
+		return
+	}
+
+	slog.Info("password changed", slog.String(logkey.TraceID, traceId), slog.String("UserID", claims.Subject))
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, please log in again"})
+}
+
+// requestPasswordReset publishes the reset link, the product service emails it.
+// The user can ask for the email again, so a failure is only logged.
+func (h *Handler) requestPasswordReset(traceId string, reset users.PasswordReset) {
+	link, err := url.Parse(h.resetURL)
+	if err != nil {
+		slog.Error("invalid password reset url", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		return
+	}
+	query := link.Query()
+	query.Set("token", reset.Token)
+	link.RawQuery = query.Encode()
+
+	data, err := json.Marshal(kafka.MSGPasswordResetRequested{
+		UserID:    reset.Contact.ID,
+		Name:      reset.Contact.Name,
+		Email:     reset.Contact.Email,
+		ResetURL:  link.String(),
+		ExpiresAt: reset.ExpiresAt,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Error("error in marshaling password reset request", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		return
+	}
+
+	err = h.k.ProduceMessage(kafka.TopicPasswordResetRequested, []byte(reset.Contact.ID), data)
+	if err != nil {
+		slog.Error("error in producing message", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+	}
+}
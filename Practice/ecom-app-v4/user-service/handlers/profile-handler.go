@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMe returns the profile of the logged in user
+func (h *Handler) GetMe(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	user, err := h.u.GetUser(c.Request.Context(), claims.Subject)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		slog.Error("error fetching user", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateMe changes the name or email of the logged in user, a new email needs the current password and gets a verification link
+func (h *Handler) UpdateMe(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	var update users.ProfileUpdate
+	err = c.ShouldBindJSON(&update)
+	if err == nil {
+		err = h.validate.Struct(update)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "please provide values in correct format, a new email needs the current password"})
+		return
+	}
+
+	user, emailChanged, err := h.u.UpdateProfile(c.Request.Context(), claims.Subject, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrUserNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case errors.Is(err, users.ErrEmailTaken):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+		case errors.Is(err, users.ErrInvalidPassword):
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		default:
+			slog.Error("error updating profile", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		}
+		return
+	}
+
+	if emailChanged {
+		slog.Info("email changed, verification requested", slog.String(logkey.TraceID, traceId), slog.String("UserID", user.ID))
+		go h.requestVerification(traceId, users.Contact{ID: user.ID, Name: user.Name, Email: user.Email})
+	}
+
+	c.JSON(http.StatusOK, user)
+}
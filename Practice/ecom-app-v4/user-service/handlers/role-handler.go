@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// roleNamePattern keeps role names usable in urls and query strings
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// ListPermissions returns every permission roles can be given
+func (h *Handler) ListPermissions(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	permissions, err := h.u.ListPermissions(c.Request.Context())
+	if err != nil {
+		slog.Error("error listing permissions", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"permissions": permissions})
+}
+
+// ListRoles returns every role with its permissions
+func (h *Handler) ListRoles(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	roles, err := h.u.ListRoles(c.Request.Context())
+	if err != nil {
+		slog.Error("error listing roles", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// PutRole creates a role or replaces its permissions. Users with the role get the new permissions
+// with their next token, within the access token lifetime.
+func (h *Handler) PutRole(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	name := c.Param("role")
+	var req users.RoleUpdate
+	err = c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err == nil && !roleNamePattern.MatchString(name) {
+		err = errors.New("invalid role name " + name)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "role names are lowercase letters, digits, - and _, permissions must be a list"})
+		return
+	}
+
+	role, err := h.u.PutRole(c.Request.Context(), name, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrUnknownPermission):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Unknown permission"})
+		case errors.Is(err, users.ErrAdminRoleLocked):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The admin role must keep the permission to manage users"})
+		default:
+			slog.Error("error saving role", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		}
+		return
+	}
+
+	slog.Info("role permissions changed", slog.String(logkey.TraceID, traceId), slog.String("Role", role.Name),
+		slog.Any("Permissions", role.Permissions), slog.String("By", claims.Subject))
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole removes a role that no user has anymore
+func (h *Handler) DeleteRole(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	name := c.Param("role")
+	err = h.u.DeleteRole(c.Request.Context(), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrRoleNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		case errors.Is(err, users.ErrBuiltinRole):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The user and admin roles can not be deleted"})
+		case errors.Is(err, users.ErrRoleInUse):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Take the role away from its users first"})
+		default:
+			slog.Error("error deleting role", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		}
+		return
+	}
+
+	slog.Info("role deleted", slog.String(logkey.TraceID, traceId), slog.String("Role", name), slog.String("By", claims.Subject))
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
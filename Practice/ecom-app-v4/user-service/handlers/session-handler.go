@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RefreshRequest carries the refresh token handed out at login or by the previous refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token.
+// Every refresh token works once, presenting a used one logs the whole login out.
+func (h *Handler) Refresh(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var req RefreshRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = h.validate.Struct(req)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+
+	user, session, err := h.u.RotateSession(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrRefreshTokenReused):
+			slog.Warn("refresh token reused, login revoked", slog.String(logkey.TraceID, traceId))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		case errors.Is(err, users.ErrInvalidRefreshToken):
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		default:
+			slog.Error("error in refreshing the session", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		}
+		return
+	}
+
+	token, err := h.a.GenerateToken(session.Claims)
+	if err != nil {
+		slog.Error("Error in generating token", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":                     user,
+		"token":                    token,
+		"expires_at":               session.Claims.ExpiresAt.Time,
+		"refresh_token":            session.RefreshToken,
+		"refresh_token_expires_at": session.RefreshExpiresAt,
+	})
+}
+
+// Logout revokes the access token of the request and, when it is sent, the refresh token of the login.
+// The other services stop accepting the access token once they synced the revocation list.
+func (h *Handler) Logout(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	claims, err := ctxmanage.GetAuthClaimsFromContext(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			slog.Error("json validation error", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": http.StatusText(http.StatusBadRequest)})
+			return
+		}
+	}
+
+	// tokens without an expiry are refused by the validation, this is only a fallback
+	expiresAt := time.Now().Add(users.AccessTokenTTL())
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	err = h.u.EndSession(c.Request.Context(), claims.Subject, claims.ID, expiresAt, req.RefreshToken)
+	if err != nil {
+		slog.Error("error in ending the session", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	// the token is refused by this instance at once, without waiting for its next sync
+	h.a.Revoke(claims.ID, expiresAt)
+
+	slog.Info("user logged out", slog.String(logkey.TraceID, traceId), slog.String("UserID", claims.Subject))
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// GetRevocationsInternal lists the revoked access tokens that did not expire yet,
+// the other services poll it to refuse them
+func (h *Handler) GetRevocationsInternal(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	tokens, err := h.u.RevokedTokens(c.Request.Context())
+	if err != nil {
+		slog.Error("error fetching revoked tokens", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to fetch revoked tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// JWKS publishes the public keys tokens are signed with, the other services load it to validate tokens
+func (h *Handler) JWKS(c *gin.Context) {
+	// the services load the document again when they see a kid they do not know
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.a.JWKS())
+}
@@ -36,3 +36,26 @@ func (h *Handler) GetStripeDetails(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"stripe_customer_id": stripeCustomerId})
 
 }
+
+// GetStripeDetailsInternal gives the order service the stripe customer of the user placing an order,
+// it is called with a service token so the user token does not have to be passed along
+func (h *Handler) GetStripeDetailsInternal(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	userId := c.Param("userId")
+	if err := h.validate.Var(userId, "required,uuid"); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	}
+
+	stripeCustomerId, err := h.u.GetStripeCustomerID(c.Request.Context(), userId)
+	if err != nil {
+		slog.Error(
+			"failed to get stripe customer id",
+			slog.String(logkey.TraceID, traceId), slog.Any(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to get stripe customer id"})
+		return
+	}
+	slog.Info("successfully got stripe customer id", slog.String(logkey.TraceID, traceId))
+	c.JSON(http.StatusOK, gin.H{"stripe_customer_id": stripeCustomerId})
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"log/slog"
 	"net/http"
+	"user-service/internal/auth"
 	"user-service/internal/stores/kafka"
 	"user-service/internal/users"
 	"user-service/pkg/ctxmanage"
@@ -74,6 +75,11 @@ func (h *Handler) Signup(c *gin.Context) {
 	// Attempt to insert the new user into the database using the `InsertUser` method.
 	user, err := h.u.InsertUser(ctx, newUser)
 	if err != nil {
+		if errors.Is(err, users.ErrEmailTaken) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+			return
+		}
+
 		// Log an error if user creation fails, along with the trace ID and specific error message.
 		slog.Error("error in creating the user",
 			slog.String(logkey.TraceID, traceId),
@@ -89,36 +95,51 @@ func (h *Handler) Signup(c *gin.Context) {
 
 	// Send a Kafka message asynchronously in a separate goroutine after user creation.
 	go func() {
-		// Marshal the created user's data into JSON format for the Kafka message payload.
-		data, err := json.Marshal(user)
+		err := h.publishAccountCreated(traceId, user)
 		if err != nil {
-			// Log an error if JSON marshaling fails, along with the trace ID.
-			slog.Error("error in marshaling user",
-				slog.String(logkey.TraceID, traceId),
-				slog.String(logkey.ERROR, err.Error()),
-			)
 			return
 		}
 
-		// Use the user's ID as the Kafka message key.
-		key := []byte(user.ID)
-
-		// Attempt to send the Kafka message using the `ProduceMessage` method.
-		err = h.k.ProduceMessage(kafka.TopicAccountCreated, key, data)
-		if err != nil {
-			// Log an error if producing the Kafka message fails, along with the trace ID.
-			slog.Error("error in producing message",
-				slog.String(logkey.TraceID, traceId),
-				slog.String(logkey.ERROR, err.Error()),
-			)
-			return
-		}
+		// The verification link goes out with the account created, the user confirms the address with it
+		h.requestVerification(traceId, users.Contact{ID: user.ID, Name: user.Name, Email: user.Email})
 	}()
 
+	// Anything the guest added to the cart before signing up moves to the new account
+	h.mergeGuestCart(c, traceId, user.ID)
+
 	// Respond with HTTP 200 OK and return the created user's data as JSON.
 	c.JSON(http.StatusOK, user)
 }
 
+// publishAccountCreated announces a new account, the consumer creates the Stripe customer of the user
+func (h *Handler) publishAccountCreated(traceId string, user users.User) error {
+	// Marshal the created user's data into JSON format for the Kafka message payload.
+	data, err := json.Marshal(user)
+	if err != nil {
+		// Log an error if JSON marshaling fails, along with the trace ID.
+		slog.Error("error in marshaling user",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		return err
+	}
+
+	// Use the user's ID as the Kafka message key.
+	key := []byte(user.ID)
+
+	// Attempt to send the Kafka message using the `ProduceMessage` method.
+	err = h.k.ProduceMessage(kafka.TopicAccountCreated, key, data)
+	if err != nil {
+		// Log an error if producing the Kafka message fails, along with the trace ID.
+		slog.Error("error in producing message",
+			slog.String(logkey.TraceID, traceId),
+			slog.String(logkey.ERROR, err.Error()),
+		)
+		return err
+	}
+	return nil
+}
+
 /*
 	when a user logs in, create a token for the user if login is a success
 	and return the token back to the client
@@ -162,6 +183,19 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	// A locked email or an IP that failed too often is not even checked, the answer is the same as for a wrong password
+	refused, err := h.u.LoginRefused(c.Request.Context(), loginPayload.Email, c.ClientIP(), h.loginPolicy)
+	if err != nil {
+		slog.Error("Error in checking login failures", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+	if refused {
+		slog.Warn("Login refused, too many failures", slog.String(logkey.TraceID, traceId), slog.String("IP", c.ClientIP()))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+
 	// Proceed to authenticate the user by verifying credentials
 	userData, claims, err := h.u.Authenticate(c.Request.Context(), loginPayload.Email, loginPayload.Password)
 
@@ -171,7 +205,13 @@ func (h *Handler) Login(c *gin.Context) {
 
 		// For incorrect credentials, send an unauthorized error
 		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, users.ErrInvalidPassword) {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+			h.loginFailed(c, traceId, loginPayload.Email)
+			return
+		}
+
+		// only reached with the right password
+		if errors.Is(err, users.ErrAccountDisabled) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
 			return
 		}
 
@@ -180,17 +220,85 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := h.a.GenerateToken(claims)
+	// The password is checked first, so the answer does not tell whether an account exists
+	if emailVerificationPending(userData) {
+		slog.Info("login refused, email not verified", slog.String(logkey.TraceID, traceId), slog.String("UserID", userData.ID))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Please verify your email address before logging in"})
+		return
+	}
+
+	// The password was right, earlier typos no longer count towards a lock.
+	// With two factor authentication on that waits for the code, the password alone does not undo a lock in the making.
+	if !userData.MFAEnabled {
+		h.clearLoginFailures(c, traceId, loginPayload.Email)
+	}
+
+	h.loginVerified(c, traceId, userData, claims)
+}
+
+func (h *Handler) clearLoginFailures(c *gin.Context, traceId, email string) {
+	err := h.u.ClearLoginFailures(c.Request.Context(), email)
+	if err != nil {
+		slog.Error("Error in clearing login failures", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+	}
+}
+
+// loginVerified continues a login whose first step succeeded, with a password or at an identity provider.
+// With two factor authentication on, that only buys a short lived challenge for the code.
+func (h *Handler) loginVerified(c *gin.Context, traceId string, userData users.User, claims auth.Claims) {
+	if userData.MFAEnabled {
+		challenge, err := h.u.CreateMFAChallenge(c.Request.Context(), userData.ID)
+		if err != nil {
+			slog.Error("Error in creating mfa challenge", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":         "Enter the code from your authenticator app",
+			"mfa_required":    true,
+			"challenge_token": challenge.Token,
+			"expires_at":      challenge.ExpiresAt,
+		})
+		return
+	}
+
+	h.completeLogin(c, traceId, userData, claims)
+}
+
+// completeLogin starts the session of a user who passed every login step and answers with the tokens
+func (h *Handler) completeLogin(c *gin.Context, traceId string, userData users.User, claims auth.Claims) {
+	// The access token is short lived, the refresh token renews it without asking for the password again
+	session, err := h.u.StartSession(c.Request.Context(), claims)
+	if err != nil {
+		slog.Error("Error in starting session", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	token, err := h.a.GenerateToken(session.Claims)
 	if err != nil {
 		slog.Error("Error in generating token", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
 		return
 	}
 
+	// Anything the guest added to the cart before logging in moves to the user's cart
+	h.mergeGuestCart(c, traceId, userData.ID)
+
+	resp := gin.H{
+		"message":                  "Login successful",
+		"user":                     userData,
+		"token":                    token,
+		"expires_at":               session.Claims.ExpiresAt.Time,
+		"refresh_token":            session.RefreshToken,
+		"refresh_token_expires_at": session.RefreshExpiresAt,
+	}
+	// roles that need two factor authentication stay out of the token until it is enabled
+	if awaiting := users.RolesAwaitingMFA(userData); len(awaiting) > 0 {
+		resp["mfa_enrollment_required"] = true
+		resp["roles_awaiting_mfa"] = awaiting
+	}
+
 	// If login is successful, return the user data in the response
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
-		"user":    userData,
-		"token":   token,
-	})
+	c.JSON(http.StatusOK, resp)
 }
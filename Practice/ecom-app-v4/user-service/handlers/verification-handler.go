@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+	"user-service/internal/auth"
+	"user-service/internal/stores/kafka"
+	"user-service/internal/users"
+	"user-service/pkg/ctxmanage"
+	"user-service/pkg/logkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// a user can ask for the verification email again once this long after the previous one
+const verificationResendCooldown = time.Minute
+
+// VerifyEmail confirms the email address of the user the token in the verification link was issued to
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	userId, email, err := h.vt.ValidateToken(c.Query("token"))
+	if err != nil {
+		slog.Error("invalid verification token", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The verification link is invalid or has expired"})
+		return
+	}
+
+	err = h.u.MarkEmailVerified(c.Request.Context(), userId, email)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			// the account is gone or its email changed after the link was sent
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The verification link is invalid or has expired"})
+			return
+		}
+		slog.Error("error in verifying the email", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+
+	slog.Info("email verified", slog.String(logkey.TraceID, traceId), slog.String("UserID", userId))
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified, you can log in now"})
+}
+
+// ResendVerification emails a new verification link to an unverified user.
+// The response is the same whether or not the email belongs to an account, so it can not be used to find users.
+func (h *Handler) ResendVerification(c *gin.Context) {
+	traceId := ctxmanage.GetTraceIdOfRequest(c)
+
+	var payload struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	err := c.ShouldBindJSON(&payload)
+	if err == nil {
+		err = h.validate.Struct(payload)
+	}
+	if err != nil {
+		slog.Error("validation failed", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "please provide a valid email"})
+		return
+	}
+
+	contact, ok, err := h.u.ClaimVerificationResend(c.Request.Context(), payload.Email, verificationResendCooldown)
+	if err != nil {
+		slog.Error("error in resending the verification email", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+	if ok {
+		h.requestVerification(traceId, contact)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists and is not verified yet, a new verification email is on its way"})
+}
+
+// requestVerification signs a verification link for the user and publishes it, the product service emails it.
+// The user can ask for the email again, so a failure is only logged.
+func (h *Handler) requestVerification(traceId string, contact users.Contact) {
+	token, expiresAt, err := h.vt.NewToken(contact.ID, contact.Email)
+	if err != nil {
+		slog.Error("error in creating verification token", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		return
+	}
+
+	link, err := url.Parse(h.verifyURL)
+	if err != nil {
+		slog.Error("invalid verification url", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		return
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	data, err := json.Marshal(kafka.MSGEmailVerificationRequested{
+		UserID:    contact.ID,
+		Name:      contact.Name,
+		Email:     contact.Email,
+		VerifyURL: link.String(),
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Error("error in marshaling verification request", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+		return
+	}
+
+	err = h.k.ProduceMessage(kafka.TopicEmailVerificationRequested, []byte(contact.ID), data)
+	if err != nil {
+		slog.Error("error in producing message", slog.String(logkey.TraceID, traceId), slog.String(logkey.ERROR, err.Error()))
+	}
+}
+
+// emailVerificationPending reports whether the user still has to verify the email before logging in
+func emailVerificationPending(user users.User) bool {
+	return auth.VerificationRequired() && !user.EmailVerified
+}
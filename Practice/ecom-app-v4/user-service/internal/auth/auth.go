@@ -4,57 +4,126 @@ import (
 	"crypto/rsa"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"time"
 )
 
 type ctxKey int
 
 const ClaimsKey ctxKey = 1
 
+// APIKeyClaimsKey holds the claims of a token an API key was exchanged for. Only RequirePermission
+// reads it, so a key opens the routes gated by a permission and none of the customer ones.
+const APIKeyClaimsKey ctxKey = 2
+
+const RoleUser = "user"
+const RoleAdmin = "admin"
+
+// Permissions are what the services check, roles are granted permissions in the role_permissions table
+// and the token carries the permissions of the roles of the user
+const (
+	PermProductsWrite = "products:write" // create, update and restock products
+	PermOrdersRead    = "orders:read"    // see the orders, invoices and returns of every customer
+	PermOrdersManage  = "orders:manage"  // move orders along, record shipments and cancel any order
+	PermOrdersRefund  = "orders:refund"
+	PermReturnsManage = "returns:manage" // review and receive returns
+	PermCouponsManage = "coupons:manage"
+	PermUsersManage   = "users:manage"    // manage users, roles and their permissions
+	PermAPIKeysCreate = "api-keys:create" // create API keys for your own account
+)
+
+// Keys signs tokens with the active key and checks them with any of the loaded keys,
+// the kid header of a token names the key it was signed with
 type Keys struct {
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
+	activeKID   string
+	privateKeys map[string]*rsa.PrivateKey
+	revoked     *Revocations
 }
 
 type Claims struct {
 	jwt.RegisteredClaims
-	Roles []string `json:"roles"`
+	Roles         []string `json:"roles"`
+	Permissions   []string `json:"permissions"`       // of the roles, the services check these
+	EmailVerified bool     `json:"email_verified"`    // checkout refuses unverified users
+	Service       string   `json:"service,omitempty"` // set on service tokens, names the calling service
+	APIKey        string   `json:"api_key,omitempty"` // set on tokens an API key was exchanged for, the id of the key
 }
 
-// NewKeys is a constructor function for Keys struct. It accepts privateKey and publicKey as parameters and returns
-// an instance of Keys struct. If either of privateKey or publicKey is nil, it returns an error.
-func NewKeys(privateKey *rsa.PrivateKey, publicKey *rsa.PublicKey) (*Keys, error) {
-	if privateKey == nil || publicKey == nil {
-		return nil, fmt.Errorf("invalid keys")
+func (c Claims) HasRoles(requiredRoles ...string) bool {
+	for _, has := range c.Roles { // roles with the user in the token
+		for _, want := range requiredRoles {
+			if has == want {
+				return true
+			}
+		}
 	}
-	return &Keys{privateKey, publicKey}, nil
+	return false
+}
 
+// HasPermissions reports whether the token carries every one of the permissions
+func (c Claims) HasPermissions(requiredPermissions ...string) bool {
+	for _, want := range requiredPermissions {
+		if !slices.Contains(c.Permissions, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewKeys is a constructor function for Keys struct. It accepts the private keys by kid, the kid of the key
+// new tokens are signed with and the list of revoked tokens as parameters and returns an instance of Keys struct.
+// If the active key is not one of the keys or the revocation list is nil, it returns an error.
+func NewKeys(privateKeys map[string]*rsa.PrivateKey, activeKID string, revoked *Revocations) (*Keys, error) {
+	if privateKeys[activeKID] == nil || revoked == nil {
+		return nil, fmt.Errorf("invalid keys")
+	}
+	return &Keys{activeKID: activeKID, privateKeys: privateKeys, revoked: revoked}, nil
 }
 
 // GenerateToken is a method for Auth struct. It generates a new JWT token using the provided claims and
-// signs it using the privateKey of the Auth struct it's called upon. If there is an error during signing,
+// signs it using the active key of the Auth struct it's called upon, the kid header names the key. If there is an error during signing,
 // it returns an error.
 func (k *Keys) GenerateToken(claims Claims) (string, error) {
 	tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	tokenStr, err := tkn.SignedString(k.privateKey)
+	tkn.Header["kid"] = k.activeKID
+	tokenStr, err := tkn.SignedString(k.privateKeys[k.activeKID])
 	if err != nil {
 		return "", err
 	}
 	return tokenStr, nil
 }
 
-// ValidateToken is a method for Auth struct. It verifies the provided JWT token using the publicKey of the Auth struct
-// it's called upon and returns the parsed claims if the JWT token is valid. If the JWT token is invalid or
+// ValidateToken is a method for Auth struct. It verifies the provided JWT token using the key named by its kid header
+// and returns the parsed claims if the JWT token is valid. If the JWT token is invalid, signed with an unknown key or
 // there is an error during parsing, it returns an error.
 func (k *Keys) ValidateToken(tokenStr string) (Claims, error) {
 	var claims Claims
 	tkn, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
-		return k.publicKey, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		privateKey, ok := k.privateKeys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return &privateKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
 	if err != nil {
 		return Claims{}, err
 	}
 	if !tkn.Valid {
 		return Claims{}, fmt.Errorf("invalid token")
 	}
+	// tokens revoked on logout or a stolen refresh token are refused until they expire
+	if k.revoked.IsRevoked(claims.ID) {
+		return Claims{}, ErrTokenRevoked
+	}
 	return claims, nil
 }
+
+// Revoke refuses the access token with the jti in this instance right away, the other instances and services
+// refuse it once they synced the list from the database
+func (k *Keys) Revoke(jti string, expiresAt time.Time) {
+	if jti == "" {
+		return
+	}
+	k.revoked.Revoke(jti, expiresAt)
+}
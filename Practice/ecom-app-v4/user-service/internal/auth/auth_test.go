@@ -0,0 +1,25 @@
+package auth
+
+import "testing"
+
+func TestHasPermissions(t *testing.T) {
+	claims := Claims{Permissions: []string{PermOrdersRead, PermOrdersRefund}}
+
+	tests := []struct {
+		name        string
+		permissions []string
+		want        bool
+	}{
+		{"one held", []string{PermOrdersRead}, true},
+		{"every one held", []string{PermOrdersRead, PermOrdersRefund}, true},
+		{"one missing", []string{PermOrdersRead, PermUsersManage}, false},
+		{"none required", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := claims.HasPermissions(tt.permissions...); got != tt.want {
+				t.Errorf("HasPermissions(%v) = %v, want %v", tt.permissions, got, tt.want)
+			}
+		})
+	}
+}
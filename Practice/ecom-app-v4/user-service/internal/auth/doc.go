@@ -0,0 +1,9 @@
+// Package auth issues the tokens of the shop and checks them.
+//
+// The other services check the tokens with copies of parts of this package in their own internal/auth:
+// revocations.go is copied to the product and order services, servicetokens.go to every service, and the
+// product and order services share the same jwks.go and usersource.go, the gateway a usersource.go that
+// exchanges API keys. Every service is built on its own from its directory, see the build contexts in
+// docker-compose.yml, so there is no module the services can import next to them; pkg/logkey is copied the
+// same way. A change to one copy is made to all of them.
+package auth
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWK is the public half of a signing key as published in the JWKS document (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is the document the other services load the public keys from
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func newJWK(kid string, publicKey *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+// PublicKey decodes the RSA public key of the JWK, it is used for the keys of external identity providers
+func (j JWK) PublicKey() (*rsa.PublicKey, error) {
+	if j.Kty != "RSA" {
+		return nil, fmt.Errorf("key %s: unsupported key type %q", j.Kid, j.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(j.N)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: decoding modulus: %w", j.Kid, err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(j.E)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: decoding exponent: %w", j.Kid, err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("key %s: invalid key", j.Kid)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// KeyID derives a kid from the public key, the RFC 7638 thumbprint, so a key keeps its id without any configuration
+func KeyID(publicKey *rsa.PublicKey) string {
+	jwk := newJWK("", publicKey)
+	// the members are required in this order and without spaces
+	thumbprint, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{jwk.E, jwk.Kty, jwk.N})
+	sum := sha256.Sum256(thumbprint)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// LoadSigningKeys reads the private keys tokens are signed and checked with.
+// With a directory every <kid>.pem in it is loaded and the file name is the kid, which allows several keys during a rotation.
+// Without one the single private.pem is used and its kid is derived from the key.
+func LoadSigningKeys(dir string) (map[string]*rsa.PrivateKey, error) {
+	if dir == "" {
+		privateKey, err := readPrivateKey("private.pem")
+		if err != nil {
+			return nil, err
+		}
+		return map[string]*rsa.PrivateKey{KeyID(&privateKey.PublicKey): privateKey}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PrivateKey, len(files))
+	for _, file := range files {
+		privateKey, err := readPrivateKey(file)
+		if err != nil {
+			return nil, err
+		}
+		keys[strings.TrimSuffix(filepath.Base(file), ".pem")] = privateKey
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys in %s", dir)
+	}
+	return keys, nil
+}
+
+func readPrivateKey(file string) (*rsa.PrivateKey, error) {
+	privatePEM, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading auth private key %s: %w", file, err)
+	}
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing auth private key %s: %w", file, err)
+	}
+	return privateKey, nil
+}
+
+// JWKS returns the public keys of every loaded key, tokens signed with a key being rotated out stay valid until it is removed
+func (k *Keys) JWKS() JWKS {
+	kids := make([]string, 0, len(k.privateKeys))
+	for kid := range k.privateKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		jwks.Keys = append(jwks.Keys, newJWK(kid, &k.privateKeys[kid].PublicKey))
+	}
+	return jwks
+}
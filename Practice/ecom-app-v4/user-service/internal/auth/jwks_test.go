@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims Claims
+	claims.Subject = "u1"
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	before, err := NewKeys(map[string]*rsa.PrivateKey{"old": oldKey}, "old", NewRevocations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// during the rotation both keys are loaded and new tokens are signed with the new one
+	during, err := NewKeys(map[string]*rsa.PrivateKey{"old": oldKey, "new": newKey}, "new", NewRevocations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := during.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := during.ValidateToken(token); err != nil {
+			t.Errorf("%s token: ValidateToken() error = %v", name, err)
+		}
+	}
+	if _, err := before.ValidateToken(newToken); err == nil {
+		t.Error("token signed with a key that is not loaded was accepted")
+	}
+
+	jwks := during.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != "new" || jwks.Keys[1].Kid != "old" {
+		t.Fatalf("JWKS() = %+v, want the new and old keys", jwks.Keys)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if new(big.Int).SetBytes(n).Cmp(newKey.N) != 0 {
+		t.Error("published modulus does not match the signing key")
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	// the RFC 7638 section 3.1 example
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	if got, want := KeyID(publicKey), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("KeyID() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+const defaultRevocationSyncInterval = 15 * time.Second
+
+// RevocationSyncInterval is how often the revoked tokens are loaded from the user service,
+// a revoked token is accepted for at most this long. REVOCATION_SYNC_INTERVAL overrides it, e.g. "30s".
+func RevocationSyncInterval() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("REVOCATION_SYNC_INTERVAL"))
+	if err != nil || d <= 0 {
+		return defaultRevocationSyncInterval
+	}
+	return d
+}
+
+// RevokedToken is an access token that was revoked before it expired, e.g. on logout
+type RevokedToken struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RevocationSource returns the access tokens that are revoked and not expired yet
+type RevocationSource func(ctx context.Context) ([]RevokedToken, error)
+
+// Revocations is the list of revoked access tokens ValidateToken checks, keyed by the jti of the token.
+// The user service revokes tokens, the list is kept in sync by Poll.
+type Revocations struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+}
+
+func NewRevocations() *Revocations {
+	return &Revocations{tokens: make(map[string]time.Time)}
+}
+
+// IsRevoked reports whether the token with the jti is revoked, tokens without a jti can not be revoked
+func (r *Revocations) IsRevoked(jti string) bool {
+	if jti == "" {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.tokens[jti]
+	return ok
+}
+
+// Revoke adds the token to the list until it expires
+func (r *Revocations) Revoke(jti string, expiresAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens[jti] = expiresAt
+}
+
+// Replace swaps the list for the tokens, expired ones are left out
+func (r *Revocations) Replace(tokens []RevokedToken) {
+	now := time.Now()
+	next := make(map[string]time.Time, len(tokens))
+	for _, t := range tokens {
+		if t.ExpiresAt.After(now) {
+			next[t.JTI] = t.ExpiresAt
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens = next
+}
+
+// Poll loads the list from the source every interval until the context is canceled.
+// A failed load keeps the previous list, the tokens in it stay revoked.
+func (r *Revocations) Poll(ctx context.Context, interval time.Duration, source RevocationSource) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		tokens, err := source(ctx)
+		if err != nil {
+			slog.Error("error syncing revoked tokens", slog.Any("error", err))
+		} else {
+			r.Replace(tokens)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateTokenRefusesRevokedToken(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := NewRevocations()
+	k, err := NewKeys(map[string]*rsa.PrivateKey{"k1": privateKey}, "k1", revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims Claims
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        "jti-1",
+		Subject:   "u1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	token, err := k.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := k.ValidateToken(token); err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	// logout revokes the token in the instance that served it
+	k.Revoke("jti-1", time.Now().Add(time.Hour))
+	if _, err := k.ValidateToken(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("ValidateToken() error = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	// an expired entry is dropped on the next sync
+	revoked.Replace([]RevokedToken{{JTI: "jti-1", ExpiresAt: time.Now().Add(-time.Minute)}})
+	if revoked.IsRevoked("jti-1") {
+		t.Error("expired revocation kept after Replace")
+	}
+	if revoked.IsRevoked("") {
+		t.Error("a token without a jti reported as revoked")
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// AudienceInternal is the audience of service tokens. User tokens are issued for "everyone",
+// the internal routes only take service tokens and the user routes refuse them.
+const AudienceInternal = "internal"
+
+// ServiceName is the client id the user service signs its own service tokens with
+const ServiceName = "user-service"
+
+// ServiceTokenTTL is how long a service token is valid, the services ask for a new one before it runs out
+const ServiceTokenTTL = 5 * time.Minute
+
+// minServiceSecretLength keeps guessable secrets out of the configuration
+const minServiceSecretLength = 32
+
+// ServiceClients are the services that may ask for service tokens, only the sha256 of their secrets is kept
+type ServiceClients struct {
+	secrets map[string][sha256.Size]byte
+}
+
+// NewServiceClients takes the secrets by client id
+func NewServiceClients(secrets map[string]string) (*ServiceClients, error) {
+	hashed := make(map[string][sha256.Size]byte, len(secrets))
+	for id, secret := range secrets {
+		if id == "" {
+			return nil, errors.New("service client id is empty")
+		}
+		if len(secret) < minServiceSecretLength {
+			return nil, fmt.Errorf("secret of service client %q must be at least %d characters", id, minServiceSecretLength)
+		}
+		hashed[id] = sha256.Sum256([]byte(secret))
+	}
+	return &ServiceClients{secrets: hashed}, nil
+}
+
+// ServiceClientsFromEnv reads SERVICE_CLIENTS, a comma separated list of client_id:secret
+func ServiceClientsFromEnv() (*ServiceClients, error) {
+	secrets := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv("SERVICE_CLIENTS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, errors.New("invalid SERVICE_CLIENTS entry, expected client_id:secret")
+		}
+		secrets[strings.TrimSpace(id)] = strings.TrimSpace(secret)
+	}
+	return NewServiceClients(secrets)
+}
+
+// Authenticate reports whether the secret belongs to the client, the comparison takes the same time for every secret
+func (s *ServiceClients) Authenticate(clientId, secret string) bool {
+	want, ok := s.secrets[clientId]
+	got := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(want[:], got[:]) == 1 && ok
+}
+
+// NewServiceClaims builds the claims of a service token, the subject and the service claim name the calling service
+func NewServiceClaims(service string, now time.Time) Claims {
+	var claims Claims
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Issuer:    os.Getenv("SERVICE_NAME"),
+		Subject:   service,
+		Audience:  jwt.ClaimStrings{AudienceInternal},
+		ExpiresAt: jwt.NewNumericDate(now.Add(ServiceTokenTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+	claims.Service = service
+	return claims
+}
+
+// IsService reports whether the claims are of a service token
+func (c Claims) IsService() bool {
+	return c.Service != "" && slices.Contains(c.Audience, AudienceInternal)
+}
+
+// LocalServiceTokens signs the service tokens of the user service itself, it holds the keys so it needs no round trip
+func LocalServiceTokens(k *Keys) ServiceTokenSource {
+	return func(_ context.Context) (ServiceToken, error) {
+		now := time.Now()
+		claims := NewServiceClaims(ServiceName, now)
+		token, err := k.GenerateToken(claims)
+		if err != nil {
+			return ServiceToken{}, err
+		}
+		return ServiceToken{AccessToken: token, ExpiresAt: claims.ExpiresAt.Time}, nil
+	}
+}
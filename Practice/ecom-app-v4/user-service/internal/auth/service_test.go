@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServiceClientsAuthenticate(t *testing.T) {
+	secret := strings.Repeat("s", minServiceSecretLength)
+	clients, err := NewServiceClients(map[string]string{"order-service": secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		clientId string
+		secret   string
+		want     bool
+	}{
+		{"right secret", "order-service", secret, true},
+		{"wrong secret", "order-service", secret + "x", false},
+		{"unknown client", "product-service", secret, false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clients.Authenticate(tt.clientId, tt.secret); got != tt.want {
+				t.Errorf("Authenticate(%q) = %v, want %v", tt.clientId, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewServiceClients(map[string]string{"order-service": "short"}); err == nil {
+		t.Error("NewServiceClients accepted a short secret")
+	}
+}
+
+func TestIsService(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims Claims
+		want   bool
+	}{
+		{"service token", NewServiceClaims("order-service", time.Now()), true},
+		{"user token", Claims{Roles: []string{RoleUser}}, false},
+		{"service claim without the audience", Claims{Service: "order-service"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claims.IsService(); got != tt.want {
+				t.Errorf("IsService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceTokensReuse(t *testing.T) {
+	fetched := 0
+	expiresAt := time.Now().Add(ServiceTokenTTL)
+	tokens, err := NewServiceTokens(func(context.Context) (ServiceToken, error) {
+		fetched++
+		return ServiceToken{AccessToken: "token", ExpiresAt: expiresAt}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		if _, err := tokens.Token(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetched != 1 {
+		t.Errorf("fetched %d tokens, want 1", fetched)
+	}
+
+	// a token about to expire is replaced
+	expiresAt = time.Now().Add(serviceTokenLeeway / 2)
+	tokens.current.ExpiresAt = expiresAt
+	if _, err := tokens.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 2 {
+		t.Errorf("fetched %d tokens, want 2", fetched)
+	}
+}
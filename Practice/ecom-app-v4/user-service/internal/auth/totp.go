@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTOTPCode = errors.New("invalid totp code")
+
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	totpSkew   = 1 // steps accepted on either side of the current one, for clocks that drift a little
+)
+
+// MFARequiredRoles are the roles that are only granted to users with two factor authentication enabled,
+// MFA_REQUIRED_ROLES is a comma separated list, e.g. "admin"
+func MFARequiredRoles() []string {
+	var roles []string
+	for _, role := range strings.Split(os.Getenv("MFA_REQUIRED_ROLES"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// TOTP creates and checks time based one time passwords (RFC 6238, SHA-1, 6 digits, 30 seconds) as authenticator apps use them.
+// The shared secrets are encrypted before they are stored, a leaked database does not give away second factors.
+type TOTP struct {
+	aead   cipher.AEAD
+	issuer string // shown in the authenticator app
+}
+
+// NewTOTP returns a TOTP that encrypts secrets with a key derived from the given secret, it must be at least 32 bytes
+func NewTOTP(secret []byte, issuer string) (*TOTP, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("totp encryption secret must be at least 32 bytes")
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTP{aead: aead, issuer: issuer}, nil
+}
+
+// NewSecret returns a random base32 secret to share with the authenticator app
+func (t *TOTP) NewSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// Seal encrypts the secret for storage
+func (t *TOTP) Seal(secret string) (string, error) {
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to seal totp secret: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(t.aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// Open decrypts a secret sealed with Seal
+func (t *TOTP) Open(sealed string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(b) < t.aead.NonceSize() {
+		return "", fmt.Errorf("failed to open totp secret: malformed")
+	}
+	secret, err := t.aead.Open(nil, b[:t.aead.NonceSize()], b[t.aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to open totp secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// ProvisioningURI is the otpauth:// URI an authenticator app reads from a QR code
+func (t *TOTP) ProvisioningURI(account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", t.issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	label := url.PathEscape(t.issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Validate checks the code against the secret at the given time and returns the time step it belongs to.
+// Codes of a step at or before lastStep are refused, so a code can not be used twice.
+func (t *TOTP) Validate(secret, code string, now time.Time, lastStep int64) (int64, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, fmt.Errorf("invalid totp secret: %w", err)
+	}
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, ErrInvalidTOTPCode
+	}
+
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, uint64(step), totpDigits)), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, ErrInvalidTOTPCode
+}
+
+// totpCode is the HOTP value (RFC 4226) of the key for the counter
+func totpCode(key []byte, counter uint64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"encoding/base32"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTOTPCodeRFC6238 checks the SHA-1 test vectors of RFC 6238 appendix B
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, uint64(tt.unix/30), 8); got != tt.want {
+			t.Errorf("totpCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPValidate(t *testing.T) {
+	totp, err := NewTOTP([]byte(strings.Repeat("k", 32)), "ecom-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / 30
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantErr  bool
+	}{
+		{"current step", totpCode(key, uint64(step), 6), 0, step, false},
+		{"previous step within the skew", totpCode(key, uint64(step-1), 6), 0, step - 1, false},
+		{"next step within the skew", totpCode(key, uint64(step+1), 6), 0, step + 1, false},
+		{"too old", totpCode(key, uint64(step-2), 6), 0, 0, true},
+		{"already used", totpCode(key, uint64(step), 6), step, 0, true},
+		{"wrong length", "12345", 0, 0, true},
+		{"wrong code", "000000", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := totp.Validate(secret, tt.code, now, tt.lastStep)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTOTPCode) {
+					t.Fatalf("Validate() error = %v, want %v", err, ErrInvalidTOTPCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if got != tt.wantStep {
+				t.Errorf("Validate() step = %d, want %d", got, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestTOTPSealOpen(t *testing.T) {
+	totp, err := NewTOTP([]byte(strings.Repeat("k", 32)), "ecom-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewTOTP([]byte(strings.Repeat("o", 32)), "ecom-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := totp.Seal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, secret) {
+		t.Fatal("sealed secret contains the secret")
+	}
+	opened, err := totp.Open(sealed)
+	if err != nil || opened != secret {
+		t.Fatalf("Open() = %q, %v, want %q", opened, err, secret)
+	}
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("secret opened with another key")
+	}
+
+	uri := totp.ProvisioningURI("asha@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/ecom-app:asha@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("ProvisioningURI() = %s", uri)
+	}
+}
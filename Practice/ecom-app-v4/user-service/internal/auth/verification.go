@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+// VerificationRequired reports whether users must verify their email before they can log in or check out.
+// It is on unless REQUIRE_EMAIL_VERIFICATION is set to false.
+func VerificationRequired() bool {
+	required, err := strconv.ParseBool(os.Getenv("REQUIRE_EMAIL_VERIFICATION"))
+	if err != nil {
+		return true
+	}
+	return required
+}
+
+// VerificationTokens signs and verifies the tokens mailed to users to confirm their email address.
+// A token is the base64 encoded payload followed by an HMAC-SHA256 signature of it. The payload
+// holds the email address, so a token stops working once the user changes it.
+type VerificationTokens struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+type verificationPayload struct {
+	UserID    string `json:"sub"`
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewVerificationTokens is a constructor function for VerificationTokens, tokens expire after ttl
+func NewVerificationTokens(secret []byte, ttl time.Duration) (*VerificationTokens, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("email verification secret must be at least 32 bytes")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("email verification token lifetime must be positive")
+	}
+	return &VerificationTokens{secret: secret, ttl: ttl}, nil
+}
+
+// NewToken returns a signed token for the email address of the user and when it expires
+func (vt *VerificationTokens) NewToken(userId, email string) (string, time.Time, error) {
+	expiresAt := time.Now().UTC().Add(vt.ttl).Truncate(time.Second)
+	payload, err := json.Marshal(verificationPayload{UserID: userId, Email: email, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + vt.sign(encoded), expiresAt, nil
+}
+
+// ValidateToken verifies the signature and expiry of the token and returns the user and email inside it
+func (vt *VerificationTokens) ValidateToken(token string) (userId, email string, err error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", "", ErrInvalidVerificationToken
+	}
+
+	// constant time comparison, so the signature can not be guessed byte by byte
+	if !hmac.Equal([]byte(signature), []byte(vt.sign(encoded))) {
+		return "", "", ErrInvalidVerificationToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", ErrInvalidVerificationToken
+	}
+	var payload verificationPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return "", "", ErrInvalidVerificationToken
+	}
+
+	if time.Now().Unix() >= payload.ExpiresAt {
+		return "", "", ErrInvalidVerificationToken
+	}
+	return payload.UserID, payload.Email, nil
+}
+
+func (vt *VerificationTokens) sign(encoded string) string {
+	mac := hmac.New(sha256.New, vt.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
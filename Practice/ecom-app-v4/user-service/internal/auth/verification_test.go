@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerificationTokens(t *testing.T) {
+	vt, err := NewVerificationTokens([]byte(strings.Repeat("s", 32)), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewVerificationTokens([]byte(strings.Repeat("o", 32)), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := NewVerificationTokens([]byte(strings.Repeat("s", 32)), time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, expiresAt, err := vt.NewToken("u1", "asha@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expiresAt) <= 0 {
+		t.Fatalf("token expires in the past: %v", expiresAt)
+	}
+
+	userId, email, err := vt.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if userId != "u1" || email != "asha@example.com" {
+		t.Errorf("ValidateToken() = %q, %q, want u1, asha@example.com", userId, email)
+	}
+
+	expiredToken, _, err := expired.NewToken("u1", "asha@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"no signature", payload},
+		{"signed with another secret", payload + "." + other.sign(payload)},
+		{"tampered payload", "x" + payload + "." + signature},
+		{"expired", expiredToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := vt.ValidateToken(tt.token)
+			if !errors.Is(err, ErrInvalidVerificationToken) {
+				t.Errorf("ValidateToken() error = %v, want %v", err, ErrInvalidVerificationToken)
+			}
+		})
+	}
+}
+
+func TestNewVerificationTokensRejectsShortSecret(t *testing.T) {
+	_, err := NewVerificationTokens([]byte("short"), time.Hour)
+	if err == nil {
+		t.Fatal("expected an error for a short secret")
+	}
+}
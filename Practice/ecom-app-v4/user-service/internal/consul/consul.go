@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	consulapi "github.com/hashicorp/consul/api"
+	"math/rand"
 	"os"
 	"strconv"
 )
@@ -122,3 +123,30 @@ func RegisterWithConsul() (*consulapi.Client, string, error) {
 	// - no error
 	return client, regId, nil
 }
+
+// GetService returns the address and port of a healthy instance of the service,
+// a random instance is picked when more than one is running
+func GetService(client *consulapi.Client, serviceName string) (string, int, error) {
+	// only services with passing health checks are returned
+	services, _, err := client.Health().Service(serviceName, "", true, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(services) == 0 {
+		return "", 0, fmt.Errorf("no healthy instance of %s", serviceName)
+	}
+
+	service := services[rand.Intn(len(services))]
+	return service.Service.Address, service.Service.Port, nil
+}
+
+// GetServiceAddress looks up the service registered for the endpoint prefix (e.g. "products")
+// in the consul KV store and returns the address of one of its instances
+func GetServiceAddress(client *consulapi.Client, serviceEndpoint string) (string, int, error) {
+	pair, _, err := client.KV().Get(serviceEndpoint, nil)
+	if err != nil || pair == nil {
+		return "", 0, fmt.Errorf("service not found")
+	}
+
+	return GetService(client, string(pair.Value))
+}
@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GitHub is not an OpenID Connect provider, there is no id token.
+// The user and the verified primary email are read from the API with the access token instead.
+type GitHub struct {
+	cfg    Config
+	client *http.Client
+
+	authURL  string
+	tokenURL string
+	apiURL   string
+}
+
+func NewGitHub(cfg Config, client *http.Client) *GitHub {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"read:user", "user:email"}
+	}
+	return &GitHub{
+		cfg:      cfg,
+		client:   client,
+		authURL:  "https://github.com/login/oauth/authorize",
+		tokenURL: "https://github.com/login/oauth/access_token",
+		apiURL:   "https://api.github.com",
+	}
+}
+
+func (g *GitHub) Name() string {
+	return g.cfg.Name
+}
+
+// AuthCodeURL ignores the nonce, it only protects id tokens and the state already ties the code to the login
+func (g *GitHub) AuthCodeURL(_ context.Context, state, _, codeChallenge string) (string, error) {
+	query := url.Values{
+		"client_id":             {g.cfg.ClientID},
+		"redirect_uri":          {g.cfg.RedirectURL},
+		"scope":                 {strings.Join(g.cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	return g.authURL + "?" + query.Encode(), nil
+}
+
+func (g *GitHub) Exchange(ctx context.Context, code, codeVerifier, _ string) (Identity, error) {
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := exchangeCode(ctx, g.client, g.tokenURL, g.cfg, code, codeVerifier, &tokens)
+	if err != nil {
+		return Identity{}, err
+	}
+	if tokens.AccessToken == "" {
+		return Identity{}, fmt.Errorf("%w: no access token in the response", ErrExchangeFailed)
+	}
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, g.client, g.apiURL+"/user", tokens.AccessToken, &user); err != nil {
+		return Identity{}, fmt.Errorf("fetching the github user: %w", err)
+	}
+	if user.ID == 0 {
+		return Identity{}, fmt.Errorf("fetching the github user: no id")
+	}
+
+	// the email on the profile may be unverified or hidden, the primary one of the email list is reliable
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, g.client, g.apiURL+"/user/emails", tokens.AccessToken, &emails); err != nil {
+		return Identity{}, fmt.Errorf("fetching the github emails: %w", err)
+	}
+
+	identity := Identity{
+		Provider: g.cfg.Name,
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Name:     user.Name,
+	}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+		}
+	}
+	return identity, nil
+}
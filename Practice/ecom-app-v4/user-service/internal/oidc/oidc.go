@@ -0,0 +1,343 @@
+// Package oidc signs users in with external identity providers such as Google (OpenID Connect) and GitHub (OAuth2).
+// Both use the authorization code flow with PKCE, the provider only returns who the user is,
+// the session and the tokens are ours.
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"user-service/internal/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrExchangeFailed = errors.New("authorization code exchange failed")
+)
+
+// keysMinRefresh limits how often an unknown kid makes us load the keys of the provider again
+const keysMinRefresh = 30 * time.Second
+
+// Identity is who the provider says the user is
+type Identity struct {
+	Provider      string
+	Subject       string // stable id of the user at the provider, emails can change
+	Email         string
+	EmailVerified bool // only a verified email is used to find or create the local user
+	Name          string
+}
+
+// Provider is an identity provider users can sign in with
+type Provider interface {
+	Name() string
+	// AuthCodeURL is where the user is sent to sign in, the provider redirects back with a code and the state
+	AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+	// Exchange trades the code for the identity of the user, the verifier proves we started the login
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (Identity, error)
+}
+
+// Config is the client registration at a provider
+type Config struct {
+	Name         string
+	Issuer       string // OpenID Connect providers only, the endpoints are discovered from it
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // the page the provider sends the user back to, it posts the code to the callback endpoint
+	Scopes       []string
+}
+
+// CodeChallenge derives the S256 PKCE challenge sent with the authorization request from the verifier (RFC 7636)
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ProvidersFromEnv returns the providers listed in OIDC_PROVIDERS (comma separated, e.g. google,github).
+// Every provider is configured with OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET, OIDC_<NAME>_ISSUER and
+// OIDC_<NAME>_REDIRECT_URL. The redirect url defaults to OIDC_REDIRECT_URL/<name> and Google's issuer is known.
+// github is the only provider that is not OpenID Connect.
+func ProvidersFromEnv(client *http.Client) (map[string]Provider, error) {
+	providers := make(map[string]Provider)
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		cfg := Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+		if cfg.ClientID == "" {
+			return nil, fmt.Errorf("%sCLIENT_ID is not set", prefix)
+		}
+		if cfg.RedirectURL == "" {
+			base := os.Getenv("OIDC_REDIRECT_URL")
+			if base == "" {
+				return nil, fmt.Errorf("%sREDIRECT_URL or OIDC_REDIRECT_URL must be set", prefix)
+			}
+			cfg.RedirectURL = strings.TrimSuffix(base, "/") + "/" + name
+		}
+
+		switch {
+		case name == "github":
+			providers[name] = NewGitHub(cfg, client)
+		case name == "google" && cfg.Issuer == "":
+			cfg.Issuer = "https://accounts.google.com"
+			fallthrough
+		default:
+			if cfg.Issuer == "" {
+				return nil, fmt.Errorf("%sISSUER is not set", prefix)
+			}
+			providers[name] = NewOIDC(cfg, client)
+		}
+	}
+	return providers, nil
+}
+
+// metadata is the part of the discovery document we use
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDC is an OpenID Connect provider, the user is taken from the id token
+type OIDC struct {
+	cfg    Config
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        *metadata // discovered on first use, the service starts even when the provider is down
+	keys        map[string]*rsa.PublicKey
+	refreshedAt time.Time
+}
+
+func NewOIDC(cfg Config, client *http.Client) *OIDC {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &OIDC{cfg: cfg, client: client}
+}
+
+func (o *OIDC) Name() string {
+	return o.cfg.Name
+}
+
+func (o *OIDC) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	meta, err := o.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.cfg.ClientID},
+		"redirect_uri":          {o.cfg.RedirectURL},
+		"scope":                 {strings.Join(o.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	return meta.AuthorizationEndpoint + "?" + query.Encode(), nil
+}
+
+// idTokenClaims are the claims of the id token we use
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+func (o *OIDC) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Identity, error) {
+	meta, err := o.metadata(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = exchangeCode(ctx, o.client, meta.TokenEndpoint, o.cfg, code, codeVerifier, &tokens)
+	if err != nil {
+		return Identity{}, err
+	}
+	if tokens.IDToken == "" {
+		return Identity{}, fmt.Errorf("%w: no id token in the response", ErrExchangeFailed)
+	}
+
+	var claims idTokenClaims
+	_, err = jwt.ParseWithClaims(tokens.IDToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.publicKey(ctx, meta, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(o.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+	// the nonce ties the id token to the login we started, a token from another login is not replayed into this one
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return Identity{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	return Identity{
+		Provider:      o.cfg.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// metadata loads the discovery document once, a failed load is tried again on the next login
+func (o *OIDC) metadata(ctx context.Context) (*metadata, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.meta != nil {
+		return o.meta, nil
+	}
+
+	var meta metadata
+	err := getJSON(ctx, o.client, strings.TrimSuffix(o.cfg.Issuer, "/")+"/.well-known/openid-configuration", "", &meta)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", o.cfg.Name, err)
+	}
+	// the document must be the issuer's own (OpenID Connect Discovery section 4.3)
+	if meta.Issuer != o.cfg.Issuer {
+		return nil, fmt.Errorf("discovering %s: issuer %q does not match %q", o.cfg.Name, meta.Issuer, o.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("discovering %s: incomplete discovery document", o.cfg.Name)
+	}
+	o.meta = &meta
+	return o.meta, nil
+}
+
+// publicKey returns the signing key with the kid, the keys are loaded again when the provider rotated them
+func (o *OIDC) publicKey(ctx context.Context, meta *metadata, kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(o.refreshedAt) < keysMinRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	o.refreshedAt = time.Now()
+
+	var jwks auth.JWKS
+	if err := getJSON(ctx, o.client, meta.JWKSURI, "", &jwks); err != nil {
+		return nil, fmt.Errorf("loading the keys of %s: %w", o.cfg.Name, err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// providers publish keys of other types too, they are skipped
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	o.keys = keys
+
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// exchangeCode redeems the authorization code at the token endpoint and decodes the response into tokens
+func exchangeCode(ctx context.Context, client *http.Client, endpoint string, cfg Config, code, codeVerifier string, tokens any) error {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {cfg.RedirectURL},
+		"client_id":     {cfg.ClientID},
+		"client_secret": {cfg.ClientSecret},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrExchangeFailed, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrExchangeFailed, err)
+	}
+	// GitHub answers errors with 200 and an error field
+	var oauthErr struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	_ = json.Unmarshal(body, &oauthErr)
+	if resp.StatusCode != http.StatusOK || oauthErr.Error != "" {
+		return fmt.Errorf("%w: status %d %s %s", ErrExchangeFailed, resp.StatusCode, oauthErr.Error, oauthErr.Description)
+	}
+	if err := json.Unmarshal(body, tokens); err != nil {
+		return fmt.Errorf("%w: %w", ErrExchangeFailed, err)
+	}
+	return nil
+}
+
+// getJSON fetches a JSON document, with an access token when one is given
+func getJSON(ctx context.Context, client *http.Client, endpoint, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,255 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+	"user-service/internal/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a local OpenID Connect provider, it hands out one code per authorization request
+// and checks the PKCE verifier when the code is redeemed
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]url.Values // code -> the authorization request it was issued for
+
+	// the id token the provider issues, tests change it to get a bad one
+	claims func(req url.Values) jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{t: t, key: key, codes: make(map[string]url.Values)}
+	m.claims = func(req url.Values) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            m.server.URL,
+			"aud":            "client-1",
+			"sub":            "subject-1",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          req.Get("nonce"),
+			"email":          "asha@example.com",
+			"email_verified": true,
+			"name":           "Asha",
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(auth.JWKS{Keys: []auth.JWK{{
+			Kty: "RSA",
+			Use: "sig",
+			Kid: "k1",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		req, ok := m.codes[r.Form.Get("code")]
+		delete(m.codes, r.Form.Get("code"))
+		m.mu.Unlock()
+
+		if !ok || r.Form.Get("client_id") != "client-1" || r.Form.Get("client_secret") != "secret-1" ||
+			r.Form.Get("redirect_uri") != req.Get("redirect_uri") ||
+			CodeChallenge(r.Form.Get("code_verifier")) != req.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims(req))
+		token.Header["kid"] = "k1"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize stands in for the user signing in at the provider, it returns the code the redirect would carry
+func (m *mockProvider) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	if u.Query().Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("authorization request without S256 challenge: %s", authURL)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := "code-" + u.Query().Get("state")
+	m.codes[code] = u.Query()
+	return code
+}
+
+func TestOIDCLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string // sent with the code, empty is the one the login started with
+		nonce    string // expected by the exchange, empty is the one sent to the provider
+		claims   func(jwt.MapClaims)
+		want     Identity
+		wantErr  error
+	}{
+		{
+			name: "valid login",
+			want: Identity{Provider: "mock", Subject: "subject-1", Email: "asha@example.com", EmailVerified: true, Name: "Asha"},
+		},
+		{
+			name:     "wrong code verifier",
+			verifier: "another-verifier-another-verifier-another-verifier",
+			wantErr:  ErrExchangeFailed,
+		},
+		{
+			name:    "nonce of another login",
+			nonce:   "other-nonce",
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:    "token for another client",
+			claims:  func(c jwt.MapClaims) { c["aud"] = "client-2" },
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:    "token from another issuer",
+			claims:  func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:    "expired token",
+			claims:  func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:   "unverified email is passed on",
+			claims: func(c jwt.MapClaims) { c["email_verified"] = false },
+			want:   Identity{Provider: "mock", Subject: "subject-1", Email: "asha@example.com", Name: "Asha"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			if tt.claims != nil {
+				base := m.claims
+				m.claims = func(req url.Values) jwt.MapClaims {
+					c := base(req)
+					tt.claims(c)
+					return c
+				}
+			}
+			p := NewOIDC(Config{
+				Name:         "mock",
+				Issuer:       m.server.URL,
+				ClientID:     "client-1",
+				ClientSecret: "secret-1",
+				RedirectURL:  "http://localhost/oauth/mock",
+			}, m.server.Client())
+
+			state, nonce, verifier := "state-"+string(rune('a'+i)), "nonce-1", "verifier-verifier-verifier-verifier-verifier"
+			authURL, err := p.AuthCodeURL(context.Background(), state, nonce, CodeChallenge(verifier))
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := m.authorize(authURL)
+
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			got, err := p.Exchange(context.Background(), code, verifier, nonce)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Exchange() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubLogin(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "code-1" || CodeChallenge(r.Form.Get("code_verifier")) != CodeChallenge("verifier-1") {
+			// GitHub reports errors with a 200
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_1", "token_type": "bearer"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": 42, "login": "asha"})
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "asha@example.com", "primary": true, "verified": true},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	g := NewGitHub(Config{Name: "github", ClientID: "client-1", RedirectURL: "http://localhost/oauth/github"}, server.Client())
+	g.tokenURL = server.URL + "/login/oauth/access_token"
+	g.apiURL = server.URL
+
+	got, err := g.Exchange(context.Background(), "code-1", "verifier-1", "")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	want := Identity{Provider: "github", Subject: "42", Email: "asha@example.com", EmailVerified: true, Name: "asha"}
+	if got != want {
+		t.Errorf("Exchange() = %+v, want %+v", got, want)
+	}
+
+	if _, err := g.Exchange(context.Background(), "code-1", "verifier-2", ""); !errors.Is(err, ErrExchangeFailed) {
+		t.Errorf("Exchange() with the wrong verifier error = %v, want %v", err, ErrExchangeFailed)
+	}
+}
@@ -26,7 +26,7 @@ func (c *Conf) ConsumeMessage(ctx context.Context, ch chan ConsumeResult) {
 		// This might occur if Kafka is unavailable or there are connection issues.
 		if errs := fetches.Errors(); len(errs) > 0 {
 			// Log the errors to help diagnose what went wrong (e.g., Kafka being down).
-			slog.Error("ERROR: ", errs)
+			slog.Error("ERROR: ", slog.Any("errors", errs))
 
 			// If there's an error (e.g., temporary network issues or Kafka being down),
 			// wait for 5 seconds before retrying to avoid overwhelming the system.
@@ -3,8 +3,11 @@ package kafka
 import "time"
 
 const (
-	TopicAccountCreated = `user-service.account-created`
-	ConsumerGroup       = `user-service`
+	TopicAccountCreated             = `user-service.account-created`
+	TopicEmailVerificationRequested = `user-service.email-verification-requested`
+	TopicPasswordResetRequested     = `user-service.password-reset-requested`
+	TopicUserLocked                 = `user-service.user-locked`
+	ConsumerGroup                   = `user-service`
 )
 
 // Representation of event that we would get in kafka
@@ -16,3 +19,34 @@ type MSGUserServiceAccountCreated struct {
 	CreatedAt time.Time `json:"created_at"` // Timestamp of creation
 	UpdatedAt time.Time `json:"updated_at"` // Timestamp of last update
 }
+
+// MSGEmailVerificationRequested is published on signup and when a user asks for the verification email again,
+// the product service emails the link to the user
+type MSGEmailVerificationRequested struct {
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	VerifyURL string    `json:"verify_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MSGPasswordResetRequested is published when a user forgot the password, the product service emails the reset link
+type MSGPasswordResetRequested struct {
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	ResetURL  string    `json:"reset_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MSGUserLocked is published when too many failed logins locked an account, the product service tells the owner
+type MSGUserLocked struct {
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	IP          string    `json:"ip"` // where the failure that locked it came from
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS addresses (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    full_name TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    line1 TEXT NOT NULL,
+    line2 TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL,
+    state TEXT NOT NULL,
+    postal_code TEXT NOT NULL,
+    country CHAR(2) NOT NULL DEFAULT 'IN',     -- ISO 3166-1 alpha-2 code
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+-- a user can only have one default address
+CREATE UNIQUE INDEX IF NOT EXISTS addresses_one_default_idx ON addresses (user_id) WHERE is_default;
+CREATE INDEX IF NOT EXISTS addresses_user_idx ON addresses (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS addresses;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- failed logins per email and per client IP. Emails are tracked whether or not an account uses them,
+-- so the throttling behaves the same for every email and does not tell which ones exist
+CREATE TABLE IF NOT EXISTS login_failures (
+    kind TEXT NOT NULL CHECK (kind IN ('email', 'ip')),
+    subject TEXT NOT NULL, -- the lower cased email or the IP
+    failures INT NOT NULL,
+    window_start TIMESTAMP NOT NULL, -- failures older than the window are forgotten
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP, -- emails only, logins are refused until then
+    PRIMARY KEY (kind, subject)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS login_failures;
+-- +goose StatementEnd
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+-- accounts at external identity providers (Google, GitHub) linked to a local user
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL, -- id of the user at the provider, it does not change with the email
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL, -- as the provider reported it when the account was linked
+    created_at TIMESTAMP,
+    last_login_at TIMESTAMP,
+    PRIMARY KEY (provider, subject)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities (user_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- a login started at a provider, the state comes back with the code and is used once
+CREATE TABLE IF NOT EXISTS external_logins (
+    state_hash TEXT PRIMARY KEY, -- sha256 of the state
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL, -- PKCE, only we can redeem the code
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS external_logins;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_identities;
+-- +goose StatementEnd
@@ -0,0 +1,66 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the roles admins can give users, users.roles holds their names
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- what the services check, the list is fixed by the code that checks them
+CREATE TABLE IF NOT EXISTS permissions (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission TEXT NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+    created_at TIMESTAMP,
+    PRIMARY KEY (role, permission)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO roles (name, description, created_at, updated_at) VALUES
+    ('user', 'every account', now(), now()),
+    ('admin', 'runs the shop', now(), now())
+ON CONFLICT (name) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO permissions (name, description) VALUES
+    ('products:write', 'create, update and restock products'),
+    ('orders:read', 'see the orders, invoices and returns of every customer'),
+    ('orders:manage', 'move orders along, record shipments and cancel any order'),
+    ('orders:refund', 'refund orders'),
+    ('returns:manage', 'review and receive returns'),
+    ('coupons:manage', 'create and list coupons'),
+    ('users:manage', 'manage users, roles and their permissions')
+ON CONFLICT (name) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- admins keep everything they could do with the role check
+INSERT INTO role_permissions (role, permission, created_at)
+SELECT 'admin', name, now() FROM permissions
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS role_permissions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS permissions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS roles;
+-- +goose StatementEnd
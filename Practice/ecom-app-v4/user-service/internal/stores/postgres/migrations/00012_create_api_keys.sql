@@ -0,0 +1,57 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE, -- the owner, requests with the key act for them
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL, -- the start of the key, shown so keys can be told apart
+    key_hash TEXT NOT NULL UNIQUE, -- sha256 of the key, the key itself is only shown when it is created
+    permissions TEXT[] NOT NULL, -- the key never has more than these, nor more than its owner
+    created_by UUID NOT NULL, -- the owner or the admin that created the key for them
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys (user_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- the access tokens keys were exchanged for, revoking the key revokes them
+CREATE TABLE IF NOT EXISTS api_key_tokens (
+    jti TEXT PRIMARY KEY,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS api_key_tokens_key_idx ON api_key_tokens (api_key_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO permissions (name, description) VALUES
+    ('api-keys:create', 'create API keys for your own account')
+ON CONFLICT (name) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO role_permissions (role, permission, created_at) VALUES ('admin', 'api-keys:create', now())
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name = 'api-keys:create';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS api_key_tokens;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS api_keys;
+-- +goose StatementEnd
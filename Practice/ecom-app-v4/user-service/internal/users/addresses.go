@@ -0,0 +1,229 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrAddressNotFound = errors.New("address not found")
+
+const defaultCountry = "IN"
+
+const addressColumns = `
+	id, user_id, full_name, phone, line1, line2, city, state, postal_code, country, is_default, created_at, updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAddress(row scanner) (Address, error) {
+	var a Address
+	err := row.Scan(&a.ID, &a.UserID, &a.FullName, &a.Phone, &a.Line1, &a.Line2, &a.City, &a.State,
+		&a.PostalCode, &a.Country, &a.IsDefault, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+// countryOrDefault returns the country code of the address, the handlers upper-case it before it is validated
+func countryOrDefault(country string) string {
+	if country == "" {
+		return defaultCountry
+	}
+	return country
+}
+
+// createsDefault reports whether a new address becomes the default of the user, a user always has one
+// once they saved an address, so the first one is the default whether it was asked for or not
+func createsDefault(requested bool, count int) bool {
+	return requested || count == 0
+}
+
+// clearDefaultAddress unsets the current default so another address can become the default
+func clearDefaultAddress(ctx context.Context, tx *sql.Tx, userId string) error {
+	_, err := tx.ExecContext(ctx, `
+	UPDATE addresses
+	SET is_default = FALSE
+	WHERE user_id = $1 AND is_default
+	`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to clear default address: %w", err)
+	}
+	return nil
+}
+
+// CreateAddress saves a new address for the user, the first address of a user is always the default
+func (c *Conf) CreateAddress(ctx context.Context, userId string, na NewAddress) (Address, error) {
+	var address Address
+	now := time.Now().UTC()
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var count int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM addresses WHERE user_id = $1`, userId).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to count addresses: %w", err)
+		}
+
+		isDefault := createsDefault(na.IsDefault, count)
+		if isDefault {
+			err = clearDefaultAddress(ctx, tx, userId)
+			if err != nil {
+				return err
+			}
+		}
+
+		query := `
+		INSERT INTO addresses
+		(id, user_id, full_name, phone, line1, line2, city, state, postal_code, country, is_default, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING ` + addressColumns
+
+		address, err = scanAddress(tx.QueryRowContext(ctx, query, uuid.NewString(), userId, na.FullName, na.Phone,
+			na.Line1, na.Line2, na.City, na.State, na.PostalCode, countryOrDefault(na.Country), isDefault, now, now))
+		if err != nil {
+			return fmt.Errorf("failed to insert address: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return Address{}, fmt.Errorf("failed to create address: %w", err)
+	}
+	return address, nil
+}
+
+// ListAddresses returns the addresses of the user, the default address first
+func (c *Conf) ListAddresses(ctx context.Context, userId string) ([]Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = $1 ORDER BY is_default DESC, created_at DESC`
+
+	rows, err := c.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch addresses: %w", err)
+	}
+	defer rows.Close()
+
+	addresses := []Address{}
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		addresses = append(addresses, address)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return addresses, nil
+}
+
+// GetAddress returns an address of the user, ErrAddressNotFound is returned for addresses of other users
+func (c *Conf) GetAddress(ctx context.Context, userId, addressId string) (Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE id = $1 AND user_id = $2`
+
+	address, err := scanAddress(c.db.QueryRowContext(ctx, query, addressId, userId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Address{}, ErrAddressNotFound
+		}
+		return Address{}, fmt.Errorf("failed to fetch address: %w", err)
+	}
+	return address, nil
+}
+
+// UpdateAddress replaces the fields of the address. Orders keep their own copy of the address,
+// so the change only applies to future checkouts.
+func (c *Conf) UpdateAddress(ctx context.Context, userId, addressId string, na NewAddress) (Address, error) {
+	var address Address
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		// a default address stays the default, is_default can only promote the address
+		if na.IsDefault {
+			err := clearDefaultAddress(ctx, tx, userId)
+			if err != nil {
+				return err
+			}
+		}
+
+		query := `
+		UPDATE addresses
+		SET full_name = $1, phone = $2, line1 = $3, line2 = $4, city = $5, state = $6, postal_code = $7,
+			country = $8, is_default = is_default OR $9, updated_at = $10
+		WHERE id = $11 AND user_id = $12
+		RETURNING ` + addressColumns
+
+		var err error
+		address, err = scanAddress(tx.QueryRowContext(ctx, query, na.FullName, na.Phone, na.Line1, na.Line2, na.City,
+			na.State, na.PostalCode, countryOrDefault(na.Country), na.IsDefault, time.Now().UTC(), addressId, userId))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrAddressNotFound
+			}
+			return fmt.Errorf("failed to update address: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return Address{}, err
+	}
+	return address, nil
+}
+
+// SetDefaultAddress makes the address the default of the user
+func (c *Conf) SetDefaultAddress(ctx context.Context, userId, addressId string) error {
+	return c.withTx(ctx, func(tx *sql.Tx) error {
+		err := clearDefaultAddress(ctx, tx, userId)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+		UPDATE addresses
+		SET is_default = TRUE, updated_at = $1
+		WHERE id = $2 AND user_id = $3
+		`, time.Now().UTC(), addressId, userId)
+		if err != nil {
+			return fmt.Errorf("failed to set default address: %w", err)
+		}
+		if num, err := res.RowsAffected(); num == 0 || err != nil {
+			return ErrAddressNotFound
+		}
+		return nil
+	})
+}
+
+// DeleteAddress removes the address, when the default is removed the newest remaining address becomes the default
+func (c *Conf) DeleteAddress(ctx context.Context, userId, addressId string) error {
+	return c.withTx(ctx, func(tx *sql.Tx) error {
+		var wasDefault bool
+		err := tx.QueryRowContext(ctx, `
+		DELETE FROM addresses
+		WHERE id = $1 AND user_id = $2
+		RETURNING is_default
+		`, addressId, userId).Scan(&wasDefault)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrAddressNotFound
+			}
+			return fmt.Errorf("failed to delete address: %w", err)
+		}
+
+		if !wasDefault {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE addresses
+		SET is_default = TRUE
+		WHERE id = (SELECT id FROM addresses WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1)
+		`, userId)
+		if err != nil {
+			return fmt.Errorf("failed to promote default address: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,33 @@
+package users
+
+import "testing"
+
+func TestCountryOrDefault(t *testing.T) {
+	if got := countryOrDefault(""); got != defaultCountry {
+		t.Errorf("countryOrDefault(\"\") = %q, want %q", got, defaultCountry)
+	}
+	if got := countryOrDefault("DE"); got != "DE" {
+		t.Errorf("countryOrDefault(\"DE\") = %q, want %q", got, "DE")
+	}
+}
+
+func TestCreatesDefault(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested bool
+		count     int
+		want      bool
+	}{
+		{name: "first address", count: 0, want: true},
+		{name: "first address asked to be the default", requested: true, count: 0, want: true},
+		{name: "asked to be the default", requested: true, count: 2, want: true},
+		{name: "another address", count: 2, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := createsDefault(tt.requested, tt.count); got != tt.want {
+				t.Errorf("createsDefault(%v, %d) = %v, want %v", tt.requested, tt.count, got, tt.want)
+			}
+		})
+	}
+}
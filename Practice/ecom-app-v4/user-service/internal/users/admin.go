@@ -0,0 +1,129 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ListUsers returns a page of the users matching the filter, newest first
+func (c *Conf) ListUsers(ctx context.Context, filter UserFilter) (UserPage, error) {
+	var conditions []string
+	var args []any
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		args = append(args, "%"+escapeLike(q)+"%")
+		conditions = append(conditions, fmt.Sprintf("(name ILIKE $%d OR email ILIKE $%d)", len(args), len(args)))
+	}
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		conditions = append(conditions, fmt.Sprintf("$%d = ANY(roles)", len(args)))
+	}
+	if filter.Disabled != nil {
+		args = append(args, *filter.Disabled)
+		conditions = append(conditions, fmt.Sprintf("(disabled_at IS NOT NULL) = $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`
+	SELECT %s, COUNT(*) OVER ()
+	FROM users
+	%s
+	ORDER BY created_at DESC, id
+	LIMIT $%d OFFSET $%d
+	`, userColumns, where, len(args)-1, len(args))
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return UserPage{}, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	page := UserPage{Users: []User{}}
+	for rows.Next() {
+		user, err := scanUser(rows, &page.Total)
+		if err != nil {
+			return UserPage{}, fmt.Errorf("failed to scan user: %w", err)
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return UserPage{}, fmt.Errorf("failed to list users: %w", err)
+	}
+	// an offset past the end has no rows to carry the total
+	if len(page.Users) == 0 && filter.Offset > 0 {
+		err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users `+where, args[:len(args)-2]...).Scan(&page.Total)
+		if err != nil {
+			return UserPage{}, fmt.Errorf("failed to count users: %w", err)
+		}
+	}
+	return page, nil
+}
+
+// SetRoles replaces the roles of the user. The roles are part of the access token, so every session of the user
+// is logged out and the new roles apply from the next login.
+func (c *Conf) SetRoles(ctx context.Context, userId string, roles []string) (User, error) {
+	var known int
+	err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM roles WHERE name = ANY($1)`, pq.StringArray(roles)).Scan(&known)
+	if err != nil {
+		return User{}, fmt.Errorf("failed to check roles: %w", err)
+	}
+	if known != len(roles) {
+		return User{}, ErrUnknownRole
+	}
+	return c.updateAccount(ctx, userId, `UPDATE users SET roles = $2, updated_at = $3 WHERE id = $1 RETURNING `+userColumns,
+		pq.StringArray(roles))
+}
+
+// SetDisabled disables or enables the account of the user. A disabled user is logged out everywhere and can not log in,
+// refresh a session or reset the password until an admin enables the account again.
+func (c *Conf) SetDisabled(ctx context.Context, userId string, disabled bool) (User, error) {
+	query := `
+	UPDATE users
+	SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, $3) ELSE NULL END, updated_at = $3
+	WHERE id = $1
+	RETURNING ` + userColumns
+	return c.updateAccount(ctx, userId, query, disabled)
+}
+
+// updateAccount runs the update of the user, query takes the user id, value and the current time,
+// and revokes the sessions of the user and the tokens of their API keys in the same transaction
+func (c *Conf) updateAccount(ctx context.Context, userId, query string, value any) (User, error) {
+	var user User
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var err error
+		user, err = scanUser(tx.QueryRowContext(ctx, query, userId, value, now))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if err := revokeUserAPIKeyTokens(ctx, tx, userId, now); err != nil {
+			return err
+		}
+		return revokeUserSessions(ctx, tx, userId, now)
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, fmt.Errorf("failed to update account: %w", err)
+	}
+	return user, nil
+}
+
+// escapeLike makes the search text match literally inside a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
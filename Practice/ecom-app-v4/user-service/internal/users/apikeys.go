@@ -0,0 +1,255 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"user-service/internal/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrInvalidAPIKey     = errors.New("invalid, expired or revoked api key")
+	ErrPermissionNotHeld = errors.New("the owner does not have every permission of the key")
+)
+
+// apiKeyPrefix marks the keys, so one that leaked into code or logs is easy to spot
+const apiKeyPrefix = "ek_"
+
+// APIKeyTokenTTL is how long the access token an API key is exchanged for is valid.
+// It is short since the permissions of the owner are only checked again with the next exchange.
+const APIKeyTokenTTL = 5 * time.Minute
+
+const apiKeyColumns = `id, user_id, name, prefix, permissions, created_by, expires_at, last_used_at, revoked_at, created_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var permissions pq.StringArray
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &permissions, &key.CreatedBy,
+		&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt)
+	key.Permissions = []string(permissions)
+	return key, err
+}
+
+// CreateAPIKey creates a key for the owner, createdBy is the owner or the admin doing it for them.
+// The key can only be given permissions the owner has, ErrPermissionNotHeld is returned otherwise.
+func (c *Conf) CreateAPIKey(ctx context.Context, ownerId, createdBy string, newKey NewAPIKey) (CreatedAPIKey, error) {
+	owner, err := c.GetUser(ctx, ownerId)
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	claims, err := c.Claims(ctx, owner)
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	if !claims.HasPermissions(newKey.Permissions...) {
+		return CreatedAPIKey{}, ErrPermissionNotHeld
+	}
+
+	secret, err := newSecretToken()
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	now := time.Now().UTC()
+	created := CreatedAPIKey{
+		APIKey: APIKey{
+			ID:          uuid.NewString(),
+			UserID:      owner.ID,
+			Name:        strings.TrimSpace(newKey.Name),
+			Prefix:      apiKeyPrefix + secret[:6],
+			Permissions: newKey.Permissions,
+			CreatedBy:   createdBy,
+			CreatedAt:   now,
+		},
+		Key: apiKeyPrefix + secret,
+	}
+	if newKey.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, newKey.ExpiresInDays)
+		created.ExpiresAt = &expiresAt
+	}
+
+	query := `
+	INSERT INTO api_keys (id, user_id, name, prefix, key_hash, permissions, created_by, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	_, err = c.db.ExecContext(ctx, query, created.ID, created.UserID, created.Name, created.Prefix, hashToken(created.Key),
+		pq.StringArray(created.Permissions), created.CreatedBy, created.ExpiresAt, created.CreatedAt)
+	if err != nil {
+		return CreatedAPIKey{}, fmt.Errorf("failed to create api key: %w", err)
+	}
+	return created, nil
+}
+
+// ListAPIKeys returns the keys of the user, the revoked ones included, newest first
+func (c *Conf) ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes the key of the user and the access tokens it was exchanged for,
+// they stop working once the services synced the revocations
+func (c *Conf) RevokeAPIKey(ctx context.Context, userId, keyId string) (APIKey, error) {
+	var key APIKey
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		query := `
+		UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $3)
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + apiKeyColumns
+		var err error
+		key, err = scanAPIKey(tx.QueryRowContext(ctx, query, keyId, userId, now))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAPIKeyNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to revoke api key: %w", err)
+		}
+
+		query = `
+		INSERT INTO revoked_tokens (jti, expires_at, revoked_at)
+		SELECT jti, expires_at, $2
+		FROM api_key_tokens
+		WHERE api_key_id = $1 AND expires_at > $2
+		ON CONFLICT (jti) DO NOTHING
+		`
+		_, err = tx.ExecContext(ctx, query, keyId, now)
+		if err != nil {
+			return fmt.Errorf("failed to revoke api key tokens: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return APIKey{}, ErrAPIKeyNotFound
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// ExchangeAPIKey returns the claims of a short lived access token for the key. The token acts for the owner
+// with the permissions of the key the owner still has, a disabled owner or a revoked or expired key get ErrInvalidAPIKey.
+// The token is remembered so revoking the key revokes it as well. The services only take it on the routes
+// gated by a permission, it can not shop, check out or change the account of its owner.
+func (c *Conf) ExchangeAPIKey(ctx context.Context, key string) (auth.Claims, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return auth.Claims{}, ErrInvalidAPIKey
+	}
+
+	var claims auth.Claims
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var keyId, userId string
+		var permissions pq.StringArray
+		var expiresAt, revokedAt sql.NullTime
+		query := `SELECT id, user_id, permissions, expires_at, revoked_at FROM api_keys WHERE key_hash = $1`
+		err := tx.QueryRowContext(ctx, query, hashToken(key)).Scan(&keyId, &userId, &permissions, &expiresAt, &revokedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidAPIKey
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch api key: %w", err)
+		}
+		if revokedAt.Valid || (expiresAt.Valid && !expiresAt.Time.After(now)) {
+			return ErrInvalidAPIKey
+		}
+
+		owner, err := scanUser(tx.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, userId))
+		if err != nil {
+			return fmt.Errorf("failed to fetch api key owner: %w", err)
+		}
+		if owner.Disabled {
+			return ErrInvalidAPIKey
+		}
+
+		claims, err = claimsFor(ctx, tx, owner)
+		if err != nil {
+			return err
+		}
+		claims.Roles = []string{}
+		claims.Permissions = keyPermissions(permissions, claims.Permissions)
+		claims.APIKey = keyId
+		tokenExpiresAt := now.Add(APIKeyTokenTTL)
+		if expiresAt.Valid && expiresAt.Time.Before(tokenExpiresAt) {
+			tokenExpiresAt = expiresAt.Time
+		}
+		claims.ExpiresAt = jwt.NewNumericDate(tokenExpiresAt)
+
+		_, err = tx.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, keyId, now)
+		if err != nil {
+			return fmt.Errorf("failed to record api key use: %w", err)
+		}
+		// the tokens are only kept until they expire, a revocation has nothing to do for them after that
+		_, err = tx.ExecContext(ctx, `DELETE FROM api_key_tokens WHERE api_key_id = $1 AND expires_at <= $2`, keyId, now)
+		if err != nil {
+			return fmt.Errorf("failed to clean up api key tokens: %w", err)
+		}
+		query = `INSERT INTO api_key_tokens (jti, api_key_id, expires_at) VALUES ($1, $2, $3)`
+		_, err = tx.ExecContext(ctx, query, claims.ID, keyId, tokenExpiresAt)
+		if err != nil {
+			return fmt.Errorf("failed to store api key token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidAPIKey) {
+			return auth.Claims{}, ErrInvalidAPIKey
+		}
+		return auth.Claims{}, fmt.Errorf("failed to exchange api key: %w", err)
+	}
+	return claims, nil
+}
+
+// keyPermissions are the permissions of the key its owner still has, a key of a demoted owner loses the rest
+func keyPermissions(key, owner []string) []string {
+	permissions := []string{}
+	for _, p := range key {
+		if slices.Contains(owner, p) {
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions
+}
+
+// revokeUserAPIKeyTokens revokes the access tokens the keys of the user were exchanged for,
+// the keys themselves stay and are exchanged with the current permissions of the user
+func revokeUserAPIKeyTokens(ctx context.Context, tx *sql.Tx, userId string, now time.Time) error {
+	query := `
+	INSERT INTO revoked_tokens (jti, expires_at, revoked_at)
+	SELECT t.jti, t.expires_at, $2
+	FROM api_key_tokens t JOIN api_keys k ON k.id = t.api_key_id
+	WHERE k.user_id = $1 AND t.expires_at > $2
+	ON CONFLICT (jti) DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, userId, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// Contact is what other services need to reach a user, e.g. to email them
+type Contact struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// GetContact returns the name and email of the user
+func (c *Conf) GetContact(ctx context.Context, userId string) (Contact, error) {
+	var contact Contact
+	err := c.db.QueryRowContext(ctx, `SELECT id, name, email FROM users WHERE id = $1`, userId).
+		Scan(&contact.ID, &contact.Name, &contact.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Contact{}, ErrUserNotFound
+		}
+		return Contact{}, fmt.Errorf("failed to fetch user contact: %w", err)
+	}
+	return contact, nil
+}
+
+// GetContactByEmail returns the name and email of the user with the email, compared case insensitively
+func (c *Conf) GetContactByEmail(ctx context.Context, email string) (Contact, error) {
+	var contact Contact
+	err := c.db.QueryRowContext(ctx, `SELECT id, name, email FROM users WHERE lower(email) = $1 LIMIT 1`, normalizeEmail(email)).
+		Scan(&contact.ID, &contact.Name, &contact.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Contact{}, ErrUserNotFound
+		}
+		return Contact{}, fmt.Errorf("failed to fetch user contact: %w", err)
+	}
+	return contact, nil
+}
@@ -0,0 +1,220 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"user-service/internal/auth"
+	"user-service/internal/oidc"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidLoginState  = errors.New("invalid or expired external login")
+	ErrIdentityUnverified = errors.New("email not verified by the identity provider")
+)
+
+// externalLoginTTL is how long the user has to sign in at the provider
+const externalLoginTTL = 10 * time.Minute
+
+// ExternalLogin is a login started at an identity provider.
+// The state travels through the provider, the nonce and the verifier stay with us until the code comes back.
+type ExternalLogin struct {
+	Provider     string
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// StartExternalLogin remembers a new login at the provider
+func (c *Conf) StartExternalLogin(ctx context.Context, provider string) (ExternalLogin, error) {
+	login := ExternalLogin{Provider: provider}
+	for _, v := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		token, err := newSecretToken()
+		if err != nil {
+			return ExternalLogin{}, err
+		}
+		*v = token
+	}
+
+	now := time.Now().UTC()
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		// logins the user gave up on are cleaned up here, there is no job for it
+		_, err := tx.ExecContext(ctx, `DELETE FROM external_logins WHERE expires_at < $1`, now)
+		if err != nil {
+			return err
+		}
+		query := `
+		INSERT INTO external_logins (state_hash, provider, nonce, code_verifier, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`
+		_, err = tx.ExecContext(ctx, query, hashToken(login.State), provider, login.Nonce, login.CodeVerifier, now.Add(externalLoginTTL), now)
+		return err
+	})
+	if err != nil {
+		return ExternalLogin{}, fmt.Errorf("failed to start external login: %w", err)
+	}
+	return login, nil
+}
+
+// FinishExternalLogin takes the login the state belongs to, a state works once and only for the provider it was made for
+func (c *Conf) FinishExternalLogin(ctx context.Context, provider, state string) (ExternalLogin, error) {
+	login := ExternalLogin{Provider: provider, State: state}
+	query := `
+	DELETE FROM external_logins
+	WHERE state_hash = $1 AND provider = $2 AND expires_at > $3
+	RETURNING nonce, code_verifier
+	`
+	err := c.db.QueryRowContext(ctx, query, hashToken(state), provider, time.Now().UTC()).Scan(&login.Nonce, &login.CodeVerifier)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ExternalLogin{}, ErrInvalidLoginState
+		}
+		return ExternalLogin{}, fmt.Errorf("failed to finish external login: %w", err)
+	}
+	return login, nil
+}
+
+// LoginWithIdentity returns the local user of an identity confirmed by a provider.
+// An identity seen before logs into the user it is linked to. A new one is linked to the user with the same email,
+// or a user is created for it, which created reports so the caller can announce the new account.
+// The email has to be verified by the provider, otherwise anyone could claim an account by typing its email there.
+func (c *Conf) LoginWithIdentity(ctx context.Context, identity oidc.Identity) (user User, created bool, err error) {
+	var userId string
+	err = c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		query := `
+		UPDATE user_identities SET last_login_at = $3
+		WHERE provider = $1 AND subject = $2
+		RETURNING user_id
+		`
+		err := tx.QueryRowContext(ctx, query, identity.Provider, identity.Subject, now).Scan(&userId)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to fetch identity: %w", err)
+		}
+
+		if !identity.EmailVerified || identity.Email == "" {
+			return ErrIdentityUnverified
+		}
+
+		var emailVerified bool
+		err = tx.QueryRowContext(ctx, `SELECT id, email_verified FROM users WHERE lower(email) = $1 FOR UPDATE`, normalizeEmail(identity.Email)).
+			Scan(&userId, &emailVerified)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			userId, err = insertExternalUser(ctx, tx, identity, now)
+			if err != nil {
+				return err
+			}
+			created = true
+		case err != nil:
+			return fmt.Errorf("failed to fetch user by email: %w", err)
+		case !emailVerified:
+			// Someone signed up with the email but never proved it is theirs, the provider just did.
+			// The password whoever signed up chose stops working and their sessions end, the owner can set a new one.
+			err = takeOverUnverifiedUser(ctx, tx, userId, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		query = `
+		INSERT INTO user_identities (provider, subject, user_id, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		`
+		_, err = tx.ExecContext(ctx, query, identity.Provider, identity.Subject, userId, identity.Email, now)
+		if err != nil {
+			return fmt.Errorf("failed to link identity: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrIdentityUnverified) {
+			return User{}, false, ErrIdentityUnverified
+		}
+		return User{}, false, fmt.Errorf("failed to login with identity: %w", err)
+	}
+
+	user, err = c.GetUser(ctx, userId)
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, created, nil
+}
+
+// insertExternalUser creates the user of an identity. It has no password the user knows,
+// one can be set with the forgot password flow.
+func insertExternalUser(ctx context.Context, tx *sql.Tx, identity oidc.Identity, now time.Time) (string, error) {
+	passwordHash, err := unusablePasswordHash()
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(identity.Name)
+	if len([]rune(name)) < 2 {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	id := uuid.NewString()
+	query := `
+	INSERT INTO users
+	(id, name, email, password_hash, created_at, updated_at, roles, email_verified, email_verified_at)
+	VALUES ($1, $2, $3, $4, $5, $5, $6, TRUE, $5)
+	`
+	_, err = tx.ExecContext(ctx, query, id, name, identity.Email, passwordHash, now, pq.StringArray{auth.RoleUser})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return "", ErrEmailTaken
+		}
+		return "", fmt.Errorf("failed to insert user: %w", err)
+	}
+	// the provider verified the email
+	err = grantBootstrapAdmin(ctx, tx, id, now)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func takeOverUnverifiedUser(ctx context.Context, tx *sql.Tx, userId string, now time.Time) error {
+	passwordHash, err := unusablePasswordHash()
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE users SET password_hash = $2, email_verified = TRUE, email_verified_at = $3, updated_at = $3
+	WHERE id = $1
+	`
+	_, err = tx.ExecContext(ctx, query, userId, passwordHash, now)
+	if err != nil {
+		return fmt.Errorf("failed to verify user: %w", err)
+	}
+	err = grantBootstrapAdmin(ctx, tx, userId, now)
+	if err != nil {
+		return err
+	}
+	return revokeUserSessions(ctx, tx, userId, now)
+}
+
+// unusablePasswordHash hashes a random secret nobody knows, logins with a password fail like any wrong one
+func unusablePasswordHash() (string, error) {
+	secret, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,427 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"user-service/internal/auth"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two factor authentication already enabled")
+	ErrMFANotEnabled     = errors.New("two factor authentication not enabled")
+	ErrNoMFAEnrollment   = errors.New("no two factor enrollment started")
+	ErrInvalidMFACode    = errors.New("invalid two factor code")
+	ErrInvalidChallenge  = errors.New("invalid or expired login challenge")
+)
+
+const (
+	recoveryCodeCount = 10
+
+	// a challenge is short lived and allows a few typos, then the password has to be entered again
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+)
+
+// MFAStatus is the two factor state of a user
+type MFAStatus struct {
+	Enabled           bool     `json:"enabled"`
+	RecoveryCodesLeft int      `json:"recovery_codes_left"`
+	Required          bool     `json:"required"`           // the policy requires it for a role of the user
+	RolesAwaitingMFA  []string `json:"roles_awaiting_mfa"` // roles left out of tokens until it is enabled
+}
+
+// MFAChallenge is the second login step, the token is exchanged for the session together with a code
+type MFAChallenge struct {
+	Token     string    `json:"challenge_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GetMFAStatus returns whether the user enabled two factor authentication and how many recovery codes are left
+func (c *Conf) GetMFAStatus(ctx context.Context, userId string) (MFAStatus, error) {
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return MFAStatus{}, err
+	}
+
+	status := MFAStatus{Enabled: user.MFAEnabled, RolesAwaitingMFA: RolesAwaitingMFA(user)}
+	for _, role := range auth.MFARequiredRoles() {
+		status.Required = status.Required || user.HasRole(role)
+	}
+	query := `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	err = c.db.QueryRowContext(ctx, query, userId).Scan(&status.RecoveryCodesLeft)
+	if err != nil {
+		return MFAStatus{}, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return status, nil
+}
+
+// StartTOTPEnrollment creates a new secret for the user, it is only used for logins once ConfirmTOTP accepted a code.
+// Starting again replaces a secret that was not confirmed.
+func (c *Conf) StartTOTPEnrollment(ctx context.Context, userId string, t *auth.TOTP) (string, error) {
+	secret, err := t.NewSecret()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := t.Seal(secret)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO user_totp (user_id, secret, created_at, updated_at)
+	VALUES ($1, $2, $3, $3)
+	ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_step = 0, created_at = $3, updated_at = $3
+	WHERE user_totp.confirmed_at IS NULL
+	`
+	res, err := c.db.ExecContext(ctx, query, userId, sealed, now)
+	if err != nil {
+		return "", fmt.Errorf("failed to start totp enrollment: %w", err)
+	}
+	num, err := res.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("failed to start totp enrollment: %w", err)
+	}
+	if num == 0 {
+		return "", ErrMFAAlreadyEnabled
+	}
+	return secret, nil
+}
+
+// ConfirmTOTP enables two factor authentication once the code from the app matches the new secret and returns
+// the recovery codes, they are not stored in a readable form and can not be shown again.
+// Every session of the user is logged out, the next login asks for a code.
+func (c *Conf) ConfirmTOTP(ctx context.Context, userId, code string, t *auth.TOTP) ([]string, error) {
+	var codes []string
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var sealed string
+		var confirmedAt sql.NullTime
+		err := tx.QueryRowContext(ctx, `SELECT secret, confirmed_at FROM user_totp WHERE user_id = $1 FOR UPDATE`, userId).
+			Scan(&sealed, &confirmedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoMFAEnrollment
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch totp secret: %w", err)
+		}
+		if confirmedAt.Valid {
+			return ErrMFAAlreadyEnabled
+		}
+
+		step, err := validateTOTP(t, sealed, code, now, 0)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE user_totp SET confirmed_at = $2, last_step = $3, updated_at = $2 WHERE user_id = $1`,
+			userId, now, step)
+		if err != nil {
+			return fmt.Errorf("failed to confirm totp: %w", err)
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, tx, userId, now)
+		if err != nil {
+			return err
+		}
+		return revokeUserSessions(ctx, tx, userId, now)
+	})
+	if err != nil {
+		if isMFAError(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to confirm totp: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableMFA turns two factor authentication off after checking the password and a code or recovery code.
+// Every session of the user is logged out, tokens may carry roles that need it.
+func (c *Conf) DisableMFA(ctx context.Context, userId, password, code string, t *auth.TOTP) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var passwordHash string
+		err := tx.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE id = $1`, userId).Scan(&passwordHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch user: %w", err)
+		}
+		if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+			return ErrInvalidPassword
+		}
+
+		if err := verifySecondFactor(ctx, tx, userId, code, t, now); err != nil {
+			return err
+		}
+		return resetMFA(ctx, tx, userId, now)
+	})
+	if err != nil {
+		if isMFAError(err) || errors.Is(err, ErrInvalidPassword) || errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to disable mfa: %w", err)
+	}
+	return nil
+}
+
+// ResetMFA lets an admin turn off two factor authentication for a user who lost the app and the recovery codes
+func (c *Conf) ResetMFA(ctx context.Context, userId string) (User, error) {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		return resetMFA(ctx, tx, userId, time.Now().UTC())
+	})
+	if err != nil {
+		return User{}, fmt.Errorf("failed to reset mfa: %w", err)
+	}
+	return c.GetUser(ctx, userId)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking a code from the app
+func (c *Conf) RegenerateRecoveryCodes(ctx context.Context, userId, code string, t *auth.TOTP) ([]string, error) {
+	var codes []string
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		// a recovery code can not be used to get new ones, the app has to be at hand
+		if isRecoveryCode(code) {
+			return ErrInvalidMFACode
+		}
+		if err := verifySecondFactor(ctx, tx, userId, code, t, now); err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(ctx, tx, userId, now)
+		return err
+	})
+	if err != nil {
+		if isMFAError(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to regenerate recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// CreateMFAChallenge starts the second login step for a user whose password was right
+func (c *Conf) CreateMFAChallenge(ctx context.Context, userId string) (MFAChallenge, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+	now := time.Now().UTC()
+	challenge := MFAChallenge{Token: token, ExpiresAt: now.Add(mfaChallengeTTL)}
+
+	query := `
+	INSERT INTO mfa_challenges (id, user_id, token_hash, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err = c.db.ExecContext(ctx, query, uuid.NewString(), userId, hashToken(token), challenge.ExpiresAt, now)
+	if err != nil {
+		return MFAChallenge{}, fmt.Errorf("failed to create mfa challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// CompleteMFAChallenge checks the code for the challenge and returns the user to start the session for.
+// The challenge works once. A wrong code counts as an attempt, after a few the challenge is used up,
+// the user is returned with ErrInvalidMFACode so the caller can count it towards the lock of the email.
+// A challenge of a locked email can not be completed.
+func (c *Conf) CompleteMFAChallenge(ctx context.Context, token, code string, t *auth.TOTP) (User, error) {
+	var userId string
+	wrongCode := false
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var id string
+		query := `
+		SELECT c.id, c.user_id
+		FROM mfa_challenges c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.token_hash = $1 AND c.used_at IS NULL AND c.expires_at > $2 AND c.attempts < $3
+		AND NOT EXISTS (
+			SELECT 1 FROM login_failures WHERE kind = $4 AND subject = lower(u.email) AND locked_until > $2
+		)
+		FOR UPDATE OF c
+		`
+		err := tx.QueryRowContext(ctx, query, hashToken(token), now, mfaChallengeMaxAttempts, loginFailureEmail).Scan(&id, &userId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidChallenge
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch mfa challenge: %w", err)
+		}
+
+		err = verifySecondFactor(ctx, tx, userId, code, t, now)
+		if errors.Is(err, ErrInvalidMFACode) {
+			// the attempt has to be committed, the error is returned once it is
+			wrongCode = true
+			_, err = tx.ExecContext(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1`, id)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE mfa_challenges SET used_at = $2 WHERE id = $1`, id, now)
+		if err != nil {
+			return fmt.Errorf("failed to use mfa challenge: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if isMFAError(err) {
+			return User{}, err
+		}
+		return User{}, fmt.Errorf("failed to complete mfa challenge: %w", err)
+	}
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return User{}, err
+	}
+	if wrongCode {
+		return user, ErrInvalidMFACode
+	}
+	return user, nil
+}
+
+// verifySecondFactor accepts a code from the app or an unused recovery code, either works once
+func verifySecondFactor(ctx context.Context, tx *sql.Tx, userId, code string, t *auth.TOTP, now time.Time) error {
+	if isRecoveryCode(code) {
+		query := `
+		UPDATE mfa_recovery_codes SET used_at = $3
+		WHERE id = (
+			SELECT id FROM mfa_recovery_codes
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+			LIMIT 1
+		)
+		`
+		res, err := tx.ExecContext(ctx, query, userId, hashToken(normalizeRecoveryCode(code)), now)
+		if err != nil {
+			return fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		num, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		if num == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	var sealed string
+	var lastStep int64
+	query := `SELECT secret, last_step FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL FOR UPDATE`
+	err := tx.QueryRowContext(ctx, query, userId).Scan(&sealed, &lastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMFANotEnabled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch totp secret: %w", err)
+	}
+
+	step, err := validateTOTP(t, sealed, code, now, lastStep)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE user_totp SET last_step = $2, updated_at = $3 WHERE user_id = $1`, userId, step, now)
+	if err != nil {
+		return fmt.Errorf("failed to record totp step: %w", err)
+	}
+	return nil
+}
+
+func validateTOTP(t *auth.TOTP, sealed, code string, now time.Time, lastStep int64) (int64, error) {
+	secret, err := t.Open(sealed)
+	if err != nil {
+		return 0, err
+	}
+	step, err := t.Validate(secret, code, now, lastStep)
+	if errors.Is(err, auth.ErrInvalidTOTPCode) {
+		return 0, ErrInvalidMFACode
+	}
+	return step, err
+}
+
+// replaceRecoveryCodes drops the old recovery codes and stores new ones
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId string, now time.Time) ([]string, error) {
+	_, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		query := `INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at) VALUES ($1, $2, $3, $4)`
+		_, err = tx.ExecContext(ctx, query, uuid.NewString(), userId, hashToken(normalizeRecoveryCode(code)), now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// resetMFA removes the second factor of the user and logs every session out
+func resetMFA(ctx context.Context, tx *sql.Tx, userId string, now time.Time) error {
+	for _, query := range []string{
+		`DELETE FROM user_totp WHERE user_id = $1`,
+		`DELETE FROM mfa_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM mfa_challenges WHERE user_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+			return fmt.Errorf("failed to reset mfa: %w", err)
+		}
+	}
+	return revokeUserSessions(ctx, tx, userId, now)
+}
+
+// recoveryAlphabet leaves out characters that are easy to mix up when typed from paper
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// newRecoveryCode returns a code like "k7m2p-xq9ta", 10 characters of 31 are about 50 bits
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	code := make([]byte, len(b))
+	for i, v := range b {
+		// the bias of the modulo is negligible for a one time code
+		code[i] = recoveryAlphabet[int(v)%len(recoveryAlphabet)]
+	}
+	return string(code[:5]) + "-" + string(code[5:]), nil
+}
+
+// isRecoveryCode tells recovery codes from app codes, which are only digits
+func isRecoveryCode(code string) bool {
+	return len(normalizeRecoveryCode(code)) == 10
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+func isMFAError(err error) bool {
+	for _, target := range []error{ErrMFAAlreadyEnabled, ErrMFANotEnabled, ErrNoMFAEnrollment, ErrInvalidMFACode, ErrInvalidChallenge} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
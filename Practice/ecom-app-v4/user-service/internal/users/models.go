@@ -2,6 +2,7 @@ package users
 
 import (
 	"github.com/lib/pq"
+	"slices"
 	"time"
 )
 
@@ -13,19 +14,136 @@ type User struct {
 	PasswordHash     string         `json:"-"` // Password hash (not exposed in JSON)
 	StripeCustomerID string         `json:"-"` // Not part of json output
 	Roles            pq.StringArray `json:"roles"`
-	CreatedAt        time.Time      `json:"created_at"` // Timestamp of creation
-	UpdatedAt        time.Time      `json:"updated_at"` // Timestamp of last update
+	EmailVerified    bool           `json:"email_verified"`
+	Disabled         bool           `json:"disabled"`               // disabled users can not log in
+	LockedUntil      *time.Time     `json:"locked_until,omitempty"` // set while logins are locked after too many failures
+	MFAEnabled       bool           `json:"mfa_enabled"`            // logins ask for a TOTP code after the password
+	CreatedAt        time.Time      `json:"created_at"`             // Timestamp of creation
+	UpdatedAt        time.Time      `json:"updated_at"`             // Timestamp of last update
 }
 
-// NewUser struct represents the data required when creating a new user
+// NewUser struct represents the data required when creating a new user.
+// Roles are not taken from the client, every account starts as a user and admins grant more.
 type NewUser struct {
-	Name     string   `json:"name" validate:"required,min=2,max=100"` // User name must be between 2-100 chars
-	Email    string   `json:"email" validate:"required,email"`        // Valid email required
-	Password string   `json:"password" validate:"required,min=5"`     // Password must be at least 5 characters long
-	Roles    []string `json:"roles" validate:"required,unique,dive,oneof=user admin"`
+	Name     string `json:"name" validate:"required,min=2,max=100"` // User name must be between 2-100 chars
+	Email    string `json:"email" validate:"required,email"`        // Valid email required
+	Password string `json:"password" validate:"required,min=5"`     // Password must be at least 5 characters long
+}
+
+// ProfileUpdate is the request body for updating your own profile, fields left out are kept
+type ProfileUpdate struct {
+	Name  *string `json:"name" validate:"omitempty,min=2,max=100"`
+	Email *string `json:"email" validate:"omitempty,email"` // a new email has to be verified again
+
+	// CurrentPassword is checked when the email changes, a stolen session can not move the account to another inbox
+	CurrentPassword string `json:"current_password" validate:"required_with=Email"`
+}
+
+// RolesUpdate replaces the roles of a user, every role must exist in the roles table
+type RolesUpdate struct {
+	Roles []string `json:"roles" validate:"required,min=1,unique,dive,min=1,max=50"`
 }
 
 //required: The roles field is mandatory.
 //unique: Ensures there are no duplicate roles in the array (requires the validator's unique tag to be supported).
 //dive: Applies validation rules to each individual element of the slice.
-//oneof=user admin: Restricts each role value to either user or admin.
+//min=1,max=50: Every role name is checked for its length, SetRoles checks that the role exists.
+
+// UserFilter narrows the users an admin lists, empty fields match everyone
+type UserFilter struct {
+	Query    string // matched against name and email
+	Role     string
+	Disabled *bool
+	Limit    int
+	Offset   int
+}
+
+// UserPage is a page of users and how many match the filter in total
+type UserPage struct {
+	Users []User `json:"users"`
+	Total int    `json:"total"`
+}
+
+// Address is a saved shipping address of a user
+type Address struct {
+	ID         string    `json:"id"` // UUID
+	UserID     string    `json:"user_id"`
+	FullName   string    `json:"full_name"`
+	Phone      string    `json:"phone"`
+	Line1      string    `json:"line1"`
+	Line2      string    `json:"line2"`
+	City       string    `json:"city"`
+	State      string    `json:"state"`
+	PostalCode string    `json:"postal_code"`
+	Country    string    `json:"country"`    // ISO 3166-1 alpha-2 code
+	IsDefault  bool      `json:"is_default"` // a user has at most one default address
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// NewAddress is the request body for creating or replacing an address
+type NewAddress struct {
+	FullName   string `json:"full_name" validate:"required,min=2,max=100"`
+	Phone      string `json:"phone" validate:"required,min=7,max=20"`
+	Line1      string `json:"line1" validate:"required,min=2,max=200"`
+	Line2      string `json:"line2" validate:"omitempty,max=200"`
+	City       string `json:"city" validate:"required,min=2,max=100"`
+	State      string `json:"state" validate:"required,min=2,max=100"`
+	PostalCode string `json:"postal_code" validate:"required,min=3,max=12"`
+	Country    string `json:"country" validate:"omitempty,iso3166_1_alpha2"` // defaults to IN
+	IsDefault  bool   `json:"is_default"`
+}
+
+// HasRole reports whether the user has the role
+func (u User) HasRole(role string) bool {
+	return slices.Contains(u.Roles, role)
+}
+
+// Role is a set of permissions admins give users
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	Users       int       `json:"users"` // how many users have the role
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RoleUpdate creates a role or replaces its description and permissions
+type RoleUpdate struct {
+	Description string   `json:"description" validate:"max=200"`
+	Permissions []string `json:"permissions" validate:"required,unique,dive,min=1,max=100"`
+}
+
+// Permission is something the services check, they are created by migrations along with the code that checks them
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// APIKey lets a tool call the APIs as its owner without a login, limited to the permissions of the key
+type APIKey struct {
+	ID          string     `json:"id"` // UUID
+	UserID      string     `json:"user_id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"` // the start of the key, the rest is only shown once
+	Permissions []string   `json:"permissions"`
+	CreatedBy   string     `json:"created_by"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"` // the last time it was exchanged for a token, a few minutes apart at most
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// NewAPIKey is the request body for creating an API key, it never expires when ExpiresInDays is left out
+type NewAPIKey struct {
+	Name          string   `json:"name" validate:"required,min=1,max=100"`
+	Permissions   []string `json:"permissions" validate:"required,min=1,unique,dive,min=1,max=100"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+// CreatedAPIKey is a new API key with the key itself, which is not stored and can not be shown again
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,200 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// PasswordReset is a reset link to email, the token is only known to the user
+type PasswordReset struct {
+	Contact   Contact
+	Token     string
+	ExpiresAt time.Time
+}
+
+// CreatePasswordReset issues a reset token for the user with the email unless one was issued within the cooldown.
+// ok is false when there is nothing to send, the caller should not tell which case it was.
+func (c *Conf) CreatePasswordReset(ctx context.Context, email string, ttl, cooldown time.Duration) (PasswordReset, bool, error) {
+	var reset PasswordReset
+	ok := false
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		// the row lock keeps two requests from both passing the cooldown
+		query := `SELECT id, name, email FROM users WHERE email = $1 AND disabled_at IS NULL FOR UPDATE`
+		err := tx.QueryRowContext(ctx, query, email).Scan(&reset.Contact.ID, &reset.Contact.Name, &reset.Contact.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch user: %w", err)
+		}
+
+		var recent bool
+		query = `SELECT EXISTS (SELECT 1 FROM password_resets WHERE user_id = $1 AND created_at > $2)`
+		err = tx.QueryRowContext(ctx, query, reset.Contact.ID, now.Add(-cooldown)).Scan(&recent)
+		if err != nil {
+			return fmt.Errorf("failed to check recent resets: %w", err)
+		}
+		if recent {
+			return nil
+		}
+
+		reset.Token, err = newSecretToken()
+		if err != nil {
+			return err
+		}
+		reset.ExpiresAt = now.Add(ttl)
+
+		query = `
+		INSERT INTO password_resets (id, user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		`
+		_, err = tx.ExecContext(ctx, query, uuid.NewString(), reset.Contact.ID, hashToken(reset.Token), reset.ExpiresAt, now)
+		if err != nil {
+			return fmt.Errorf("failed to store password reset: %w", err)
+		}
+		ok = true
+		return nil
+	})
+	if err != nil {
+		return PasswordReset{}, false, fmt.Errorf("failed to create password reset: %w", err)
+	}
+	if !ok {
+		return PasswordReset{}, false, nil
+	}
+	return reset, true, nil
+}
+
+// ResetPassword sets the password of the user the token was issued to and logs every session of the user out.
+// The token and any other pending token of the user can not be used again.
+// The email received the link, so the address counts as verified.
+func (c *Conf) ResetPassword(ctx context.Context, token, password string) (string, error) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	var userId string
+	err = c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var usedAt sql.NullTime
+		var expiresAt time.Time
+		query := `SELECT user_id, used_at, expires_at FROM password_resets WHERE token_hash = $1 FOR UPDATE`
+		err := tx.QueryRowContext(ctx, query, hashToken(token)).Scan(&userId, &usedAt, &expiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch password reset: %w", err)
+		}
+		if !resetUsable(usedAt, expiresAt, now) {
+			return ErrInvalidResetToken
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE password_resets SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL`, userId, now)
+		if err != nil {
+			return fmt.Errorf("failed to mark password reset used: %w", err)
+		}
+
+		// a reset link does not bring back an account an admin disabled
+		query = `
+		UPDATE users
+		SET password_hash = $2, email_verified = TRUE, email_verified_at = COALESCE(email_verified_at, $3), updated_at = $3
+		WHERE id = $1 AND disabled_at IS NULL
+		`
+		res, err := tx.ExecContext(ctx, query, userId, string(passwordHash), now)
+		if err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		num, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		if num == 0 {
+			return ErrInvalidResetToken
+		}
+		err = grantBootstrapAdmin(ctx, tx, userId, now)
+		if err != nil {
+			return err
+		}
+
+		// the reset proves the owner has the email, a lock from someone guessing the old password is lifted
+		query = `DELETE FROM login_failures WHERE kind = $1 AND subject = (SELECT lower(email) FROM users WHERE id = $2)`
+		_, err = tx.ExecContext(ctx, query, loginFailureEmail, userId)
+		if err != nil {
+			return fmt.Errorf("failed to clear login failures: %w", err)
+		}
+
+		return revokeUserSessions(ctx, tx, userId, now)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidResetToken) {
+			return "", ErrInvalidResetToken
+		}
+		return "", fmt.Errorf("failed to reset password: %w", err)
+	}
+	return userId, nil
+}
+
+// resetUsable reports whether a reset token can still be used, it works once and only until it expires
+func resetUsable(usedAt sql.NullTime, expiresAt, now time.Time) bool {
+	return !usedAt.Valid && now.Before(expiresAt)
+}
+
+// ChangePassword replaces the password of the user after checking the current one and logs every session of the user out,
+// including the one asking for the change. ErrInvalidPassword is returned when the current password does not match.
+func (c *Conf) ChangePassword(ctx context.Context, userId, currentPassword, newPassword string) error {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	err = c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var currentHash string
+		err := tx.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE id = $1 FOR UPDATE`, userId).Scan(&currentHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch user: %w", err)
+		}
+
+		err = bcrypt.CompareHashAndPassword([]byte(currentHash), []byte(currentPassword))
+		if err != nil {
+			return ErrInvalidPassword
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE users SET password_hash = $2, updated_at = $3 WHERE id = $1`, userId, string(passwordHash), now)
+		if err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+
+		// a reset link sent before the change must not undo it
+		_, err = tx.ExecContext(ctx, `UPDATE password_resets SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL`, userId, now)
+		if err != nil {
+			return fmt.Errorf("failed to expire password resets: %w", err)
+		}
+
+		return revokeUserSessions(ctx, tx, userId, now)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidPassword) || errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to change password: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	"user-service/internal/auth"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrUnknownRole       = errors.New("unknown role")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrBuiltinRole       = errors.New("the user and admin roles can not be deleted")
+	ErrRoleInUse         = errors.New("role still given to users")
+	ErrAdminRoleLocked   = errors.New("the admin role must keep the permission to manage users")
+)
+
+// queryer is a *sql.DB or a *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// roleColumns are the columns scanRole reads, the role table is aliased r
+const roleColumns = `r.name, r.description, r.created_at, r.updated_at,
+	ARRAY(SELECT permission FROM role_permissions WHERE role = r.name ORDER BY permission),
+	(SELECT COUNT(*) FROM users WHERE r.name = ANY(users.roles))`
+
+func scanRole(row interface{ Scan(...any) error }) (Role, error) {
+	var role Role
+	var permissions pq.StringArray
+	err := row.Scan(&role.Name, &role.Description, &role.CreatedAt, &role.UpdatedAt, &permissions, &role.Users)
+	role.Permissions = []string(permissions)
+	return role, err
+}
+
+// Claims builds the claims of an access token for the user with the permissions of the roles it carries
+func (c *Conf) Claims(ctx context.Context, user User) (auth.Claims, error) {
+	return claimsFor(ctx, c.db, user)
+}
+
+func claimsFor(ctx context.Context, q queryer, user User) (auth.Claims, error) {
+	claims := NewClaims(user)
+	permissions, err := rolePermissions(ctx, q, claims.Roles)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	claims.Permissions = permissions
+	return claims, nil
+}
+
+// RolePermissions returns every permission the roles grant together
+func (c *Conf) RolePermissions(ctx context.Context, roles []string) ([]string, error) {
+	return rolePermissions(ctx, c.db, roles)
+}
+
+func rolePermissions(ctx context.Context, q queryer, roles []string) ([]string, error) {
+	query := `SELECT DISTINCT permission FROM role_permissions WHERE role = ANY($1) ORDER BY permission`
+	rows, err := q.QueryContext(ctx, query, pq.StringArray(roles))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// ListPermissions returns every permission roles can be given
+func (c *Conf) ListPermissions(ctx context.Context) ([]Permission, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT name, description FROM permissions ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.Name, &p.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		permissions = append(permissions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// ListRoles returns every role with its permissions
+func (c *Conf) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+roleColumns+` FROM roles r ORDER BY r.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	return roles, nil
+}
+
+// GetRole returns the role with its permissions
+func (c *Conf) GetRole(ctx context.Context, name string) (Role, error) {
+	role, err := scanRole(c.db.QueryRowContext(ctx, `SELECT `+roleColumns+` FROM roles r WHERE r.name = $1`, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Role{}, ErrRoleNotFound
+		}
+		return Role{}, fmt.Errorf("failed to fetch role: %w", err)
+	}
+	return role, nil
+}
+
+// PutRole creates the role or replaces its description and permissions.
+// Users keep their sessions, the new permissions are in their tokens from the next refresh on.
+// The admin role always keeps the permission to manage users, otherwise nobody could undo the change.
+func (c *Conf) PutRole(ctx context.Context, name string, update RoleUpdate) (Role, error) {
+	if name == auth.RoleAdmin && !slices.Contains(update.Permissions, auth.PermUsersManage) {
+		return Role{}, ErrAdminRoleLocked
+	}
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var known int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM permissions WHERE name = ANY($1)`, pq.StringArray(update.Permissions)).
+			Scan(&known)
+		if err != nil {
+			return fmt.Errorf("failed to check permissions: %w", err)
+		}
+		if known != len(update.Permissions) {
+			return ErrUnknownPermission
+		}
+
+		query := `
+		INSERT INTO roles (name, description, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (name) DO UPDATE SET description = $2, updated_at = $3
+		`
+		_, err = tx.ExecContext(ctx, query, name, update.Description, now)
+		if err != nil {
+			return fmt.Errorf("failed to save role: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role = $1 AND NOT permission = ANY($2)`,
+			name, pq.StringArray(update.Permissions))
+		if err != nil {
+			return fmt.Errorf("failed to remove permissions: %w", err)
+		}
+		query = `
+		INSERT INTO role_permissions (role, permission, created_at)
+		SELECT $1, permission, $3 FROM unnest($2::TEXT[]) AS permission
+		ON CONFLICT DO NOTHING
+		`
+		_, err = tx.ExecContext(ctx, query, name, pq.StringArray(update.Permissions), now)
+		if err != nil {
+			return fmt.Errorf("failed to add permissions: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrUnknownPermission) {
+			return Role{}, ErrUnknownPermission
+		}
+		return Role{}, fmt.Errorf("failed to put role: %w", err)
+	}
+	return c.GetRole(ctx, name)
+}
+
+// DeleteRole removes a role nobody has anymore, the built in roles stay
+func (c *Conf) DeleteRole(ctx context.Context, name string) error {
+	if name == auth.RoleUser || name == auth.RoleAdmin {
+		return ErrBuiltinRole
+	}
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var users int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE $1 = ANY(roles)`, name).Scan(&users)
+		if err != nil {
+			return fmt.Errorf("failed to count users: %w", err)
+		}
+		if users > 0 {
+			return ErrRoleInUse
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM roles WHERE name = $1`, name)
+		if err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
+		num, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
+		if num == 0 {
+			return ErrRoleNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		for _, target := range []error{ErrRoleInUse, ErrRoleNotFound} {
+			if errors.Is(err, target) {
+				return target
+			}
+		}
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+	return nil
+}
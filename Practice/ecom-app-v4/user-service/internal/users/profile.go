@@ -0,0 +1,107 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// userColumns are the columns scanUser reads, in its order
+const userColumns = `id, name, email, created_at, updated_at, roles, email_verified, disabled_at IS NOT NULL,
+	(SELECT locked_until FROM login_failures WHERE kind = 'email' AND subject = lower(users.email)), ` + mfaEnabledColumn
+
+// mfaEnabledColumn tells whether the user confirmed a TOTP app
+const mfaEnabledColumn = `EXISTS (SELECT 1 FROM user_totp WHERE user_totp.user_id = users.id AND confirmed_at IS NOT NULL)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser reads the userColumns of the row, extra receives any columns selected after them
+func scanUser(row rowScanner, extra ...any) (User, error) {
+	var user User
+	var lockedUntil sql.NullTime
+	dest := append([]any{&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Roles,
+		&user.EmailVerified, &user.Disabled, &lockedUntil, &user.MFAEnabled}, extra...)
+	err := row.Scan(dest...)
+	// a lock that ran out is not cleared, it just no longer counts
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		user.LockedUntil = &lockedUntil.Time
+	}
+	return user, err
+}
+
+// GetUser returns the user with the id
+func (c *Conf) GetUser(ctx context.Context, userId string) (User, error) {
+	user, err := scanUser(c.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, userId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, fmt.Errorf("failed to fetch user: %w", err)
+	}
+	return user, nil
+}
+
+// UpdateProfile changes the name and email of the user. A new email is unverified until the user confirms it,
+// emailChanged tells the caller to send the verification link. ErrEmailTaken is returned if another account uses it,
+// ErrInvalidPassword when the email changes and the current password does not match.
+func (c *Conf) UpdateProfile(ctx context.Context, userId string, update ProfileUpdate) (user User, emailChanged bool, err error) {
+	err = c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var passwordHash string
+		query := `SELECT ` + userColumns + `, password_hash FROM users WHERE id = $1 FOR UPDATE`
+		current, err := scanUser(tx.QueryRowContext(ctx, query, userId), &passwordHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch user: %w", err)
+		}
+
+		name := current.Name
+		if update.Name != nil {
+			name = strings.TrimSpace(*update.Name)
+		}
+		email := current.Email
+		if update.Email != nil && !strings.EqualFold(*update.Email, current.Email) {
+			email = *update.Email
+			emailChanged = true
+
+			err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(update.CurrentPassword))
+			if err != nil {
+				return ErrInvalidPassword
+			}
+		}
+
+		query = `
+		UPDATE users
+		SET name = $2, email = $3, updated_at = $4,
+		    email_verified = email_verified AND NOT $5,
+		    email_verified_at = CASE WHEN $5 THEN NULL ELSE email_verified_at END,
+		    verification_sent_at = CASE WHEN $5 THEN $4 ELSE verification_sent_at END
+		WHERE id = $1
+		RETURNING ` + userColumns
+		user, err = scanUser(tx.QueryRowContext(ctx, query, userId, name, email, now, emailChanged))
+		if err != nil {
+			if isUniqueViolation(err) {
+				return ErrEmailTaken
+			}
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrEmailTaken) || errors.Is(err, ErrInvalidPassword) {
+			return User{}, false, err
+		}
+		return User{}, false, fmt.Errorf("failed to update profile: %w", err)
+	}
+	return user, emailChanged, nil
+}
@@ -0,0 +1,334 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+	"user-service/internal/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+const (
+	defaultAccessTokenTTL   = 15 * time.Minute
+	defaultRefreshTokenTTL  = 30 * 24 * time.Hour
+	defaultPasswordResetTTL = time.Hour
+)
+
+// Session is what a login hands out, the claims of the access token to sign and the refresh token that renews it
+type Session struct {
+	Claims           auth.Claims
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// AccessTokenTTL is the lifetime of access tokens, ACCESS_TOKEN_TTL overrides it, e.g. "10m"
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL is the lifetime of refresh tokens, REFRESH_TOKEN_TTL overrides it, e.g. "168h"
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// PasswordResetTTL is how long a password reset link works, PASSWORD_RESET_TTL overrides it, e.g. "30m"
+func PasswordResetTTL() time.Duration {
+	return durationFromEnv("PASSWORD_RESET_TTL", defaultPasswordResetTTL)
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// NewClaims builds the claims of an access token for the user, every token gets its own jti so it can be revoked.
+// The permissions of the roles are added by Claims.
+func NewClaims(user User) auth.Claims {
+	now := time.Now()
+
+	var claims auth.Claims
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Issuer:    os.Getenv("SERVICE_NAME"),
+		Subject:   user.ID,
+		Audience:  jwt.ClaimStrings{"everyone"},
+		ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL())),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+	claims.Roles = GrantedRoles(user)
+	claims.EmailVerified = user.EmailVerified
+	return claims
+}
+
+// GrantedRoles are the roles that go into the access token. Roles the policy keeps for users with two factor
+// authentication are left out until the user enabled it, RolesAwaitingMFA lists them.
+func GrantedRoles(user User) []string {
+	awaiting := RolesAwaitingMFA(user)
+	granted := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		if !slices.Contains(awaiting, role) {
+			granted = append(granted, role)
+		}
+	}
+	return granted
+}
+
+// RolesAwaitingMFA are the roles of the user that need two factor authentication enabled first
+func RolesAwaitingMFA(user User) []string {
+	if user.MFAEnabled {
+		return nil
+	}
+	var awaiting []string
+	for _, role := range auth.MFARequiredRoles() {
+		if slices.Contains(user.Roles, role) {
+			awaiting = append(awaiting, role)
+		}
+	}
+	return awaiting
+}
+
+// StartSession creates the first refresh token of a new login, paired with the access token of the claims
+func (c *Conf) StartSession(ctx context.Context, claims auth.Claims) (Session, error) {
+	var session Session
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		session, err = insertRefreshToken(ctx, tx, uuid.NewString(), claims)
+		return err
+	})
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to start session: %w", err)
+	}
+	return session, nil
+}
+
+// RotateSession exchanges the refresh token for a new access and refresh token, the old one can not be used again.
+// Presenting a refresh token that was already exchanged means it leaked, so the whole login is revoked
+// including the access tokens issued to it and ErrRefreshTokenReused is returned.
+func (c *Conf) RotateSession(ctx context.Context, refreshToken string) (User, Session, error) {
+	var user User
+	var session Session
+	reused := false
+
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		var id, familyId string
+		var expiresAt time.Time
+		var usedAt, revokedAt sql.NullTime
+		query := `
+		SELECT id, family_id, user_id, expires_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+		`
+		err := tx.QueryRowContext(ctx, query, hashToken(refreshToken)).
+			Scan(&id, &familyId, &user.ID, &expiresAt, &usedAt, &revokedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch refresh token: %w", err)
+		}
+
+		if usedAt.Valid || revokedAt.Valid {
+			// the revocation has to be committed, the error is returned once it is
+			reused = true
+			return revokeFamily(ctx, tx, familyId, now)
+		}
+		if !expiresAt.After(now) {
+			return ErrInvalidRefreshToken
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = $2 WHERE id = $1`, id, now)
+		if err != nil {
+			return fmt.Errorf("failed to mark refresh token used: %w", err)
+		}
+
+		query = `
+		SELECT id, name, email, created_at, updated_at, roles, email_verified, disabled_at IS NOT NULL, ` + mfaEnabledColumn + `
+		FROM users
+		WHERE id = $1
+		`
+		err = tx.QueryRowContext(ctx, query, user.ID).
+			Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Roles, &user.EmailVerified, &user.Disabled, &user.MFAEnabled)
+		if err != nil {
+			return fmt.Errorf("failed to fetch user details: %w", err)
+		}
+		if user.Disabled {
+			return ErrInvalidRefreshToken
+		}
+
+		claims, err := claimsFor(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		session, err = insertRefreshToken(ctx, tx, familyId, claims)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return User{}, Session{}, ErrInvalidRefreshToken
+		}
+		return User{}, Session{}, fmt.Errorf("failed to rotate session: %w", err)
+	}
+	if reused {
+		return User{}, Session{}, ErrRefreshTokenReused
+	}
+	return user, session, nil
+}
+
+// EndSession revokes the access token with the jti and, when it is given, the login of the refresh token.
+// A refresh token of another user or an unknown one is ignored, the access token is revoked either way.
+func (c *Conf) EndSession(ctx context.Context, userId, jti string, accessExpiresAt time.Time, refreshToken string) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		if jti != "" {
+			query := `
+			INSERT INTO revoked_tokens (jti, expires_at, revoked_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (jti) DO NOTHING
+			`
+			_, err := tx.ExecContext(ctx, query, jti, accessExpiresAt.UTC(), now)
+			if err != nil {
+				return fmt.Errorf("failed to revoke access token: %w", err)
+			}
+		}
+
+		if refreshToken == "" {
+			return nil
+		}
+		var familyId string
+		query := `SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2`
+		err := tx.QueryRowContext(ctx, query, hashToken(refreshToken), userId).Scan(&familyId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch refresh token: %w", err)
+		}
+		return revokeFamily(ctx, tx, familyId, now)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	return nil
+}
+
+// RevokedTokens returns the access tokens that are revoked and not expired yet
+func (c *Conf) RevokedTokens(ctx context.Context) ([]auth.RevokedToken, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > $1`, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch revoked tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []auth.RevokedToken{}
+	for rows.Next() {
+		var t auth.RevokedToken
+		if err := rows.Scan(&t.JTI, &t.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revoked token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch revoked tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// insertRefreshToken stores a new refresh token of the family, paired with the access token of the claims
+func insertRefreshToken(ctx context.Context, tx *sql.Tx, familyId string, claims auth.Claims) (Session, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return Session{}, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(RefreshTokenTTL())
+
+	query := `
+	INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, access_jti, access_expires_at, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err = tx.ExecContext(ctx, query, uuid.NewString(), familyId, claims.Subject, hashToken(token), claims.ID,
+		claims.ExpiresAt.Time.UTC(), expiresAt, now)
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return Session{Claims: claims, RefreshToken: token, RefreshExpiresAt: expiresAt}, nil
+}
+
+// revokeFamily revokes every refresh token of the login and the access tokens issued with them
+func revokeFamily(ctx context.Context, tx *sql.Tx, familyId string, now time.Time) error {
+	query := `
+	INSERT INTO revoked_tokens (jti, expires_at, revoked_at)
+	SELECT access_jti, access_expires_at, $2
+	FROM refresh_tokens
+	WHERE family_id = $1 AND access_expires_at > $2
+	ON CONFLICT (jti) DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, familyId, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL`, familyId, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// revokeUserSessions revokes every login of the user and the access tokens issued with them
+func revokeUserSessions(ctx context.Context, tx *sql.Tx, userId string, now time.Time) error {
+	query := `
+	INSERT INTO revoked_tokens (jti, expires_at, revoked_at)
+	SELECT access_jti, access_expires_at, $2
+	FROM refresh_tokens
+	WHERE user_id = $1 AND access_expires_at > $2
+	ON CONFLICT (jti) DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, userId, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`, userId, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// newSecretToken returns 32 random bytes, long enough that a plain sha256 of it is safe to store
+func newSecretToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
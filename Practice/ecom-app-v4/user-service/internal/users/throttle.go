@@ -0,0 +1,176 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	loginFailureEmail = "email"
+	loginFailureIP    = "ip"
+)
+
+// LoginPolicy decides how failed logins are slowed down and when an email is locked
+type LoginPolicy struct {
+	MaxFailures   int           // failures of an email before it is locked
+	LockDuration  time.Duration // how long a locked email is refused
+	IPMaxFailures int           // failures from one IP before it is refused until its window passes
+	Window        time.Duration // failures older than this are forgotten
+	BaseDelay     time.Duration // delay after the third failure in a row, it doubles with every further one
+	MaxDelay      time.Duration
+}
+
+// LoginPolicyFromEnv reads LOGIN_MAX_FAILURES, LOGIN_LOCK_DURATION, LOGIN_IP_MAX_FAILURES and LOGIN_FAILURE_WINDOW,
+// anything unset or invalid keeps its default
+func LoginPolicyFromEnv() LoginPolicy {
+	return LoginPolicy{
+		MaxFailures:   intFromEnv("LOGIN_MAX_FAILURES", 5),
+		LockDuration:  durationFromEnv("LOGIN_LOCK_DURATION", 15*time.Minute),
+		IPMaxFailures: intFromEnv("LOGIN_IP_MAX_FAILURES", 50),
+		Window:        durationFromEnv("LOGIN_FAILURE_WINDOW", time.Hour),
+		BaseDelay:     500 * time.Millisecond,
+		MaxDelay:      8 * time.Second,
+	}
+}
+
+func intFromEnv(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
+// Delay is how long the answer to a failed login is held back after the given number of failures in a row.
+// The first two are answered right away so a typo costs nothing.
+func (p LoginPolicy) Delay(failures int) time.Duration {
+	if failures < 3 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 3; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// LoginFailure is the outcome of recording a failed login
+type LoginFailure struct {
+	Delay       time.Duration
+	Locked      bool // this failure locked the email
+	LockedUntil time.Time
+}
+
+// LoginRefused reports whether logins for the email or from the IP are refused right now,
+// the password is not checked at all then
+func (c *Conf) LoginRefused(ctx context.Context, email, ip string, policy LoginPolicy) (bool, error) {
+	now := time.Now().UTC()
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM login_failures WHERE kind = $1 AND subject = $2 AND locked_until > $5
+	) OR EXISTS (
+		SELECT 1 FROM login_failures WHERE kind = $3 AND subject = $4 AND failures >= $6 AND window_start > $7
+	)
+	`
+	var refused bool
+	err := c.db.QueryRowContext(ctx, query, loginFailureEmail, normalizeEmail(email), loginFailureIP, ip,
+		now, policy.IPMaxFailures, now.Add(-policy.Window)).Scan(&refused)
+	if err != nil {
+		return false, fmt.Errorf("failed to check login failures: %w", err)
+	}
+	return refused, nil
+}
+
+// RecordLoginFailure counts a failed login for the email and the IP and locks the email once it reached the limit
+func (c *Conf) RecordLoginFailure(ctx context.Context, email, ip string, policy LoginPolicy) (LoginFailure, error) {
+	var failure LoginFailure
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		emailFailures, err := countLoginFailure(ctx, tx, loginFailureEmail, normalizeEmail(email), now, policy.Window)
+		if err != nil {
+			return err
+		}
+		ipFailures := 0
+		if ip != "" {
+			ipFailures, err = countLoginFailure(ctx, tx, loginFailureIP, ip, now, policy.Window)
+			if err != nil {
+				return err
+			}
+		}
+		// the IP limit is far higher, its delay grows with the share of it that is used up
+		failure.Delay = max(policy.Delay(emailFailures), policy.Delay(ipFailures*policy.MaxFailures/policy.IPMaxFailures))
+
+		if emailFailures < policy.MaxFailures {
+			return nil
+		}
+		// the count starts over, once the lock ends there are MaxFailures attempts before the next one
+		failure.Locked = true
+		failure.LockedUntil = now.Add(policy.LockDuration)
+		query := `
+		UPDATE login_failures
+		SET locked_until = $3, failures = 0, window_start = $4
+		WHERE kind = $1 AND subject = $2
+		`
+		_, err = tx.ExecContext(ctx, query, loginFailureEmail, normalizeEmail(email), failure.LockedUntil, now)
+		if err != nil {
+			return fmt.Errorf("failed to lock login: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return LoginFailure{}, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return failure, nil
+}
+
+// countLoginFailure adds a failure to the subject and returns how many it has within the window
+func countLoginFailure(ctx context.Context, tx *sql.Tx, kind, subject string, now time.Time, window time.Duration) (int, error) {
+	query := `
+	INSERT INTO login_failures (kind, subject, failures, window_start, last_failure_at)
+	VALUES ($1, $2, 1, $3, $3)
+	ON CONFLICT (kind, subject) DO UPDATE SET
+		failures = CASE WHEN login_failures.window_start < $4 THEN 1 ELSE login_failures.failures + 1 END,
+		window_start = CASE WHEN login_failures.window_start < $4 THEN $3 ELSE login_failures.window_start END,
+		last_failure_at = $3
+	RETURNING failures
+	`
+	var failures int
+	err := tx.QueryRowContext(ctx, query, kind, subject, now, now.Add(-window)).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count login failure: %w", err)
+	}
+	return failures, nil
+}
+
+// ClearLoginFailures forgets the failures of the email after a successful login.
+// The failures of the IP are kept, one known password must not reset the limit for guessing others.
+func (c *Conf) ClearLoginFailures(ctx context.Context, email string) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM login_failures WHERE kind = $1 AND subject = $2`, loginFailureEmail, normalizeEmail(email))
+	if err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+	return nil
+}
+
+// UnlockUser lifts the lock of the user's email and forgets its failures
+func (c *Conf) UnlockUser(ctx context.Context, userId string) (User, error) {
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return User{}, err
+	}
+	if err := c.ClearLoginFailures(ctx, user.Email); err != nil {
+		return User{}, err
+	}
+	user.LockedUntil = nil
+	return user, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -5,15 +5,24 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 	"os"
+	"strings"
 	"time"
 	"user-service/internal/auth"
 )
 
-var ErrInvalidPassword = errors.New("invalid password")
+var (
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrAccountDisabled = errors.New("account disabled")
+	ErrEmailTaken      = errors.New("email already registered")
+)
+
+// dummyPasswordHash is compared against when the email is unknown
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
 
 type Conf struct {
 	db *sql.DB
@@ -54,15 +63,18 @@ func (c *Conf) InsertUser(ctx context.Context, newUser NewUser) (User, error) {
 		// The `RETURNING` clause retrieves the inserted user's data after the operation.
 		query := `
       INSERT INTO users
-      (id, name, email, password_hash, created_at, updated_at, roles)
-      VALUES ($1, $2, $3, $4, $5, $6, $7)
-      RETURNING id, name, email, created_at, updated_at, roles
+      (id, name, email, password_hash, created_at, updated_at, roles, verification_sent_at)
+      VALUES ($1, $2, $3, $4, $5, $6, $7, $5)
+      RETURNING id, name, email, created_at, updated_at, roles, email_verified
       `
 		// Execute the `INSERT` query within the transaction to add the new user.
 		// `QueryRowContext` executes the query and scans the resulting row into the `user` struct.
-		err = tx.QueryRowContext(ctx, query, id, newUser.Name, newUser.Email, hashedPassword, createdAt, updatedAt, newUser.Roles).
-			Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Roles)
+		err = tx.QueryRowContext(ctx, query, id, newUser.Name, newUser.Email, hashedPassword, createdAt, updatedAt, pq.StringArray{auth.RoleUser}).
+			Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Roles, &user.EmailVerified)
 		if err != nil {
+			if isUniqueViolation(err) {
+				return ErrEmailTaken
+			}
 			// Return an error if the query execution or scan fails.
 			return fmt.Errorf("failed to insert user: %w", err)
 		}
@@ -73,6 +85,9 @@ func (c *Conf) InsertUser(ctx context.Context, newUser NewUser) (User, error) {
 
 	// If the transaction or insertion fails, return an error.
 	if err != nil {
+		if errors.Is(err, ErrEmailTaken) {
+			return User{}, ErrEmailTaken
+		}
 		return User{}, fmt.Errorf("failed to insert user: %w", err)
 	}
 
@@ -83,10 +98,11 @@ func (c *Conf) InsertUser(ctx context.Context, newUser NewUser) (User, error) {
 func (c *Conf) Authenticate(ctx context.Context, email, password string) (User, auth.Claims, error) {
 	// Define a User struct to store the fetched data
 	var user User
+	var claims auth.Claims
 	f := func(tx *sql.Tx) error {
 		// SQL query to fetch the user details by email
 		query := `
-		SELECT id, name, email, password_hash, created_at, updated_at, roles
+		SELECT id, name, email, password_hash, created_at, updated_at, roles, email_verified, disabled_at IS NOT NULL, ` + mfaEnabledColumn + `
 		FROM users
 		WHERE email = $1
 	`
@@ -95,9 +111,13 @@ func (c *Conf) Authenticate(ctx context.Context, email, password string) (User,
 
 		// Execute the query to fetch the user details
 		err := tx.QueryRowContext(ctx, query, email).
-			Scan(&user.ID, &user.Name, &user.Email, &passwordHash, &user.CreatedAt, &user.UpdatedAt, &user.Roles)
+			Scan(&user.ID, &user.Name, &user.Email, &passwordHash, &user.CreatedAt, &user.UpdatedAt, &user.Roles, &user.EmailVerified, &user.Disabled, &user.MFAEnabled)
 
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// a wrong email takes as long to answer as a wrong password, the timing does not tell which emails exist
+				_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+			}
 			return fmt.Errorf("failed to fetch user details: %w", err)
 		}
 
@@ -107,26 +127,20 @@ func (c *Conf) Authenticate(ctx context.Context, email, password string) (User,
 			return fmt.Errorf("incorrect password %w", ErrInvalidPassword)
 		}
 
-		// Return the authenticated user (password is excluded in the returned struct)
-		return nil
+		// checked after the password, so the answer does not tell a stranger the account exists
+		if user.Disabled {
+			return ErrAccountDisabled
+		}
+
+		// Successful authentication! Build the JWT claims with the permissions of the roles.
+		claims, err = claimsFor(ctx, tx, user)
+		return err
 	}
 	//executing the function withing transaction
 	err := c.withTx(ctx, f)
 	if err != nil {
 		return User{}, auth.Claims{}, fmt.Errorf("failed to authenticate user: %w", err)
 	}
-	var claims auth.Claims
-
-	// Successful authentication! Generate JWT claims.
-	claims.RegisteredClaims = jwt.RegisteredClaims{
-		Issuer:    os.Getenv("SERVICE_NAME"),
-		Subject:   user.ID,
-		Audience:  jwt.ClaimStrings{"everyone"},
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-	}
-	claims.Roles = user.Roles
-
 	return user, claims, nil
 }
 
@@ -163,3 +177,45 @@ func (c *Conf) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
 	// Return nil if the function executes successfully and the transaction is committed.
 	return nil
 }
+
+// execer is a *sql.DB or a *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// bootstrapAdmins returns the emails listed in ADMIN_EMAILS (comma separated), lower cased.
+// They become admins, which is how the first admin of a new deployment is created.
+func bootstrapAdmins() []string {
+	var admins []string
+	for _, admin := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" {
+			admins = append(admins, normalizeEmail(admin))
+		}
+	}
+	return admins
+}
+
+// grantBootstrapAdmin makes the user an admin if their email is listed in ADMIN_EMAILS and verified.
+// Every account starts as a user, it is called wherever an email becomes verified, so typing
+// the address of an admin at signup gives nothing until its owner proves it is theirs.
+func grantBootstrapAdmin(ctx context.Context, db execer, userId string, now time.Time) error {
+	admins := bootstrapAdmins()
+	if len(admins) == 0 {
+		return nil
+	}
+
+	query := `
+	UPDATE users SET roles = array_append(roles, $2), updated_at = $4
+	WHERE id = $1 AND email_verified AND lower(email) = ANY($3) AND NOT ($2 = ANY(roles))
+	`
+	_, err := db.ExecContext(ctx, query, userId, auth.RoleAdmin, pq.StringArray(admins), now)
+	if err != nil {
+		return fmt.Errorf("failed to grant admin role: %w", err)
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,154 @@
+package users
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+	"user-service/internal/auth"
+)
+
+func TestBootstrapAdmins(t *testing.T) {
+	t.Setenv("ADMIN_EMAILS", " Owner@example.com, ,ops@example.com")
+	if got, want := bootstrapAdmins(), []string{"owner@example.com", "ops@example.com"}; !slices.Equal(got, want) {
+		t.Errorf("bootstrapAdmins() = %v, want %v", got, want)
+	}
+
+	t.Setenv("ADMIN_EMAILS", "")
+	if got := bootstrapAdmins(); len(got) != 0 {
+		t.Errorf("bootstrapAdmins() = %v, want none", got)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got, want := escapeLike(`50%_off\`), `50\%\_off\\`; got != want {
+		t.Errorf("escapeLike() = %q, want %q", got, want)
+	}
+}
+
+func TestLoginPolicyDelay(t *testing.T) {
+	p := LoginPolicy{BaseDelay: 500 * time.Millisecond, MaxDelay: 4 * time.Second}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 0},
+		{3, 500 * time.Millisecond},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{50, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestGrantedRoles(t *testing.T) {
+	t.Setenv("MFA_REQUIRED_ROLES", "admin")
+
+	tests := []struct {
+		name string
+		user User
+		want []string
+	}{
+		{"user without mfa", User{Roles: []string{"user"}}, []string{"user"}},
+		{"admin without mfa", User{Roles: []string{"user", "admin"}}, []string{"user"}},
+		{"admin with mfa", User{Roles: []string{"user", "admin"}, MFAEnabled: true}, []string{"user", "admin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GrantedRoles(tt.user); !slices.Equal(got, tt.want) {
+				t.Errorf("GrantedRoles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := newRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 11 || code[5] != '-' || !isRecoveryCode(code) {
+		t.Fatalf("newRecoveryCode() = %q", code)
+	}
+	if normalizeRecoveryCode(" K7M2P-XQ9TA ") != "k7m2pxq9ta" {
+		t.Error("recovery codes must match however they are typed")
+	}
+	if isRecoveryCode("123456") {
+		t.Error("an app code must not be taken for a recovery code")
+	}
+}
+
+func TestKeyPermissions(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   []string
+		owner []string
+		want  []string
+	}{
+		{"owner has them all", []string{auth.PermOrdersRead}, []string{auth.PermOrdersRead, auth.PermOrdersRefund}, []string{auth.PermOrdersRead}},
+		{"owner lost one", []string{auth.PermOrdersRead, auth.PermOrdersRefund}, []string{auth.PermOrdersRead}, []string{auth.PermOrdersRead}},
+		{"owner lost all", []string{auth.PermProductsWrite}, []string{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := keyPermissions(tt.key, tt.owner)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("keyPermissions(%v, %v) = %v, want %v", tt.key, tt.owner, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResetUsable(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		usedAt    sql.NullTime
+		expiresAt time.Time
+		want      bool
+	}{
+		{"unused and valid", sql.NullTime{}, now.Add(time.Minute), true},
+		{"already used", sql.NullTime{Time: now.Add(-time.Minute), Valid: true}, now.Add(time.Minute), false},
+		{"expires now", sql.NullTime{}, now, false},
+		{"expired", sql.NullTime{}, now.Add(-time.Second), false},
+		{"used and expired", sql.NullTime{Time: now.Add(-time.Hour), Valid: true}, now.Add(-time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resetUsable(tt.usedAt, tt.expiresAt, now); got != tt.want {
+				t.Errorf("resetUsable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResetTokenHash(t *testing.T) {
+	token, err := newSecretToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newSecretToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == other {
+		t.Fatal("two reset tokens are the same")
+	}
+
+	// only the hash is stored, a leaked table does not give working links
+	if hashToken(token) != hashToken(token) {
+		t.Error("hashToken() is not stable")
+	}
+	if hashToken(token) == token || hashToken(token) == hashToken(other) {
+		t.Error("hashToken() must not reveal or confuse tokens")
+	}
+}
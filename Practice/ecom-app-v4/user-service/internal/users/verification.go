@@ -0,0 +1,68 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MarkEmailVerified confirms the email of the user. The email has to still be the one the token
+// was issued for, otherwise ErrUserNotFound is returned. Verifying twice is not an error.
+func (c *Conf) MarkEmailVerified(ctx context.Context, userId, email string) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		query := `
+		UPDATE users
+		SET email_verified = TRUE, email_verified_at = COALESCE(email_verified_at, $3), updated_at = $3
+		WHERE id = $1 AND email = $2
+		`
+		res, err := tx.ExecContext(ctx, query, userId, email, now)
+		if err != nil {
+			return fmt.Errorf("failed to verify email: %w", err)
+		}
+
+		num, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to verify email: %w", err)
+		}
+		if num == 0 {
+			return ErrUserNotFound
+		}
+		return grantBootstrapAdmin(ctx, tx, userId, now)
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+	return nil
+}
+
+// ClaimVerificationResend returns the unverified user with the email if no verification email
+// went out to them within the cooldown and records that one is being sent now.
+// ok is false when there is nothing to send, the caller should not tell which case it was.
+func (c *Conf) ClaimVerificationResend(ctx context.Context, email string, cooldown time.Duration) (Contact, bool, error) {
+	now := time.Now().UTC()
+
+	query := `
+	UPDATE users
+	SET verification_sent_at = $2
+	WHERE email = $1 AND NOT email_verified AND disabled_at IS NULL
+	AND (verification_sent_at IS NULL OR verification_sent_at < $3)
+	RETURNING id, name, email
+	`
+	var contact Contact
+	err := c.db.QueryRowContext(ctx, query, email, now, now.Add(-cooldown)).
+		Scan(&contact.ID, &contact.Name, &contact.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Contact{}, false, nil
+		}
+		return Contact{}, false, fmt.Errorf("failed to claim verification resend: %w", err)
+	}
+	return contact, true, nil
+}
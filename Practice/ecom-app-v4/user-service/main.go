@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
 	"log/slog"
 	"net/http"
@@ -15,6 +14,7 @@ import (
 	"user-service/handlers"
 	"user-service/internal/auth"
 	"user-service/internal/consul"
+	"user-service/internal/oidc"
 	"user-service/internal/stores/kafka"
 	"user-service/internal/stores/postgres"
 	"user-service/internal/users"
@@ -60,28 +60,69 @@ func startApp() error {
 			//------------------------------------------------------//
 	*/
 	slog.Info("main : Started : Initializing authentication support")
-	privatePEM, err := os.ReadFile("private.pem")
+	// JWT_KEYS_DIR holds one <kid>.pem per key during a rotation, JWT_SIGNING_KID picks the one new tokens are signed with.
+	// Without it the single private.pem is used.
+	privateKeys, err := auth.LoadSigningKeys(os.Getenv("JWT_KEYS_DIR"))
 	if err != nil {
-		return fmt.Errorf("reading auth private key %w", err)
+		return err
+	}
+	activeKID := os.Getenv("JWT_SIGNING_KID")
+	if activeKID == "" && len(privateKeys) == 1 {
+		for kid := range privateKeys {
+			activeKID = kid
+		}
 	}
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+	slog.Info("auth keys loaded", slog.Int("Keys", len(privateKeys)), slog.String("SigningKID", activeKID))
+
+	// access tokens revoked on logout are refused, the list is synced from the database
+	revoked := auth.NewRevocations()
+	a, err := auth.NewKeys(privateKeys, activeKID, revoked)
 	if err != nil {
-		return fmt.Errorf("parsing auth private key %w", err)
+		return fmt.Errorf("constructing auth %w", err)
 	}
 
-	publicPEM, err := os.ReadFile("pubkey.pem")
+	// links in the verification emails are signed with this secret and expire after EMAIL_VERIFICATION_TTL
+	verificationTTL := 24 * time.Hour
+	if v := os.Getenv("EMAIL_VERIFICATION_TTL"); v != "" {
+		verificationTTL, err = time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("parsing EMAIL_VERIFICATION_TTL %w", err)
+		}
+	}
+
+	vt, err := auth.NewVerificationTokens([]byte(os.Getenv("EMAIL_VERIFICATION_SECRET")), verificationTTL)
 	if err != nil {
-		return fmt.Errorf("reading auth public key %w", err)
+		return fmt.Errorf("initializing email verification %w", err)
 	}
+	slog.Info("email verification", slog.Bool("Required", auth.VerificationRequired()))
 
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+	// TOTP secrets are stored encrypted with this key, the issuer is the name authenticator apps show next to the account
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "ecom-app"
+	}
+	totp, err := auth.NewTOTP([]byte(os.Getenv("TOTP_ENCRYPTION_KEY")), issuer)
 	if err != nil {
-		return fmt.Errorf("parsing auth public key %w", err)
+		return fmt.Errorf("initializing totp %w", err)
 	}
+	slog.Info("two factor authentication", slog.Any("RequiredForRoles", auth.MFARequiredRoles()))
 
-	a, err := auth.NewKeys(privateKey, publicKey)
+	// "Sign in with" providers, each configured with OIDC_<NAME>_* variables
+	providers, err := oidc.ProvidersFromEnv(&http.Client{Timeout: 10 * time.Second})
 	if err != nil {
-		return fmt.Errorf("constructing auth %w", err)
+		return fmt.Errorf("configuring identity providers %w", err)
+	}
+	slog.Info("identity providers", slog.Int("Count", len(providers)))
+
+	// the other services get service tokens for the internal routes with these secrets,
+	// the user service signs its own since it holds the keys
+	serviceClients, err := auth.ServiceClientsFromEnv()
+	if err != nil {
+		return fmt.Errorf("configuring service clients %w", err)
+	}
+	serviceTokens, err := auth.NewServiceTokens(auth.LocalServiceTokens(a))
+	if err != nil {
+		return fmt.Errorf("initializing service tokens %w", err)
 	}
 
 	/*
@@ -93,6 +134,10 @@ func startApp() error {
 	if err != nil {
 		return err
 	}
+
+	revocationCtx, stopRevocations := context.WithCancel(context.Background())
+	defer stopRevocations()
+	go revoked.Poll(revocationCtx, auth.RevocationSyncInterval(), u.RevokedTokens)
 	//------------------------------------------------------//
 
 	/*
@@ -152,6 +197,20 @@ func startApp() error {
 		}
 	}()
 
+	/*
+			//------------------------------------------------------//
+		               Registering with Consul
+		   the consul client is used to reach the product service as well
+			//------------------------------------------------------//
+	*/
+
+	consulClient, regId, err := consul.RegisterWithConsul()
+	if err != nil {
+		return err
+	}
+
+	defer consulClient.Agent().ServiceDeregister(regId)
+
 	/*
 
 			//------------------------------------------------------//
@@ -169,26 +228,13 @@ func startApp() error {
 		WriteTimeout: 800 * time.Second,
 		IdleTimeout:  800 * time.Second,
 		//handlers.API returns gin.Engine which implements Handler Interface
-		Handler: handlers.API(u, a, kafkaConf),
+		Handler: handlers.API(consulClient, u, a, vt, totp, providers, serviceClients, serviceTokens, kafkaConf),
 	}
 	serverErrors := make(chan error)
 	go func() {
 		serverErrors <- api.ListenAndServe()
 	}()
 
-	/*
-			//------------------------------------------------------//
-		               Registering with Consul
-			//------------------------------------------------------//
-	*/
-
-	consulClient, regId, err := consul.RegisterWithConsul()
-	if err != nil {
-		return err
-	}
-
-	defer consulClient.Agent().ServiceDeregister(regId)
-
 	/*
 			//------------------------------------------------------//
 		               Listening for error signals
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-service/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// the token an API key was exchanged for passes RequirePermission but never RequireLogin,
+// and handlers reading ClaimsKey do not see it
+func TestAPIKeyToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := auth.NewKeys(map[string]*rsa.PrivateKey{"k1": privateKey}, "k1", auth.NewRevocations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMid(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(apiKey string) string {
+		var claims auth.Claims
+		claims.Subject = "u1"
+		claims.APIKey = apiKey
+		claims.Permissions = []string{auth.PermAPIKeysCreate}
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		token, err := k.GenerateToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+
+	var subject string
+	handler := func(c *gin.Context) {
+		if claims, ok := c.Request.Context().Value(auth.ClaimsKey).(auth.Claims); ok {
+			subject = claims.Subject
+		}
+		c.Status(http.StatusOK)
+	}
+
+	r := gin.New()
+	r.GET("/login", m.Authentication(), m.RequireLogin(handler))
+	r.GET("/permission", m.Authentication(), m.RequirePermission(handler, auth.PermAPIKeysCreate))
+	r.GET("/customer", m.Authentication(), handler)
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+		wantSubject   string
+	}{
+		{name: "login with a session token", path: "/login", authorization: sign(""), wantStatus: http.StatusOK, wantSubject: "u1"},
+		{name: "login with an api key token", path: "/login", authorization: sign("key-1"), wantStatus: http.StatusForbidden},
+		{name: "permission with an api key token", path: "/permission", authorization: sign("key-1"), wantStatus: http.StatusOK, wantSubject: "u1"},
+		{name: "customer route with an api key token", path: "/customer", authorization: sign("key-1"), wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject = ""
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Authorization", tt.authorization)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus || subject != tt.wantSubject {
+				t.Errorf("got (%d, %q), want (%d, %q)", rec.Code, subject, tt.wantStatus, tt.wantSubject)
+			}
+		})
+	}
+}